@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// isAgent tells whether the beat is run as nvidiadockerbeat agent.
+func isAgent(args []string) bool {
+	return len(args) > 1 && args[1] == "agent"
+}
+
+// agent serves the GPUs of the host, read by the GPU source of the first
+// enabled nvidiadocker module of the configuration, at its agent.listen
+// address, over HTTPS with agent.cert and agent.key, for a beat running on
+// another host with the agent GPU source. The agent does not publish events,
+// so GPU hosts do not need an output. Like test gpu, the command is handled
+// before the beat starts, with the same flags. It returns the exit code once
+// serving fails.
+func agent() int {
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	if err := handleFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	modules, err := loadModules()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config file: %v\n", err)
+		return 1
+	}
+	if len(modules) == 0 {
+		fmt.Fprintf(os.Stderr, "No nvidiadocker module is enabled in %s.modules\n", Name)
+		return 1
+	}
+	config := modules[0].config
+	if strings.ToLower(config.GPUSource) == nvidiadocker.GPUSourceAgent {
+		fmt.Fprintf(os.Stderr, "The agent cannot read its GPUs with gpu_source '%s'\n", config.GPUSource)
+		return 1
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logp.Info("Serving the GPUs read with gpu_source '%s' on %s", config.GPUSource, config.Agent.Listen)
+	if err := nvidiadocker.ServeAgent(config.Agent, collector); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
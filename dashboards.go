@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/elastic/beats/libbeat/cfgfile"
+)
+
+// dashboardsSources are the settings of the dashboards configuration telling
+// where to load the dashboards from.
+var dashboardsSources = []string{"directory", "file", "url", "snapshot"}
+
+// isSetup tells whether the beat is run with -setup, which loads the Kibana
+// dashboards and index pattern.
+func isSetup(args []string) bool {
+	for _, arg := range args[1:] {
+		switch arg {
+		case "-setup", "--setup", "-setup=true", "--setup=true":
+			return true
+		}
+	}
+	return false
+}
+
+// setupDashboards makes -setup load the dashboards bundled with the beat,
+// from the kibana directory of its home path, or _meta/kibana in a source
+// tree, unless the configuration tells where to load them from. libbeat 5.5
+// downloads the dashboards of the official beats by default, which hold none
+// of the beat.
+func setupDashboards() error {
+	if err := handleFlags(); err != nil {
+		return err
+	}
+	cfg, err := cfgfile.Load("")
+	if err != nil {
+		return err
+	}
+	for _, source := range dashboardsSources {
+		if cfg.HasField("dashboards." + source) {
+			return nil
+		}
+	}
+
+	home, err := cfg.String("path.home", -1)
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{"kibana", filepath.Join("_meta", "kibana")} {
+		dir = filepath.Join(home, dir)
+		if _, err := os.Stat(filepath.Join(dir, "dashboard")); err == nil {
+			return flag.Set("E", "dashboards.directory="+dir)
+		}
+	}
+	return nil
+}
@@ -9,5 +9,21 @@ package include
 import (
 	// This list is automatically generated by `make imports`
 	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/accounting"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/audit"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/autodiscover"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/condition"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fabric"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/gpu"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/health"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/inventory"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/mig"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/nvlink"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/process"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/selfmonitor"
 	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/status"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/summary"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/topology"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/vgpu"
+	_ "github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/xid"
 )
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/elastic/beats/libbeat/beat"
@@ -15,6 +16,21 @@ import (
 var Name = "nvidiadockerbeat"
 
 func main() {
+	if isTestGPU(os.Args) {
+		os.Exit(testGPU())
+	}
+	if isAgent(os.Args) {
+		os.Exit(agent())
+	}
+	if isPreview(os.Args) {
+		os.Exit(preview())
+	}
+	if isSetup(os.Args) {
+		if err := setupDashboards(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 	if err := beat.Run(Name, "", beater.New); err != nil {
 		os.Exit(1)
 	}
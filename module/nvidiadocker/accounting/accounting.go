@@ -0,0 +1,247 @@
+package accounting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "accounting", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports the GPU usage of every finished process recorded by the
+// driver in accounting mode, attributed to the container the process ran in.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector       nvidiadocker.AccountingCollector
+	containerClient nvidiadocker.ContainerClient
+	versions        *nvidiadocker.VersionCache
+	driver          *nvidiadocker.DriverCheck
+	gate            *nvidiadocker.FetchGate
+	hostFS          string
+	localHost       bool
+	labels          nvidiadocker.LabelsConfig
+	filter          *nvidiadocker.ContainerFilter
+
+	// started is set after the first fetch, the processes that had finished
+	// before are not reported.
+	started bool
+	// reported holds the finished processes that have been reported.
+	reported map[processKey]bool
+	// containers holds the containers of the running processes, which cannot
+	// be looked up anymore once the process has finished.
+	containers map[processKey]common.MapStr
+	// excluded holds the running processes of containers the filter did not
+	// select, which are not reported.
+	excluded map[processKey]bool
+}
+
+type processKey struct {
+	gpu string
+	pid uint
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	accountingCollector, ok := collector.(nvidiadocker.AccountingCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support reporting accounted processes", config.GPUSource)
+	}
+
+	containerClient, err := nvidiadocker.NewContainerClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MetricSet{
+		BaseMetricSet:   base,
+		collector:       accountingCollector,
+		containerClient: containerClient,
+		hostFS:          config.HostFS,
+		localHost:       config.LocalHost(),
+		labels:          config.Labels,
+		filter:          nvidiadocker.NewContainerFilter(config),
+		versions:        nvidiadocker.NewVersionCache(collector),
+		driver:          nvidiadocker.NewDriverCheck(config),
+		gate:            nvidiadocker.NewFetchGate(config, base),
+		reported:        map[processKey]bool{},
+		containers:      map[processKey]common.MapStr{},
+		excluded:        map[processKey]bool{},
+	}, nil
+}
+
+// Fetch returns one event per process that finished since the previous fetch.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	processes, err := m.collector.AccountedProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	events := m.finished(processes, m.lookupContainer)
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+// finished returns the events of the processes that finished since the
+// previous call, and remembers the containers of the running processes with
+// lookup, which also tells whether the container is selected.
+func (m *MetricSet) finished(processes []nvidiadocker.AccountedProcess, lookup func(pid uint) (common.MapStr, bool)) []common.MapStr {
+	var events []common.MapStr
+	seen := make(map[processKey]bool, len(processes))
+	for i := range processes {
+		process := &processes[i]
+		key := processKey{gpu: process.GPUUUID, pid: process.PID}
+		seen[key] = true
+
+		if process.Running {
+			// The pid may be reused by a new process.
+			delete(m.reported, key)
+			if _, found := m.containers[key]; !found && !m.excluded[key] {
+				container, selected := lookup(process.PID)
+				if selected {
+					m.containers[key] = container
+				} else {
+					m.excluded[key] = true
+				}
+			}
+			continue
+		}
+
+		if m.reported[key] {
+			continue
+		}
+		m.reported[key] = true
+		if !m.started || m.excluded[key] {
+			continue
+		}
+
+		event := eventMapping(process)
+		if container := m.containers[key]; container != nil {
+			event["container"] = container
+		}
+		events = append(events, event)
+	}
+	m.started = true
+
+	// The driver drops the oldest processes from its buffer.
+	for key := range m.reported {
+		if !seen[key] {
+			delete(m.reported, key)
+		}
+	}
+	for key := range m.containers {
+		if !seen[key] {
+			delete(m.containers, key)
+		}
+	}
+	for key := range m.excluded {
+		if !seen[key] {
+			delete(m.excluded, key)
+		}
+	}
+	return events
+}
+
+// lookupContainer returns the container the process runs in, or nil when it
+// does not run in a container, and whether the filter selects the container.
+// The processes of a remote host are not looked up in the local /proc, where
+// their PIDs are unrelated processes.
+func (m *MetricSet) lookupContainer(pid uint) (common.MapStr, bool) {
+	if !m.localHost {
+		return nil, true
+	}
+	containerID, err := nvidiadocker.ContainerIDFromPID(m.hostFS, pid)
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot read cgroup of pid %d: %v", pid, err)
+	}
+	if containerID == "" {
+		return nil, true
+	}
+
+	container, err := m.containerClient.InspectContainer(containerID)
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot inspect container %s: %v", containerID, err)
+	}
+	if container != nil && !m.filter.MatchContainer(container) {
+		return nil, false
+	}
+	return containerMapping(containerID, container, m.labels), true
+}
+
+func eventMapping(process *nvidiadocker.AccountedProcess) common.MapStr {
+	gpu := common.MapStr{
+		"uuid": process.GPUUUID,
+	}
+	if process.GPUIndex != nil {
+		gpu["index"] = *process.GPUIndex
+	}
+
+	return common.MapStr{
+		"pid": process.PID,
+		"gpu": gpu,
+		"utilization": common.MapStr{
+			"gpu":    process.Utilization.GPU,
+			"memory": process.Utilization.Memory,
+		},
+		"usage": common.MapStr{
+			"gpu":    common.MapStr{"pct": nvidiadocker.Percent(process.Utilization.GPU)},
+			"memory": common.MapStr{"pct": nvidiadocker.Percent(process.Utilization.Memory)},
+		},
+		"memory": common.MapStr{
+			"max_used": common.MapStr{
+				"bytes": process.MaxMemoryUsed * nvidiadocker.MiB,
+			},
+		},
+		"runtime": common.MapStr{
+			"ms": process.Time,
+		},
+	}
+}
+
+func containerMapping(containerID string, container *docker.Container, labels nvidiadocker.LabelsConfig) common.MapStr {
+	event := common.MapStr{
+		"id": containerID,
+	}
+	if container != nil {
+		event["name"] = strings.TrimPrefix(container.Name, "/")
+		if image := nvidiadocker.Image(container); image != nil {
+			event["image"] = image
+		}
+		if container.Config != nil {
+			event["labels"] = labels.Labels(container.Config.Labels)
+			if owner := labels.Owner(container.Config.Labels); owner != nil {
+				event["owner"] = owner
+			}
+			nvidiadocker.AddOrchestration(event, container.Config.Labels)
+		}
+	}
+	return event
+}
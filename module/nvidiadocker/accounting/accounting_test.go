@@ -0,0 +1,100 @@
+package accounting
+
+import (
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	index := uint(2)
+	event := eventMapping(&nvidiadocker.AccountedProcess{
+		PID:           23421,
+		GPUUUID:       "GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67",
+		GPUIndex:      &index,
+		Utilization:   nvidiadocker.UtilizationInfo{GPU: 87, Memory: 42},
+		MaxMemoryUsed: 10240,
+		Time:          3600500,
+	})
+
+	testDatas := map[string]interface{}{
+		"pid":                   uint(23421),
+		"gpu.uuid":              "GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67",
+		"gpu.index":             uint(2),
+		"utilization.gpu":       uint(87),
+		"utilization.memory":    uint(42),
+		"usage.gpu.pct":         0.87,
+		"usage.memory.pct":      0.42,
+		"memory.max_used.bytes": uint64(10240 * 1024 * 1024),
+		"runtime.ms":            uint64(3600500),
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
+
+func TestFinished(t *testing.T) {
+	m := &MetricSet{
+		reported:   map[processKey]bool{},
+		containers: map[processKey]common.MapStr{},
+		excluded:   map[processKey]bool{},
+	}
+	lookups := 0
+	lookup := func(pid uint) (common.MapStr, bool) {
+		lookups++
+		return common.MapStr{"id": "c1"}, pid != 30
+	}
+
+	gpu := "GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67"
+	old := nvidiadocker.AccountedProcess{PID: 10, GPUUUID: gpu}
+	job := nvidiadocker.AccountedProcess{PID: 20, GPUUUID: gpu, Running: true}
+
+	// Processes that finished before the first fetch are not reported.
+	if events := m.finished([]nvidiadocker.AccountedProcess{old, job}, lookup); len(events) != 0 {
+		t.Fatalf("expected no events, got %v", events)
+	}
+	if events := m.finished([]nvidiadocker.AccountedProcess{old, job}, lookup); len(events) != 0 {
+		t.Fatalf("expected no events, got %v", events)
+	}
+	if lookups != 1 {
+		t.Fatalf("expected the container to be looked up once, got %d", lookups)
+	}
+
+	job.Running = false
+	job.Time = 5000
+	events := m.finished([]nvidiadocker.AccountedProcess{old, job}, lookup)
+	if len(events) != 1 || events[0]["pid"] != uint(20) {
+		t.Fatalf("expected the finished process, got %v", events)
+	}
+	if id, _ := events[0].GetValue("container.id"); id != "c1" {
+		t.Fatalf("expected container c1, got %v", id)
+	}
+
+	if events := m.finished([]nvidiadocker.AccountedProcess{old, job}, lookup); len(events) != 0 {
+		t.Fatalf("expected the process to be reported once, got %v", events)
+	}
+
+	// The processes of containers the filter did not select are not reported.
+	sidecar := nvidiadocker.AccountedProcess{PID: 30, GPUUUID: gpu, Running: true}
+	m.finished([]nvidiadocker.AccountedProcess{sidecar}, lookup)
+	sidecar.Running = false
+	if events := m.finished([]nvidiadocker.AccountedProcess{sidecar}, lookup); len(events) != 0 {
+		t.Fatalf("expected the excluded process not to be reported, got %v", events)
+	}
+
+	m.finished(nil, lookup)
+	if len(m.reported) != 0 || len(m.containers) != 0 || len(m.excluded) != 0 {
+		t.Fatalf("expected dropped processes to be forgotten, got %v %v %v", m.reported, m.containers, m.excluded)
+	}
+}
@@ -0,0 +1,197 @@
+package nvidiadocker
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultAgentPort is the port the agent serves the GPUs of its host on.
+const defaultAgentPort = "9480"
+
+// The endpoints of the agent, serving JSON.
+const (
+	agentDevicesPath   = "/v1/devices"
+	agentProcessesPath = "/v1/processes"
+	agentVersionsPath  = "/v1/versions"
+)
+
+// agentTimeout bounds the requests to an agent, so that an unreachable host
+// does not block the fetches of the MetricSets.
+const agentTimeout = 10 * time.Second
+
+func init() {
+	if err := AddCollector(GPUSourceAgent, NVIDIABackend, newAgentCollector); err != nil {
+		panic(err)
+	}
+}
+
+// AgentHandler serves the GPUs read by the collector, for the agent GPU
+// source of a beat running on another host. The status of the GPUs is served
+// at /v1/devices, the compute processes at /v1/processes and the driver and
+// CUDA versions at /v1/versions, the last two only if the collector can
+// report them. The requests without the bearer token are refused, if any.
+func AgentHandler(collector GPUCollector, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(agentDevicesPath, func(w http.ResponseWriter, r *http.Request) {
+		devices, err := collector.Query(nil)
+		writeAgentJSON(w, devices, err)
+	})
+	mux.HandleFunc(agentProcessesPath, func(w http.ResponseWriter, r *http.Request) {
+		processCollector, ok := collector.(ProcessCollector)
+		if !ok {
+			http.Error(w, "listing processes is not supported", http.StatusNotImplemented)
+			return
+		}
+		processes, err := processCollector.Processes()
+		writeAgentJSON(w, processes, err)
+	})
+	mux.HandleFunc(agentVersionsPath, func(w http.ResponseWriter, r *http.Request) {
+		versionCollector, ok := collector.(VersionCollector)
+		if !ok {
+			http.Error(w, "reporting versions is not supported", http.StatusNotImplemented)
+			return
+		}
+		versions, err := versionCollector.Versions()
+		writeAgentJSON(w, versions, err)
+	})
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(authorization), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// ServeAgent serves the GPUs read by the collector at the agent.listen
+// address, over HTTPS if agent.cert and agent.key are set. It only returns
+// once serving fails.
+func ServeAgent(config AgentConfig, collector GPUCollector) error {
+	handler := AgentHandler(collector, config.Token)
+	if config.Cert != "" || config.Key != "" {
+		return http.ListenAndServeTLS(config.Listen, config.Cert, config.Key, handler)
+	}
+	return http.ListenAndServe(config.Listen, handler)
+}
+
+func writeAgentJSON(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// agentCollector reads the GPU status from the beat running as an agent on
+// the host of the GPUs.
+type agentCollector struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newAgentCollector(config Config) (GPUCollector, error) {
+	client := &http.Client{Timeout: agentTimeout}
+	if config.Agent.CA != "" {
+		ca, err := ioutil.ReadFile(config.Agent.CA)
+		if err != nil {
+			return nil, fmt.Errorf("agent.ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("agent.ca: no certificate found in %s", config.Agent.CA)
+		}
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+	return &agentCollector{
+		url:    strings.TrimRight(config.Agent.URL, "/"),
+		token:  config.Agent.Token,
+		client: client,
+	}, nil
+}
+
+func (c *agentCollector) List() ([]uint, error) {
+	devices, err := c.devices()
+	if err != nil {
+		return nil, err
+	}
+	return indexList(len(devices)), nil
+}
+
+func (c *agentCollector) Query(indices []uint) ([]DeviceStatus, error) {
+	devices, err := c.devices()
+	if err != nil {
+		return nil, err
+	}
+	return filterDevices(devices, indices)
+}
+
+func (c *agentCollector) Processes() ([]ProcessInfo, error) {
+	var processes []ProcessInfo
+	if err := c.get(agentProcessesPath, &processes); err != nil {
+		return nil, err
+	}
+	return processes, nil
+}
+
+func (c *agentCollector) Versions() (Versions, error) {
+	var versions Versions
+	if err := c.get(agentVersionsPath, &versions); err != nil {
+		return Versions{}, err
+	}
+	return versions, nil
+}
+
+func (c *agentCollector) devices() ([]DeviceStatus, error) {
+	var devices []DeviceStatus
+	if err := c.get(agentDevicesPath, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+func (c *agentCollector) get(path string, v interface{}) (err error) {
+	defer func(start time.Time) {
+		observeGPUSourceCall(start, err)
+	}(time.Now())
+
+	req, err := http.NewRequest("GET", c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("agent %s: %s: %s", c.url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
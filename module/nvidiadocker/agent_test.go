@@ -0,0 +1,121 @@
+package nvidiadocker
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type mockAgentCollector struct {
+	devices []DeviceStatus
+}
+
+func (c *mockAgentCollector) List() ([]uint, error) {
+	return indexList(len(c.devices)), nil
+}
+
+func (c *mockAgentCollector) Query(indices []uint) ([]DeviceStatus, error) {
+	return filterDevices(c.devices, indices)
+}
+
+func (c *mockAgentCollector) Versions() (Versions, error) {
+	return Versions{Driver: "535.104.05", CUDA: "12.2"}, nil
+}
+
+func TestAgent(t *testing.T) {
+	local := &mockAgentCollector{devices: []DeviceStatus{
+		{Index: toUintP(0), UUID: "GPU-0", MinorNumber: toUintP(0), Utilization: UtilizationInfo{GPU: 90}},
+		{Index: toUintP(1), UUID: "GPU-1", MinorNumber: toUintP(2), PersistenceMode: toBoolP(true)},
+	}}
+	server := httptest.NewServer(AgentHandler(local, ""))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.GPUSource = GPUSourceAgent
+	config.Agent.URL = server.URL + "/"
+	collector, err := NewCollector(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indices, err := collector.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indices, []uint{0, 1}) {
+		t.Fatalf("unexpected indices %v", indices)
+	}
+	devices, err := collector.Query([]uint{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || !reflect.DeepEqual(devices[0], local.devices[1]) {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+
+	versions, err := collector.(VersionCollector).Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Driver != "535.104.05" || versions.CUDA != "12.2" {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	// The local collector cannot list processes.
+	_, err = collector.(ProcessCollector).Processes()
+	if err == nil || !strings.Contains(err.Error(), http.StatusText(http.StatusNotImplemented)) {
+		t.Fatalf("expected not implemented error, got %v", err)
+	}
+}
+
+func TestAgentTokenTLS(t *testing.T) {
+	local := &mockAgentCollector{devices: []DeviceStatus{{Index: toUintP(0), UUID: "GPU-0"}}}
+	server := httptest.NewTLSServer(AgentHandler(local, "secret"))
+	defer server.Close()
+
+	ca, err := ioutil.TempFile("", "agent-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	ca.Close()
+
+	config := DefaultConfig()
+	config.GPUSource = GPUSourceAgent
+	config.Agent.URL = server.URL
+	config.Agent.CA = ca.Name()
+
+	// The requests without the token are refused.
+	collector, err := NewCollector(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = collector.List()
+	if err == nil || !strings.Contains(err.Error(), http.StatusText(http.StatusUnauthorized)) {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+
+	config.Agent.Token = "secret"
+	collector, err = NewCollector(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := collector.Query(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].UUID != "GPU-0" {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+
+	config.Agent.CA = "/nonexistent/ca.pem"
+	if _, err := NewCollector(config); err == nil {
+		t.Fatal("expected error for missing CA")
+	}
+}
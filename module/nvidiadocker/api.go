@@ -0,0 +1,124 @@
+package nvidiadocker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func init() {
+	if err := AddCollector(GPUSourceAPI, NVIDIABackend, newAPICollector); err != nil {
+		panic(err)
+	}
+}
+
+// apiCollector reads the GPU status from the nvidia-docker-plugin REST API.
+type apiCollector struct {
+	apiURL string
+}
+
+func newAPICollector(config Config) (GPUCollector, error) {
+	return &apiCollector{apiURL: config.APIURL}, nil
+}
+
+func (c *apiCollector) List() ([]uint, error) {
+	devices, err := getGPUDeviceStatus(c.apiURL)
+	if err != nil {
+		return nil, err
+	}
+	return indexList(len(devices)), nil
+}
+
+func (c *apiCollector) Query(indices []uint) ([]DeviceStatus, error) {
+	devices, err := getGPUDeviceStatus(c.apiURL)
+	if err != nil {
+		return nil, err
+	}
+	// The REST API does not report when the plugin read the values, which
+	// it does on every request.
+	timestamp := time.Now()
+
+	infos, err := getGPUDeviceInfo(c.apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// The status endpoint only reports dynamic values, identity comes from
+	// the info endpoint which lists the devices in the same order.
+	for i := range devices {
+		devices[i].Index = toUintP(uint(i))
+		devices[i].Timestamp = timestamp
+		if i < len(infos) {
+			devices[i].UUID = infos[i].UUID
+			devices[i].Name = infos[i].Model
+			devices[i].PCI.BusID = infos[i].PCI.BusID
+			devices[i].PowerLimit = infos[i].Power
+			devices[i].Memory.GlobalTotal = infos[i].Memory.Global
+			if findStrs := nvidiaDeviceRegexp.FindStringSubmatch(infos[i].Path); len(findStrs) == 2 {
+				if minor, err := strconv.ParseUint(findStrs[1], 10, 32); err == nil {
+					devices[i].MinorNumber = toUintP(uint(minor))
+				}
+			}
+			if devices[i].Memory.GlobalTotal >= devices[i].Memory.GlobalUsed {
+				devices[i].Memory.GlobalFree = devices[i].Memory.GlobalTotal - devices[i].Memory.GlobalUsed
+			}
+		}
+	}
+	return filterDevices(devices, indices)
+}
+
+func (c *apiCollector) Processes() ([]ProcessInfo, error) {
+	devices, err := c.Query(nil)
+	if err != nil {
+		return nil, err
+	}
+	return deviceProcesses(devices), nil
+}
+
+func (c *apiCollector) Versions() (Versions, error) {
+	info := NvidiaInfo{}
+	if err := getAPIJSON(fmt.Sprintf("%s/v1.0/gpu/info/json", c.apiURL), &info); err != nil {
+		return Versions{}, err
+	}
+	return Versions{Driver: info.Version.Driver, CUDA: info.Version.CUDA}, nil
+}
+
+func getGPUDeviceStatus(apiURL string) ([]DeviceStatus, error) {
+	status := NvidiaStatus{}
+	if err := getAPIJSON(fmt.Sprintf("%s/v1.0/gpu/status/json", apiURL), &status); err != nil {
+		return nil, err
+	}
+
+	return status.Devices, nil
+}
+
+func getGPUDeviceInfo(apiURL string) ([]DeviceInfo, error) {
+	info := NvidiaInfo{}
+	if err := getAPIJSON(fmt.Sprintf("%s/v1.0/gpu/info/json", apiURL), &info); err != nil {
+		return nil, err
+	}
+
+	return info.Devices, nil
+}
+
+func getAPIJSON(url string, v interface{}) (err error) {
+	defer func(start time.Time) {
+		observeGPUSourceCall(start, err)
+	}(time.Now())
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	bytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, v)
+}
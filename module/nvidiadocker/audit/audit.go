@@ -0,0 +1,334 @@
+package audit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "audit", New); err != nil {
+		panic(err)
+	}
+}
+
+// Actions of the audit events.
+const (
+	actionStart = "gpu.allocation.start"
+	actionEnd   = "gpu.allocation.end"
+)
+
+// MetricSet follows the events of the container runtime and reports one
+// audit event per container with GPUs that started or died, with the GPUs it
+// was granted.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector nvidiadocker.GPUCollector
+	client    nvidiadocker.ContainerClient
+	source    nvidiadocker.ContainerEventSource
+	filter    *nvidiadocker.ContainerFilter
+	versions  *nvidiadocker.VersionCache
+	driver    *nvidiadocker.DriverCheck
+	gate      *nvidiadocker.FetchGate
+	backend   *nvidiadocker.Backend
+	hostFS    string
+	period    time.Duration
+
+	// kubeletCheckpoint is read on every container start for the GPUs the
+	// kubelet allocated to the containers of pods.
+	kubeletCheckpoint string
+
+	mutex     sync.Mutex
+	following bool
+	// fetched is the time of the latest fetch, the container events are
+	// dropped when the MetricSet stopped fetching.
+	fetched time.Time
+	// grants holds the GPUs of the running containers by container ID.
+	grants map[string]grant
+	// devices is the GPU inventory of the latest fetch, the GPUs of the
+	// started containers are looked up in it.
+	devices []nvidiadocker.DeviceStatus
+	// pending holds the audit events since the previous fetch.
+	pending []common.MapStr
+}
+
+// grant is the GPUs granted to a running container.
+type grant struct {
+	name  string
+	uuids []string
+	since time.Time
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := nvidiadocker.NewContainerClient(config)
+	if err != nil {
+		return nil, err
+	}
+	source, ok := client.(nvidiadocker.ContainerEventSource)
+	if !ok {
+		return nil, fmt.Errorf("runtime '%s' does not support following container events", config.Runtime)
+	}
+
+	gate := nvidiadocker.NewFetchGate(config, base)
+	return &MetricSet{
+		BaseMetricSet:     base,
+		collector:         collector,
+		client:            client,
+		source:            source,
+		filter:            nvidiadocker.NewContainerFilter(config),
+		versions:          nvidiadocker.NewVersionCache(collector),
+		driver:            nvidiadocker.NewDriverCheck(config),
+		gate:              gate,
+		kubeletCheckpoint: config.KubeletCheckpointPath(),
+		backend:           config.ContainerBackend(),
+		hostFS:            config.HostFS,
+		period:            gate.Period(),
+		grants:            map[string]grant{},
+	}, nil
+}
+
+// Fetch returns the audit events of the containers that started or died
+// since the previous fetch. The first fetch starts following the container
+// events and records the GPUs of the running containers, whose start is not
+// reported.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	// The GPUs of the started containers are looked up in the inventory of
+	// the latest fetch instead of querying the GPUs on every event.
+	if gpuDevices, err := m.collector.Query(nil); err == nil {
+		m.mutex.Lock()
+		m.devices = gpuDevices
+		m.mutex.Unlock()
+	} else {
+		logp.Debug("nvidiadocker", "Cannot query the GPUs, keeping the previous inventory: %v", err)
+	}
+
+	if err := m.follow(); err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	events := m.pending
+	m.pending = nil
+	m.mutex.Unlock()
+
+	if events == nil {
+		events = []common.MapStr{}
+	}
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+// follow starts following the container events if they are not followed, and
+// records the GPUs of the running containers. The containers recorded before
+// the stream ended that are not running anymore are dropped, the ones
+// recorded from the events received since are kept.
+func (m *MetricSet) follow() error {
+	m.mutex.Lock()
+	m.fetched = time.Now()
+	following := m.following
+	m.mutex.Unlock()
+	if following {
+		return nil
+	}
+
+	// Adding the listener negotiates the Docker API version, before the
+	// events are received and the containers listed concurrently.
+	events := make(chan *docker.APIEvents, 100)
+	if err := m.source.AddEventListener(events); err != nil {
+		return fmt.Errorf("cannot follow container events: %v", err)
+	}
+	m.mutex.Lock()
+	m.following = true
+	m.mutex.Unlock()
+	go m.receive(events)
+
+	listed := time.Now()
+	apiContainers, err := m.client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		return err
+	}
+	grants := map[string]grant{}
+	for _, apiContainer := range apiContainers {
+		if grant, found := m.grant(apiContainer.ID); found {
+			grants[apiContainer.ID] = grant
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for id, g := range m.grants {
+		if _, found := grants[id]; !found && g.since.Before(listed) {
+			logp.Debug("nvidiadocker", "Container %s stopped while the container events were not followed", id)
+			delete(m.grants, id)
+		}
+	}
+	for id, g := range grants {
+		if _, found := m.grants[id]; !found {
+			m.grants[id] = g
+		}
+	}
+	return nil
+}
+
+// receive handles the container events until the stream ends or the
+// MetricSet stopped fetching, after which the next fetch follows them again.
+func (m *MetricSet) receive(events chan *docker.APIEvents) {
+	defer func() {
+		m.mutex.Lock()
+		m.following = false
+		m.mutex.Unlock()
+	}()
+
+	ticker := time.NewTicker(m.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok || event == docker.EOFEvent {
+				return
+			}
+			m.handle(event)
+		case <-ticker.C:
+			m.mutex.Lock()
+			fetched := m.fetched
+			m.mutex.Unlock()
+			if nvidiadocker.Stale(fetched, m.period) {
+				logp.Debug("nvidiadocker", "Stopping to follow container events, no fetch since %v", fetched)
+				nvidiadocker.RemoveEventListener(m.source, events)
+				return
+			}
+		}
+	}
+}
+
+// handle records the audit event of a container event. Events of API
+// versions before 1.22 only have the Status and ID fields.
+func (m *MetricSet) handle(event *docker.APIEvents) {
+	if event.Type != "" && event.Type != "container" {
+		return
+	}
+	id, action := event.Actor.ID, event.Action
+	if id == "" {
+		id, action = event.ID, event.Status
+	}
+	if action != "start" && action != "die" {
+		return
+	}
+
+	m.mutex.Lock()
+	stale := nvidiadocker.Stale(m.fetched, m.period)
+	m.mutex.Unlock()
+	if stale {
+		return
+	}
+
+	at := time.Unix(0, event.TimeNano)
+	if event.TimeNano == 0 {
+		at = time.Unix(event.Time, 0)
+	}
+
+	if action == "start" {
+		g, found := m.grant(id)
+		if !found {
+			return
+		}
+		g.since = at
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.grants[id] = g
+		m.pending = append(m.pending, eventMapping(actionStart, id, g, at))
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	g, found := m.grants[id]
+	if !found {
+		return
+	}
+	delete(m.grants, id)
+	audit := eventMapping(actionEnd, id, g, at)
+	if !g.since.IsZero() && at.After(g.since) {
+		audit.Put("allocation.duration.ms", int64(at.Sub(g.since)/time.Millisecond))
+	}
+	if exitCode, err := strconv.Atoi(event.Actor.Attributes["exitCode"]); err == nil {
+		audit["exit_code"] = exitCode
+	}
+	m.pending = append(m.pending, audit)
+}
+
+// grant inspects the container and returns the GPUs it has access to, false
+// if it has none or is not selected by the container filter of the module.
+func (m *MetricSet) grant(id string) (grant, bool) {
+	container, runtime, err := m.client.InspectContainerWithRuntime(id)
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot inspect container %s: %v", id, err)
+		return grant{}, false
+	}
+	if !m.filter.MatchContainer(container) {
+		return grant{}, false
+	}
+
+	m.mutex.Lock()
+	gpuDevices := m.devices
+	m.mutex.Unlock()
+	allocations := nvidiadocker.LoadKubeletAllocations(m.kubeletCheckpoint)
+	indices := m.backend.ContainerDeviceIndices(container, runtime, allocations, gpuDevices, m.hostFS)
+	if len(indices) == 0 {
+		return grant{}, false
+	}
+
+	g := grant{
+		name:  strings.TrimPrefix(container.Name, "/"),
+		uuids: make([]string, 0, len(indices)),
+		since: container.State.StartedAt,
+	}
+	for _, index := range indices {
+		g.uuids = append(g.uuids, gpuDevices[index].UUID)
+	}
+	return g, true
+}
+
+func eventMapping(action, id string, g grant, at time.Time) common.MapStr {
+	container := common.MapStr{"id": id}
+	if g.name != "" {
+		container["name"] = g.name
+	}
+	return common.MapStr{
+		"action":    action,
+		"timestamp": common.Time(at),
+		"container": container,
+		"gpu": common.MapStr{
+			"count": len(g.uuids),
+			"uuids": g.uuids,
+		},
+	}
+}
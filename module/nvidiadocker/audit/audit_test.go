@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+type mockContainerClient struct {
+	containers map[string]*docker.Container
+	listener   chan<- *docker.APIEvents
+}
+
+func (c *mockContainerClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	var containers []docker.APIContainers
+	for id := range c.containers {
+		containers = append(containers, docker.APIContainers{ID: id})
+	}
+	return containers, nil
+}
+
+func (c *mockContainerClient) InspectContainer(id string) (*docker.Container, error) {
+	return c.containers[id], nil
+}
+
+func (c *mockContainerClient) InspectContainerWithRuntime(id string) (*docker.Container, *nvidiadocker.ContainerRuntime, error) {
+	return c.containers[id], nil, nil
+}
+
+func (c *mockContainerClient) AddEventListener(listener chan<- *docker.APIEvents) error {
+	c.listener = listener
+	return nil
+}
+
+func (c *mockContainerClient) RemoveEventListener(listener chan *docker.APIEvents) error {
+	c.listener = nil
+	return nil
+}
+
+func gpuContainer(id string, devices ...string) *docker.Container {
+	container := &docker.Container{ID: id, Name: "/" + id, HostConfig: &docker.HostConfig{}, Config: &docker.Config{}}
+	for _, device := range devices {
+		container.HostConfig.Devices = append(container.HostConfig.Devices, docker.Device{PathOnHost: device, PathInContainer: device})
+	}
+	return container
+}
+
+func TestHandle(t *testing.T) {
+	index0, index1 := uint(0), uint(1)
+	client := &mockContainerClient{containers: map[string]*docker.Container{
+		"train": gpuContainer("train", "/dev/nvidia0", "/dev/nvidia1"),
+		"web":   gpuContainer("web"),
+	}}
+	m := &MetricSet{
+		devices: []nvidiadocker.DeviceStatus{
+			{Index: &index0, UUID: "GPU-0"},
+			{Index: &index1, UUID: "GPU-1"},
+		},
+		client:  client,
+		source:  client,
+		fetched: time.Now(),
+		period:  10 * time.Second,
+		grants:  map[string]grant{},
+	}
+
+	started := time.Unix(1498910400, 0)
+	for _, id := range []string{"train", "web"} {
+		m.handle(&docker.APIEvents{Type: "container", Action: "start", Actor: docker.APIActor{ID: id}, TimeNano: started.UnixNano()})
+	}
+	m.handle(&docker.APIEvents{Type: "container", Action: "die", TimeNano: started.Add(time.Minute).UnixNano(),
+		Actor: docker.APIActor{ID: "train", Attributes: map[string]string{"exitCode": "137"}}})
+	m.handle(&docker.APIEvents{Type: "container", Action: "die", Actor: docker.APIActor{ID: "web"}})
+
+	if len(m.pending) != 2 {
+		t.Fatalf("expected the start and end of the container with GPUs, got %v", m.pending)
+	}
+
+	for i, testDatas := range []map[string]interface{}{
+		{
+			"action":         actionStart,
+			"timestamp":      common.Time(started),
+			"container.id":   "train",
+			"container.name": "train",
+			"gpu.count":      2,
+		},
+		{
+			"action":                 actionEnd,
+			"exit_code":              137,
+			"allocation.duration.ms": int64(60000),
+		},
+	} {
+		for key, expected := range testDatas {
+			value, err := m.pending[i].GetValue(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != expected {
+				t.Fatalf("%s: expected %v, got %v", key, expected, value)
+			}
+		}
+		if uuids, _ := m.pending[i].GetValue("gpu.uuids"); len(uuids.([]string)) != 2 {
+			t.Fatalf("unexpected GPUs %v", uuids)
+		}
+		fieldstest.CheckEvent(t, m.pending[i])
+	}
+
+	// The events are dropped once the MetricSet stopped fetching.
+	m.pending = nil
+	m.fetched = time.Now().Add(-time.Hour)
+	m.handle(&docker.APIEvents{Type: "container", Action: "start", Actor: docker.APIActor{ID: "train"}})
+	if len(m.pending) != 0 {
+		t.Fatalf("expected no events, got %v", m.pending)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	client := &mockContainerClient{containers: map[string]*docker.Container{
+		"train": gpuContainer("train", "/dev/nvidia0"),
+	}}
+	m := &MetricSet{
+		devices: []nvidiadocker.DeviceStatus{{UUID: "GPU-0"}},
+		client:  client,
+		source:  client,
+		period:  10 * time.Second,
+		grants: map[string]grant{
+			// Stopped while the container events were not followed.
+			"gone": {uuids: []string{"GPU-0"}, since: time.Now().Add(-time.Hour)},
+			// Started from an event received while listing the containers.
+			"new": {uuids: []string{"GPU-0"}, since: time.Now().Add(time.Second)},
+		},
+	}
+
+	if err := m.follow(); err != nil {
+		t.Fatal(err)
+	}
+	if client.listener == nil || !m.following {
+		t.Fatal("expected the container events to be followed")
+	}
+	if g := m.grants["train"]; len(g.uuids) != 1 || g.uuids[0] != "GPU-0" {
+		t.Fatalf("expected the running container to be recorded, got %v", m.grants)
+	}
+	if _, found := m.grants["gone"]; found {
+		t.Fatalf("expected the stopped container to be dropped, got %v", m.grants)
+	}
+	if _, found := m.grants["new"]; !found {
+		t.Fatalf("expected the container started since to be kept, got %v", m.grants)
+	}
+
+	// A container running before the first fetch is reported when it dies.
+	m.handle(&docker.APIEvents{Type: "container", Action: "die", Actor: docker.APIActor{ID: "train"}})
+	if len(m.pending) != 1 || m.pending[0]["action"] != actionEnd {
+		t.Fatalf("expected the end of the running container, got %v", m.pending)
+	}
+}
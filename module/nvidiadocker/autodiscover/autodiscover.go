@@ -0,0 +1,323 @@
+package autodiscover
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "autodiscover", New); err != nil {
+		panic(err)
+	}
+}
+
+// Actions of the autodiscover events.
+const (
+	actionStart = "start"
+	actionStop  = "stop"
+)
+
+// configPrefix and configSuffix name the configuration files written for the
+// containers with GPUs, configPrefix<container ID>configSuffix.
+const (
+	configPrefix = "nvidiadocker-"
+	configSuffix = ".yml"
+)
+
+// MetricSet discovers the containers with GPUs, for the other beats to attach
+// configurations, like log collection, to the GPU workloads only. It reports a
+// start event for every container with GPUs found running and a stop event
+// once it stopped, and writes a configuration rendered from a template for
+// every running container with GPUs to a directory the other beats reload
+// their configurations from. The containers follow the events of the Docker
+// daemon, so a fetch only inspects the containers that started since the
+// previous one.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector  nvidiadocker.GPUCollector
+	containers *nvidiadocker.ContainerCache
+	labels     nvidiadocker.LabelsConfig
+	versions   *nvidiadocker.VersionCache
+	driver     *nvidiadocker.DriverCheck
+	gate       *nvidiadocker.FetchGate
+	backend    *nvidiadocker.Backend
+	hostFS     string
+
+	// kubeletCheckpoint is read when containers started for the GPUs the
+	// kubelet allocated to the containers of pods.
+	kubeletCheckpoint string
+
+	// configs writes the configurations of the containers, nil if no path
+	// is set.
+	configs *configWriter
+
+	// discovered holds the running containers by ID, with the containers
+	// without GPUs so that they are only matched once.
+	discovered map[string]*discovered
+}
+
+// discovered is a running container and the UUIDs of its GPUs, empty if it
+// has none.
+type discovered struct {
+	container common.MapStr
+	uuids     []string
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := nvidiadocker.NewContainerClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs *configWriter
+	if config.Autodiscover.Path != "" {
+		if configs, err = newConfigWriter(config.Autodiscover); err != nil {
+			return nil, err
+		}
+	}
+
+	gate := nvidiadocker.NewFetchGate(config, base)
+	return &MetricSet{
+		BaseMetricSet:     base,
+		collector:         collector,
+		containers:        nvidiadocker.NewContainerCache(client, nvidiadocker.NewContainerFilter(config), gate.Period()),
+		labels:            config.Labels,
+		versions:          nvidiadocker.NewVersionCache(collector),
+		driver:            nvidiadocker.NewDriverCheck(config),
+		gate:              gate,
+		kubeletCheckpoint: config.KubeletCheckpointPath(),
+		backend:           config.ContainerBackend(),
+		hostFS:            config.HostFS,
+		configs:           configs,
+		discovered:        map[string]*discovered{},
+	}, nil
+}
+
+// Fetch reports the containers with GPUs that started or stopped since the
+// previous fetch, and writes their configurations. The containers running on
+// the first fetch are reported as started.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	containers, _, err := m.containers.Containers(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := m.discover(containers)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.writeConfigs(); err != nil {
+		return nil, err
+	}
+
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+// discover matches the GPUs of the containers that started since the
+// previous fetch, and returns the start events of the ones with GPUs and the
+// stop events of the containers with GPUs that stopped. The GPUs are only
+// queried if a container started.
+func (m *MetricSet) discover(containers []*nvidiadocker.CachedContainer) ([]common.MapStr, error) {
+	running := make(map[string]bool, len(containers))
+	var started []*nvidiadocker.CachedContainer
+	for _, c := range containers {
+		running[c.Container.ID] = true
+		if _, found := m.discovered[c.Container.ID]; !found {
+			started = append(started, c)
+		}
+	}
+
+	events := []common.MapStr{}
+	if len(started) > 0 {
+		gpuDevices, err := m.collector.Query(nil)
+		if err != nil {
+			return nil, err
+		}
+		allocations := nvidiadocker.LoadKubeletAllocations(m.kubeletCheckpoint)
+		for _, c := range started {
+			d := &discovered{container: containerMapping(c.Container, m.labels)}
+			for _, index := range m.backend.ContainerDeviceIndices(c.Container, c.Runtime, allocations, gpuDevices, m.hostFS) {
+				d.uuids = append(d.uuids, gpuDevices[index].UUID)
+			}
+			m.discovered[c.Container.ID] = d
+			if len(d.uuids) > 0 {
+				events = append(events, m.eventMapping(actionStart, c.Container.ID, d))
+			}
+		}
+	}
+
+	var stopped []string
+	for id := range m.discovered {
+		if !running[id] {
+			stopped = append(stopped, id)
+		}
+	}
+	sort.Strings(stopped)
+	for _, id := range stopped {
+		d := m.discovered[id]
+		delete(m.discovered, id)
+		if len(d.uuids) > 0 {
+			events = append(events, m.eventMapping(actionStop, id, d))
+		}
+	}
+	return events, nil
+}
+
+// writeConfigs writes the configurations of the running containers with GPUs
+// if a path is set.
+func (m *MetricSet) writeConfigs() error {
+	if m.configs == nil {
+		return nil
+	}
+	running := map[string]*discovered{}
+	for id, d := range m.discovered {
+		if len(d.uuids) > 0 {
+			running[id] = d
+		}
+	}
+	return m.configs.sync(running)
+}
+
+func (m *MetricSet) eventMapping(action, id string, d *discovered) common.MapStr {
+	event := common.MapStr{
+		"action":    action,
+		"container": d.container,
+		"gpu": common.MapStr{
+			"count": len(d.uuids),
+			"uuids": d.uuids,
+		},
+	}
+	if m.configs != nil && action == actionStart {
+		event["config"] = m.configs.file(id)
+	}
+	return event
+}
+
+func containerMapping(container *docker.Container, labels nvidiadocker.LabelsConfig) common.MapStr {
+	event := common.MapStr{
+		"id":   container.ID,
+		"name": strings.TrimPrefix(container.Name, "/"),
+	}
+	if image := nvidiadocker.Image(container); image != nil {
+		event["image"] = image
+	}
+	if container.Config != nil {
+		event["labels"] = labels.Labels(container.Config.Labels)
+	}
+	return event
+}
+
+// configWriter writes the configurations of the running containers with GPUs
+// to a directory, one file per container, and removes the ones of the
+// containers that stopped.
+type configWriter struct {
+	path     string
+	template *template.Template
+}
+
+func newConfigWriter(config nvidiadocker.AutodiscoverConfig) (*configWriter, error) {
+	if config.Template == "" {
+		return nil, fmt.Errorf("autodiscover.template is required with autodiscover.path")
+	}
+	t, err := template.New("autodiscover").Funcs(template.FuncMap{"join": strings.Join}).Option("missingkey=error").Parse(config.Template)
+	if err != nil {
+		return nil, fmt.Errorf("autodiscover.template: %v", err)
+	}
+	if err := os.MkdirAll(config.Path, 0755); err != nil {
+		return nil, fmt.Errorf("autodiscover.path: %v", err)
+	}
+	return &configWriter{path: config.Path, template: t}, nil
+}
+
+func (w *configWriter) file(id string) string {
+	return filepath.Join(w.path, configPrefix+id+configSuffix)
+}
+
+// sync writes the configurations of the given containers that are missing and
+// removes the ones of the other containers, including the ones left behind by
+// a previous run of the beat.
+func (w *configWriter) sync(containers map[string]*discovered) error {
+	files, err := filepath.Glob(filepath.Join(w.path, configPrefix+"*"+configSuffix))
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, file := range files {
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), configPrefix), configSuffix)
+		if _, found := containers[id]; found {
+			existing[id] = true
+			continue
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for id, d := range containers {
+		if existing[id] {
+			continue
+		}
+		if err := w.write(id, d); err != nil {
+			logp.Err("Cannot write the autodiscover configuration of container %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// write renders the configuration of the container. It is written under
+// another name first and then renamed, so that a beat reloading the directory
+// never reads a partial file.
+func (w *configWriter) write(id string, d *discovered) error {
+	var buf bytes.Buffer
+	data := map[string]interface{}{
+		"container": d.container,
+		"gpu": map[string]interface{}{
+			"count": len(d.uuids),
+			"uuids": d.uuids,
+		},
+	}
+	if err := w.template.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(w.path, "."+configPrefix+id+".tmp")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.file(id))
+}
@@ -0,0 +1,151 @@
+package autodiscover
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+type mockCollector struct {
+	devices []nvidiadocker.DeviceStatus
+	queries int
+}
+
+func (c *mockCollector) List() ([]uint, error) {
+	return nil, nil
+}
+
+func (c *mockCollector) Query(indices []uint) ([]nvidiadocker.DeviceStatus, error) {
+	c.queries++
+	return c.devices, nil
+}
+
+type mockContainerClient struct {
+	containers map[string]*docker.Container
+}
+
+func (c *mockContainerClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	var containers []docker.APIContainers
+	for id := range c.containers {
+		containers = append(containers, docker.APIContainers{ID: id})
+	}
+	return containers, nil
+}
+
+func (c *mockContainerClient) InspectContainer(id string) (*docker.Container, error) {
+	return c.containers[id], nil
+}
+
+func (c *mockContainerClient) InspectContainerWithRuntime(id string) (*docker.Container, *nvidiadocker.ContainerRuntime, error) {
+	container, found := c.containers[id]
+	if !found {
+		return nil, nil, &docker.NoSuchContainer{ID: id}
+	}
+	return container, nil, nil
+}
+
+func gpuContainer(id string, devices ...string) *docker.Container {
+	container := &docker.Container{
+		ID:         id,
+		Name:       "/" + id,
+		State:      docker.State{Running: true},
+		HostConfig: &docker.HostConfig{},
+		Config:     &docker.Config{Image: "nvcr.io/nvidia/pytorch:23.10-py3", Labels: map[string]string{"team": "research"}},
+	}
+	for _, device := range devices {
+		container.HostConfig.Devices = append(container.HostConfig.Devices, docker.Device{PathOnHost: device, PathInContainer: device})
+	}
+	return container
+}
+
+func TestDiscover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autodiscover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Left behind by a container that stopped while the beat was not running.
+	stale := filepath.Join(dir, "nvidiadocker-gone.yml")
+	if err := ioutil.WriteFile(stale, []byte("- input_type: log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := newConfigWriter(nvidiadocker.AutodiscoverConfig{
+		Path:     dir,
+		Template: `- paths: ["/var/lib/docker/containers/{{.container.id}}/*.log"]` + "\n" + `  fields.gpu: "{{join .gpu.uuids ","}}"` + "\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index0, index1 := uint(0), uint(1)
+	collector := &mockCollector{devices: []nvidiadocker.DeviceStatus{
+		{Index: &index0, UUID: "GPU-0"},
+		{Index: &index1, UUID: "GPU-1"},
+	}}
+	client := &mockContainerClient{containers: map[string]*docker.Container{
+		"train": gpuContainer("train", "/dev/nvidia0", "/dev/nvidia1"),
+		"web":   gpuContainer("web"),
+	}}
+	m := &MetricSet{
+		collector:  collector,
+		containers: nvidiadocker.NewContainerCache(client, nil, 0),
+		backend:    nvidiadocker.BackendOf(nvidiadocker.GPUSourceSMI),
+		configs:    configs,
+		discovered: map[string]*discovered{},
+	}
+
+	fetch := func() []common.MapStr {
+		containers, _, err := m.containers.Containers(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, err := m.discover(containers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.writeConfigs(); err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			fieldstest.CheckEvent(t, event)
+		}
+		return events
+	}
+
+	events := fetch()
+	if len(events) != 1 || events[0]["action"] != actionStart || events[0]["config"] != configs.file("train") {
+		t.Fatalf("expected the start of the container with GPUs, got %v", events)
+	}
+	config, err := ioutil.ReadFile(configs.file("train"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config) != "- paths: [\"/var/lib/docker/containers/train/*.log\"]\n  fields.gpu: \"GPU-0,GPU-1\"\n" {
+		t.Fatalf("unexpected configuration %q", config)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected the stale configuration to be removed, got %v", err)
+	}
+
+	// The GPUs are only queried when containers started.
+	if events := fetch(); len(events) != 0 || collector.queries != 1 {
+		t.Fatalf("expected no events and no query, got %v after %d queries", events, collector.queries)
+	}
+
+	delete(client.containers, "train")
+	events = fetch()
+	if len(events) != 1 || events[0]["action"] != actionStop {
+		t.Fatalf("expected the stop of the container with GPUs, got %v", events)
+	}
+	if _, err := os.Stat(configs.file("train")); !os.IsNotExist(err) {
+		t.Fatalf("expected the configuration to be removed, got %v", err)
+	}
+}
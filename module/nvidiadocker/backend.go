@@ -0,0 +1,68 @@
+package nvidiadocker
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Backend is a GPU vendor. The GPU sources registered with the backend read
+// the GPUs of the vendor, while the backend tells whether the driver of the
+// vendor is loaded and which GPUs the devices and the container runtime of
+// the vendor give a container. The matching shared by all vendors, like the
+// kubelet allocations, is done by ContainerDeviceIndices.
+type Backend struct {
+	// Vendor names the vendor in the logs.
+	Vendor string
+
+	// DriverFile exists on the host while the kernel driver of the vendor is
+	// loaded. The driver is not checked if it is empty.
+	DriverFile string
+
+	// ResourcePrefix prefixes the Kubernetes resources advertised by the
+	// device plugin of the vendor, like nvidia.com/ for nvidia.com/gpu.
+	ResourcePrefix string
+
+	// ContainerDevices returns the positions in gpuDevices of the GPUs the
+	// container was given, in any order and possibly repeated. Files of the
+	// host are read from under hostFS.
+	ContainerDevices func(container *docker.Container, runtime *ContainerRuntime, gpuDevices []DeviceStatus, hostFS string) []int
+
+	// RemoteContainerDevices is ContainerDevices for the containers of a
+	// remote host, reading only the container configuration, not the files
+	// of the local host. No GPU of a remote container is matched if it is
+	// nil.
+	RemoteContainerDevices func(container *docker.Container, runtime *ContainerRuntime, gpuDevices []DeviceStatus, hostFS string) []int
+}
+
+// The backends of the GPU vendors supported by the GPU sources.
+var (
+	NVIDIABackend = &Backend{
+		Vendor:           "NVIDIA",
+		DriverFile:       driverVersionFile,
+		ResourcePrefix:   "nvidia.com/",
+		ContainerDevices: nvidiaContainerDevices,
+
+		RemoteContainerDevices: nvidiaConfigDevices,
+	}
+	AMDBackend = &Backend{
+		Vendor:           "AMD",
+		DriverFile:       "/sys/module/amdgpu",
+		ResourcePrefix:   "amd.com/",
+		ContainerDevices: RenderNodeDevices,
+	}
+)
+
+// Remote returns the backend matching the GPUs of the containers of a remote
+// host with RemoteContainerDevices, the NVIDIA backend if b is nil.
+func (b *Backend) Remote() *Backend {
+	if b == nil {
+		b = NVIDIABackend
+	}
+	remote := *b
+	remote.ContainerDevices = b.RemoteContainerDevices
+	if remote.ContainerDevices == nil {
+		remote.ContainerDevices = func(*docker.Container, *ContainerRuntime, []DeviceStatus, string) []int {
+			return nil
+		}
+	}
+	return &remote
+}
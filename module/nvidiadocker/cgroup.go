@@ -0,0 +1,137 @@
+package nvidiadocker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nvidiaDeviceMajor is the major number of the /dev/nvidiaN character devices.
+// Minor numbers up to nvidiaMaxGPUMinor are GPUs, the ones above are control
+// devices such as /dev/nvidiactl.
+const (
+	nvidiaDeviceMajor = 195
+	nvidiaMaxGPUMinor = 253
+)
+
+var containerIDRegexp = regexp.MustCompile("[0-9a-f]{64}")
+
+// ContainerIDFromPID returns the ID of the container the process with
+// the given PID runs in, or an empty string if it does not run in a container.
+// /proc is read from under hostFS.
+func ContainerIDFromPID(hostFS string, pid uint) (string, error) {
+	f, err := os.Open(HostPath(hostFS, fmt.Sprintf("/proc/%d/cgroup", pid)))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return containerIDFromCgroup(f)
+}
+
+// containerIDFromCgroup extracts the container ID from the content of a
+// /proc/<pid>/cgroup file. Both the cgroupfs (/docker/<id>) and the systemd
+// (/system.slice/docker-<id>.scope) layouts are supported.
+func containerIDFromCgroup(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if id := containerIDRegexp.FindString(scanner.Text()); id != "" {
+			return id, nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// CgroupDevices returns the positions in devices of the GPUs the devices
+// cgroup of the process with the given PID allows access to. It covers
+// containers granted GPUs through device cgroup rules instead of explicit
+// device mappings. Only the cgroup v1 devices controller is supported: with
+// cgroup v2 the rules are an eBPF program and no devices are returned. /proc
+// and /sys are read from under hostFS.
+func CgroupDevices(hostFS string, pid int, devices []DeviceStatus) ([]int, error) {
+	f, err := os.Open(HostPath(hostFS, fmt.Sprintf("/proc/%d/cgroup", pid)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	path, err := cgroupControllerPath(f, "devices")
+	if err != nil || path == "" {
+		return nil, err
+	}
+
+	list, err := os.Open(HostPath(hostFS, filepath.Join("/sys/fs/cgroup/devices", path, "devices.list")))
+	if err != nil {
+		return nil, err
+	}
+	defer list.Close()
+
+	all, minors, err := parseDevicesList(list)
+	if err != nil {
+		return nil, err
+	}
+	if all {
+		return resolveDevices([]string{"all"}, devices), nil
+	}
+	return minorPositions(minors, devices), nil
+}
+
+// cgroupControllerPath returns the cgroup path of the given controller from
+// the content of a /proc/<pid>/cgroup file.
+func cgroupControllerPath(r io.Reader, controller string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			if c == controller {
+				return parts[2], nil
+			}
+		}
+	}
+	return "", scanner.Err()
+}
+
+// parseDevicesList parses a cgroup v1 devices.list file and returns whether
+// all GPUs are allowed, or else the minor numbers of the allowed GPUs.
+func parseDevicesList(r io.Reader) (bool, []uint, error) {
+	var minors []uint
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Entries look like "c 195:0 rwm" or "a *:* rwm".
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "a" {
+			return true, nil, nil
+		}
+		if fields[0] != "c" {
+			continue
+		}
+
+		numbers := strings.SplitN(fields[1], ":", 2)
+		if len(numbers) != 2 {
+			continue
+		}
+		if numbers[0] != "*" && numbers[0] != strconv.Itoa(nvidiaDeviceMajor) {
+			continue
+		}
+		if numbers[1] == "*" {
+			return true, nil, nil
+		}
+		minor, err := strconv.ParseUint(numbers[1], 10, 64)
+		if err != nil || minor > nvidiaMaxGPUMinor {
+			continue
+		}
+		minors = append(minors, uint(minor))
+	}
+	return false, minors, scanner.Err()
+}
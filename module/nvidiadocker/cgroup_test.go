@@ -0,0 +1,83 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContainerIDFromCgroup(t *testing.T) {
+	testDatas := []struct {
+		Cgroup      string
+		ContainerID string
+	}{
+		{
+			"11:devices:/docker/4e3bb646c7ff48078295daccfdbc5a344e3bb646c7ff48078295daccfdbc5a34\n" +
+				"1:name=systemd:/docker/4e3bb646c7ff48078295daccfdbc5a344e3bb646c7ff48078295daccfdbc5a34\n",
+			"4e3bb646c7ff48078295daccfdbc5a344e3bb646c7ff48078295daccfdbc5a34",
+		},
+		{
+			"0::/system.slice/docker-ca766152aa55425fb6fcb84319732915ca766152aa55425fb6fcb84319732915.scope\n",
+			"ca766152aa55425fb6fcb84319732915ca766152aa55425fb6fcb84319732915",
+		},
+		{
+			"11:devices:/user.slice\n1:name=systemd:/user.slice/user-1000.slice/session-2.scope\n",
+			"",
+		},
+	}
+
+	for _, testData := range testDatas {
+		id, err := containerIDFromCgroup(strings.NewReader(testData.Cgroup))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != testData.ContainerID {
+			t.Fatalf("expected %q, got %q", testData.ContainerID, id)
+		}
+	}
+}
+
+func TestCgroupControllerPath(t *testing.T) {
+	cgroup := "12:cpu,cpuacct:/docker/abc\n" +
+		"11:devices:/system.slice/docker-abc.scope\n" +
+		"1:name=systemd:/system.slice/docker-abc.scope\n"
+
+	path, err := cgroupControllerPath(strings.NewReader(cgroup), "devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/system.slice/docker-abc.scope" {
+		t.Fatalf("unexpected path %q", path)
+	}
+
+	path, err = cgroupControllerPath(strings.NewReader("0::/system.slice/docker-abc.scope\n"), "devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "" {
+		t.Fatalf("unexpected path %q", path)
+	}
+}
+
+func TestParseDevicesList(t *testing.T) {
+	testDatas := []struct {
+		List   string
+		All    bool
+		Minors []uint
+	}{
+		{"c 1:3 rwm\nc 195:255 rw\nc 195:0 rw\nc 195:2 rw\n", false, []uint{0, 2}},
+		{"c 1:3 rwm\nb *:* m\n", false, nil},
+		{"c 195:* rwm\n", true, nil},
+		{"a *:* rwm\n", true, nil},
+	}
+
+	for _, testData := range testDatas {
+		all, minors, err := parseDevicesList(strings.NewReader(testData.List))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if all != testData.All || !reflect.DeepEqual(minors, testData.Minors) {
+			t.Fatalf("%q: unexpected result %v %v", testData.List, all, minors)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GPUCollector reads the status of the GPUs of a host. Implementations are
+// registered with AddCollector and selected with the gpu_source option.
+type GPUCollector interface {
+	// List returns the indices of all GPUs available on the host.
+	List() ([]uint, error)
+
+	// Query returns the status of the GPUs with the given indices. A nil
+	// slice queries all GPUs, ordered by index.
+	Query(indices []uint) ([]DeviceStatus, error)
+}
+
+// ProcessCollector is implemented by GPUCollectors that can list the compute
+// processes running on the GPUs.
+type ProcessCollector interface {
+	// Processes returns the compute processes of all GPUs.
+	Processes() ([]ProcessInfo, error)
+}
+
+// HealthCollector is implemented by GPUCollectors that can report the memory
+// health of the GPUs.
+type HealthCollector interface {
+	// Health returns the memory health of all GPUs.
+	Health() ([]DeviceHealth, error)
+}
+
+// XIDCollector is implemented by GPUCollectors that can report the XID errors
+// of the driver.
+type XIDCollector interface {
+	// XIDEvents returns the XID errors that occurred since the previous call.
+	// The first call starts listening and returns no errors.
+	XIDEvents() ([]XIDEvent, error)
+}
+
+// VersionCollector is implemented by GPUCollectors that can report the driver
+// and CUDA versions.
+type VersionCollector interface {
+	// Versions returns the driver and CUDA versions of the host.
+	Versions() (Versions, error)
+}
+
+// MIGCollector is implemented by GPUCollectors that can report the MIG
+// devices of the GPUs in MIG mode.
+type MIGCollector interface {
+	// MIGDevices returns the MIG devices of all GPUs.
+	MIGDevices() ([]MIGDevice, error)
+}
+
+// VGPUCollector is implemented by GPUCollectors that can report the vGPUs of
+// a vGPU host.
+type VGPUCollector interface {
+	// VGPUs returns the active vGPUs of all GPUs.
+	VGPUs() ([]VGPU, error)
+}
+
+// NVLinkCollector is implemented by GPUCollectors that can report the NVLink
+// counters of the GPUs.
+type NVLinkCollector interface {
+	// NVLinks returns the NVLinks of all GPUs.
+	NVLinks() ([]NVLink, error)
+}
+
+// TopologyCollector is implemented by GPUCollectors that can report how the
+// GPUs are connected.
+type TopologyCollector interface {
+	// Topology returns the topology of all GPUs.
+	Topology() ([]GPUTopology, error)
+}
+
+// FabricCollector is implemented by GPUCollectors that can report the state
+// of the GPUs in the NVLink fabric of NVSwitch systems.
+type FabricCollector interface {
+	// GPUFabric returns the fabric state of the GPUs attached to NVSwitches.
+	GPUFabric() ([]GPUFabric, error)
+}
+
+// NVSwitchCollector is implemented by GPUCollectors that can report the
+// errors of the NVSwitches of the host.
+type NVSwitchCollector interface {
+	// NVSwitches returns all NVSwitches of the host.
+	NVSwitches() ([]NVSwitch, error)
+}
+
+// AccountingCollector is implemented by GPUCollectors that can report the
+// processes recorded by the driver in accounting mode.
+type AccountingCollector interface {
+	// AccountedProcesses returns the running and finished processes recorded
+	// on all GPUs with accounting mode enabled.
+	AccountedProcesses() ([]AccountedProcess, error)
+}
+
+// InventoryCollector is implemented by GPUCollectors that can report the
+// serial numbers, firmware versions and hardware specification of the GPUs.
+type InventoryCollector interface {
+	// Inventory returns the inventory of all GPUs, ordered by index.
+	Inventory() ([]DeviceInventory, error)
+}
+
+// CollectorFactory creates a GPUCollector from the module configuration.
+type CollectorFactory func(config Config) (GPUCollector, error)
+
+var (
+	collectors = map[string]CollectorFactory{}
+	backends   = map[string]*Backend{}
+)
+
+// AddCollector registers a CollectorFactory under the given gpu_source name,
+// reading the GPUs of the vendor of backend. An error is returned if a
+// factory has already been registered under the name.
+func AddCollector(name string, backend *Backend, factory CollectorFactory) error {
+	if name == "" {
+		return fmt.Errorf("collector name is required")
+	}
+	name = strings.ToLower(name)
+	if _, exists := collectors[name]; exists {
+		return fmt.Errorf("collector '%s' is already registered", name)
+	}
+	if backend == nil || backend.ContainerDevices == nil {
+		return fmt.Errorf("collector '%s' cannot be registered without a backend", name)
+	}
+	if factory == nil {
+		return fmt.Errorf("collector '%s' cannot be registered with a nil factory", name)
+	}
+	collectors[name] = factory
+	backends[name] = backend
+	return nil
+}
+
+// BackendOf returns the backend of the vendor the given gpu_source reads the
+// GPUs of, or nil if no GPU source is registered under the name.
+func BackendOf(gpuSource string) *Backend {
+	return backends[strings.ToLower(gpuSource)]
+}
+
+// ContainerBackend returns the backend matching the GPUs of the containers of
+// the configured host, only by the container configuration on a remote host.
+func (c Config) ContainerBackend() *Backend {
+	backend := BackendOf(c.GPUSource)
+	if !c.LocalHost() {
+		return backend.Remote()
+	}
+	return backend
+}
+
+// NewCollector creates the GPUCollector selected by the gpu_source option.
+func NewCollector(config Config) (GPUCollector, error) {
+	factory, found := collectors[strings.ToLower(config.GPUSource)]
+	if !found {
+		names := make([]string, 0, len(collectors))
+		for name := range collectors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown gpu_source '%s', must be one of %s",
+			config.GPUSource, strings.Join(names, ", "))
+	}
+	return factory(config)
+}
+
+// filterDevices returns the devices with the given indices, in the order the
+// indices are given. A nil slice returns all devices.
+func filterDevices(devices []DeviceStatus, indices []uint) ([]DeviceStatus, error) {
+	if indices == nil {
+		return devices, nil
+	}
+
+	filtered := make([]DeviceStatus, 0, len(indices))
+	for _, index := range indices {
+		if int(index) >= len(devices) {
+			return nil, fmt.Errorf("no GPU with index %d", index)
+		}
+		filtered = append(filtered, devices[index])
+	}
+	return filtered, nil
+}
+
+// deviceProcesses returns the processes attached to the given devices, tagged
+// with the device they run on.
+func deviceProcesses(devices []DeviceStatus) []ProcessInfo {
+	var processes []ProcessInfo
+	for _, device := range devices {
+		for _, process := range device.Processes {
+			process.GPUUUID = device.UUID
+			process.GPUIndex = device.Index
+			processes = append(processes, process)
+		}
+	}
+	return processes
+}
+
+func indexList(count int) []uint {
+	indices := make([]uint, count)
+	for i := range indices {
+		indices[i] = uint(i)
+	}
+	return indices
+}
+
+func toUintP(val uint) *uint {
+	return &val
+}
+
+func toBoolP(val bool) *bool {
+	return &val
+}
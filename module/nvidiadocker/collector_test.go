@@ -0,0 +1,67 @@
+package nvidiadocker
+
+import (
+	"testing"
+)
+
+type mockCollector struct {
+	devices []DeviceStatus
+}
+
+func (c *mockCollector) List() ([]uint, error) {
+	return indexList(len(c.devices)), nil
+}
+
+func (c *mockCollector) Query(indices []uint) ([]DeviceStatus, error) {
+	return filterDevices(c.devices, indices)
+}
+
+func TestNewCollector(t *testing.T) {
+	mock := &mockCollector{
+		devices: []DeviceStatus{
+			{Index: toUintP(0), Temperature: 30},
+			{Index: toUintP(1), Temperature: 40},
+		},
+	}
+	if err := AddCollector("mock", NVIDIABackend, func(config Config) (GPUCollector, error) {
+		return mock, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(collectors, "mock")
+	defer delete(backends, "mock")
+
+	if err := AddCollector("mock", NVIDIABackend, newAPICollector); err == nil {
+		t.Fatal("expected error on duplicate registration")
+	}
+	if err := AddCollector("nobackend", nil, newAPICollector); err == nil {
+		t.Fatal("expected error on registration without backend")
+	}
+	if BackendOf("MOCK") != NVIDIABackend || BackendOf(GPUSourceROCm) != AMDBackend {
+		t.Fatal("unexpected backends")
+	}
+
+	config := DefaultConfig()
+	config.GPUSource = "mock"
+	collector, err := NewCollector(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err := collector.Query([]uint{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Temperature != 40 {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+
+	if _, err := collector.Query([]uint{2}); err == nil {
+		t.Fatal("expected error for unknown index")
+	}
+
+	config.GPUSource = "unknown"
+	if _, err := NewCollector(config); err == nil {
+		t.Fatal("expected error for unknown gpu_source")
+	}
+}
@@ -0,0 +1,266 @@
+package condition
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "condition", New); err != nil {
+		panic(err)
+	}
+}
+
+// Names of the conditions the MetricSet reports.
+const (
+	conditionThermalThrottleBegan = "thermal_throttle_began"
+	conditionThermalThrottleEnded = "thermal_throttle_ended"
+	conditionECCError             = "ecc_error"
+	conditionFellOffBus           = "fell_off_bus"
+	conditionBackOnBus            = "back_on_bus"
+)
+
+// MetricSet reports one event per change of condition of a GPU since the
+// previous fetch, like the start of thermal throttling, with the containers
+// using the GPU.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector  nvidiadocker.GPUCollector
+	containers *nvidiadocker.ContainerCache
+	versions   *nvidiadocker.VersionCache
+	driver     *nvidiadocker.DriverCheck
+	gate       *nvidiadocker.FetchGate
+	backend    *nvidiadocker.Backend
+	hostFS     string
+
+	// kubeletCheckpoint is read on every fetch for the GPUs the kubelet
+	// allocated to the containers of pods.
+	kubeletCheckpoint string
+
+	tracker *conditionTracker
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	containerClient, err := nvidiadocker.NewContainerClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	gate := nvidiadocker.NewFetchGate(config, base)
+	return &MetricSet{
+		BaseMetricSet:     base,
+		collector:         collector,
+		containers:        nvidiadocker.NewContainerCache(containerClient, nvidiadocker.NewContainerFilter(config), gate.Period()),
+		versions:          nvidiadocker.NewVersionCache(collector),
+		driver:            nvidiadocker.NewDriverCheck(config),
+		gate:              gate,
+		kubeletCheckpoint: config.KubeletCheckpointPath(),
+		backend:           config.ContainerBackend(),
+		hostFS:            config.HostFS,
+		tracker:           newConditionTracker(),
+	}, nil
+}
+
+// Fetch returns one event per condition of a GPU that began or ended since
+// the previous fetch. The first fetch only records the conditions.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	trace := nvidiadocker.NewFetchTrace(m.BaseMetricSet)
+	gpuDevices, err := m.collector.Query(nil)
+	if err != nil {
+		return nil, err
+	}
+	trace.Phase("gpu_query", "gpus", len(gpuDevices))
+
+	events := m.tracker.update(gpuDevices, m.deviceContainers(trace, gpuDevices))
+	m.versions.AddTo(events)
+	trace.Done(len(events))
+	return events, nil
+}
+
+// deviceContainers returns the containers using every GPU, by position in
+// gpuDevices. A failure to list the containers only leaves the conditions
+// without containers.
+func (m *MetricSet) deviceContainers(trace *nvidiadocker.FetchTrace, gpuDevices []nvidiadocker.DeviceStatus) map[int][]common.MapStr {
+	cached, _, err := m.containers.Containers(trace)
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot list the containers of the GPU conditions: %v", err)
+		return nil
+	}
+
+	allocations := nvidiadocker.LoadKubeletAllocations(m.kubeletCheckpoint)
+	containers := map[int][]common.MapStr{}
+	for _, c := range cached {
+		container := common.MapStr{"id": c.Container.ID}
+		if name := strings.TrimPrefix(c.Container.Name, "/"); name != "" {
+			container["name"] = name
+		}
+		for _, index := range m.backend.ContainerDeviceIndices(c.Container, c.Runtime, allocations, gpuDevices, m.hostFS) {
+			containers[index] = append(containers[index], container)
+		}
+	}
+	trace.Phase("attribute", "containers", len(cached))
+	return containers
+}
+
+// gpuState is the condition of a GPU on the previous fetch.
+type gpuState struct {
+	gpu        common.MapStr
+	containers []common.MapStr
+	throttled  bool
+	ecc        nvidiadocker.ECCErrorCounts
+	missing    bool
+}
+
+// conditionTracker compares the conditions of the GPUs with the ones of the
+// previous fetch. GPUs are identified by UUID, GPUs without one are not
+// tracked.
+type conditionTracker struct {
+	started bool
+	gpus    map[string]*gpuState
+}
+
+func newConditionTracker() *conditionTracker {
+	return &conditionTracker{gpus: map[string]*gpuState{}}
+}
+
+// update records the conditions of the GPUs of the current fetch, as returned
+// by a query of all GPUs, with the containers using them by position, and
+// returns the events of the conditions that changed since the previous
+// fetch. Nothing is returned on the first fetch.
+func (t *conditionTracker) update(devices []nvidiadocker.DeviceStatus, containers map[int][]common.MapStr) []common.MapStr {
+	events := []common.MapStr{}
+	seen := map[string]bool{}
+	for i := range devices {
+		device := &devices[i]
+		if device.UUID == "" {
+			continue
+		}
+		seen[device.UUID] = true
+
+		state := &gpuState{
+			gpu:        gpuMapping(device),
+			containers: containers[i],
+			throttled:  device.ThrottleReasons.HWThermalSlowdown || device.ThrottleReasons.SWThermalSlowdown,
+			ecc:        device.ECC.Volatile,
+		}
+		previous, found := t.gpus[device.UUID]
+		t.gpus[device.UUID] = state
+		if !found {
+			continue
+		}
+
+		if previous.missing {
+			events = append(events, conditionEvent(conditionBackOnBus, state,
+				fmt.Sprintf("GPU %s is back on the bus", device.UUID)))
+		}
+		switch {
+		case state.throttled && !previous.throttled:
+			events = append(events, conditionEvent(conditionThermalThrottleBegan, state,
+				fmt.Sprintf("GPU %s started thermal throttling at %d C", device.UUID, device.Temperature)))
+		case !state.throttled && previous.throttled:
+			events = append(events, conditionEvent(conditionThermalThrottleEnded, state,
+				fmt.Sprintf("GPU %s stopped thermal throttling at %d C", device.UUID, device.Temperature)))
+		}
+
+		// Counters nvidia-smi reported an invalid value for keep their last
+		// valid value, lower counters were reset with the driver.
+		if _, invalid := device.InvalidFields["ecc.errors.corrected.volatile.total"]; invalid {
+			state.ecc.SingleBit = previous.ecc.SingleBit
+		}
+		if _, invalid := device.InvalidFields["ecc.errors.uncorrected.volatile.total"]; invalid {
+			state.ecc.DoubleBit = previous.ecc.DoubleBit
+		}
+		var singleBit, doubleBit uint64
+		if state.ecc.SingleBit > previous.ecc.SingleBit {
+			singleBit = state.ecc.SingleBit - previous.ecc.SingleBit
+		}
+		if state.ecc.DoubleBit > previous.ecc.DoubleBit {
+			doubleBit = state.ecc.DoubleBit - previous.ecc.DoubleBit
+		}
+		if singleBit > 0 || doubleBit > 0 {
+			event := conditionEvent(conditionECCError, state,
+				fmt.Sprintf("GPU %s had %d single bit and %d double bit ECC errors", device.UUID, singleBit, doubleBit))
+			event["ecc"] = common.MapStr{
+				"volatile": common.MapStr{
+					"single_bit": common.MapStr{"delta": singleBit},
+					"double_bit": common.MapStr{"delta": doubleBit},
+				},
+			}
+			events = append(events, event)
+		}
+	}
+
+	// The GPUs that disappeared are kept with the containers they had, until
+	// they come back.
+	var missing []string
+	for uuid, state := range t.gpus {
+		if !seen[uuid] && !state.missing {
+			missing = append(missing, uuid)
+		}
+	}
+	sort.Strings(missing)
+	for _, uuid := range missing {
+		state := t.gpus[uuid]
+		state.missing = true
+		events = append(events, conditionEvent(conditionFellOffBus, state,
+			fmt.Sprintf("GPU %s fell off the bus", uuid)))
+	}
+
+	if !t.started {
+		t.started = true
+		return []common.MapStr{}
+	}
+	return events
+}
+
+func gpuMapping(device *nvidiadocker.DeviceStatus) common.MapStr {
+	gpu := common.MapStr{
+		"uuid": device.UUID,
+	}
+	if device.Index != nil {
+		gpu["index"] = *device.Index
+	}
+	if device.PCI.BusID != "" {
+		gpu["pci"] = common.MapStr{"bus_id": device.PCI.BusID}
+	}
+	return gpu
+}
+
+func conditionEvent(name string, state *gpuState, description string) common.MapStr {
+	event := common.MapStr{
+		"name":        name,
+		"description": description,
+		"gpu":         state.gpu.Clone(),
+	}
+	if len(state.containers) > 0 {
+		event["containers"] = state.containers
+	}
+	return event
+}
@@ -0,0 +1,104 @@
+package condition
+
+import (
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestConditionTracker(t *testing.T) {
+	index0, index1 := uint(0), uint(1)
+	devices := []nvidiadocker.DeviceStatus{
+		{Index: &index0, UUID: "GPU-0", PCI: nvidiadocker.PCIStatusInfo{BusID: "00000000:07:00.0"}},
+		{Index: &index1, UUID: "GPU-1"},
+		{Index: &index1},
+	}
+	containers := map[int][]common.MapStr{
+		0: {{"id": "id1", "name": "trainer"}},
+	}
+	tracker := newConditionTracker()
+
+	devices[0].ECC.Volatile.SingleBit = 3
+	if events := tracker.update(devices, containers); len(events) != 0 {
+		t.Fatalf("expected no conditions on the first fetch, got %v", events)
+	}
+
+	devices[0].ThrottleReasons.HWThermalSlowdown = true
+	devices[0].Temperature = 91
+	devices[0].ECC.Volatile.SingleBit = 5
+	events := tracker.update(devices, containers)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 conditions, got %v", events)
+	}
+
+	testDatas := map[string]interface{}{
+		"name":           conditionThermalThrottleBegan,
+		"description":    "GPU GPU-0 started thermal throttling at 91 C",
+		"gpu.index":      uint(0),
+		"gpu.uuid":       "GPU-0",
+		"gpu.pci.bus_id": "00000000:07:00.0",
+	}
+	for key, expected := range testDatas {
+		value, err := events[0].GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+	if containers := events[0]["containers"].([]common.MapStr); len(containers) != 1 || containers[0]["id"] != "id1" {
+		t.Fatalf("unexpected containers %v", containers)
+	}
+	fieldstest.CheckEvent(t, events[0])
+
+	if name := events[1]["name"]; name != conditionECCError {
+		t.Fatalf("expected an ECC error, got %v", name)
+	}
+	if delta, _ := events[1].GetValue("ecc.volatile.single_bit.delta"); delta != uint64(2) {
+		t.Fatalf("unexpected ECC errors %v", delta)
+	}
+	fieldstest.CheckEvent(t, events[1])
+
+	// GPU-0 falls off the bus and is reported with its last containers.
+	events = tracker.update(devices[1:], nil)
+	if len(events) != 1 || events[0]["name"] != conditionFellOffBus || events[0]["containers"] == nil {
+		t.Fatalf("expected GPU-0 to fall off the bus, got %v", events)
+	}
+	if events = tracker.update(devices[1:], nil); len(events) != 0 {
+		t.Fatalf("expected the missing GPU to be reported once, got %v", events)
+	}
+
+	// It comes back after a reset, which cleared its ECC errors.
+	devices[0].ThrottleReasons.HWThermalSlowdown = false
+	devices[0].ECC.Volatile.SingleBit = 0
+	events = tracker.update(devices, containers)
+	if len(events) != 2 || events[0]["name"] != conditionBackOnBus || events[1]["name"] != conditionThermalThrottleEnded {
+		t.Fatalf("expected GPU-0 back without throttling, got %v", events)
+	}
+}
+
+func TestConditionTrackerInvalidECC(t *testing.T) {
+	devices := []nvidiadocker.DeviceStatus{{UUID: "GPU-0"}}
+	devices[0].ECC.Volatile.DoubleBit = 1
+	tracker := newConditionTracker()
+	tracker.update(devices, nil)
+
+	devices[0].ECC.Volatile.DoubleBit = 0
+	devices[0].InvalidFields = map[string]string{"ecc.errors.uncorrected.volatile.total": "[Unknown Error]"}
+	if events := tracker.update(devices, nil); len(events) != 0 {
+		t.Fatalf("expected no conditions, got %v", events)
+	}
+
+	devices[0].ECC.Volatile.DoubleBit = 2
+	devices[0].InvalidFields = nil
+	events := tracker.update(devices, nil)
+	if len(events) != 1 {
+		t.Fatalf("expected an ECC error, got %v", events)
+	}
+	if delta, _ := events[0].GetValue("ecc.volatile.double_bit.delta"); delta != uint64(1) {
+		t.Fatalf("expected the errors since the last valid value, got %v", delta)
+	}
+}
@@ -0,0 +1,352 @@
+package nvidiadocker
+
+import (
+	"time"
+
+	"github.com/elastic/beats/libbeat/common/match"
+)
+
+// Sources the GPU status can be read from, selected with the gpu_source option.
+const (
+	GPUSourceAPI  = "api"
+	GPUSourceNVML = "nvml"
+	GPUSourceSMI  = "smi"
+	GPUSourceDCGM = "dcgm"
+	GPUSourceROCm = "rocm"
+	// GPUSourceAgent reads the GPUs of a remote host from the beat running
+	// there as an agent.
+	GPUSourceAgent = "agent"
+)
+
+// Container runtimes the containers are read from, selected with the runtime
+// option.
+const (
+	RuntimeDocker     = "docker"
+	RuntimeContainerd = "containerd"
+	RuntimeCRI        = "cri"
+)
+
+// Attributions of the GPUs shared by several containers, selected with the
+// shared_gpu_attribution option.
+const (
+	AttributionNone   = "none"
+	AttributionEqual  = "equal"
+	AttributionMemory = "memory"
+)
+
+// Layouts of the status events, selected with the fields_format option.
+const (
+	FieldsFormatLegacy = "legacy"
+	FieldsFormatECS    = "ecs"
+)
+
+// Handlings of the events of the gpu MetricSet whose sample is identical to
+// the one of the previous fetch, selected with the stale_samples option.
+const (
+	StaleSamplesFlag = "flag"
+	StaleSamplesDrop = "drop"
+)
+
+// Config contains the module configuration shared by all MetricSets.
+type Config struct {
+	APIURL         string `config:"apiurl"`
+	GPUSource      string `config:"gpu_source"`
+	DockerEndpoint string `config:"dockerendpoint"`
+
+	// TLS settings of the Docker daemon. CertPath holds ca.pem, cert.pem and
+	// key.pem, which CA, Cert and Key override. KeyPassphrase decrypts an
+	// encrypted key. TLSVerify is nil if not set.
+	CertPath      string `config:"cert_path"`
+	CA            string `config:"ca"`
+	Cert          string `config:"cert"`
+	Key           string `config:"key"`
+	KeyPassphrase string `config:"key_passphrase"`
+	TLSVerify     *bool  `config:"tls_verify"`
+
+	// DockerTimeout bounds every request to the Docker daemon. The Docker API
+	// version is negotiated with the daemon unless DockerAPIVersion is set.
+	DockerTimeout    time.Duration `config:"docker_timeout"`
+	DockerAPIVersion string        `config:"docker_api_version"`
+
+	// Runtime selects the container runtime the containers are read from.
+	// RuntimeEndpoint is the socket of the containerd and CRI runtimes, the
+	// default of ctr and crictl is used if it is empty.
+	Runtime             string `config:"runtime"`
+	RuntimeEndpoint     string `config:"runtime_endpoint"`
+	ContainerdNamespace string `config:"containerd_namespace"`
+
+	// ReportPerDevice makes the status MetricSet emit one event per container
+	// and GPU instead of one event per container with aggregated values.
+	ReportPerDevice bool `config:"report_per_device"`
+
+	// IncludeExited makes the status MetricSet report a final event of the
+	// containers that stopped or released their GPUs since the previous
+	// fetch, marking the end of their GPU allocation.
+	IncludeExited bool `config:"include_exited"`
+
+	// EmitNonGPUContainers makes the status MetricSet also report the
+	// containers without GPUs, with zero GPU values.
+	EmitNonGPUContainers bool `config:"emit_non_gpu_containers"`
+
+	// ComposeProjectEvents makes the status MetricSet also report one event
+	// per Docker Compose project with the GPU usage of its containers.
+	ComposeProjectEvents bool `config:"compose_project_events"`
+
+	// ImageEvents makes the status MetricSet also report one event per
+	// container image with the GPU usage of its containers.
+	ImageEvents bool `config:"image_events"`
+
+	// SharedGPUAttribution selects how the status MetricSet attributes a GPU
+	// used by several containers: whole to every container, split equally,
+	// or split by the GPU memory used by the processes of the containers.
+	SharedGPUAttribution string `config:"shared_gpu_attribution"`
+
+	// IdleDetection makes the status MetricSet report the containers whose
+	// GPUs stayed below a utilization threshold for a period.
+	IdleDetection IdleDetectionConfig `config:"idle_detection"`
+
+	// ProcessCommandLine makes the process MetricSet report the command line
+	// of the GPU processes, which may hold secrets passed as arguments.
+	ProcessCommandLine ProcessCommandLineConfig `config:"process_command_line"`
+
+	// Prometheus configures the endpoint the status MetricSet serves the GPU
+	// metrics of the containers at in the Prometheus format.
+	Prometheus PrometheusConfig `config:"prometheus"`
+
+	// Statsd configures the StatsD server the status MetricSet pushes the GPU
+	// utilization of the host and of the containers to.
+	Statsd StatsdConfig `config:"statsd"`
+
+	// Health configures the endpoint the status MetricSet serves the outcome
+	// of its latest fetch at.
+	Health HealthConfig `config:"health"`
+
+	// Watchdog configures the notifications of the systemd watchdog sent by
+	// the status MetricSet.
+	Watchdog WatchdogConfig `config:"watchdog"`
+
+	// Autodiscover configures the configurations the autodiscover MetricSet
+	// writes for the containers with GPUs.
+	Autodiscover AutodiscoverConfig `config:"autodiscover"`
+
+	// Agent configures the agent serving the GPUs of the host, and the agent
+	// the agent GPU source reads them from.
+	Agent AgentConfig `config:"agent"`
+
+	// SSH configures the hosts read over SSH, and sshHost is the one the
+	// configuration polls, set by ApplyHost.
+	SSH     SSHConfig `config:"ssh"`
+	sshHost *sshHost
+
+	// FieldsFormat selects the layout of the status events: the original
+	// field names, or container and gpu fields named like the ECS fields
+	// and metricbeat modules.
+	FieldsFormat string `config:"fields_format"`
+
+	// Labels selects the container labels reported by the MetricSets.
+	Labels LabelsConfig `config:"labels"`
+
+	// The containers to inspect and report, selected by their labels, given
+	// as name or name=value, and by regular expressions of their names.
+	IncludeLabels []string        `config:"include_labels"`
+	ExcludeLabels []string        `config:"exclude_labels"`
+	IncludeNames  []match.Matcher `config:"include_names"`
+	ExcludeNames  []match.Matcher `config:"exclude_names"`
+
+	// Periods overrides the period of the module for some of its MetricSets,
+	// by name. Only longer periods are supported.
+	Periods map[string]time.Duration `config:"periods"`
+
+	// SampleInterval makes the gpu and status MetricSets sample the GPUs at
+	// this interval between fetches and report statistics of the samples. 0
+	// disables it.
+	SampleInterval time.Duration `config:"sample_interval"`
+
+	// StaleSamples selects whether the gpu MetricSet flags the events of the
+	// GPUs whose sample did not change since the previous fetch, a symptom
+	// of a hung driver, or drops them.
+	StaleSamples string `config:"stale_samples"`
+
+	// ReportFahrenheit makes the gpu MetricSet also report the temperatures
+	// in degrees Fahrenheit.
+	ReportFahrenheit bool `config:"report_fahrenheit"`
+
+	// Alerts sets the thresholds above which the gpu MetricSet flags the
+	// events of the GPUs with alert.level and alert.reason.
+	Alerts AlertsConfig `config:"alerts"`
+
+	// SMITimeout bounds every run of nvidia-smi and dcgmi, which are run up
+	// to SMIRetries more times after a transient failure.
+	SMITimeout time.Duration `config:"smi_timeout"`
+	SMIRetries int           `config:"smi_retries"`
+
+	// SMIPath is the nvidia-smi binary run by the smi and dcgm GPU sources.
+	// SMIExtraFields are --query-gpu fields reported as is under gpu.raw.
+	SMIPath        string   `config:"smi_path"`
+	SMIExtraFields []string `config:"smi_extra_fields"`
+
+	// DeviceCacheTTL is how long the nvml GPU source caches the static
+	// properties of the GPUs, like their UUID, name and PCI bus ID. 0
+	// disables the cache.
+	DeviceCacheTTL time.Duration `config:"device_cache_ttl"`
+
+	// ROCmSMIPath is the rocm-smi binary run by the rocm GPU source.
+	ROCmSMIPath string `config:"rocm_smi_path"`
+
+	// KubeletCheckpoint is the kubelet device manager checkpoint the status
+	// MetricSet reads the GPUs allocated to pods from. Empty disables it.
+	KubeletCheckpoint string `config:"kubelet_checkpoint"`
+
+	// HostFS is the mountpoint of the host's filesystem when the beat runs in
+	// a container. /proc, the cgroups and the kubelet checkpoint are read
+	// from under it, and the tools and the NVML library of the GPU sources
+	// are looked up in it if the container does not provide them.
+	HostFS string `config:"hostfs"`
+}
+
+// IdleDetectionConfig configures the idle GPU detection of the status
+// MetricSet. Threshold is a utilization ratio between 0 and 1.
+type IdleDetectionConfig struct {
+	Enabled   bool          `config:"enabled"`
+	Threshold float64       `config:"threshold"`
+	Period    time.Duration `config:"period"`
+}
+
+// AlertsConfig configures the alert thresholds of the gpu MetricSet. The
+// temperature is in degrees Celsius, the utilization, memory and power are
+// ratios between 0 and 1 of the full utilization, the total memory and the
+// enforced power limit. A threshold of 0 is disabled.
+type AlertsConfig struct {
+	Temperature AlertThresholds `config:"temperature"`
+	Utilization AlertThresholds `config:"utilization"`
+	Memory      AlertThresholds `config:"memory"`
+	Power       AlertThresholds `config:"power"`
+}
+
+// AlertThresholds are the values above which a GPU is alerted at the warning
+// and critical levels.
+type AlertThresholds struct {
+	Warning  float64 `config:"warning"`
+	Critical float64 `config:"critical"`
+}
+
+// ProcessCommandLineConfig configures the command lines reported by the
+// process MetricSet. Command lines longer than MaxLength bytes are truncated.
+type ProcessCommandLineConfig struct {
+	Enabled   bool `config:"enabled"`
+	MaxLength int  `config:"max_length"`
+}
+
+// PrometheusConfig configures the /metrics endpoint of the status MetricSet.
+// Host is the address it listens on.
+type PrometheusConfig struct {
+	Enabled bool   `config:"enabled"`
+	Host    string `config:"host"`
+}
+
+// HealthConfig configures the /healthz endpoint of the status MetricSet. Host
+// is the address it listens on.
+type HealthConfig struct {
+	Enabled bool   `config:"enabled"`
+	Host    string `config:"host"`
+}
+
+// WatchdogConfig configures the systemd watchdog. The watchdog stops being
+// notified once the fetches of a host failed MaxFailures times in a row.
+type WatchdogConfig struct {
+	Enabled     bool `config:"enabled"`
+	MaxFailures int  `config:"max_failures"`
+}
+
+// StatsdConfig configures the StatsD push of the status MetricSet. Host is
+// the UDP address of the StatsD server, Prefix is prepended to the metric
+// names.
+type StatsdConfig struct {
+	Enabled       bool          `config:"enabled"`
+	Host          string        `config:"host"`
+	Prefix        string        `config:"prefix"`
+	FlushInterval time.Duration `config:"flush_interval"`
+}
+
+// AutodiscoverConfig configures the configuration files written for the
+// running containers with GPUs to Path, for the beats reloading their
+// configurations from it. Template is rendered for every container with
+// text/template.
+type AutodiscoverConfig struct {
+	Path     string `config:"path"`
+	Template string `config:"template"`
+}
+
+// AgentConfig configures the agent mode. Listen is the address the agent
+// serves the GPUs of its host on, URL the agent the agent GPU source reads.
+// Token is the bearer token the agent requires and the agent GPU source
+// sends, none if empty. The agent serves HTTPS with Cert and Key, the agent
+// GPU source verifies it with CA, or the system roots if empty.
+type AgentConfig struct {
+	Listen string `config:"listen"`
+	URL    string `config:"url"`
+	Token  string `config:"token"`
+	Cert   string `config:"cert"`
+	Key    string `config:"key"`
+	CA     string `config:"ca"`
+}
+
+// DefaultConfig returns the default module configuration.
+func DefaultConfig() Config {
+	return Config{
+		APIURL:               "",
+		GPUSource:            GPUSourceAPI,
+		DockerEndpoint:       "",
+		DockerTimeout:        10 * time.Second,
+		DockerAPIVersion:     "",
+		Runtime:              RuntimeDocker,
+		RuntimeEndpoint:      "",
+		ContainerdNamespace:  "k8s.io",
+		ReportPerDevice:      false,
+		FieldsFormat:         FieldsFormatLegacy,
+		SharedGPUAttribution: AttributionNone,
+		EmitNonGPUContainers: false,
+		IncludeExited:        false,
+		SampleInterval:       0,
+		StaleSamples:         StaleSamplesFlag,
+		ReportFahrenheit:     false,
+		SMITimeout:           5 * time.Second,
+		SMIRetries:           1,
+		SMIPath:              "nvidia-smi",
+		DeviceCacheTTL:       5 * time.Minute,
+		ROCmSMIPath:          "rocm-smi",
+		KubeletCheckpoint:    DefaultKubeletCheckpoint,
+		HostFS:               "",
+		IdleDetection: IdleDetectionConfig{
+			Enabled:   false,
+			Threshold: 0.05,
+			Period:    30 * time.Minute,
+		},
+		ProcessCommandLine: ProcessCommandLineConfig{
+			Enabled:   false,
+			MaxLength: 256,
+		},
+		Prometheus: PrometheusConfig{
+			Enabled: false,
+			Host:    "localhost:9479",
+		},
+		Statsd: StatsdConfig{
+			Enabled:       false,
+			Host:          "localhost:8125",
+			Prefix:        "nvidiadocker",
+			FlushInterval: 10 * time.Second,
+		},
+		Health: HealthConfig{
+			Enabled: false,
+			Host:    "localhost:9481",
+		},
+		Watchdog: WatchdogConfig{
+			Enabled:     true,
+			MaxFailures: 3,
+		},
+		Agent: AgentConfig{
+			Listen: "localhost:" + defaultAgentPort,
+			URL:    "http://localhost:" + defaultAgentPort,
+		},
+	}
+}
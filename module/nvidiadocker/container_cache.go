@@ -0,0 +1,308 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ContainerEventSource is implemented by ContainerClients that can stream the
+// start and stop of containers.
+type ContainerEventSource interface {
+	// AddEventListener sends the events of the runtime to the listener until
+	// the stream ends, which closes the listener.
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	// RemoveEventListener stops sending the events to the listener, and
+	// ends the stream if it was the last one.
+	RemoveEventListener(listener chan *docker.APIEvents) error
+}
+
+// RemoveEventListener stops sending the events of the source to the
+// listener, once the MetricSet following them stopped fetching. The listener
+// is drained meanwhile, as the stream blocks on a full listener.
+func RemoveEventListener(source ContainerEventSource, listener chan *docker.APIEvents) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case _, ok := <-listener:
+				if !ok {
+					listener = nil
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	if err := source.RemoveEventListener(listener); err != nil {
+		logp.Debug("nvidiadocker", "Cannot stop following container events: %v", err)
+	}
+	close(done)
+}
+
+// CachedContainer is a running container with its NVIDIA runtime settings.
+type CachedContainer struct {
+	Container *docker.Container
+	Runtime   *ContainerRuntime
+}
+
+// ContainerError is the failure to read a single container, which is
+// reported without failing the other containers.
+type ContainerError struct {
+	ID  string
+	Err error
+}
+
+func (e *ContainerError) Error() string {
+	return fmt.Sprintf("container %s: %v", e.ID, e.Err)
+}
+
+// ContainerCache holds the running containers of a runtime so that every
+// container is only inspected once. With an event stream the containers are
+// only listed once and then follow the start and stop events, otherwise they
+// are listed on every call and only new containers are inspected. Only the
+// containers selected by the filter are kept, which are filtered before being
+// inspected if the runtime lists their names and labels. The event stream is
+// left once the cache has not been read for StalePeriods periods, like after
+// its module was reloaded.
+type ContainerCache struct {
+	client ContainerClient
+	filter *ContainerFilter
+	period time.Duration
+
+	mu         sync.Mutex
+	containers map[string]*CachedContainer
+	// pending holds the started containers that are not inspected yet.
+	pending map[string]bool
+	// excluded holds the running containers the filter did not select.
+	excluded map[string]bool
+	// watching is set while the event stream is followed, and complete once
+	// the containers were listed since, as the stream then keeps them current.
+	watching bool
+	complete bool
+	// read is the time the containers were last read.
+	read time.Time
+}
+
+// NewContainerCache creates a ContainerCache reading the containers selected
+// by the filter from the given client, for a MetricSet reading them at the
+// given period, 0 if it is read once. A nil filter selects every container.
+func NewContainerCache(client ContainerClient, filter *ContainerFilter, period time.Duration) *ContainerCache {
+	return &ContainerCache{
+		client:     client,
+		filter:     filter,
+		period:     period,
+		containers: map[string]*CachedContainer{},
+		pending:    map[string]bool{},
+		excluded:   map[string]bool{},
+	}
+}
+
+// Containers returns the running containers, ordered by ID, along with the
+// containers that could not be inspected. These are inspected again on the
+// next call. The listing and inspection phases are logged to trace, which may
+// be nil.
+func (c *ContainerCache) Containers(trace *FetchTrace) ([]*CachedContainer, []*ContainerError, error) {
+	c.mu.Lock()
+	c.read = time.Now()
+	c.mu.Unlock()
+
+	c.watch()
+
+	c.mu.Lock()
+	complete := c.complete
+	c.mu.Unlock()
+
+	if !complete {
+		if err := c.list(); err != nil {
+			return nil, nil, err
+		}
+	}
+	trace.Phase("list", "cached", complete)
+
+	c.mu.Lock()
+	pending := make([]string, 0, len(c.pending))
+	for id := range c.pending {
+		pending = append(pending, id)
+	}
+	c.mu.Unlock()
+
+	sort.Strings(pending)
+
+	var failures []*ContainerError
+	for _, id := range pending {
+		container, runtime, err := c.client.InspectContainerWithRuntime(id)
+		if _, stopped := err.(*docker.NoSuchContainer); stopped {
+			c.mu.Lock()
+			delete(c.pending, id)
+			c.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			logp.Debug("nvidiadocker", "Cannot inspect container %s: %v", id, err)
+			failures = append(failures, &ContainerError{ID: id, Err: err})
+			continue
+		}
+
+		c.mu.Lock()
+		// The container may have stopped while being inspected.
+		if c.pending[id] {
+			delete(c.pending, id)
+			switch {
+			case !container.State.Running:
+			case !c.filter.MatchContainer(container):
+				c.excluded[id] = true
+			default:
+				c.containers[id] = &CachedContainer{Container: container, Runtime: runtime}
+			}
+		}
+		c.mu.Unlock()
+	}
+	trace.Phase("inspect", "inspected", len(pending), "failures", len(failures))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]string, 0, len(c.containers))
+	for id := range c.containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	containers := make([]*CachedContainer, 0, len(ids))
+	for _, id := range ids {
+		containers = append(containers, c.containers[id])
+	}
+	return containers, failures, nil
+}
+
+// list replaces the cached containers with the running ones, marking the new
+// ones for inspection.
+func (c *ContainerCache) list() error {
+	apiContainers, err := c.client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	running := make(map[string]bool, len(apiContainers))
+	for _, apiContainer := range apiContainers {
+		id := apiContainer.ID
+		running[id] = true
+		if _, found := c.containers[id]; found || c.excluded[id] {
+			continue
+		}
+		// Runtimes that do not list the names are filtered once inspected.
+		if len(apiContainer.Names) > 0 && !c.filter.Match(apiContainer.Names[0], apiContainer.Labels) {
+			c.excluded[id] = true
+			continue
+		}
+		c.pending[id] = true
+	}
+	for _, ids := range []map[string]bool{c.pending, c.excluded} {
+		for id := range ids {
+			if !running[id] {
+				delete(ids, id)
+			}
+		}
+	}
+	for id := range c.containers {
+		if !running[id] {
+			delete(c.containers, id)
+		}
+	}
+	// Events received since the listener was added are applied on top.
+	c.complete = c.watching
+	return nil
+}
+
+// watch starts following the events of the runtime if it has an event stream
+// and the cache does not follow it yet.
+func (c *ContainerCache) watch() {
+	source, ok := c.client.(ContainerEventSource)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watching {
+		return
+	}
+
+	events := make(chan *docker.APIEvents, 100)
+	if err := source.AddEventListener(events); err != nil {
+		logp.Debug("nvidiadocker", "Cannot follow container events: %v", err)
+		return
+	}
+	c.watching = true
+	go c.follow(source, events)
+}
+
+// follow applies the events until the stream ends or the cache is not read
+// anymore.
+func (c *ContainerCache) follow(source ContainerEventSource, events chan *docker.APIEvents) {
+	var tick <-chan time.Time
+	if c.period > 0 {
+		ticker := time.NewTicker(c.period)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	defer func() {
+		// The containers are listed again until the stream is followed
+		// again.
+		c.mu.Lock()
+		c.watching = false
+		c.complete = false
+		c.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok || event == docker.EOFEvent {
+				return
+			}
+			c.apply(event)
+		case <-tick:
+			c.mu.Lock()
+			read := c.read
+			c.mu.Unlock()
+			if Stale(read, c.period) {
+				logp.Debug("nvidiadocker", "Stopping to follow container events, not read since %v", read)
+				RemoveEventListener(source, events)
+				return
+			}
+		}
+	}
+}
+
+// apply updates the cache with a container event. Events of API versions
+// before 1.22 only have the Status and ID fields.
+func (c *ContainerCache) apply(event *docker.APIEvents) {
+	if event.Type != "" && event.Type != "container" {
+		return
+	}
+	id, action := event.Actor.ID, event.Action
+	if id == "" {
+		id, action = event.ID, event.Status
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch action {
+	case "start":
+		delete(c.containers, id)
+		delete(c.excluded, id)
+		c.pending[id] = true
+	case "die", "destroy":
+		delete(c.containers, id)
+		delete(c.pending, id)
+		delete(c.excluded, id)
+	}
+}
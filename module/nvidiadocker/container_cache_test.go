@@ -0,0 +1,227 @@
+package nvidiadocker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common/match"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+type mockContainerClient struct {
+	running map[string]bool
+	failing map[string]bool
+	labels  map[string]map[string]string
+	// listNames makes the list hold the names and labels of the containers.
+	listNames bool
+	lists     int
+	inspects  int
+}
+
+func (c *mockContainerClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	c.lists++
+	var containers []docker.APIContainers
+	for id := range c.running {
+		container := docker.APIContainers{ID: id}
+		if c.listNames {
+			container.Names = []string{"/" + id}
+			container.Labels = c.labels[id]
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+func (c *mockContainerClient) InspectContainer(id string) (*docker.Container, error) {
+	container, _, err := c.InspectContainerWithRuntime(id)
+	return container, err
+}
+
+func (c *mockContainerClient) InspectContainerWithRuntime(id string) (*docker.Container, *ContainerRuntime, error) {
+	c.inspects++
+	if c.failing[id] {
+		return nil, nil, errors.New("request timed out")
+	}
+	if !c.running[id] {
+		return nil, nil, &docker.NoSuchContainer{ID: id}
+	}
+	return &docker.Container{
+		ID:     id,
+		Name:   "/" + id,
+		State:  docker.State{Running: true},
+		Config: &docker.Config{Labels: c.labels[id]},
+	}, &ContainerRuntime{}, nil
+}
+
+type mockEventClient struct {
+	*mockContainerClient
+	listener chan<- *docker.APIEvents
+	removed  chan bool
+}
+
+func (c *mockEventClient) AddEventListener(listener chan<- *docker.APIEvents) error {
+	c.listener = listener
+	return nil
+}
+
+func (c *mockEventClient) RemoveEventListener(listener chan *docker.APIEvents) error {
+	if c.removed != nil {
+		c.removed <- true
+	}
+	return nil
+}
+
+func containerIDs(t *testing.T, cache *ContainerCache) []string {
+	containers, _, err := cache.Containers(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(containers))
+	for _, c := range containers {
+		ids = append(ids, c.Container.ID)
+	}
+	return ids
+}
+
+func TestContainerCache(t *testing.T) {
+	client := &mockContainerClient{running: map[string]bool{"a": true, "b": true}}
+	cache := NewContainerCache(client, nil, 0)
+
+	if ids := containerIDs(t, cache); len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Fatalf("unexpected containers %v", ids)
+	}
+
+	delete(client.running, "a")
+	client.running["c"] = true
+	if ids := containerIDs(t, cache); len(ids) != 2 || ids[0] != "b" || ids[1] != "c" {
+		t.Fatalf("unexpected containers %v", ids)
+	}
+
+	// Without events the containers are listed every time, but only new ones
+	// are inspected.
+	if client.lists != 2 || client.inspects != 3 {
+		t.Fatalf("expected 2 lists and 3 inspects, got %d and %d", client.lists, client.inspects)
+	}
+}
+
+func TestContainerCacheInspectFailure(t *testing.T) {
+	client := &mockContainerClient{
+		running: map[string]bool{"a": true, "b": true},
+		failing: map[string]bool{"b": true},
+	}
+	cache := NewContainerCache(client, nil, 0)
+
+	containers, failures, err := cache.Containers(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || containers[0].Container.ID != "a" {
+		t.Fatalf("expected the inspected container only, got %v", containers)
+	}
+	if len(failures) != 1 || failures[0].ID != "b" {
+		t.Fatalf("expected the failed container, got %v", failures)
+	}
+
+	// The failed container is inspected again.
+	delete(client.failing, "b")
+	if ids := containerIDs(t, cache); len(ids) != 2 || ids[1] != "b" {
+		t.Fatalf("unexpected containers %v", ids)
+	}
+}
+
+func TestContainerCacheFilter(t *testing.T) {
+	client := &mockContainerClient{
+		running: map[string]bool{"train": true, "istio-proxy": true, "notebook": true},
+		labels: map[string]map[string]string{
+			"train":    {"com.nvidia.volumes.needed": "nvidia_driver"},
+			"notebook": {"com.nvidia.volumes.needed": "nvidia_driver"},
+		},
+	}
+	filter := &ContainerFilter{
+		includeLabels: labelSelectors([]string{"com.nvidia.volumes.needed=nvidia_driver"}),
+		excludeNames:  []match.Matcher{match.MustCompile("^note")},
+	}
+
+	for _, listNames := range []bool{false, true} {
+		client.listNames = listNames
+		client.inspects = 0
+		cache := NewContainerCache(client, filter, 0)
+
+		for i := 0; i < 2; i++ {
+			if ids := containerIDs(t, cache); len(ids) != 1 || ids[0] != "train" {
+				t.Fatalf("unexpected containers %v", ids)
+			}
+		}
+
+		// Containers listed with their names and labels are filtered before
+		// being inspected, the others are only inspected once.
+		expected := 3
+		if listNames {
+			expected = 1
+		}
+		if client.inspects != expected {
+			t.Fatalf("expected %d inspects, got %d", expected, client.inspects)
+		}
+	}
+}
+
+func TestContainerCacheStale(t *testing.T) {
+	client := &mockEventClient{
+		mockContainerClient: &mockContainerClient{running: map[string]bool{"a": true}},
+		removed:             make(chan bool, 1),
+	}
+	cache := NewContainerCache(client, nil, 10*time.Millisecond)
+	containerIDs(t, cache)
+
+	// The cache is not read anymore, like after its module was reloaded.
+	select {
+	case <-client.removed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the cache to stop following the events")
+	}
+}
+
+func TestContainerCacheEvents(t *testing.T) {
+	client := &mockEventClient{mockContainerClient: &mockContainerClient{running: map[string]bool{"a": true}}}
+	cache := NewContainerCache(client, nil, 0)
+
+	if ids := containerIDs(t, cache); len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("unexpected containers %v", ids)
+	}
+
+	client.running["b"] = true
+	cache.apply(&docker.APIEvents{Type: "container", Action: "start", Actor: docker.APIActor{ID: "b"}})
+	delete(client.running, "a")
+	cache.apply(&docker.APIEvents{Status: "die", ID: "a"})
+	cache.apply(&docker.APIEvents{Type: "network", Action: "start", Actor: docker.APIActor{ID: "c"}})
+
+	if ids := containerIDs(t, cache); len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("unexpected containers %v", ids)
+	}
+	if client.lists != 1 || client.inspects != 2 {
+		t.Fatalf("expected 1 list and 2 inspects, got %d and %d", client.lists, client.inspects)
+	}
+
+	// The containers are listed again once the event stream ends.
+	close(client.listener)
+	for deadline := time.Now().Add(time.Second); ; {
+		cache.mu.Lock()
+		watching := cache.watching
+		cache.mu.Unlock()
+		if !watching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the cache to stop following the events")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if ids := containerIDs(t, cache); len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("unexpected containers %v", ids)
+	}
+	if client.lists != 2 || client.inspects != 2 {
+		t.Fatalf("expected 2 lists and 2 inspects, got %d and %d", client.lists, client.inspects)
+	}
+}
@@ -0,0 +1,258 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/logp"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var (
+	nvidiaDeviceRegexp = regexp.MustCompile("^/dev/nvidia([0-9]+)$")
+	renderNodeRegexp   = regexp.MustCompile("^/dev/dri/renderD[0-9]+$")
+)
+
+// The volume driver of nvidia-docker 1.x, which mounts the driver libraries
+// into the containers as a nvidia_driver_<version> volume at
+// /usr/local/nvidia.
+const (
+	nvidiaDockerV1VolumeDriver = "nvidia-docker"
+	nvidiaDockerV1VolumePrefix = "nvidia_driver_"
+)
+
+// NVIDIADockerV1 tells whether the container was started by nvidia-docker
+// 1.x, which maps the GPUs into the container as /dev/nvidiaN devices and
+// mounts the driver volume of nvidia-docker-plugin. The label of the CUDA
+// images requesting the volume is not used, as these images also run without
+// GPUs.
+func NVIDIADockerV1(container *docker.Container) bool {
+	if container.HostConfig != nil && container.HostConfig.VolumeDriver == nvidiaDockerV1VolumeDriver {
+		return true
+	}
+	for _, mount := range container.Mounts {
+		if mount.Driver == nvidiaDockerV1VolumeDriver || strings.HasPrefix(mount.Name, nvidiaDockerV1VolumePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainerDeviceIndices returns the positions in gpuDevices of the GPUs the
+// container has access to. The GPUs the kubelet allocated to the container of
+// a pod take precedence, as the device plugin can expose GPUs the container
+// configuration does not show. The other GPUs are matched by the
+// ContainerDevices of the backend, the NVIDIA backend if b is nil. Files of
+// the host are read from under hostFS. How the GPUs were matched is logged at
+// debug level.
+func (b *Backend) ContainerDeviceIndices(container *docker.Container, runtime *ContainerRuntime, allocations KubeletAllocations, gpuDevices []DeviceStatus, hostFS string) []int {
+	if indices, found := allocations.Devices(container.Config.Labels, gpuDevices); found {
+		debugAttribution(container, "allocated by the kubelet", indices, gpuDevices)
+		return indices
+	}
+	if b == nil {
+		b = NVIDIABackend
+	}
+
+	var indices []int
+	seen := map[int]bool{}
+	for _, index := range b.ContainerDevices(container, runtime, gpuDevices, hostFS) {
+		if index >= 0 && index < len(gpuDevices) && !seen[index] {
+			seen[index] = true
+			indices = append(indices, index)
+		}
+	}
+	if len(indices) == 0 {
+		debugAttribution(container, "matched", nil, gpuDevices)
+	}
+	return indices
+}
+
+// debugAttribution logs the GPUs of the container and how they were matched.
+func debugAttribution(container *docker.Container, how string, indices []int, gpuDevices []DeviceStatus) {
+	if !logp.IsDebug("nvidiadocker") {
+		return
+	}
+	if len(indices) == 0 {
+		logp.Debug("nvidiadocker", "Container %s: no GPU %s", container.ID, how)
+		return
+	}
+	gpus := make([]string, 0, len(indices))
+	for _, index := range indices {
+		if index < 0 || index >= len(gpuDevices) {
+			gpus = append(gpus, "unknown")
+			continue
+		}
+		gpus = append(gpus, gpuDevices[index].UUID)
+	}
+	logp.Debug("nvidiadocker", "Container %s: GPUs %s %s", container.ID, strings.Join(gpus, ", "), how)
+}
+
+// nvidiaContainerDevices returns the positions in gpuDevices of the NVIDIA
+// GPUs mapped explicitly into the container as /dev/nvidiaN devices, or as
+// the capability devices of their MIG instances, or provided by the NVIDIA
+// container runtime, or else allowed by its devices cgroup. The control
+// devices, like /dev/nvidiactl and /dev/nvidia-uvm, are mapped along with the
+// GPUs and do not attribute any.
+func nvidiaContainerDevices(container *docker.Container, runtime *ContainerRuntime, gpuDevices []DeviceStatus, hostFS string) []int {
+	indices := mappedNVIDIADevices(container, gpuDevices)
+	if len(indices) == 0 {
+		capabilityIndices, err := migCapabilityGPUs(container, gpuDevices, hostFS)
+		if err != nil {
+			logp.Debug("nvidiadocker", "Cannot read MIG capabilities of container %s: %v", container.ID, err)
+		}
+		if len(capabilityIndices) > 0 {
+			debugAttribution(container, "mapped as MIG capability devices", capabilityIndices, gpuDevices)
+		}
+		indices = capabilityIndices
+	}
+	if visible := VisibleDevices(container.Config.Env, runtime, gpuDevices); len(visible) > 0 {
+		debugAttribution(container, "provided by the NVIDIA container runtime", visible, gpuDevices)
+		indices = append(indices, visible...)
+	}
+
+	// Fall back to the devices cgroup for containers granted GPUs through
+	// device cgroup rules.
+	if len(indices) == 0 && container.State.Pid > 0 {
+		cgroupIndices, err := CgroupDevices(hostFS, container.State.Pid, gpuDevices)
+		if err != nil {
+			logp.Debug("nvidiadocker", "Cannot read devices cgroup of container %s: %v", container.ID, err)
+		}
+		if len(cgroupIndices) > 0 {
+			debugAttribution(container, "allowed by the devices cgroup", cgroupIndices, gpuDevices)
+		}
+		indices = cgroupIndices
+	}
+	return indices
+}
+
+// nvidiaConfigDevices returns the positions in gpuDevices of the NVIDIA GPUs
+// mapped explicitly into the container as /dev/nvidiaN devices, or provided
+// by the NVIDIA container runtime. Unlike nvidiaContainerDevices, it only
+// reads the container configuration, for the containers of a remote host.
+func nvidiaConfigDevices(container *docker.Container, runtime *ContainerRuntime, gpuDevices []DeviceStatus, hostFS string) []int {
+	indices := mappedNVIDIADevices(container, gpuDevices)
+	if visible := VisibleDevices(container.Config.Env, runtime, gpuDevices); len(visible) > 0 {
+		debugAttribution(container, "provided by the NVIDIA container runtime", visible, gpuDevices)
+		indices = append(indices, visible...)
+	}
+	return indices
+}
+
+// mappedNVIDIADevices returns the positions in gpuDevices of the GPUs mapped
+// into the container as /dev/nvidiaN devices.
+func mappedNVIDIADevices(container *docker.Container, gpuDevices []DeviceStatus) []int {
+	var indices []int
+	for _, device := range container.HostConfig.Devices {
+		if findStrs := nvidiaDeviceRegexp.FindStringSubmatch(device.PathOnHost); findStrs != nil && len(findStrs) == 2 {
+			if minor, err := strconv.ParseUint(findStrs[1], 10, 32); err == nil {
+				indices = append(indices, minorPosition(uint(minor), gpuDevices))
+			}
+		}
+	}
+	if len(indices) > 0 {
+		how := "mapped as /dev/nvidiaN devices"
+		if NVIDIADockerV1(container) {
+			how += " by nvidia-docker 1.x"
+		}
+		debugAttribution(container, how, indices, gpuDevices)
+	}
+	return indices
+}
+
+// RenderNodeDevices returns the positions in gpuDevices of the GPUs mapped
+// into the container as DRM render nodes, /dev/dri/renderDN, which is how
+// containers get AMD and Intel GPUs.
+func RenderNodeDevices(container *docker.Container, runtime *ContainerRuntime, gpuDevices []DeviceStatus, hostFS string) []int {
+	var indices []int
+	for _, device := range container.HostConfig.Devices {
+		if !renderNodeRegexp.MatchString(device.PathOnHost) {
+			continue
+		}
+		indices = append(indices, renderNodeDevice(hostFS, device.PathOnHost, gpuDevices))
+	}
+	if len(indices) > 0 {
+		debugAttribution(container, "mapped as render nodes", indices, gpuDevices)
+	}
+	return indices
+}
+
+// renderNodeDevice returns the position in gpuDevices of the GPU of a DRM
+// render node, matched by the PCI bus ID of the device sysfs links the node
+// to, read from under hostFS, or -1 if it is not among gpuDevices.
+func renderNodeDevice(hostFS, node string, gpuDevices []DeviceStatus) int {
+	link, err := os.Readlink(HostPath(hostFS, path.Join("/sys/class/drm", path.Base(node), "device")))
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot read the device of render node %s: %v", node, err)
+		return -1
+	}
+	return busIDPosition(filepath.Base(link), gpuDevices)
+}
+
+// normalizeBusID returns a PCI bus ID with a domain of 8 hexadecimal digits
+// in lowercase, as nvidia-smi reports 00000000:03:00.0 and sysfs and rocm-smi
+// 0000:03:00.0. An empty string is returned for invalid bus IDs.
+func normalizeBusID(busID string) string {
+	parts := strings.SplitN(strings.ToLower(busID), ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	domain, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%08x:%s", domain, parts[1])
+}
+
+// ContainerDeviceNumbers returns the N of the /dev/nvidiaN device path in the
+// container of the GPUs mapped into it as /dev/nvidiaN devices, by position in
+// gpuDevices. A device can be mapped to another number than on the host, like
+// /dev/nvidia3 as /dev/nvidia0, and the applications of the container refer to
+// it by that number. The GPUs provided by the NVIDIA container runtime keep
+// their number and are not returned.
+func ContainerDeviceNumbers(container *docker.Container, gpuDevices []DeviceStatus) map[int]uint {
+	numbers := map[int]uint{}
+	if container.HostConfig == nil {
+		return numbers
+	}
+	for _, device := range container.HostConfig.Devices {
+		host := nvidiaDeviceRegexp.FindStringSubmatch(device.PathOnHost)
+		inContainer := nvidiaDeviceRegexp.FindStringSubmatch(device.PathInContainer)
+		if len(host) != 2 || len(inContainer) != 2 {
+			continue
+		}
+		minor, err := strconv.ParseUint(host[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		number, err := strconv.ParseUint(inContainer[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		if position := minorPosition(uint(minor), gpuDevices); position >= 0 {
+			numbers[position] = uint(number)
+		}
+	}
+	return numbers
+}
+
+// MissingDevices returns the /dev/nvidiaN devices mapped into the container
+// that are not among gpuDevices, like the GPUs that fell off the bus.
+func MissingDevices(container *docker.Container, gpuDevices []DeviceStatus) []string {
+	var missing []string
+	for _, device := range container.HostConfig.Devices {
+		findStrs := nvidiaDeviceRegexp.FindStringSubmatch(device.PathOnHost)
+		if len(findStrs) != 2 {
+			continue
+		}
+		if minor, err := strconv.ParseUint(findStrs[1], 10, 32); err == nil && minorPosition(uint(minor), gpuDevices) < 0 {
+			missing = append(missing, device.PathOnHost)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,320 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestNvidiaDeviceRegexp(t *testing.T) {
+	testDatas := []struct {
+		DeviceName  string
+		DeviceIndex string
+		Matched     bool
+	}{
+		{
+			"/dev/nvidia0",
+			"0",
+			true,
+		},
+		{
+			"/dev/nvidia12",
+			"12",
+			true,
+		},
+		{
+			"/dev/test0",
+			"",
+			false,
+		},
+		{
+			"/nvidia0",
+			"",
+			false,
+		},
+		{
+			"/dev/nvidia",
+			"",
+			false,
+		},
+		{
+			"/dev/nvidiactl",
+			"",
+			false,
+		},
+		{
+			"/dev/nvidia-uvm",
+			"",
+			false,
+		},
+		{
+			"/dev/nvidia-caps/nvidia-cap12",
+			"",
+			false,
+		},
+	}
+
+	for _, testData := range testDatas {
+		findStrs := nvidiaDeviceRegexp.FindStringSubmatch(testData.DeviceName)
+		if (findStrs != nil) != testData.Matched {
+			t.Fatal("not matched")
+		}
+
+		if testData.Matched {
+			if !reflect.DeepEqual([]string{testData.DeviceName, testData.DeviceIndex}, findStrs) {
+				t.Fatal("not matched")
+			}
+		}
+
+	}
+}
+
+func TestContainerDeviceIndices(t *testing.T) {
+	gpuDevices := make([]DeviceStatus, 4)
+
+	indices := NVIDIABackend.ContainerDeviceIndices(&docker.Container{
+		HostConfig: &docker.HostConfig{
+			Devices: []docker.Device{
+				{PathOnHost: "/dev/nvidia1", PathInContainer: "/dev/nvidia1"},
+				{PathOnHost: "/dev/nvidiactl", PathInContainer: "/dev/nvidiactl"},
+			},
+		},
+		Config: &docker.Config{
+			Env: []string{"NVIDIA_VISIBLE_DEVICES=1,3"},
+		},
+	}, &ContainerRuntime{Runtime: "nvidia"}, nil, gpuDevices, "")
+
+	if !reflect.DeepEqual(indices, []int{1, 3}) {
+		t.Fatalf("unexpected indices %v", indices)
+	}
+}
+
+func TestContainerDeviceIndicesRenderNodes(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+
+	drm := filepath.Join(hostFS, "/sys/class/drm")
+	if err := os.MkdirAll(drm, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for node, busID := range map[string]string{"renderD128": "0000:03:00.0", "renderD129": "0000:43:00.0"} {
+		if err := os.MkdirAll(filepath.Join(drm, node), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("../../../"+busID, filepath.Join(drm, node, "device")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gpuDevices := []DeviceStatus{
+		{PCI: PCIStatusInfo{BusID: "0000:03:00.0"}},
+		{PCI: PCIStatusInfo{BusID: "00000000:43:00.0"}},
+	}
+	indices := AMDBackend.ContainerDeviceIndices(&docker.Container{
+		HostConfig: &docker.HostConfig{
+			Devices: []docker.Device{
+				{PathOnHost: "/dev/kfd", PathInContainer: "/dev/kfd"},
+				{PathOnHost: "/dev/dri/renderD129", PathInContainer: "/dev/dri/renderD128"},
+				{PathOnHost: "/dev/dri/renderD130", PathInContainer: "/dev/dri/renderD129"},
+			},
+		},
+		Config: &docker.Config{},
+	}, &ContainerRuntime{}, nil, gpuDevices, hostFS)
+
+	if !reflect.DeepEqual(indices, []int{1}) {
+		t.Fatalf("unexpected indices %v", indices)
+	}
+}
+
+func TestContainerDeviceIndicesKubelet(t *testing.T) {
+	gpuDevices := []DeviceStatus{
+		{Index: toUintP(0), UUID: "GPU-0"},
+		{Index: toUintP(1), UUID: "GPU-1"},
+	}
+
+	checkpoint, err := ioutil.TempFile("", "kubelet_internal_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(checkpoint.Name())
+	checkpoint.WriteString(`{"Data":{"PodDeviceEntries":[` +
+		`{"PodUID":"pod-a","ContainerName":"train","ResourceName":"nvidia.com/gpu","DeviceIDs":{"0":["GPU-1"]}}]}}`)
+	checkpoint.Close()
+
+	allocations := LoadKubeletAllocations(checkpoint.Name())
+
+	// The image exposes all GPUs, but the kubelet only allocated GPU-1.
+	container := &docker.Container{
+		HostConfig: &docker.HostConfig{},
+		Config: &docker.Config{
+			Env: []string{"NVIDIA_VISIBLE_DEVICES=all"},
+			Labels: map[string]string{
+				KubernetesPodUIDLabel:        "pod-a",
+				KubernetesContainerNameLabel: "train",
+			},
+		},
+	}
+	runtime := &ContainerRuntime{Runtime: "nvidia"}
+
+	if indices := NVIDIABackend.ContainerDeviceIndices(container, runtime, allocations, gpuDevices, ""); !reflect.DeepEqual(indices, []int{1}) {
+		t.Fatalf("unexpected indices %v", indices)
+	}
+	if indices := NVIDIABackend.ContainerDeviceIndices(container, runtime, nil, gpuDevices, ""); !reflect.DeepEqual(indices, []int{0, 1}) {
+		t.Fatalf("unexpected indices without checkpoint %v", indices)
+	}
+
+	if allocations := LoadKubeletAllocations(checkpoint.Name() + ".missing"); allocations != nil {
+		t.Fatalf("expected no allocations, got %v", allocations)
+	}
+}
+
+func TestNVIDIADockerV1(t *testing.T) {
+	volumeDriver := &docker.Container{HostConfig: &docker.HostConfig{VolumeDriver: "nvidia-docker"}, Config: &docker.Config{}}
+	mount := &docker.Container{
+		HostConfig: &docker.HostConfig{},
+		Config:     &docker.Config{},
+		Mounts:     []docker.Mount{{Name: "nvidia_driver_375.66", Destination: "/usr/local/nvidia", Driver: "local"}},
+	}
+	// The CUDA images request the volume with a label, and also run without
+	// nvidia-docker.
+	image := &docker.Container{
+		HostConfig: &docker.HostConfig{},
+		Config:     &docker.Config{Labels: map[string]string{"com.nvidia.volumes.needed": "nvidia_driver"}},
+	}
+
+	if !NVIDIADockerV1(volumeDriver) || !NVIDIADockerV1(mount) {
+		t.Fatal("expected nvidia-docker 1.x containers")
+	}
+	if NVIDIADockerV1(image) {
+		t.Fatal("unexpected nvidia-docker 1.x image container")
+	}
+}
+
+func TestContainerDeviceNumbers(t *testing.T) {
+	gpuDevices := []DeviceStatus{
+		{Index: toUintP(0), MinorNumber: toUintP(0)},
+		{Index: toUintP(1), MinorNumber: toUintP(3)},
+	}
+	container := &docker.Container{
+		HostConfig: &docker.HostConfig{
+			Devices: []docker.Device{
+				{PathOnHost: "/dev/nvidia3", PathInContainer: "/dev/nvidia0"},
+				{PathOnHost: "/dev/nvidiactl", PathInContainer: "/dev/nvidiactl"},
+				// A GPU that fell off the bus has no position.
+				{PathOnHost: "/dev/nvidia5", PathInContainer: "/dev/nvidia1"},
+			},
+		},
+		Config: &docker.Config{},
+	}
+
+	if numbers := ContainerDeviceNumbers(container, gpuDevices); !reflect.DeepEqual(numbers, map[int]uint{1: 0}) {
+		t.Fatalf("unexpected numbers %v", numbers)
+	}
+}
+
+func TestMissingDevices(t *testing.T) {
+	container := &docker.Container{
+		HostConfig: &docker.HostConfig{
+			Devices: []docker.Device{
+				{PathOnHost: "/dev/nvidia1", PathInContainer: "/dev/nvidia1"},
+				{PathOnHost: "/dev/nvidia3", PathInContainer: "/dev/nvidia3"},
+				{PathOnHost: "/dev/nvidiactl", PathInContainer: "/dev/nvidiactl"},
+			},
+		},
+		Config: &docker.Config{},
+	}
+
+	missing := MissingDevices(container, make([]DeviceStatus, 2))
+	if !reflect.DeepEqual(missing, []string{"/dev/nvidia3"}) {
+		t.Fatalf("unexpected missing devices %v", missing)
+	}
+
+	// The GPU of minor number 1 fell off the bus, the GPU of minor number 3
+	// is now at index 1.
+	minors := []uint{0, 3}
+	gpuDevices := []DeviceStatus{{MinorNumber: &minors[0]}, {MinorNumber: &minors[1]}}
+	missing = MissingDevices(container, gpuDevices)
+	if !reflect.DeepEqual(missing, []string{"/dev/nvidia1"}) {
+		t.Fatalf("unexpected missing devices %v", missing)
+	}
+	indices := NVIDIABackend.ContainerDeviceIndices(container, nil, nil, gpuDevices, "")
+	if !reflect.DeepEqual(indices, []int{1}) {
+		t.Fatalf("unexpected indices %v", indices)
+	}
+}
+
+func TestRemoteContainerDevices(t *testing.T) {
+	// A local process whose PID is the one of the remote container, in a
+	// container allowed to use the GPU of minor number 1.
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+	id := strings.Repeat("ab", 32)
+	files := map[string]string{
+		"proc/42/cgroup": "4:devices:/docker/" + id + "\n",
+		"sys/fs/cgroup/devices/docker/" + id + "/devices.list": "c 195:1 rwm\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(hostFS, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gpuDevices := []DeviceStatus{
+		{Index: toUintP(0), MinorNumber: toUintP(0)},
+		{Index: toUintP(1), MinorNumber: toUintP(1)},
+	}
+	container := &docker.Container{
+		HostConfig: &docker.HostConfig{},
+		Config:     &docker.Config{},
+		State:      docker.State{Pid: 42},
+	}
+
+	config := DefaultConfig()
+	config.HostFS = hostFS
+	if !config.LocalHost() || config.KubeletCheckpointPath() == "" {
+		t.Fatal("expected the local host")
+	}
+	if indices := config.ContainerBackend().ContainerDeviceIndices(container, nil, nil, gpuDevices, hostFS); !reflect.DeepEqual(indices, []int{1}) {
+		t.Fatalf("expected the GPU of the local devices cgroup, got %v", indices)
+	}
+
+	for _, host := range []string{"tcp://gpu1:2376", "ssh://ops@gpu1"} {
+		remote := config
+		remote.GPUSource = GPUSourceSMI
+		if strings.HasPrefix(host, "tcp") {
+			remote.GPUSource = GPUSourceAPI
+		}
+		if err := remote.ApplyHost(host); err != nil {
+			t.Fatal(err)
+		}
+		if remote.LocalHost() || remote.KubeletCheckpointPath() != "" {
+			t.Fatalf("expected %s not to be the local host", host)
+		}
+		if indices := remote.ContainerBackend().ContainerDeviceIndices(container, nil, nil, gpuDevices, hostFS); len(indices) != 0 {
+			t.Fatalf("expected no GPU of %s from the local /proc, got %v", host, indices)
+		}
+	}
+
+	// The GPUs of the container configuration are still matched.
+	container.HostConfig.Devices = []docker.Device{{PathOnHost: "/dev/nvidia0", PathInContainer: "/dev/nvidia0"}}
+	remote := config
+	remote.DockerEndpoint = "tcp://gpu1:2376"
+	if indices := remote.ContainerBackend().ContainerDeviceIndices(container, nil, nil, gpuDevices, hostFS); !reflect.DeepEqual(indices, []int{0}) {
+		t.Fatalf("expected the mapped GPU, got %v", indices)
+	}
+}
@@ -0,0 +1,92 @@
+package nvidiadocker
+
+import (
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common/match"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ContainerFilter selects the containers the MetricSets inspect and report,
+// from the include_labels, exclude_labels, include_names and exclude_names
+// options. A container is selected if it has one of the include labels and
+// its name matches one of the include names, where no include option means
+// any container, and it has none of the exclude labels and its name matches
+// none of the exclude names. The labels are given as name or name=value.
+type ContainerFilter struct {
+	includeLabels []labelSelector
+	excludeLabels []labelSelector
+	includeNames  []match.Matcher
+	excludeNames  []match.Matcher
+}
+
+type labelSelector struct {
+	name     string
+	value    string
+	hasValue bool
+}
+
+// NewContainerFilter returns the filter of the given configuration, nil if it
+// selects every container.
+func NewContainerFilter(config Config) *ContainerFilter {
+	if len(config.IncludeLabels) == 0 && len(config.ExcludeLabels) == 0 &&
+		len(config.IncludeNames) == 0 && len(config.ExcludeNames) == 0 {
+		return nil
+	}
+	return &ContainerFilter{
+		includeLabels: labelSelectors(config.IncludeLabels),
+		excludeLabels: labelSelectors(config.ExcludeLabels),
+		includeNames:  config.IncludeNames,
+		excludeNames:  config.ExcludeNames,
+	}
+}
+
+func labelSelectors(labels []string) []labelSelector {
+	selectors := make([]labelSelector, 0, len(labels))
+	for _, label := range labels {
+		parts := strings.SplitN(label, "=", 2)
+		selector := labelSelector{name: parts[0]}
+		if len(parts) == 2 {
+			selector.value = parts[1]
+			selector.hasValue = true
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors
+}
+
+// Match returns whether the container with the given name and labels is
+// selected.
+func (f *ContainerFilter) Match(name string, labels map[string]string) bool {
+	if f == nil {
+		return true
+	}
+	name = strings.TrimPrefix(name, "/")
+
+	if len(f.includeLabels) > 0 && !hasAnyLabel(labels, f.includeLabels) {
+		return false
+	}
+	if len(f.includeNames) > 0 && !matchAny(f.includeNames, name) {
+		return false
+	}
+	return !hasAnyLabel(labels, f.excludeLabels) && !matchAny(f.excludeNames, name)
+}
+
+// MatchContainer returns whether the inspected container is selected.
+func (f *ContainerFilter) MatchContainer(container *docker.Container) bool {
+	var labels map[string]string
+	if container.Config != nil {
+		labels = container.Config.Labels
+	}
+	return f.Match(container.Name, labels)
+}
+
+func hasAnyLabel(labels map[string]string, selectors []labelSelector) bool {
+	for _, selector := range selectors {
+		value, found := labels[selector.name]
+		if found && (!selector.hasValue || value == selector.value) {
+			return true
+		}
+	}
+	return false
+}
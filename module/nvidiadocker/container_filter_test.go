@@ -0,0 +1,46 @@
+package nvidiadocker
+
+import (
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestContainerFilter(t *testing.T) {
+	c, err := common.NewConfigFrom(map[string]interface{}{
+		"include_labels": []string{"com.nvidia.volumes.needed", "gpu=true"},
+		"exclude_labels": []string{"io.kubernetes.container.name=istio-proxy"},
+		"exclude_names":  []string{"^k8s_POD_"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	if err := c.Unpack(&config); err != nil {
+		t.Fatal(err)
+	}
+	filter := NewContainerFilter(config)
+
+	testDatas := []struct {
+		Name     string
+		Labels   map[string]string
+		Expected bool
+	}{
+		{"/train", map[string]string{"com.nvidia.volumes.needed": "nvidia_driver"}, true},
+		{"/train", map[string]string{"gpu": "true"}, true},
+		{"/train", map[string]string{"gpu": "false"}, false},
+		{"/train", nil, false},
+		{"/k8s_POD_train", map[string]string{"gpu": "true"}, false},
+		{"/sidecar", map[string]string{"gpu": "true", "io.kubernetes.container.name": "istio-proxy"}, false},
+	}
+
+	for _, testData := range testDatas {
+		if matched := filter.Match(testData.Name, testData.Labels); matched != testData.Expected {
+			t.Fatalf("%s %v: expected %v, got %v", testData.Name, testData.Labels, testData.Expected, matched)
+		}
+	}
+
+	if filter := NewContainerFilter(DefaultConfig()); filter != nil || !filter.Match("/train", nil) {
+		t.Fatal("expected every container to be selected without options")
+	}
+}
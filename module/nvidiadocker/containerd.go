@@ -0,0 +1,129 @@
+package nvidiadocker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// containerdClient reads the containers of a containerd namespace with ctr.
+type containerdClient struct {
+	endpoint  string
+	namespace string
+}
+
+// ListContainers returns the containers with a running task.
+func (c *containerdClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	tasks, err := c.tasks()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(tasks))
+	for id := range tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	containers := make([]docker.APIContainers, 0, len(ids))
+	for _, id := range ids {
+		containers = append(containers, docker.APIContainers{ID: id, State: "running"})
+	}
+	return containers, nil
+}
+
+func (c *containerdClient) InspectContainer(id string) (*docker.Container, error) {
+	container, _, err := c.InspectContainerWithRuntime(id)
+	return container, err
+}
+
+func (c *containerdClient) InspectContainerWithRuntime(id string) (*docker.Container, *ContainerRuntime, error) {
+	output, err := c.exec("containers", "info", id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The process ID is only known to the task of the container.
+	tasks, err := c.tasks()
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseCtrContainerInfo(output, tasks[id])
+}
+
+// tasks returns the process IDs of the running tasks, by container ID.
+func (c *containerdClient) tasks() (map[string]int, error) {
+	output, err := c.exec("tasks", "list")
+	if err != nil {
+		return nil, err
+	}
+	return parseCtrTasks(output)
+}
+
+func (c *containerdClient) exec(args ...string) ([]byte, error) {
+	global := []string{"--namespace", c.namespace}
+	if c.endpoint != "" {
+		global = append(global, "--address", c.endpoint)
+	}
+	return execCtrCommand(append(global, args...)...)
+}
+
+func execCtrCommand(args ...string) ([]byte, error) {
+	cmd := exec.Command("ctr", args...)
+	return cmd.Output()
+}
+
+// parseCtrTasks parses the output of ctr tasks list into the process IDs of
+// the running tasks:
+//
+//	TASK                                                                PID      STATUS
+//	4a8b1e2c0f6c5d1b7e9a3f2d8c6b4a1e0f9d8c7b6a5e4d3c2b1a0f9e8d7c6b5a    12345    RUNNING
+func parseCtrTasks(output []byte) (map[string]int, error) {
+	tasks := map[string]int{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] == "TASK" || fields[2] != "RUNNING" {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("ctr: invalid pid value %q: %v", fields[1], err)
+		}
+		tasks[fields[0]] = pid
+	}
+	return tasks, nil
+}
+
+func parseCtrContainerInfo(output []byte, pid int) (*docker.Container, *ContainerRuntime, error) {
+	var info struct {
+		ID      string
+		Labels  map[string]string
+		Image   string
+		Runtime struct {
+			Name    string
+			Options json.RawMessage
+		}
+		Spec ociSpec
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return nil, nil, err
+	}
+
+	// containerd containers have no name, the kubelet labels them with the
+	// name of the container in the pod.
+	name := info.Labels[KubernetesContainerNameLabel]
+	if name == "" {
+		name = info.ID
+	}
+
+	nvidiaRuntime := strings.Contains(info.Runtime.Name, "nvidia") ||
+		strings.Contains(string(info.Runtime.Options), "nvidia")
+
+	container, runtime := ociContainer(info.ID, name, info.Image, info.Labels, pid, &info.Spec, nvidiaRuntime)
+	return container, runtime, nil
+}
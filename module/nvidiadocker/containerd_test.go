@@ -0,0 +1,67 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCtrTasks(t *testing.T) {
+	output := []byte("TASK                                                                PID      STATUS    \n" +
+		"4a8b1e2c0f6c5d1b7e9a3f2d8c6b4a1e0f9d8c7b6a5e4d3c2b1a0f9e8d7c6b5a    12345    RUNNING    \n" +
+		"9f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0    0        STOPPED    \n")
+
+	tasks, err := parseCtrTasks(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"4a8b1e2c0f6c5d1b7e9a3f2d8c6b4a1e0f9d8c7b6a5e4d3c2b1a0f9e8d7c6b5a": 12345}
+	if !reflect.DeepEqual(tasks, expected) {
+		t.Fatalf("expected %v, got %v", expected, tasks)
+	}
+
+	if _, err := parseCtrTasks([]byte("abc    pid    RUNNING\n")); err == nil {
+		t.Fatal("expected error for invalid pid")
+	}
+}
+
+func TestParseCtrContainerInfo(t *testing.T) {
+	output := []byte(`{
+    "ID": "4a8b1e2c0f6c5d1b7e9a3f2d8c6b4a1e0f9d8c7b6a5e4d3c2b1a0f9e8d7c6b5a",
+    "Labels": {
+        "io.kubernetes.container.name": "train",
+        "io.kubernetes.pod.uid": "pod-a"
+    },
+    "Image": "docker.io/nvidia/cuda:11.4.2-base",
+    "Runtime": {
+        "Name": "io.containerd.runc.v2",
+        "Options": {"type_url": "containerd.runc.v1.Options"}
+    },
+    "Spec": {
+        "ociVersion": "1.0.2-dev",
+        "process": {"env": ["NVIDIA_VISIBLE_DEVICES=0"]},
+        "linux": {"devices": [{"path": "/dev/nvidia0", "type": "c", "major": 195, "minor": 0}]}
+    }
+}`)
+
+	container, runtime, err := parseCtrContainerInfo(output, 12345)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Name != "/train" || container.State.Pid != 12345 ||
+		container.Config.Image != "docker.io/nvidia/cuda:11.4.2-base" ||
+		len(container.HostConfig.Devices) != 1 || container.HostConfig.Devices[0].PathOnHost != "/dev/nvidia0" ||
+		!reflect.DeepEqual(container.Config.Env, []string{"NVIDIA_VISIBLE_DEVICES=0"}) {
+		t.Fatalf("unexpected container %+v", container)
+	}
+	if runtime.UsesNvidiaRuntime() {
+		t.Fatalf("expected the runc runtime, got %+v", runtime)
+	}
+
+	container, _, err = parseCtrContainerInfo([]byte(`{"ID": "abc", "Spec": {}}`), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Name != "/abc" {
+		t.Fatalf("expected the ID as name, got %q", container.Name)
+	}
+}
@@ -0,0 +1,108 @@
+package nvidiadocker
+
+import (
+	"time"
+)
+
+// Widths of the hardware counters, after which they roll over to 0.
+const (
+	Counter32 = 32
+	Counter64 = 64
+)
+
+// CounterStore remembers the values of counters between fetches to report
+// their increase and rate since the previous fetch. Counters are identified by
+// a key, like the GPU UUID and the name of the counter.
+type CounterStore struct {
+	now      func() time.Time
+	previous map[string]counterValue
+	current  map[string]counterValue
+}
+
+type counterValue struct {
+	value uint64
+	time  time.Time
+}
+
+// CounterDelta is the increase of a counter since the previous fetch, and the
+// increase per second.
+type CounterDelta struct {
+	Delta uint64
+	Rate  float64
+}
+
+// NewCounterStore creates an empty CounterStore.
+func NewCounterStore() *CounterStore {
+	return &CounterStore{
+		now:      time.Now,
+		previous: map[string]counterValue{},
+		current:  map[string]counterValue{},
+	}
+}
+
+// Update records the value of a counter of the given width for the current
+// fetch and returns its increase since the previous fetch. ok is false on the
+// first fetch of a counter.
+func (s *CounterStore) Update(key string, value uint64, width uint) (delta CounterDelta, ok bool) {
+	now := s.now()
+	s.current[key] = counterValue{value: value, time: now}
+
+	previous, found := s.previous[key]
+	if !found {
+		return CounterDelta{}, false
+	}
+
+	delta.Delta = counterIncrease(value, previous.value, width)
+	if elapsed := now.Sub(previous.time).Seconds(); elapsed > 0 {
+		delta.Rate = float64(delta.Delta) / elapsed
+	}
+	return delta, true
+}
+
+// Change records the value of a gauge, like a temperature, for the current
+// fetch and returns its change per second since the previous fetch, negative
+// if it decreased. ok is false on the first fetch of a gauge.
+func (s *CounterStore) Change(key string, value uint64) (perSecond float64, ok bool) {
+	now := s.now()
+	s.current[key] = counterValue{value: value, time: now}
+
+	previous, found := s.previous[key]
+	if !found {
+		return 0, false
+	}
+	if elapsed := now.Sub(previous.time).Seconds(); elapsed > 0 {
+		perSecond = (float64(value) - float64(previous.value)) / elapsed
+	}
+	return perSecond, true
+}
+
+// Skip keeps the previous value of a counter whose current value is unknown,
+// so that its next increase is computed from the last known value.
+func (s *CounterStore) Skip(key string) {
+	if previous, found := s.previous[key]; found {
+		s.current[key] = previous
+	}
+}
+
+// Commit ends a fetch. Counters that were not updated during the fetch, like
+// the counters of a GPU that has been removed, are forgotten.
+func (s *CounterStore) Commit() {
+	s.previous = s.current
+	s.current = make(map[string]counterValue, len(s.previous))
+}
+
+// counterIncrease returns the increase of a counter. A counter lower than
+// before that was in the top quarter of its range has rolled over. Any other
+// decrease is a reset, by a driver reload or a GPU reset, after which all of
+// the value is new.
+func counterIncrease(current, previous uint64, width uint) uint64 {
+	if current >= previous {
+		return current - previous
+	}
+
+	max := ^uint64(0) >> (64 - width)
+	if previous > max-max/4 {
+		return max - previous + current + 1
+	}
+	return current
+}
@@ -0,0 +1,110 @@
+package nvidiadocker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounterStore(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	store := NewCounterStore()
+	store.now = func() time.Time { return now }
+
+	if _, ok := store.Update("gpu0/replays", 100, Counter32); ok {
+		t.Fatal("expected no delta on the first fetch")
+	}
+	store.Commit()
+
+	testDatas := []struct {
+		Value    uint64
+		Width    uint
+		Expected CounterDelta
+	}{
+		{150, Counter32, CounterDelta{Delta: 50, Rate: 5}},
+		// Reset by a driver reload.
+		{20, Counter32, CounterDelta{Delta: 20, Rate: 2}},
+		{1<<32 - 10, Counter32, CounterDelta{Delta: 1<<32 - 30, Rate: float64(1<<32-30) / 10}},
+		// Rolled over.
+		{30, Counter32, CounterDelta{Delta: 40, Rate: 4}},
+	}
+
+	for i, testData := range testDatas {
+		now = now.Add(10 * time.Second)
+		delta, ok := store.Update("gpu0/replays", testData.Value, testData.Width)
+		if !ok || delta != testData.Expected {
+			t.Fatalf("fetch %d: expected %+v, got %+v", i, testData.Expected, delta)
+		}
+		store.Commit()
+	}
+
+	store.Commit()
+	if _, ok := store.Update("gpu0/replays", 40, Counter32); ok {
+		t.Fatal("expected counter missing from a fetch to be forgotten")
+	}
+}
+
+func TestCounterStoreChange(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	store := NewCounterStore()
+	store.now = func() time.Time { return now }
+
+	if _, ok := store.Change("gpu0/temperature", 60); ok {
+		t.Fatal("expected no change on the first fetch")
+	}
+	store.Commit()
+
+	for _, testData := range []struct {
+		Value    uint64
+		Expected float64
+	}{
+		{70, 1},
+		{65, -0.5},
+		{65, 0},
+	} {
+		now = now.Add(10 * time.Second)
+		perSecond, ok := store.Change("gpu0/temperature", testData.Value)
+		if !ok || perSecond != testData.Expected {
+			t.Fatalf("%d: expected %v, got %v", testData.Value, testData.Expected, perSecond)
+		}
+		store.Commit()
+	}
+}
+
+func TestCounterStoreSkip(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	store := NewCounterStore()
+	store.now = func() time.Time { return now }
+
+	store.Update("gpu0/ecc", 10, Counter64)
+	store.Commit()
+
+	now = now.Add(10 * time.Second)
+	store.Skip("gpu0/ecc")
+	store.Commit()
+
+	now = now.Add(10 * time.Second)
+	delta, ok := store.Update("gpu0/ecc", 14, Counter64)
+	if !ok || delta != (CounterDelta{Delta: 4, Rate: 0.2}) {
+		t.Fatalf("expected the increase since the last known value, got %+v", delta)
+	}
+}
+
+func TestCounterIncrease(t *testing.T) {
+	testDatas := []struct {
+		Current, Previous uint64
+		Width             uint
+		Expected          uint64
+	}{
+		{10, 5, Counter64, 5},
+		{3, 5, Counter64, 3},
+		{3, ^uint64(0) - 1, Counter64, 5},
+		{3, 1<<31 + 5, Counter32, 3},
+		{3, 1<<32 - 2, Counter32, 5},
+	}
+
+	for _, testData := range testDatas {
+		if increase := counterIncrease(testData.Current, testData.Previous, testData.Width); increase != testData.Expected {
+			t.Fatalf("%+v: got %d", testData, increase)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package nvidiadocker
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// criClient reads the containers of a CRI runtime, like containerd or CRI-O
+// on a Kubernetes node, with crictl.
+type criClient struct {
+	endpoint string
+}
+
+func (c *criClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	output, err := c.exec("ps", "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+	return parseCrictlPs(output)
+}
+
+func (c *criClient) InspectContainer(id string) (*docker.Container, error) {
+	container, _, err := c.InspectContainerWithRuntime(id)
+	return container, err
+}
+
+func (c *criClient) InspectContainerWithRuntime(id string) (*docker.Container, *ContainerRuntime, error) {
+	output, err := c.exec("inspect", "--output", "json", id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseCrictlInspect(output)
+}
+
+func (c *criClient) exec(args ...string) ([]byte, error) {
+	if c.endpoint != "" {
+		args = append([]string{"--runtime-endpoint", c.endpoint}, args...)
+	}
+	return execCrictlCommand(args...)
+}
+
+func execCrictlCommand(args ...string) ([]byte, error) {
+	cmd := exec.Command("crictl", args...)
+	return cmd.Output()
+}
+
+type criMetadata struct {
+	Name string `json:"name"`
+}
+
+// criImage is the image a container was created from, as a reference like
+// docker.io/nvidia/cuda:11.4.2-base, or an image ID if it was pulled by ID.
+type criImage struct {
+	Image string `json:"image"`
+}
+
+func parseCrictlPs(output []byte) ([]docker.APIContainers, error) {
+	var ps struct {
+		Containers []struct {
+			ID       string            `json:"id"`
+			Metadata criMetadata       `json:"metadata"`
+			Labels   map[string]string `json:"labels"`
+			State    string            `json:"state"`
+		} `json:"containers"`
+	}
+	if err := json.Unmarshal(output, &ps); err != nil {
+		return nil, err
+	}
+
+	containers := make([]docker.APIContainers, 0, len(ps.Containers))
+	for _, c := range ps.Containers {
+		containers = append(containers, docker.APIContainers{
+			ID:     c.ID,
+			Names:  []string{"/" + c.Metadata.Name},
+			Labels: c.Labels,
+			State:  c.State,
+		})
+	}
+	return containers, nil
+}
+
+func parseCrictlInspect(output []byte) (*docker.Container, *ContainerRuntime, error) {
+	var inspect struct {
+		Status struct {
+			ID       string            `json:"id"`
+			Metadata criMetadata       `json:"metadata"`
+			Image    criImage          `json:"image"`
+			Labels   map[string]string `json:"labels"`
+		} `json:"status"`
+		Info struct {
+			Pid            int             `json:"pid"`
+			RuntimeType    string          `json:"runtimeType"`
+			RuntimeOptions json.RawMessage `json:"runtimeOptions"`
+			RuntimeSpec    ociSpec         `json:"runtimeSpec"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(output, &inspect); err != nil {
+		return nil, nil, err
+	}
+
+	// The NVIDIA runtime is configured as a runtime handler, or as the binary
+	// of the runc handler.
+	nvidiaRuntime := strings.Contains(inspect.Info.RuntimeType, "nvidia") ||
+		strings.Contains(string(inspect.Info.RuntimeOptions), "nvidia")
+
+	container, runtime := ociContainer(inspect.Status.ID, inspect.Status.Metadata.Name, inspect.Status.Image.Image, inspect.Status.Labels,
+		inspect.Info.Pid, &inspect.Info.RuntimeSpec, nvidiaRuntime)
+	return container, runtime, nil
+}
@@ -0,0 +1,100 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCrictlPs(t *testing.T) {
+	output := []byte(`{
+  "containers": [
+    {
+      "id": "4a8b1e2c0f6c5d1b7e9a3f2d8c6b4a1e0f9d8c7b6a5e4d3c2b1a0f9e8d7c6b5a",
+      "podSandboxId": "9f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0",
+      "metadata": {"name": "train", "attempt": 0},
+      "image": {"image": "sha256:2b7d2f1a"},
+      "state": "CONTAINER_RUNNING",
+      "labels": {
+        "io.kubernetes.container.name": "train",
+        "io.kubernetes.pod.uid": "pod-a"
+      }
+    }
+  ]
+}`)
+
+	containers, err := parseCrictlPs(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || containers[0].ID != "4a8b1e2c0f6c5d1b7e9a3f2d8c6b4a1e0f9d8c7b6a5e4d3c2b1a0f9e8d7c6b5a" ||
+		!reflect.DeepEqual(containers[0].Names, []string{"/train"}) || containers[0].Labels[KubernetesPodUIDLabel] != "pod-a" {
+		t.Fatalf("unexpected containers %+v", containers)
+	}
+}
+
+func TestParseCrictlInspect(t *testing.T) {
+	output := []byte(`{
+  "status": {
+    "id": "4a8b1e2c0f6c5d1b7e9a3f2d8c6b4a1e0f9d8c7b6a5e4d3c2b1a0f9e8d7c6b5a",
+    "metadata": {"name": "train", "attempt": 0},
+    "image": {"image": "docker.io/nvidia/cuda:11.4.2-base"},
+    "state": "CONTAINER_RUNNING",
+    "labels": {"io.kubernetes.pod.uid": "pod-a", "io.kubernetes.container.name": "train"}
+  },
+  "info": {
+    "pid": 12345,
+    "runtimeType": "io.containerd.runc.v2",
+    "runtimeOptions": {"binary_name": "/usr/bin/nvidia-container-runtime"},
+    "runtimeSpec": {
+      "process": {"env": ["PATH=/usr/bin", "NVIDIA_VISIBLE_DEVICES=GPU-1"]},
+      "linux": {"devices": [{"path": "/dev/nvidia1", "type": "c", "major": 195, "minor": 1}]}
+    }
+  }
+}`)
+
+	container, runtime, err := parseCrictlInspect(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.ID != "4a8b1e2c0f6c5d1b7e9a3f2d8c6b4a1e0f9d8c7b6a5e4d3c2b1a0f9e8d7c6b5a" || container.Name != "/train" ||
+		container.State.Pid != 12345 || !container.State.Running ||
+		container.Config.Labels[KubernetesContainerNameLabel] != "train" ||
+		container.Config.Image != "docker.io/nvidia/cuda:11.4.2-base" ||
+		len(container.HostConfig.Devices) != 1 || container.HostConfig.Devices[0].PathOnHost != "/dev/nvidia1" {
+		t.Fatalf("unexpected container %+v", container)
+	}
+	if !runtime.UsesNvidiaRuntime() {
+		t.Fatalf("expected the nvidia runtime, got %+v", runtime)
+	}
+
+	devices := []DeviceStatus{{Index: toUintP(0), UUID: "GPU-0"}, {Index: toUintP(1), UUID: "GPU-1"}}
+	if positions := VisibleDevices(container.Config.Env, runtime, devices); !reflect.DeepEqual(positions, []int{1}) {
+		t.Fatalf("unexpected visible devices %v", positions)
+	}
+}
+
+func TestParseCrictlInspectHook(t *testing.T) {
+	output := []byte(`{
+  "status": {"id": "abc", "metadata": {"name": "serve"}},
+  "info": {
+    "pid": 0,
+    "runtimeType": "io.containerd.runc.v2",
+    "runtimeSpec": {
+      "process": {"env": ["NVIDIA_VISIBLE_DEVICES=all"]},
+      "hooks": {"prestart": [{"path": "/usr/bin/nvidia-container-runtime-hook", "args": ["prestart"]}]}
+    }
+  }
+}`)
+
+	container, runtime, err := parseCrictlInspect(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.State.Running || !runtime.UsesNvidiaRuntime() {
+		t.Fatalf("unexpected container %+v with runtime %+v", container, runtime)
+	}
+
+	if _, _, err := parseCrictlInspect([]byte("not json")); err == nil {
+		t.Fatal("expected error for invalid output")
+	}
+}
@@ -0,0 +1,252 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	if err := AddCollector(GPUSourceDCGM, NVIDIABackend, newDCGMCollector); err != nil {
+		panic(err)
+	}
+}
+
+// dcgmProfilingFields are the DCGM profiling field IDs sampled with dcgmi
+// dmon, by the column dcgmi reports them under.
+var dcgmProfilingFields = []struct {
+	id     int
+	column string
+	set    func(p *ProfilingInfo, value float64)
+}{
+	{1001, "GRACT", func(p *ProfilingInfo, value float64) { p.GraphicsActive = value }},
+	{1002, "SMACT", func(p *ProfilingInfo, value float64) { p.SMActive = value }},
+	{1003, "SMOCC", func(p *ProfilingInfo, value float64) { p.SMOccupancy = value }},
+	{1004, "TENSO", func(p *ProfilingInfo, value float64) { p.TensorActive = value }},
+	{1005, "DRAMA", func(p *ProfilingInfo, value float64) { p.DRAMActive = value }},
+	{1006, "FP64A", func(p *ProfilingInfo, value float64) { p.FP64Active = value }},
+	{1007, "FP32A", func(p *ProfilingInfo, value float64) { p.FP32Active = value }},
+	{1008, "FP16A", func(p *ProfilingInfo, value float64) { p.FP16Active = value }},
+}
+
+// dcgmNVSwitchFields are the DCGM field IDs of the SXid of the last fatal
+// and non fatal error of an NVSwitch, sampled with dcgmi dmon in this order.
+var dcgmNVSwitchFields = []string{"856", "857"}
+
+// dcgmCollector reads the GPU status with nvidia-smi and adds the profiling
+// metrics of the Data Center GPU Manager, sampled with dcgmi. The DCGM host
+// engine, nv-hostengine, has to run on the host.
+type dcgmCollector struct {
+	*smiCollector
+	dcgmiPath string
+}
+
+func newDCGMCollector(config Config) (GPUCollector, error) {
+	smi, err := newSMICollectorFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &dcgmCollector{
+		smiCollector: smi,
+		dcgmiPath:    config.hostBinary("dcgmi"),
+	}, nil
+}
+
+func (c *dcgmCollector) Query(indices []uint) ([]DeviceStatus, error) {
+	devices, err := c.smiCollector.Query(indices)
+	if err != nil || len(devices) == 0 {
+		return devices, err
+	}
+
+	ids := make([]string, 0, len(devices))
+	for _, device := range devices {
+		if device.Index != nil {
+			ids = append(ids, strconv.FormatUint(uint64(*device.Index), 10))
+		}
+	}
+	fields := make([]string, len(dcgmProfilingFields))
+	for i, field := range dcgmProfilingFields {
+		fields[i] = strconv.Itoa(field.id)
+	}
+
+	output, err := c.execDCGMICommand("dmon", "-e", strings.Join(fields, ","), "-c", "1", "-i", strings.Join(ids, ","))
+	if err != nil {
+		return nil, fmt.Errorf("dcgmi dmon: %v", err)
+	}
+	profiling, err := parseDCGMIDmon(output)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range devices {
+		if devices[i].Index == nil {
+			continue
+		}
+		if p, found := profiling[*devices[i].Index]; found {
+			devices[i].Profiling = p
+		}
+	}
+	return devices, nil
+}
+
+// NVSwitches reads the errors of the NVSwitches DCGM discovers on the host.
+func (c *dcgmCollector) NVSwitches() ([]NVSwitch, error) {
+	output, err := c.execDCGMICommand("discovery", "--list")
+	if err != nil {
+		return nil, fmt.Errorf("dcgmi discovery: %v", err)
+	}
+	ids := parseDCGMIDiscoverySwitches(output)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	entities := make([]string, len(ids))
+	for i, id := range ids {
+		entities[i] = "nvswitch:" + strconv.FormatUint(uint64(id), 10)
+	}
+	output, err = c.execDCGMICommand("dmon", "-e", strings.Join(dcgmNVSwitchFields, ","), "-c", "1", "-i", strings.Join(entities, ","))
+	if err != nil {
+		return nil, fmt.Errorf("dcgmi dmon: %v", err)
+	}
+	return parseDCGMISwitchDmon(output)
+}
+
+func (c *dcgmCollector) execDCGMICommand(args ...string) ([]byte, error) {
+	return c.runner.run(c.dcgmiPath, args...)
+}
+
+// parseDCGMIDmon reads the profiling metrics from the output of dcgmi dmon,
+// which has one line per GPU below a header naming the fields:
+//
+//	# Entity  GRACT  SMACT  SMOCC  TENSO  DRAMA  FP64A  FP32A  FP16A
+//	      ID
+//	    GPU 0  0.000  0.000  0.000  0.000  0.000  0.000  0.000  0.000
+//
+// Fields a GPU does not support are reported as N/A and left at 0.
+func parseDCGMIDmon(output []byte) (map[uint]*ProfilingInfo, error) {
+	var columns []string
+	profiling := map[uint]*ProfilingInfo{}
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			// The entity column is followed by the field columns.
+			if header := strings.Fields(strings.TrimPrefix(line, "#")); columns == nil && len(header) > 0 {
+				columns = header[1:]
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// Other entities, like the GPU instances of MIG devices, are skipped.
+		if fields[0] != "GPU" {
+			continue
+		}
+		if columns == nil {
+			return nil, fmt.Errorf("dcgmi dmon: missing header before line %q", line)
+		}
+		if len(fields) != len(columns)+2 {
+			return nil, fmt.Errorf("dcgmi dmon: unexpected line %q", line)
+		}
+
+		index, err := parseSMIUint(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("dcgmi dmon: invalid gpu value %q: %v", fields[1], err)
+		}
+
+		p := &ProfilingInfo{}
+		for i, column := range columns {
+			for _, field := range dcgmProfilingFields {
+				if field.column != column {
+					continue
+				}
+				value, err := parseDCGMIValue(fields[i+2])
+				if err != nil {
+					return nil, fmt.Errorf("dcgmi dmon: invalid %s value %q: %v", column, fields[i+2], err)
+				}
+				field.set(p, value)
+			}
+		}
+		profiling[uint(index)] = p
+	}
+	return profiling, nil
+}
+
+// parseDCGMIDiscoverySwitches reads the IDs of the NVSwitches from the output
+// of dcgmi discovery --list, which lists them below the GPUs:
+//
+//	6 NvSwitches found.
+//	+-----------+
+//	| Switch ID |
+//	+-----------+
+//	| 11        |
+//	| 10        |
+//	+-----------+
+func parseDCGMIDiscoverySwitches(output []byte) []uint {
+	var ids []uint
+	var switches bool
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, "found.") {
+			switches = strings.Contains(strings.ToLower(line), "nvswitch")
+			continue
+		}
+		if !switches || !strings.HasPrefix(line, "|") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(strings.Trim(line, "|")), 10, 32)
+		if err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// parseDCGMISwitchDmon reads the errors of the NVSwitches from the output of
+// dcgmi dmon sampling dcgmNVSwitchFields, which has one line per NVSwitch
+// below the header, with the values in the order of the fields:
+//
+//	Switch 11  0   0
+//	Switch 10  95  N/A
+//
+// Errors that were never reported are reported as N/A or 0.
+func parseDCGMISwitchDmon(output []byte) ([]NVSwitch, error) {
+	var switches []NVSwitch
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "Switch" {
+			continue
+		}
+		if len(fields) != len(dcgmNVSwitchFields)+2 {
+			return nil, fmt.Errorf("dcgmi dmon: unexpected line %q", line)
+		}
+
+		id, err := parseSMIUint(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("dcgmi dmon: invalid switch value %q: %v", fields[1], err)
+		}
+		var sxids [2]uint
+		for i := range sxids {
+			value, err := parseDCGMIValue(fields[i+2])
+			if err != nil {
+				return nil, fmt.Errorf("dcgmi dmon: invalid field %s value %q: %v", dcgmNVSwitchFields[i], fields[i+2], err)
+			}
+			sxids[i] = uint(value)
+		}
+		switches = append(switches, NVSwitch{
+			ID:           uint(id),
+			FatalSXID:    sxids[0],
+			NonFatalSXID: sxids[1],
+		})
+	}
+	return switches, nil
+}
+
+func parseDCGMIValue(value string) (float64, error) {
+	if value == "N/A" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
@@ -0,0 +1,85 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDCGMIDmon(t *testing.T) {
+	output := "# Entity  GRACT  SMACT  SMOCC  TENSO  DRAMA  FP64A  FP32A  FP16A\n" +
+		"      ID\n" +
+		"    GPU 0  0.951  0.873  0.412  0.305  0.520  0.000  0.221  N/A\n" +
+		"    GPU 1  0.000  0.000  0.000  0.000  0.000  0.000  0.000  0.000\n" +
+		"  GPU-I 7  0.500  0.400  0.300  0.200  0.100  0.000  0.000  0.000\n"
+
+	profiling, err := parseDCGMIDmon([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiling) != 2 {
+		t.Fatalf("expected 2 gpus, got %d", len(profiling))
+	}
+
+	expected := ProfilingInfo{
+		GraphicsActive: 0.951,
+		SMActive:       0.873,
+		SMOccupancy:    0.412,
+		TensorActive:   0.305,
+		DRAMActive:     0.520,
+		FP64Active:     0,
+		FP32Active:     0.221,
+		FP16Active:     0,
+	}
+	if *profiling[0] != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *profiling[0])
+	}
+
+	if _, err := parseDCGMIDmon([]byte("    GPU 0  0.951\n")); err == nil {
+		t.Fatal("expected error for missing header")
+	}
+	if _, err := parseDCGMIDmon([]byte("# Entity  SMACT  SMOCC\n    GPU 0  abc  0.1\n")); err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+}
+
+func TestParseDCGMIDiscoverySwitches(t *testing.T) {
+	output := "2 GPUs found.\n" +
+		"+--------+----------------------------------------------------------------------+\n" +
+		"| GPU ID | Device Information                                                   |\n" +
+		"+--------+----------------------------------------------------------------------+\n" +
+		"| 0      | Name: NVIDIA A100-SXM4-40GB                                          |\n" +
+		"+--------+----------------------------------------------------------------------+\n" +
+		"2 NvSwitches found.\n" +
+		"+-----------+\n" +
+		"| Switch ID |\n" +
+		"+-----------+\n" +
+		"| 11        |\n" +
+		"| 10        |\n" +
+		"+-----------+\n" +
+		"0 CPUs found.\n"
+
+	if ids := parseDCGMIDiscoverySwitches([]byte(output)); !reflect.DeepEqual(ids, []uint{11, 10}) {
+		t.Fatalf("expected switches 11 and 10, got %v", ids)
+	}
+	if ids := parseDCGMIDiscoverySwitches([]byte("1 GPUs found.\n| 0      | Name: Tesla T4 |\n")); ids != nil {
+		t.Fatalf("expected no switches, got %v", ids)
+	}
+}
+
+func TestParseDCGMISwitchDmon(t *testing.T) {
+	output := "Switch 11 0      0\n" +
+		"Switch 10 95     N/A\n"
+
+	switches, err := parseDCGMISwitchDmon([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NVSwitch{{ID: 11}, {ID: 10, FatalSXID: 95}}
+	if !reflect.DeepEqual(switches, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, switches)
+	}
+
+	if _, err := parseDCGMISwitchDmon([]byte("Switch 11 abc 0\n")); err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+}
@@ -0,0 +1,360 @@
+package nvidiadocker
+
+import (
+	"time"
+)
+
+// MiB is the unit of the memory values reported by the GPU sources.
+const MiB = 1024 * 1024
+
+// Percent converts a utilization in percent, as reported by the GPU sources,
+// to the ratio reported in the .pct fields.
+func Percent(value uint) float64 {
+	return float64(value) / 100
+}
+
+type NvidiaStatus struct {
+	Devices []DeviceStatus
+}
+
+type NvidiaInfo struct {
+	Version VersionInfo
+	Devices []DeviceInfo
+}
+
+type VersionInfo struct {
+	Driver string
+	CUDA   string
+}
+
+type DeviceInfo struct {
+	UUID   string
+	Path   string
+	Model  string
+	Power  float64
+	PCI    PCIInfo
+	Memory MemoryInfoStatic
+}
+
+type PCIInfo struct {
+	BusID string
+}
+
+type MemoryInfoStatic struct {
+	Global uint64
+}
+
+type ClockInfo struct {
+	Cores  uint
+	Memory uint
+}
+
+type UtilizationInfo struct {
+	GPU     uint
+	Memory  uint
+	Encoder uint
+	Decoder uint
+}
+
+// PCIThroughputInfo holds the PCIe throughput in MB/s.
+type PCIThroughputInfo struct {
+	RX uint
+	TX uint
+}
+
+// PCILinkInfo holds the generation and the width in lanes of a PCIe link.
+type PCILinkInfo struct {
+	Gen   uint
+	Width uint
+}
+
+type PCIStatusInfo struct {
+	BusID      string
+	BAR1Used   uint64
+	Throughput PCIThroughputInfo
+	Replays    uint64
+	// Link is the link the GPU negotiated, MaxLink the fastest link the GPU
+	// and the slot support. They are zero if the GPU source does not report
+	// them.
+	Link    PCILinkInfo
+	MaxLink PCILinkInfo
+}
+
+type ECCErrorsInfo struct {
+	L1Cache uint64
+	L2Cache uint64
+	Global  uint64
+}
+
+// EncoderStatsInfo holds the active NVENC sessions, their average frame rate
+// and their average latency in microseconds.
+type EncoderStatsInfo struct {
+	SessionCount   uint
+	AverageFPS     uint
+	AverageLatency uint
+}
+
+// ECCInfo holds the ECC error counters of the GPU. Volatile counters are
+// reset when the driver is reloaded, aggregate counters persist.
+type ECCInfo struct {
+	Volatile  ECCErrorCounts
+	Aggregate ECCErrorCounts
+}
+
+// ECCErrorCounts holds the number of corrected single bit and uncorrected
+// double bit ECC errors.
+type ECCErrorCounts struct {
+	SingleBit uint64
+	DoubleBit uint64
+}
+
+// ThrottleReasonsInfo holds the reasons the GPU clocks are currently lowered.
+type ThrottleReasonsInfo struct {
+	GPUIdle                   bool
+	ApplicationsClocksSetting bool
+	SWPowerCap                bool
+	HWSlowdown                bool
+	HWThermalSlowdown         bool
+	HWPowerBrakeSlowdown      bool
+	SWThermalSlowdown         bool
+	SyncBoost                 bool
+}
+
+// MemoryInfo holds the device memory in MiB.
+type MemoryInfo struct {
+	GlobalUsed  uint64
+	GlobalTotal uint64
+	GlobalFree  uint64
+	ECCErrors   ECCErrorsInfo
+}
+
+type ProcessInfo struct {
+	PID        uint
+	Name       string
+	MemoryUsed uint64
+	GPUUUID    string
+	GPUIndex   *uint
+}
+
+// AccountedProcess holds the GPU usage of a process recorded by the driver in
+// accounting mode. The utilizations are averaged over the runtime of the
+// process.
+type AccountedProcess struct {
+	PID           uint
+	GPUUUID       string
+	GPUIndex      *uint
+	Utilization   UtilizationInfo
+	MaxMemoryUsed uint64
+	Time          uint64
+	Running       bool
+}
+
+// RetiredPagesInfo holds the number of device memory pages retired because of
+// multiple single bit or double bit ECC errors, and whether pages are pending
+// retirement on the next driver reload.
+type RetiredPagesInfo struct {
+	SingleBit uint64
+	DoubleBit uint64
+	Pending   bool
+}
+
+// RemappedRowsInfo holds the number of device memory rows remapped because of
+// correctable and uncorrectable ECC errors, whether a remapping is pending
+// until the GPU is reset, and whether a remapping failed, which happens when
+// a bank has no spare row left. Row remapping replaces page retirement from
+// the Ampere GPUs on.
+type RemappedRowsInfo struct {
+	Correctable   uint64
+	Uncorrectable uint64
+	Pending       bool
+	Failure       bool
+}
+
+// MIGDevice holds the status of a MIG device, a compute instance of a GPU
+// instance partitioned from a GPU in MIG mode. Memory values are in MiB, the
+// memory of a MIG device is the memory of its GPU instance.
+type MIGDevice struct {
+	UUID              string
+	Name              string
+	Index             uint
+	GPUIndex          *uint
+	GPUMinorNumber    *uint
+	GPUUUID           string
+	GPUInstanceID     uint
+	ComputeInstanceID uint
+	Utilization       UtilizationInfo
+	Memory            MemoryInfo
+}
+
+// VGPU holds the status of a vGPU, a virtual GPU a physical GPU of a vGPU
+// host exposes to a virtual machine. Memory values are in MiB, the total
+// memory is the frame buffer of the vGPU.
+type VGPU struct {
+	ID                 string
+	Name               string
+	Type               string
+	UUID               string
+	GPUBusID           string
+	VMUUID             string
+	VMName             string
+	GuestDriverVersion string
+	LicenseStatus      string
+	Utilization        UtilizationInfo
+	Memory             MemoryInfo
+}
+
+// NVLink holds the counters of a NVLink of a GPU. The data counters are in
+// KiB, all counters increase from the driver load on.
+type NVLink struct {
+	GPUIndex       *uint
+	GPUUUID        string
+	Link           uint
+	Active         bool
+	DataTX         uint64
+	DataRX         uint64
+	ReplayErrors   uint64
+	RecoveryErrors uint64
+	CRCErrors      uint64
+}
+
+// GPUFabric holds the state of a GPU of an NVSwitch system in the NVLink
+// fabric, which Fabric Manager sets up when the GPUs are attached to the
+// NVSwitches. The GPUs cannot run CUDA applications until its state is
+// completed with a success status. State and Status are lower case with
+// underscores, like in_progress.
+type GPUFabric struct {
+	Index  *uint
+	UUID   string
+	BusID  string
+	State  string
+	Status string
+}
+
+// NVSwitch holds the errors of an NVSwitch of the host, by the SXid of the
+// last fatal and non fatal error, 0 if none was reported.
+type NVSwitch struct {
+	ID           uint
+	FatalSXID    uint
+	NonFatalSXID uint
+}
+
+// GPUTopology holds how a GPU is connected to the other GPUs and network
+// devices of the host, and the CPUs and NUMA node closest to it.
+type GPUTopology struct {
+	Index        uint
+	Links        []TopologyLink
+	CPUAffinity  string
+	NUMAAffinity string
+}
+
+// TopologyLink is the connection of a GPU to a peer device, with the link type
+// as reported by nvidia-smi topo -m: NV# for # bonded NVLinks, PIX, PXB, PHB,
+// NODE or SYS for PCIe paths of growing distance.
+type TopologyLink struct {
+	Peer string
+	Type string
+}
+
+// DeviceHealth holds the memory health of a GPU.
+type DeviceHealth struct {
+	Index        *uint
+	UUID         string
+	RetiredPages RetiredPagesInfo
+	// RemappedRows is nil if the GPU or the driver does not support row
+	// remapping.
+	RemappedRows *RemappedRowsInfo
+}
+
+// DeviceInventory holds the identity and hardware specification of a GPU,
+// which do not change while the host runs. The power limit is in watts, the
+// memory size in MiB. Values the GPU does not report are empty.
+type DeviceInventory struct {
+	Index           *uint
+	UUID            string
+	Name            string
+	Serial          string
+	VBIOSVersion    string
+	BoardPartNumber string
+	PCIBusID        string
+	MaxPowerLimit   float64
+	MemoryTotal     uint64
+}
+
+// ProfilingInfo holds the DCGM profiling metrics of a GPU, as the ratio of
+// time or capacity the units of the GPU were active, between 0 and 1.
+type ProfilingInfo struct {
+	GraphicsActive float64
+	SMActive       float64
+	SMOccupancy    float64
+	TensorActive   float64
+	DRAMActive     float64
+	FP64Active     float64
+	FP32Active     float64
+	FP16Active     float64
+}
+
+// TemperatureThresholds holds the temperatures in degrees Celsius at which a
+// GPU slows down its clocks and shuts down to protect itself.
+type TemperatureThresholds struct {
+	Slowdown uint
+	Shutdown uint
+}
+
+// DeviceStatus holds the status of a GPU. Power values are in watts, the fan
+// speed is a percent of the maximum speed.
+type DeviceStatus struct {
+	// Timestamp is when the GPU source sampled the GPU: the timestamp
+	// nvidia-smi reports, or the time the values were read for the other
+	// GPU sources.
+	Timestamp time.Time
+
+	Index              *uint
+	UUID               string
+	Name               string
+	Power              float64
+	PowerLimit         float64
+	PowerEnforcedLimit float64
+	// Energy consumed since the driver was loaded, in millijoules.
+	Energy      uint64
+	Temperature uint
+	// MemoryTemperature is the temperature of the HBM of the GPU in degrees
+	// Celsius, nil if the GPU does not report it.
+	MemoryTemperature *uint
+	// HotspotTemperature is the temperature of the hottest spot of the die
+	// in degrees Celsius, nil if the GPU does not report it.
+	HotspotTemperature *uint
+	// TemperatureThresholds are zero if the GPU does not report them.
+	TemperatureThresholds TemperatureThresholds
+	FanSpeed              uint
+	PerformanceState      string
+	ComputeMode           string
+	// PersistenceMode is nil if the GPU source does not report it.
+	PersistenceMode *bool
+	Utilization     UtilizationInfo
+	EncoderStats    EncoderStatsInfo
+	Memory          MemoryInfo
+	Clocks          ClockInfo
+	ThrottleReasons ThrottleReasonsInfo
+	ECC             ECCInfo
+	PCI             PCIStatusInfo
+	Processes       []ProcessInfo
+
+	// MinorNumber is the N of the /dev/nvidiaN device of the GPU, which
+	// unlike the index does not shift when another GPU falls off the bus.
+	// It is nil if the GPU source does not report it.
+	MinorNumber *uint
+
+	// Profiling is only reported by the dcgm GPU source.
+	Profiling *ProfilingInfo
+
+	// Raw holds the values of the smi_extra_fields by field name. Values
+	// nvidia-smi does not report are left out.
+	Raw map[string]string
+
+	// InvalidFields holds the values nvidia-smi reported that could not be
+	// parsed, by query field name. The fields are left at zero.
+	InvalidFields map[string]string
+	// Unsupported lists the nvidia-smi query fields the GPU does not
+	// support, like the utilization of a vGPU. The fields are left at zero.
+	Unsupported []string
+}
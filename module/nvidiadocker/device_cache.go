@@ -0,0 +1,84 @@
+package nvidiadocker
+
+import (
+	"sync"
+	"time"
+)
+
+// DeviceProperties are the properties of a GPU that do not change while it
+// stays on the bus.
+type DeviceProperties struct {
+	UUID                  string
+	Name                  string
+	BusID                 string
+	MinorNumber           *uint
+	TemperatureThresholds TemperatureThresholds
+	MaxPCILink            PCILinkInfo
+}
+
+// DeviceCache holds the static properties of the GPUs by index, so that only
+// their dynamic values are read on every fetch. The cache is emptied after its
+// TTL, when the number of GPUs changes, and when it is invalidated after an
+// error or an event of the driver, as the indices of the GPUs shift when one
+// falls off the bus or is reset. A TTL of 0 disables the cache.
+type DeviceCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mutex   sync.Mutex
+	count   int
+	expires time.Time
+	devices map[uint]DeviceProperties
+}
+
+// NewDeviceCache creates an empty DeviceCache.
+func NewDeviceCache(ttl time.Duration) *DeviceCache {
+	return &DeviceCache{
+		ttl:     ttl,
+		now:     time.Now,
+		devices: map[uint]DeviceProperties{},
+	}
+}
+
+// Get returns the cached properties of the GPU at the given index, count being
+// the current number of GPUs. ok is false if they have to be read again.
+func (c *DeviceCache) Get(index uint, count int) (properties DeviceProperties, ok bool) {
+	if c == nil || c.ttl <= 0 {
+		return DeviceProperties{}, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now := c.now(); count != c.count || !now.Before(c.expires) {
+		c.devices = map[uint]DeviceProperties{}
+		c.count = count
+		c.expires = now.Add(c.ttl)
+		return DeviceProperties{}, false
+	}
+	properties, ok = c.devices[index]
+	return properties, ok
+}
+
+// Put caches the properties of the GPU at the given index.
+func (c *DeviceCache) Put(index uint, properties DeviceProperties) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.devices[index] = properties
+}
+
+// Invalidate empties the cache.
+func (c *DeviceCache) Invalidate() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.devices = map[uint]DeviceProperties{}
+	c.expires = time.Time{}
+}
@@ -0,0 +1,46 @@
+package nvidiadocker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceCache(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	cache := NewDeviceCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	if _, ok := cache.Get(0, 2); ok {
+		t.Fatal("expected an empty cache")
+	}
+	cache.Put(0, DeviceProperties{UUID: "GPU-0"})
+	if properties, ok := cache.Get(0, 2); !ok || properties.UUID != "GPU-0" {
+		t.Fatalf("unexpected properties %v, %v", properties, ok)
+	}
+	if _, ok := cache.Get(1, 2); ok {
+		t.Fatal("expected no properties of an uncached GPU")
+	}
+
+	// A GPU fell off the bus.
+	if _, ok := cache.Get(0, 1); ok {
+		t.Fatal("expected the cache to be emptied when the number of GPUs changes")
+	}
+
+	cache.Put(0, DeviceProperties{UUID: "GPU-1"})
+	cache.Invalidate()
+	if _, ok := cache.Get(0, 1); ok {
+		t.Fatal("expected an invalidated cache to be empty")
+	}
+
+	cache.Put(0, DeviceProperties{UUID: "GPU-1"})
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get(0, 1); ok {
+		t.Fatal("expected the cache to expire")
+	}
+
+	disabled := NewDeviceCache(0)
+	disabled.Put(0, DeviceProperties{UUID: "GPU-0"})
+	if _, ok := disabled.Get(0, 1); ok {
+		t.Fatal("expected a disabled cache to be empty")
+	}
+}
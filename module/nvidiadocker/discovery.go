@@ -0,0 +1,174 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// CheckDiscovery runs the discovery of the status MetricSet end to end with
+// the given configuration, as the test gpu command does to validate a
+// deployment before shipping: whether the driver is loaded, the GPU source
+// answers and finds GPUs, the container runtime answers, and which GPUs the
+// running containers are matched to. The results are written to w, and false
+// is returned if a check failed.
+func CheckDiscovery(w io.Writer, config Config) bool {
+	d := &discovery{w: w, config: config}
+
+	d.checkDriver()
+	collector, err := NewCollector(config)
+	d.result("gpu source", err, "%s", config.GPUSource)
+	if err != nil {
+		return false
+	}
+
+	devices, ok := d.checkGPUs(collector)
+	if !ok {
+		return false
+	}
+
+	client, err := NewContainerClient(config)
+	if err != nil {
+		d.result("container runtime", err, "")
+		return false
+	}
+	d.checkContainers(client, devices)
+	return !d.failed
+}
+
+type discovery struct {
+	w      io.Writer
+	config Config
+	failed bool
+}
+
+// result writes the result of a check, an error failing the discovery.
+func (d *discovery) result(name string, err error, format string, args ...interface{}) {
+	label := name + strings.Repeat(".", 20-len(name))
+	if err != nil {
+		d.failed = true
+		fmt.Fprintf(d.w, "%s ERROR %v\n", label, err)
+		return
+	}
+	fmt.Fprintf(d.w, "%s OK %s\n", label, fmt.Sprintf(format, args...))
+}
+
+// warning writes a check that found nothing wrong, but nothing to report
+// either.
+func (d *discovery) warning(name, format string, args ...interface{}) {
+	label := name + strings.Repeat(".", 20-len(name))
+	fmt.Fprintf(d.w, "%s WARN %s\n", label, fmt.Sprintf(format, args...))
+}
+
+// detail writes a line under the previous check.
+func (d *discovery) detail(format string, args ...interface{}) {
+	fmt.Fprintf(d.w, "    "+format+"\n", args...)
+}
+
+func (d *discovery) checkDriver() {
+	backend := BackendOf(d.config.GPUSource)
+	if !d.config.readsLocalGPUs() || backend == nil || backend.DriverFile == "" {
+		d.result("driver", nil, "not checked for gpu_source %s", d.config.GPUSource)
+		return
+	}
+
+	path := HostPath(d.config.HostFS, backend.DriverFile)
+	if _, err := os.Stat(path); err != nil {
+		d.result("driver", fmt.Errorf("%s driver is not loaded: %v", backend.Vendor, err), "")
+		return
+	}
+	d.result("driver", nil, "%s driver loaded (%s)", backend.Vendor, path)
+}
+
+// checkGPUs queries the GPUs, which fails if there are none.
+func (d *discovery) checkGPUs(collector GPUCollector) ([]DeviceStatus, bool) {
+	devices, err := collector.Query(nil)
+	if err == nil && len(devices) == 0 {
+		err = fmt.Errorf("no GPUs found")
+	}
+	d.result("gpus", err, "%d found", len(devices))
+	if err != nil {
+		return nil, false
+	}
+
+	for i := range devices {
+		device := &devices[i]
+		index := "-"
+		if device.Index != nil {
+			index = fmt.Sprint(*device.Index)
+		}
+		d.detail("%s %s %s %s", index, device.UUID, device.Name, device.PCI.BusID)
+	}
+	return devices, true
+}
+
+// checkContainers lists the running containers selected by the container
+// filter and writes the GPUs they are matched to.
+func (d *discovery) checkContainers(client ContainerClient, devices []DeviceStatus) {
+	apiContainers, err := client.ListContainers(docker.ListContainersOptions{})
+	d.result("container runtime", err, "%s, %d running containers", d.config.Runtime, len(apiContainers))
+	if err != nil {
+		return
+	}
+
+	cached, failures, err := NewContainerCache(client, NewContainerFilter(d.config), 0).Containers(nil)
+	if err != nil {
+		d.result("containers", err, "")
+		return
+	}
+	for _, failure := range failures {
+		d.result("containers", failure, "")
+	}
+
+	allocations := LoadKubeletAllocations(d.config.KubeletCheckpointPath())
+	backend := d.config.ContainerBackend()
+
+	var (
+		matched int
+		lines   []string
+	)
+	for _, c := range cached {
+		indices := backend.ContainerDeviceIndices(c.Container, c.Runtime, allocations, devices, d.config.HostFS)
+		missing := MissingDevices(c.Container, devices)
+		if len(indices) == 0 && len(missing) == 0 {
+			// nvidia-docker 1.x only mounts the driver volume of the
+			// containers run with docker instead of nvidia-docker.
+			if NVIDIADockerV1(c.Container) {
+				lines = append(lines, fmt.Sprintf("%s (%s): nvidia-docker 1.x driver volume without GPU devices",
+					strings.TrimPrefix(c.Container.Name, "/"), shortID(c.Container.ID)))
+			}
+			continue
+		}
+		if len(indices) > 0 {
+			matched++
+		}
+
+		gpus := make([]string, 0, len(indices)+len(missing))
+		for _, index := range indices {
+			gpus = append(gpus, devices[index].UUID)
+		}
+		for _, device := range missing {
+			gpus = append(gpus, device+" (not found)")
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s): %s", strings.TrimPrefix(c.Container.Name, "/"), shortID(c.Container.ID), strings.Join(gpus, ", ")))
+	}
+	if len(lines) == 0 {
+		d.warning("containers", "none of %d selected containers uses a GPU", len(cached))
+		return
+	}
+	d.result("containers", nil, "%d of %d selected containers use GPUs", matched, len(cached))
+	for _, line := range lines {
+		d.detail("%s", line)
+	}
+}
+
+// shortID returns the 12 characters long ID Docker shows.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
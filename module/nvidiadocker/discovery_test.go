@@ -0,0 +1,84 @@
+package nvidiadocker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// discoveryClient maps the devices in devices into the containers of
+// mockContainerClient.
+type discoveryClient struct {
+	*mockContainerClient
+	devices map[string][]string
+}
+
+func (c *discoveryClient) InspectContainerWithRuntime(id string) (*docker.Container, *ContainerRuntime, error) {
+	container, runtime, err := c.mockContainerClient.InspectContainerWithRuntime(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	container.HostConfig = &docker.HostConfig{}
+	for _, device := range c.devices[id] {
+		container.HostConfig.Devices = append(container.HostConfig.Devices, docker.Device{PathOnHost: device})
+	}
+	return container, runtime, nil
+}
+
+func TestCheckDiscovery(t *testing.T) {
+	collector := &mockCollector{
+		devices: []DeviceStatus{
+			{Index: toUintP(0), UUID: "GPU-0", Name: "Tesla P40"},
+			{Index: toUintP(1), UUID: "GPU-1", Name: "Tesla P40"},
+		},
+	}
+	client := &discoveryClient{
+		mockContainerClient: &mockContainerClient{
+			running: map[string]bool{"train": true, "stuck": true, "web": true, "broken": true},
+			failing: map[string]bool{"broken": true},
+		},
+		devices: map[string][]string{
+			"train": {"/dev/nvidia1"},
+			"stuck": {"/dev/nvidia3"},
+		},
+	}
+
+	var buf bytes.Buffer
+	d := &discovery{w: &buf, config: DefaultConfig()}
+	devices, ok := d.checkGPUs(collector)
+	if !ok {
+		t.Fatalf("unexpected failure:\n%s", buf.String())
+	}
+	d.checkContainers(client, devices)
+
+	report := buf.String()
+	for _, expected := range []string{
+		"gpus................ OK 2 found\n",
+		"    1 GPU-1 Tesla P40 \n",
+		"container runtime... OK docker, 4 running containers\n",
+		"containers.......... ERROR container broken: request timed out\n",
+		"containers.......... OK 1 of 3 selected containers use GPUs\n",
+		"    stuck (stuck): /dev/nvidia3 (not found)\n",
+		"    train (train): GPU-1\n",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Fatalf("expected %q in report:\n%s", expected, report)
+		}
+	}
+	if !d.failed {
+		t.Fatal("expected the inspect failure to fail the discovery")
+	}
+}
+
+func TestCheckDiscoveryNoGPUs(t *testing.T) {
+	var buf bytes.Buffer
+	d := &discovery{w: &buf, config: DefaultConfig()}
+	if _, ok := d.checkGPUs(&mockCollector{}); ok {
+		t.Fatal("expected a host without GPUs to fail")
+	}
+	if report := buf.String(); report != "gpus................ ERROR no GPUs found\n" {
+		t.Fatalf("unexpected report %q", report)
+	}
+}
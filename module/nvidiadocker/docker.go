@@ -0,0 +1,384 @@
+package nvidiadocker
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// DockerClient is a Docker client that also decodes the container settings
+// used by the NVIDIA container runtime, which the client library does not
+// know about.
+type DockerClient struct {
+	*docker.Client
+	httpClient *http.Client
+	baseURL    string
+	timeout    time.Duration
+
+	// apiVersion is the Docker API version of the requests, negotiated with
+	// the daemon on the first request unless docker_api_version is set. The
+	// negotiation replaces Client, mutex guards it as the requests and the
+	// container events are sent from several goroutines.
+	mutex      sync.Mutex
+	apiVersion string
+	negotiated bool
+	newClient  func(apiVersion string) (*docker.Client, error)
+}
+
+// maxDockerAPIVersion is the newest Docker API version the module knows the
+// responses of.
+const maxDockerAPIVersion = "1.41"
+
+// ContainerRuntime holds the GPU related settings of a container that are
+// not part of docker.HostConfig.
+type ContainerRuntime struct {
+	Runtime        string
+	DeviceRequests []DeviceRequest
+}
+
+// DeviceRequest is a device requested with docker run --gpus.
+type DeviceRequest struct {
+	Driver       string
+	Count        int
+	DeviceIDs    []string
+	Capabilities [][]string
+}
+
+// dockerTLS holds the certificate files of a TLS connection to the Docker
+// daemon.
+type dockerTLS struct {
+	CA            string
+	Cert          string
+	Key           string
+	KeyPassphrase string
+	Verify        bool
+}
+
+// newDockerTLS returns the TLS settings of the Docker daemon, or nil to
+// connect without TLS. Like the docker CLI, the DOCKER_CERT_PATH and
+// DOCKER_TLS_VERIFY environment variables are read if the options are not
+// set. The certificate of the daemon is verified unless tls_verify is false.
+func newDockerTLS(config Config, getenv func(string) string) *dockerTLS {
+	certPath := config.CertPath
+	if certPath == "" {
+		certPath = getenv("DOCKER_CERT_PATH")
+	}
+
+	enabled := certPath != "" || config.CA != "" || config.Cert != "" || config.Key != "" ||
+		getenv("DOCKER_TLS_VERIFY") != "" || (config.TLSVerify != nil && *config.TLSVerify)
+	if !enabled {
+		return nil
+	}
+
+	t := &dockerTLS{
+		CA:            config.CA,
+		Cert:          config.Cert,
+		Key:           config.Key,
+		KeyPassphrase: config.KeyPassphrase,
+		Verify:        config.TLSVerify == nil || *config.TLSVerify,
+	}
+	if certPath != "" {
+		if t.CA == "" {
+			t.CA = filepath.Join(certPath, "ca.pem")
+		}
+		if t.Cert == "" {
+			t.Cert = filepath.Join(certPath, "cert.pem")
+		}
+		if t.Key == "" {
+			t.Key = filepath.Join(certPath, "key.pem")
+		}
+	}
+	return t
+}
+
+// newTLSClient creates a client connecting with the certificates of t. An
+// encrypted key is decrypted with the passphrase, which the client library
+// does not support.
+func newTLSClient(endpoint string, t *dockerTLS, apiVersion string) (*docker.Client, error) {
+	if t.KeyPassphrase == "" {
+		return docker.NewVersionedTLSClient(endpoint, t.Cert, t.Key, t.CA, apiVersion)
+	}
+
+	cert, err := ioutil.ReadFile(t.Cert)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := ioutil.ReadFile(t.Key)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decryptPEMKey(encrypted, t.KeyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", t.Key, err)
+	}
+	// Like the client library, a missing CA skips the verification.
+	ca, err := ioutil.ReadFile(t.CA)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return docker.NewVersionedTLSClientFromBytes(endpoint, cert, key, ca, apiVersion)
+}
+
+// decryptPEMKey decrypts a PEM encoded private key encrypted with the
+// passphrase, either an encrypted PKCS#8 key or a key in the legacy OpenSSL
+// format with the Proc-Type and DEK-Info headers. A key that is not
+// encrypted is returned as is.
+func decryptPEMKey(data []byte, passphrase string) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key")
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		der, err := decryptPKCS8Key(block.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	if !x509.IsEncryptedPEMBlock(block) {
+		return data, nil
+	}
+	// The legacy format cannot detect a wrong passphrase reliably, which is
+	// why x509.DecryptPEMBlock is deprecated, but the keys written by older
+	// OpenSSL versions are still around.
+	der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// dockerEndpoint returns the dockerendpoint option, or the DOCKER_HOST
+// environment variable if it is not set.
+func dockerEndpoint(config Config, getenv func(string) string) string {
+	if config.DockerEndpoint != "" {
+		return config.DockerEndpoint
+	}
+	if host := getenv("DOCKER_HOST"); host != "" {
+		return host
+	}
+	return "unix:///var/run/docker.sock"
+}
+
+// NewDockerClient creates a client for the Docker daemon configured with the
+// dockerendpoint, TLS, timeout and API version options.
+func NewDockerClient(config Config) (*DockerClient, error) {
+	endpoint := dockerEndpoint(config, os.Getenv)
+	t := newDockerTLS(config, os.Getenv)
+	newClient := func(apiVersion string) (*docker.Client, error) {
+		var (
+			client *docker.Client
+			err    error
+		)
+		if t != nil {
+			client, err = newTLSClient(endpoint, t, apiVersion)
+			if err == nil {
+				// Without a CA the client skips the verification, verify
+				// against the system roots instead.
+				client.TLSConfig.InsecureSkipVerify = !t.Verify
+			}
+		} else {
+			client, err = docker.NewVersionedClient(endpoint, apiVersion)
+		}
+		if err != nil {
+			return nil, err
+		}
+		client.SkipServerVersionCheck = true
+		client.SetTimeout(config.DockerTimeout)
+		return client, nil
+	}
+
+	client, err := newClient(config.DockerAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(client.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DockerClient{
+		Client:     client,
+		httpClient: client.HTTPClient,
+		baseURL:    strings.TrimRight(client.Endpoint(), "/"),
+		timeout:    config.DockerTimeout,
+		apiVersion: config.DockerAPIVersion,
+		negotiated: config.DockerAPIVersion != "",
+		newClient:  newClient,
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socketPath := u.Path
+		c.httpClient = &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return client.Dialer.Dial("unix", socketPath)
+				},
+			},
+			Timeout: config.DockerTimeout,
+		}
+		c.baseURL = "http://unix.sock"
+	case "tcp":
+		u.Scheme = "http"
+		if client.TLSConfig != nil {
+			u.Scheme = "https"
+		}
+		c.baseURL = strings.TrimRight(u.String(), "/")
+	}
+
+	return c, nil
+}
+
+// ListContainers returns the containers matching the given options.
+func (c *DockerClient) ListContainers(opts docker.ListContainersOptions) (_ []docker.APIContainers, err error) {
+	defer func(start time.Time) {
+		observeDockerCall(start, err)
+	}(time.Now())
+
+	client, _, err := c.negotiateAPIVersion()
+	if err != nil {
+		return nil, err
+	}
+	return client.ListContainers(opts)
+}
+
+// InspectContainer returns the container with the given ID.
+func (c *DockerClient) InspectContainer(id string) (_ *docker.Container, err error) {
+	defer func(start time.Time) {
+		observeDockerCall(start, err)
+	}(time.Now())
+
+	client, _, err := c.negotiateAPIVersion()
+	if err != nil {
+		return nil, err
+	}
+	return client.InspectContainer(id)
+}
+
+// AddEventListener sends the container events to the listener. The API
+// version is negotiated first, so that the listener is added to the client
+// that is not replaced anymore and RemoveEventListener finds it.
+func (c *DockerClient) AddEventListener(listener chan<- *docker.APIEvents) error {
+	client, _, err := c.negotiateAPIVersion()
+	if err != nil {
+		return err
+	}
+	return client.AddEventListener(listener)
+}
+
+// RemoveEventListener stops sending the container events to the listener.
+func (c *DockerClient) RemoveEventListener(listener chan *docker.APIEvents) error {
+	c.mutex.Lock()
+	client := c.Client
+	c.mutex.Unlock()
+	return client.RemoveEventListener(listener)
+}
+
+// negotiateAPIVersion makes the requests use the API version of the daemon,
+// or maxDockerAPIVersion if the daemon is newer. Until it succeeds, requests
+// are sent without a version, which the daemon reads as its own. It returns
+// the client and the API version of the requests.
+func (c *DockerClient) negotiateAPIVersion() (*docker.Client, string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.negotiated {
+		return c.Client, c.apiVersion, nil
+	}
+
+	env, err := c.Client.Version()
+	if err != nil {
+		return nil, "", err
+	}
+	apiVersion := negotiatedAPIVersion(env.Get("ApiVersion"))
+
+	client, err := c.newClient(apiVersion)
+	if err != nil {
+		return nil, "", err
+	}
+	c.Client = client
+	c.apiVersion = apiVersion
+	c.negotiated = true
+	return client, apiVersion, nil
+}
+
+// negotiatedAPIVersion returns the lower of the given daemon API version and
+// maxDockerAPIVersion.
+func negotiatedAPIVersion(serverVersion string) string {
+	server, err := docker.NewAPIVersion(serverVersion)
+	if err != nil {
+		return maxDockerAPIVersion
+	}
+	newest, _ := docker.NewAPIVersion(maxDockerAPIVersion)
+	if server.LessThan(newest) {
+		return serverVersion
+	}
+	return maxDockerAPIVersion
+}
+
+// InspectContainerWithRuntime returns the container with the given ID along
+// with its NVIDIA runtime settings, decoded from a single inspect request.
+func (c *DockerClient) InspectContainerWithRuntime(id string) (_ *docker.Container, _ *ContainerRuntime, err error) {
+	defer func(start time.Time) {
+		observeDockerCall(start, err)
+	}(time.Now())
+
+	_, apiVersion, err := c.negotiateAPIVersion()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path := "/containers/" + id + "/json"
+	if apiVersion != "" {
+		path = "/v" + apiVersion + path
+	}
+	resp, err := c.httpClient.Get(c.baseURL + path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, &docker.NoSuchContainer{ID: id}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("inspect container %s: %s: %s", id, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return parseContainerJSON(body)
+}
+
+// parseContainerJSON parses a container inspected with the Docker API, along
+// with its NVIDIA runtime settings.
+func parseContainerJSON(body []byte) (*docker.Container, *ContainerRuntime, error) {
+	var container docker.Container
+	if err := json.Unmarshal(body, &container); err != nil {
+		return nil, nil, err
+	}
+
+	var raw struct {
+		HostConfig ContainerRuntime
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, nil, err
+	}
+
+	return &container, &raw.HostConfig, nil
+}
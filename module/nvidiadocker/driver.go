@@ -0,0 +1,81 @@
+package nvidiadocker
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// driverVersionFile is created by the NVIDIA kernel module once it is loaded.
+const driverVersionFile = "/proc/driver/nvidia/version"
+
+// DriverCheck tells whether the GPU driver is loaded, so that nodes
+// without GPUs skip the GPU queries instead of failing every fetch. The
+// MetricSets of a host share a DriverCheck, which logs once when the driver
+// is missing and once when it is loaded again.
+type DriverCheck struct {
+	path   string
+	vendor string
+
+	mu      sync.Mutex
+	missing bool
+}
+
+var (
+	driverChecksMu sync.Mutex
+	driverChecks   = map[string]*DriverCheck{}
+)
+
+// NewDriverCheck returns the DriverCheck of the host, checking the driver of
+// the backend of the GPU source right away. The driver is only checked for the
+// GPU sources reading the GPUs of the host of the beat.
+func NewDriverCheck(config Config) *DriverCheck {
+	backend := BackendOf(config.GPUSource)
+	if !config.readsLocalGPUs() || backend == nil || backend.DriverFile == "" {
+		return &DriverCheck{}
+	}
+	path, vendor := HostPath(config.HostFS, backend.DriverFile), backend.Vendor
+
+	driverChecksMu.Lock()
+	defer driverChecksMu.Unlock()
+	d, found := driverChecks[path]
+	if !found {
+		d = &DriverCheck{path: path, vendor: vendor}
+		d.Available()
+		driverChecks[path] = d
+	}
+	return d
+}
+
+// readsLocalGPUs tells whether the GPU source reads the GPUs of the host of
+// the beat, unlike the api and agent GPU sources, which read them from the
+// nvidia-docker-plugin or the agent, possibly of a remote host, and the GPU
+// sources of a host read over SSH.
+func (c Config) readsLocalGPUs() bool {
+	source := strings.ToLower(c.GPUSource)
+	return source != GPUSourceAPI && source != GPUSourceAgent && c.sshHost == nil
+}
+
+// Available returns whether the driver is loaded. It is checked on every
+// call, so that the GPUs are reported as soon as the driver is loaded.
+func (d *DriverCheck) Available() bool {
+	if d == nil || d.path == "" {
+		return true
+	}
+
+	_, err := os.Stat(d.path)
+	available := err == nil
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !available && !d.missing {
+		logp.Warn("%s driver is not loaded (%v), GPU metrics are skipped until it is loaded", d.vendor, err)
+	}
+	if available && d.missing {
+		logp.Info("%s driver is loaded, GPU metrics are reported again", d.vendor)
+	}
+	d.missing = !available
+	return available
+}
@@ -0,0 +1,45 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDriverCheck(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+
+	config := DefaultConfig()
+	config.GPUSource = GPUSourceSMI
+	config.HostFS = hostFS
+	d := NewDriverCheck(config)
+	if d.Available() {
+		t.Fatal("expected missing driver")
+	}
+	if NewDriverCheck(config) != d {
+		t.Fatal("expected the driver check of the host to be shared")
+	}
+
+	writeHostFile(t, hostFS, driverVersionFile, "NVRM version: NVIDIA UNIX x86_64 Kernel Module  470.57.02\n", 0644)
+	if !d.Available() {
+		t.Fatal("expected loaded driver")
+	}
+
+	config.GPUSource = GPUSourceAPI
+	if !NewDriverCheck(config).Available() {
+		t.Fatal("expected the api GPU source not to be checked")
+	}
+	config.GPUSource = GPUSourceAgent
+	if d := NewDriverCheck(config); d.path != "" {
+		t.Fatal("expected the agent GPU source not to be checked")
+	}
+
+	var unchecked *DriverCheck
+	if !unchecked.Available() {
+		t.Fatal("expected a nil driver check to report the driver as available")
+	}
+}
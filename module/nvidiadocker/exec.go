@@ -0,0 +1,150 @@
+package nvidiadocker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// transientExitCode is the exit code of nvidia-smi and dcgmi for unknown
+// errors, which are often transient, like a GPU busy recovering from an XID
+// error. The other failures, like a missing driver, persist.
+const transientExitCode = 255
+
+// stuckCommands holds the start time of the command lines that timed out and
+// are still running, by command line. A command stuck in the driver cannot be
+// killed, the command line is not run again until it exits, so that a hung
+// driver does not pile up processes on every fetch.
+var (
+	stuckCommandsMu sync.Mutex
+	stuckCommands   = map[string]time.Time{}
+)
+
+// commandRunner runs the command line tools of the GPU sources, on the host
+// read over SSH if any. A command running longer than timeout is killed, and
+// failed commands are retried up to retries times if the failure may be
+// transient. Timed out commands are not retried, a command stuck in the driver
+// would only leave one more process behind.
+type commandRunner struct {
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+	ssh        *sshHost
+}
+
+func newCommandRunner(config Config) commandRunner {
+	return commandRunner{
+		timeout:    config.SMITimeout,
+		retries:    config.SMIRetries,
+		retryDelay: time.Second,
+		ssh:        config.sshHost,
+	}
+}
+
+// run returns the standard output of the command. The error of a failed
+// command holds its error output.
+func (r commandRunner) run(name string, args ...string) (output []byte, err error) {
+	defer func(start time.Time) {
+		observeGPUSourceCall(start, err)
+	}(time.Now())
+
+	for attempt := 0; ; attempt++ {
+		output, transient, err := r.runOnce(name, args...)
+		if err == nil {
+			return output, nil
+		}
+		if !transient || attempt >= r.retries {
+			return nil, err
+		}
+		logp.Debug("nvidiadocker", "Retrying %v", err)
+		time.Sleep(r.retryDelay)
+	}
+}
+
+func (r commandRunner) runOnce(name string, args ...string) (output []byte, transient bool, err error) {
+	ctx := context.Background()
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	command, commandArgs := name, args
+	if r.ssh != nil {
+		command, commandArgs = r.ssh.command(name, args)
+	}
+
+	key := strings.Join(append([]string{command}, commandArgs...), "\x00")
+	stuckCommandsMu.Lock()
+	started, stuck := stuckCommands[key]
+	stuckCommandsMu.Unlock()
+	if stuck {
+		return nil, false, fmt.Errorf("%s: still running after timing out, started %v ago", commandLine(name, args), time.Since(started).Round(time.Second))
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, commandArgs...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, false, err
+	}
+
+	// A process stuck in the driver cannot be killed, so the command is
+	// left behind instead of waiting for it, and recorded as stuck until it
+	// exits.
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		stuckCommandsMu.Lock()
+		stuckCommands[key] = start
+		stuckCommandsMu.Unlock()
+		go func() {
+			<-done
+			stuckCommandsMu.Lock()
+			delete(stuckCommands, key)
+			stuckCommandsMu.Unlock()
+		}()
+		return nil, false, fmt.Errorf("%s: timed out after %v", commandLine(name, args), r.timeout)
+	}
+
+	if err == nil {
+		return stdout.Bytes(), false, nil
+	}
+
+	// nvidia-smi reports most errors on its standard output.
+	message := strings.TrimSpace(stderr.String())
+	if message == "" {
+		message = strings.TrimSpace(stdout.String())
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() == transientExitCode {
+			transient = true
+		}
+	}
+	if message != "" {
+		err = fmt.Errorf("%v: %s", err, message)
+	}
+	return nil, transient, fmt.Errorf("%s: %v", commandLine(name, args), err)
+}
+
+// commandLine returns the command and its first argument, to identify the
+// command in errors without the long query arguments.
+func commandLine(name string, args []string) string {
+	if len(args) == 0 {
+		return name
+	}
+	return name + " " + args[0]
+}
@@ -0,0 +1,116 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandRunner(t *testing.T) {
+	runner := commandRunner{timeout: time.Second}
+
+	output, err := runner.run("sh", "-c", "echo 0, Tesla P40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "0, Tesla P40\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+
+	_, err = runner.run("sh", "-c", "echo NVIDIA-SMI has failed because it could not communicate with the NVIDIA driver; exit 9")
+	if err == nil || !strings.Contains(err.Error(), "could not communicate") {
+		t.Fatalf("expected the output of the command in the error, got %v", err)
+	}
+	_, err = runner.run("sh", "-c", "echo 'Invalid combination of input arguments.' >&2; exit 2")
+	if err == nil || !strings.Contains(err.Error(), "Invalid combination") {
+		t.Fatalf("expected the error output of the command in the error, got %v", err)
+	}
+
+	if _, err := runner.run("nvidia-smi-missing"); err == nil {
+		t.Fatal("expected error for missing command")
+	}
+}
+
+func TestCommandRunnerTimeout(t *testing.T) {
+	runner := commandRunner{timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	_, err := runner.run("sleep", "5")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the command to be abandoned, took %v", elapsed)
+	}
+}
+
+func TestCommandRunnerStuck(t *testing.T) {
+	runner := commandRunner{timeout: 50 * time.Millisecond, retries: 1, retryDelay: time.Millisecond}
+
+	// The background sleep keeps the output of the killed shell open, like a
+	// process stuck in the driver. The command line is unique to the run, so
+	// a command left stuck by a previous run does not fail this one.
+	start := time.Now()
+	script := "sleep 1 & wait # " + strconv.FormatInt(start.UnixNano(), 10)
+	_, err := runner.run("sh", "-c", script)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the timed out command not to be retried, took %v", elapsed)
+	}
+
+	_, err = runner.run("sh", "-c", script)
+	if err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Fatalf("expected the stuck command not to be run again, got %v", err)
+	}
+
+	// The command is run again once the stuck one exited.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		_, err = runner.run("sh", "-c", script)
+		if err != nil && strings.Contains(err.Error(), "timed out") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the command to be run again, got %v", err)
+		}
+	}
+}
+
+func TestCommandRunnerRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "failed")
+
+	// Fails with an unknown error on the first run only.
+	script := "if [ -e " + marker + " ]; then echo ok; else touch " + marker + "; exit 255; fi"
+
+	runner := commandRunner{timeout: time.Second}
+	if _, err := runner.run("sh", "-c", script); err == nil {
+		t.Fatal("expected error without retries")
+	}
+
+	os.Remove(marker)
+	runner.retries = 1
+	output, err := runner.run("sh", "-c", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "ok\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+
+	// Persistent failures are not retried.
+	os.Remove(marker)
+	script = "if [ -e " + marker + " ]; then echo ok; else touch " + marker + "; exit 9; fi"
+	if _, err := runner.run("sh", "-c", script); err == nil {
+		t.Fatal("expected error for persistent failure")
+	}
+}
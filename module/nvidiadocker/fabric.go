@@ -0,0 +1,38 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// FabricManagerName is the name of the Fabric Manager process, which sets up
+// the NVLink fabric of the GPUs and NVSwitches of NVSwitch systems like the
+// DGX and HGX.
+const FabricManagerName = "nv-fabricmanager"
+
+// FabricManagerRunning tells whether Fabric Manager runs on the host, from
+// the processes listed in /proc under hostFS.
+func FabricManagerRunning(hostFS string) (bool, error) {
+	entries, err := ioutil.ReadDir(HostPath(hostFS, "/proc"))
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		pid, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		// Processes exit while /proc is listed.
+		if name, err := ProcessName(hostFS, uint(pid)); err == nil && name == FabricManagerName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// normalizeFabricValue turns a fabric state or status reported by the GPU
+// sources, like "In Progress", into lower case with underscores.
+func normalizeFabricValue(value string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(value)), " ", "_", -1)
+}
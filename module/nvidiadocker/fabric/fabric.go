@@ -0,0 +1,148 @@
+package fabric
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "fabric", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports the NVLink fabric of NVSwitch systems: the fabric state of
+// every GPU attached to the NVSwitches, whether Fabric Manager runs, and the
+// errors of the NVSwitches if the GPU source can read them.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector nvidiadocker.FabricCollector
+	versions  *nvidiadocker.VersionCache
+	driver    *nvidiadocker.DriverCheck
+	gate      *nvidiadocker.FetchGate
+	hostFS    string
+
+	// switches is nil if the GPU source cannot read the NVSwitches.
+	switches nvidiadocker.NVSwitchCollector
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	fabricCollector, ok := collector.(nvidiadocker.FabricCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support reporting the NVLink fabric", config.GPUSource)
+	}
+	switches, _ := collector.(nvidiadocker.NVSwitchCollector)
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		collector:     fabricCollector,
+		versions:      nvidiadocker.NewVersionCache(collector),
+		driver:        nvidiadocker.NewDriverCheck(config),
+		gate:          nvidiadocker.NewFetchGate(config, base),
+		hostFS:        config.HostFS,
+		switches:      switches,
+	}, nil
+}
+
+// Fetch returns one event per GPU attached to NVSwitches and one event per
+// NVSwitch.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	gpus, err := m.collector.GPUFabric()
+	if err != nil {
+		return nil, err
+	}
+	var switches []nvidiadocker.NVSwitch
+	if m.switches != nil {
+		if switches, err = m.switches.NVSwitches(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fabric Manager only runs on NVSwitch systems.
+	var running *bool
+	if len(gpus) > 0 {
+		r, err := nvidiadocker.FabricManagerRunning(m.hostFS)
+		if err != nil {
+			logp.Debug("nvidiadocker", "Cannot tell whether Fabric Manager runs: %v", err)
+		} else {
+			running = &r
+		}
+	}
+
+	events := make([]common.MapStr, 0, len(gpus)+len(switches))
+	for i := range gpus {
+		events = append(events, gpuEventMapping(&gpus[i], running))
+	}
+	for i := range switches {
+		events = append(events, switchEventMapping(&switches[i]))
+	}
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+// gpuEventMapping reports the fabric state of a GPU. running is nil if it is
+// unknown whether Fabric Manager runs.
+func gpuEventMapping(gpu *nvidiadocker.GPUFabric, running *bool) common.MapStr {
+	event := common.MapStr{
+		"gpu": common.MapStr{
+			"uuid": gpu.UUID,
+			"pci": common.MapStr{
+				"bus_id": gpu.BusID,
+			},
+		},
+		"state": gpu.State,
+	}
+	if gpu.Index != nil {
+		event.Put("gpu.index", *gpu.Index)
+	}
+	if gpu.Status != "" {
+		event["status"] = gpu.Status
+	}
+	if running != nil {
+		event["fabric_manager"] = common.MapStr{
+			"running": *running,
+		}
+	}
+	return event
+}
+
+func switchEventMapping(nvswitch *nvidiadocker.NVSwitch) common.MapStr {
+	return common.MapStr{
+		"nvswitch": common.MapStr{
+			"id": nvswitch.ID,
+		},
+		"errors": common.MapStr{
+			"fatal": common.MapStr{
+				"sxid": nvswitch.FatalSXID,
+			},
+			"non_fatal": common.MapStr{
+				"sxid": nvswitch.NonFatalSXID,
+			},
+		},
+	}
+}
@@ -0,0 +1,68 @@
+package fabric
+
+import (
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestGPUEventMapping(t *testing.T) {
+	index := uint(2)
+	running := false
+	event := gpuEventMapping(&nvidiadocker.GPUFabric{
+		Index:  &index,
+		UUID:   "GPU-5b6c1b1a-2f4c-4a1e-9d7b-0c8f3e6a1d22",
+		BusID:  "00000000:07:00.0",
+		State:  "completed",
+		Status: "insufficient_resources",
+	}, &running)
+
+	testDatas := map[string]interface{}{
+		"gpu.index":              uint(2),
+		"gpu.uuid":               "GPU-5b6c1b1a-2f4c-4a1e-9d7b-0c8f3e6a1d22",
+		"gpu.pci.bus_id":         "00000000:07:00.0",
+		"state":                  "completed",
+		"status":                 "insufficient_resources",
+		"fabric_manager.running": false,
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+	fieldstest.CheckEvent(t, event)
+
+	event = gpuEventMapping(&nvidiadocker.GPUFabric{State: "in_progress"}, nil)
+	for _, key := range []string{"status", "fabric_manager", "gpu.index"} {
+		if _, err := event.GetValue(key); err == nil {
+			t.Fatalf("expected no %s", key)
+		}
+	}
+}
+
+func TestSwitchEventMapping(t *testing.T) {
+	event := switchEventMapping(&nvidiadocker.NVSwitch{ID: 10, FatalSXID: 95})
+
+	testDatas := map[string]interface{}{
+		"nvswitch.id":           uint(10),
+		"errors.fatal.sxid":     uint(95),
+		"errors.non_fatal.sxid": uint(0),
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+	fieldstest.CheckEvent(t, event)
+}
@@ -0,0 +1,30 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFabricManagerRunning(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+	writeHostFile(t, hostFS, "/proc/1/comm", "systemd\n", 0644)
+	writeHostFile(t, hostFS, "/proc/self/comm", "nvidiadockerbeat\n", 0644)
+
+	if running, err := FabricManagerRunning(hostFS); err != nil || running {
+		t.Fatalf("expected Fabric Manager not to run, got %v, %v", running, err)
+	}
+
+	writeHostFile(t, hostFS, "/proc/2310/comm", "nv-fabricmanager\n", 0644)
+	if running, err := FabricManagerRunning(hostFS); err != nil || !running {
+		t.Fatalf("expected Fabric Manager to run, got %v, %v", running, err)
+	}
+
+	if _, err := FabricManagerRunning("/nonexistent"); err == nil {
+		t.Fatal("expected an error without /proc")
+	}
+}
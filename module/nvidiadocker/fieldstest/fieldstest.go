@@ -0,0 +1,223 @@
+/*
+Package fieldstest checks in the tests of the MetricSets that the fields of
+their events are documented in their fields.yml, with a type matching the
+value. The template of the beat is generated from the fields.yml files, and a
+field missing from them is mapped dynamically, as a keyword for strings, which
+breaks the aggregations of numeric fields reported as strings.
+*/
+package fieldstest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+type field struct {
+	Name   string  `config:"name"`
+	Type   string  `config:"type"`
+	Fields []field `config:"fields"`
+}
+
+// numericTypes are the types of the fields.yml holding numbers.
+var numericTypes = map[string]bool{
+	"long":         true,
+	"integer":      true,
+	"short":        true,
+	"byte":         true,
+	"double":       true,
+	"float":        true,
+	"half_float":   true,
+	"scaled_float": true,
+}
+
+// CheckEvent fails the test if a field of the event of a MetricSet is not
+// documented in the fields.yml of the MetricSet, under _meta of the directory
+// of the test, or in the fields.yml of the module, or if its documented type
+// does not match its value. Fields documented with the object type may hold
+// any field, and the elements of arrays of objects are checked as the fields
+// of the array. It also fails the test if the event holds a key Metricbeat
+// does not ship.
+func CheckEvent(t *testing.T, event common.MapStr) {
+	types, err := documentedTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errs []string
+	for _, key := range reservedKeys {
+		if _, found := event[key]; found {
+			errs = append(errs, fmt.Sprintf("%s is removed from the events by Metricbeat", key))
+		}
+	}
+	checkFields(types, "", event, &errs)
+	sort.Strings(errs)
+	for _, err := range errs {
+		t.Error(err)
+	}
+}
+
+// reservedKeys are the keys Metricbeat 5.5 removes from the root of the
+// events of MetricSets, to override the index and the document type of the
+// event, so fields under them are never shipped.
+var reservedKeys = []string{"index", "type"}
+
+// documentedTypes returns the types of the fields of the MetricSet, by name
+// relative to the MetricSet, and of the module, under the mb.ModuleData key
+// the MetricSets report them under.
+func documentedTypes() (map[string]string, error) {
+	metricSet, err := loadFields(filepath.Join("_meta", "fields.yml"))
+	if err != nil {
+		return nil, err
+	}
+	module, err := loadFields(filepath.Join("..", "_meta", "fields.yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	types := map[string]string{}
+	for _, f := range metricSet {
+		addTypes(types, "", f.Fields)
+	}
+	// The module fields are documented under the group of the module name.
+	for _, f := range module {
+		for _, group := range f.Fields {
+			addTypes(types, mb.ModuleData+".", group.Fields)
+		}
+	}
+	return types, nil
+}
+
+func loadFields(path string) ([]field, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fields.yml files hold a list, which is unpacked as a field of a
+	// document.
+	var buf bytes.Buffer
+	buf.WriteString("fields:\n")
+	for _, line := range strings.Split(string(data), "\n") {
+		buf.WriteString("  " + line + "\n")
+	}
+	config, err := common.NewConfigWithYAML(buf.Bytes(), path)
+	if err != nil {
+		return nil, err
+	}
+	var fields struct {
+		Fields []field `config:"fields"`
+	}
+	if err := config.Unpack(&fields); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return fields.Fields, nil
+}
+
+func addTypes(types map[string]string, prefix string, fields []field) {
+	for _, f := range fields {
+		name := prefix + f.Name
+		if f.Type == "group" {
+			addTypes(types, name+".", f.Fields)
+			continue
+		}
+		// The fields of the objects of nested fields are documented under
+		// them.
+		if f.Type == "nested" && len(f.Fields) > 0 {
+			addTypes(types, name+".", f.Fields)
+		}
+		if f.Type == "" {
+			f.Type = "keyword"
+		}
+		types[name] = f.Type
+	}
+}
+
+func checkFields(types map[string]string, prefix string, event common.MapStr, errs *[]string) {
+	for key, value := range event {
+		name := prefix + key
+		if objectPrefix(types, name) {
+			continue
+		}
+
+		switch v := value.(type) {
+		case common.MapStr:
+			checkFields(types, name+".", v, errs)
+			continue
+		case map[string]interface{}:
+			checkFields(types, name+".", common.MapStr(v), errs)
+			continue
+		case []common.MapStr:
+			for _, element := range v {
+				checkFields(types, name+".", element, errs)
+			}
+			continue
+		}
+
+		documented, found := types[name]
+		if !found {
+			*errs = append(*errs, fmt.Sprintf("%s is not documented in fields.yml", name))
+			continue
+		}
+		if err := checkType(documented, value); err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+}
+
+// objectPrefix tells whether the field is, or is under, a field documented
+// with the object type.
+func objectPrefix(types map[string]string, name string) bool {
+	for {
+		if types[name] == "object" {
+			return true
+		}
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
+
+// checkType returns an error if the value cannot be indexed as the documented
+// type.
+func checkType(documented string, value interface{}) error {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		if v.Kind() == reflect.Ptr && v.IsNil() || v.Kind() != reflect.Ptr && v.Len() == 0 {
+			return nil
+		}
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		} else {
+			v = v.Index(0)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if !numericTypes[documented] {
+			return fmt.Errorf("number documented as %s", documented)
+		}
+	case reflect.Bool:
+		if documented != "boolean" {
+			return fmt.Errorf("boolean documented as %s", documented)
+		}
+	case reflect.String:
+		if numericTypes[documented] || documented == "boolean" {
+			return fmt.Errorf("string documented as %s", documented)
+		}
+	}
+	return nil
+}
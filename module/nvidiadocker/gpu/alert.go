@@ -0,0 +1,120 @@
+package gpu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// Alert levels of the events, by increasing severity.
+const (
+	alertWarning  = "warning"
+	alertCritical = "critical"
+)
+
+// alertRule checks a value of the GPUs against the thresholds of an alert.
+// key is the event field of the value, which is left out of the events of the
+// GPUs that do not report it, and value returns false if the value cannot be
+// computed, like the memory ratio of a GPU without total memory.
+type alertRule struct {
+	name       string
+	thresholds nvidiadocker.AlertThresholds
+	ratio      bool
+	key        string
+	value      func(device *nvidiadocker.DeviceStatus) (float64, bool)
+}
+
+// alertChecker flags the events of the GPUs exceeding the alert thresholds.
+type alertChecker struct {
+	rules []alertRule
+}
+
+// newAlertChecker returns the checker of the given thresholds, nil if none
+// is set.
+func newAlertChecker(config nvidiadocker.AlertsConfig) (*alertChecker, error) {
+	rules := []alertRule{
+		{"temperature", config.Temperature, false, "temperature", func(device *nvidiadocker.DeviceStatus) (float64, bool) {
+			return float64(device.Temperature), true
+		}},
+		{"utilization", config.Utilization, true, "utilization.gpu", func(device *nvidiadocker.DeviceStatus) (float64, bool) {
+			return nvidiadocker.Percent(device.Utilization.GPU), true
+		}},
+		{"memory", config.Memory, true, "memory.used.bytes", func(device *nvidiadocker.DeviceStatus) (float64, bool) {
+			if device.Memory.GlobalTotal == 0 {
+				return 0, false
+			}
+			return float64(device.Memory.GlobalUsed) / float64(device.Memory.GlobalTotal), true
+		}},
+		{"power", config.Power, true, "power.draw.watts", func(device *nvidiadocker.DeviceStatus) (float64, bool) {
+			if device.PowerEnforcedLimit == 0 {
+				return 0, false
+			}
+			return device.Power / device.PowerEnforcedLimit, true
+		}},
+	}
+
+	checker := &alertChecker{}
+	for _, rule := range rules {
+		warning, critical := rule.thresholds.Warning, rule.thresholds.Critical
+		for _, threshold := range []struct {
+			level string
+			value float64
+		}{{alertWarning, warning}, {alertCritical, critical}} {
+			switch {
+			case threshold.value < 0:
+				return nil, fmt.Errorf("alerts.%s.%s must not be negative, got %v", rule.name, threshold.level, threshold.value)
+			case rule.ratio && threshold.value > 1:
+				return nil, fmt.Errorf("alerts.%s.%s must be a ratio between 0 and 1, got %v", rule.name, threshold.level, threshold.value)
+			}
+		}
+		if warning > 0 && critical > 0 && warning > critical {
+			return nil, fmt.Errorf("alerts.%s.warning %v must not be above alerts.%s.critical %v", rule.name, warning, rule.name, critical)
+		}
+		if warning > 0 || critical > 0 {
+			checker.rules = append(checker.rules, rule)
+		}
+	}
+	if len(checker.rules) == 0 {
+		return nil, nil
+	}
+	return checker, nil
+}
+
+// addTo sets alert.level to the highest level the GPU exceeds a threshold
+// of, and alert.reason to the exceeded thresholds. Events of GPUs below
+// every threshold are left as is.
+func (c *alertChecker) addTo(event common.MapStr, device *nvidiadocker.DeviceStatus) {
+	var (
+		level   string
+		reasons []string
+	)
+	for _, rule := range c.rules {
+		if found, _ := event.HasKey(rule.key); !found {
+			continue
+		}
+		value, ok := rule.value(device)
+		if !ok {
+			continue
+		}
+
+		switch thresholds := rule.thresholds; {
+		case thresholds.Critical > 0 && value > thresholds.Critical:
+			level = alertCritical
+			reasons = append(reasons, fmt.Sprintf("%s %.3g above critical threshold %g", rule.name, value, thresholds.Critical))
+		case thresholds.Warning > 0 && value > thresholds.Warning:
+			if level == "" {
+				level = alertWarning
+			}
+			reasons = append(reasons, fmt.Sprintf("%s %.3g above warning threshold %g", rule.name, value, thresholds.Warning))
+		}
+	}
+	if level == "" {
+		return
+	}
+	event["alert"] = common.MapStr{
+		"level":  level,
+		"reason": strings.Join(reasons, "; "),
+	}
+}
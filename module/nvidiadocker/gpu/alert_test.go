@@ -0,0 +1,81 @@
+package gpu
+
+import (
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestNewAlertChecker(t *testing.T) {
+	if checker, err := newAlertChecker(nvidiadocker.AlertsConfig{}); checker != nil || err != nil {
+		t.Fatalf("expected no checker without thresholds, got %v, %v", checker, err)
+	}
+
+	for _, config := range []nvidiadocker.AlertsConfig{
+		{Temperature: nvidiadocker.AlertThresholds{Warning: -1}},
+		{Utilization: nvidiadocker.AlertThresholds{Critical: 95}},
+		{Memory: nvidiadocker.AlertThresholds{Warning: 0.95, Critical: 0.9}},
+	} {
+		if _, err := newAlertChecker(config); err == nil {
+			t.Fatalf("expected %+v to be invalid", config)
+		}
+	}
+}
+
+func TestAlertChecker(t *testing.T) {
+	checker, err := newAlertChecker(nvidiadocker.AlertsConfig{
+		Temperature: nvidiadocker.AlertThresholds{Warning: 80, Critical: 90},
+		Memory:      nvidiadocker.AlertThresholds{Warning: 0.9},
+		Power:       nvidiadocker.AlertThresholds{Critical: 0.95},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device := &nvidiadocker.DeviceStatus{
+		Temperature:        85,
+		Memory:             nvidiadocker.MemoryInfo{GlobalUsed: 7600, GlobalTotal: 8000},
+		Power:              200,
+		PowerEnforcedLimit: 250,
+	}
+	event := eventMapping(device)
+	checker.addTo(event, device)
+
+	testDatas := map[string]interface{}{
+		"alert.level":  "warning",
+		"alert.reason": "temperature 85 above warning threshold 80; memory 0.95 above warning threshold 0.9",
+	}
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+	fieldstest.CheckEvent(t, event)
+
+	device.Temperature = 95
+	event = eventMapping(device)
+	checker.addTo(event, device)
+	if level, _ := event.GetValue("alert.level"); level != "critical" {
+		t.Fatalf("expected a critical alert, got %v", level)
+	}
+
+	// The temperature of a GPU that does not report it is not checked.
+	device.Unsupported = []string{"temperature.gpu", "memory.used", "memory.total", "memory.free"}
+	event = eventMapping(device)
+	checker.addTo(event, device)
+	if _, found := event["alert"]; found {
+		t.Fatalf("expected no alert, got %v", event["alert"])
+	}
+
+	event = common.MapStr{}
+	checker.addTo(event, &nvidiadocker.DeviceStatus{})
+	if len(event) != 0 {
+		t.Fatalf("expected no alert, got %v", event)
+	}
+}
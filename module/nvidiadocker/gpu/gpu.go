@@ -0,0 +1,544 @@
+package gpu
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "gpu", New, nvidiadocker.ParseHost); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports the status of every GPU of the host, independent of the
+// containers using them.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector nvidiadocker.GPUCollector
+	versions  *nvidiadocker.VersionCache
+	driver    *nvidiadocker.DriverCheck
+	gate      *nvidiadocker.FetchGate
+
+	// counters holds the counters of the previous fetch, to report their
+	// increase since.
+	counters *nvidiadocker.CounterStore
+
+	// sampler samples the GPUs between fetches if sample_interval is set.
+	sampler *nvidiadocker.Sampler
+
+	// tracker detects the GPUs that were reset or fell off the bus since the
+	// previous fetch.
+	tracker *nvidiadocker.DeviceTracker
+
+	// stale detects the GPUs whose sample did not change since the previous
+	// fetch, whose events are dropped if dropStale is set.
+	stale     *nvidiadocker.StaleSampleDetector
+	dropStale bool
+
+	// fahrenheit adds the temperatures in degrees Fahrenheit to the events.
+	fahrenheit bool
+
+	// alerts flags the events of the GPUs exceeding the alert thresholds,
+	// nil if none is set.
+	alerts *alertChecker
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+	if err := config.ApplyHost(base.HostData().URI); err != nil {
+		return nil, err
+	}
+
+	var dropStale bool
+	switch strings.ToLower(config.StaleSamples) {
+	case nvidiadocker.StaleSamplesFlag:
+	case nvidiadocker.StaleSamplesDrop:
+		dropStale = true
+	default:
+		return nil, fmt.Errorf("unknown stale_samples '%s', must be one of %s, %s",
+			config.StaleSamples, nvidiadocker.StaleSamplesDrop, nvidiadocker.StaleSamplesFlag)
+	}
+
+	alerts, err := newAlertChecker(config.Alerts)
+	if err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MetricSet{
+		BaseMetricSet: base,
+		collector:     collector,
+		versions:      nvidiadocker.NewVersionCache(collector),
+		driver:        nvidiadocker.NewDriverCheck(config),
+		gate:          nvidiadocker.NewFetchGate(config, base),
+		counters:      nvidiadocker.NewCounterStore(),
+		tracker:       nvidiadocker.NewDeviceTracker(),
+		stale:         nvidiadocker.NewStaleSampleDetector(),
+		dropStale:     dropStale,
+		fahrenheit:    config.ReportFahrenheit,
+		alerts:        alerts,
+	}
+
+	if config.SampleInterval > 0 {
+		period := m.gate.Period()
+		if config.SampleInterval >= period {
+			return nil, fmt.Errorf("sample_interval %v must be shorter than the period %v", config.SampleInterval, period)
+		}
+		m.sampler = nvidiadocker.NewSampler(collector, config.SampleInterval, period)
+	}
+	return m, nil
+}
+
+// Fetch returns one event per GPU.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	trace := nvidiadocker.NewFetchTrace(m.BaseMetricSet)
+	devices, err := m.collector.Query(nil)
+	if err != nil {
+		return nil, err
+	}
+	trace.Phase("gpu_query", "gpus", len(devices))
+
+	// Sampling starts with the first fetch, whose events have no samples.
+	var summaries map[string]nvidiadocker.SampleSummary
+	if m.sampler != nil {
+		m.sampler.Start()
+		summaries = m.sampler.Summaries()
+	}
+
+	mpsDevices := m.mpsDevices()
+	reset := m.tracker.Update(devices)
+	stale := m.stale.Update(devices)
+
+	events := make([]common.MapStr, 0, len(devices))
+	for i := range devices {
+		device := &devices[i]
+		key := nvidiadocker.DeviceKey(device)
+		if stale[key] && m.dropStale {
+			continue
+		}
+
+		event := eventMapping(device)
+		counterMapping(event, key, device, m.counters)
+		temperatureTrend(event, key, device, m.counters)
+		if m.alerts != nil {
+			m.alerts.addTo(event, device)
+		}
+		if m.fahrenheit {
+			addFahrenheit(event)
+		}
+
+		if summary, found := summaries[key]; found && summary.Count > 0 {
+			event["samples"] = samplesMapping(summary)
+		}
+		if mpsDevices != nil {
+			event.Put("mps.enabled", mpsDevices[device.UUID])
+		}
+		event["reset_detected"] = reset[device.UUID]
+		event.Put("sample.stale", stale[key])
+		if !device.Timestamp.IsZero() {
+			event.Put("sample.timestamp", common.Time(device.Timestamp))
+		}
+
+		events = append(events, event)
+	}
+	m.counters.Commit()
+	m.versions.AddTo(events)
+	trace.Done(len(events))
+	return events, nil
+}
+
+// mpsDevices returns the UUIDs of the GPUs an MPS server runs on, or nil if
+// the GPU source cannot list the GPU processes.
+func (m *MetricSet) mpsDevices() map[string]bool {
+	collector, ok := m.collector.(nvidiadocker.ProcessCollector)
+	if !ok {
+		return nil
+	}
+	processes, err := collector.Processes()
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot list GPU processes: %v", err)
+		return nil
+	}
+	return nvidiadocker.MPSDevices(processes)
+}
+
+func samplesMapping(summary nvidiadocker.SampleSummary) common.MapStr {
+	stats := func(s nvidiadocker.SampleStats) common.MapStr {
+		return common.MapStr{
+			"min": s.Min,
+			"max": s.Max,
+			"avg": s.Avg,
+			"p95": s.P95,
+		}
+	}
+
+	gpu := stats(summary.GPU)
+	gpu["buckets"] = summary.GPUBuckets.MapStr()
+
+	return common.MapStr{
+		"count": summary.Count,
+		"utilization": common.MapStr{
+			"gpu":    gpu,
+			"memory": stats(summary.Memory),
+		},
+		"memory": common.MapStr{
+			"used": stats(summary.MemoryUsed),
+		},
+		"power": common.MapStr{
+			"draw": stats(summary.Power),
+		},
+		"temperature": stats(summary.Temperature),
+	}
+}
+
+// counterMapping adds the counters of the device to the event, along with
+// their increase since the previous fetch, which is left out on the first
+// fetch of a GPU.
+func counterMapping(event common.MapStr, key string, device *nvidiadocker.DeviceStatus, counters *nvidiadocker.CounterStore) {
+	counter := func(name string, value uint64, width uint) common.MapStr {
+		c := common.MapStr{"count": value}
+		if delta, ok := counters.Update(key+"/"+name, value, width); ok {
+			c["delta"] = delta.Delta
+		}
+		return c
+	}
+
+	// Counters nvidia-smi reported an invalid value for are left out, so that
+	// the next delta is computed from the last valid value.
+	invalid := invalidKeys(device)
+	eccCounters := []struct {
+		name  string
+		value uint64
+	}{
+		{"ecc.volatile.single_bit", device.ECC.Volatile.SingleBit},
+		{"ecc.volatile.double_bit", device.ECC.Volatile.DoubleBit},
+		{"ecc.aggregate.single_bit", device.ECC.Aggregate.SingleBit},
+		{"ecc.aggregate.double_bit", device.ECC.Aggregate.DoubleBit},
+	}
+	for _, c := range eccCounters {
+		if invalid[c.name] {
+			counters.Skip(key + "/" + c.name)
+			continue
+		}
+		event.Put(c.name, counter(c.name, c.value, nvidiadocker.Counter64))
+	}
+
+	replays := common.MapStr{"count": device.PCI.Replays}
+	if delta, ok := counters.Update(key+"/pci.replays", device.PCI.Replays, nvidiadocker.Counter32); ok {
+		replays["delta"] = delta.Delta
+		replays["rate"] = delta.Rate
+	}
+	event.Put("pci.replays", replays)
+
+	// The energy counter is not supported by every GPU.
+	if device.Energy == 0 {
+		return
+	}
+	energy := common.MapStr{
+		"total": common.MapStr{"joules": float64(device.Energy) / 1000},
+	}
+	if delta, ok := counters.Update(key+"/energy", device.Energy, nvidiadocker.Counter64); ok {
+		energy["delta"] = common.MapStr{"joules": float64(delta.Delta) / 1000}
+		event.Put("power.average.watts", delta.Rate/1000)
+	}
+	event["energy"] = energy
+}
+
+// temperatureTrend adds the change of the temperature per minute since the
+// previous fetch, left out on the first fetch of a GPU and while its
+// temperature is not reported. A fast ramp warns of throttling before the
+// temperature reaches the slowdown threshold.
+func temperatureTrend(event common.MapStr, key string, device *nvidiadocker.DeviceStatus, counters *nvidiadocker.CounterStore) {
+	if _, found := event["temperature"]; !found {
+		counters.Skip(key + "/temperature")
+		return
+	}
+	if perSecond, ok := counters.Change(key+"/temperature", uint64(device.Temperature)); ok {
+		event["temperature_delta_per_min"] = perSecond * 60
+	}
+}
+
+func eventMapping(device *nvidiadocker.DeviceStatus) common.MapStr {
+	event := common.MapStr{
+		"uuid": device.UUID,
+		"name": device.Name,
+		"pci": common.MapStr{
+			"bus_id": device.PCI.BusID,
+			"throughput": common.MapStr{
+				"rx": common.MapStr{
+					"bytes": uint64(device.PCI.Throughput.RX) * nvidiadocker.MiB,
+				},
+				"tx": common.MapStr{
+					"bytes": uint64(device.PCI.Throughput.TX) * nvidiadocker.MiB,
+				},
+			},
+		},
+		"utilization": common.MapStr{
+			"gpu":     device.Utilization.GPU,
+			"memory":  device.Utilization.Memory,
+			"encoder": device.Utilization.Encoder,
+			"decoder": device.Utilization.Decoder,
+		},
+		"usage": common.MapStr{
+			"gpu":     common.MapStr{"pct": nvidiadocker.Percent(device.Utilization.GPU)},
+			"memory":  common.MapStr{"pct": nvidiadocker.Percent(device.Utilization.Memory)},
+			"encoder": common.MapStr{"pct": nvidiadocker.Percent(device.Utilization.Encoder)},
+			"decoder": common.MapStr{"pct": nvidiadocker.Percent(device.Utilization.Decoder)},
+		},
+		"encoder": common.MapStr{
+			"sessions": device.EncoderStats.SessionCount,
+			"fps":      device.EncoderStats.AverageFPS,
+			"latency": common.MapStr{
+				"us": device.EncoderStats.AverageLatency,
+			},
+		},
+		"memory": common.MapStr{
+			"used": common.MapStr{
+				"bytes": device.Memory.GlobalUsed * nvidiadocker.MiB,
+			},
+			"total": common.MapStr{
+				"bytes": device.Memory.GlobalTotal * nvidiadocker.MiB,
+			},
+			"free": common.MapStr{
+				"bytes": device.Memory.GlobalFree * nvidiadocker.MiB,
+			},
+		},
+		"temperature": device.Temperature,
+		"fan": common.MapStr{
+			"speed": device.FanSpeed,
+		},
+		"pstate": device.PerformanceState,
+		"throttle": common.MapStr{
+			"gpu_idle":                    device.ThrottleReasons.GPUIdle,
+			"applications_clocks_setting": device.ThrottleReasons.ApplicationsClocksSetting,
+			"sw_power_cap":                device.ThrottleReasons.SWPowerCap,
+			"hw_slowdown":                 device.ThrottleReasons.HWSlowdown,
+			"hw_thermal_slowdown":         device.ThrottleReasons.HWThermalSlowdown,
+			"hw_power_brake_slowdown":     device.ThrottleReasons.HWPowerBrakeSlowdown,
+			"sw_thermal_slowdown":         device.ThrottleReasons.SWThermalSlowdown,
+			"sync_boost":                  device.ThrottleReasons.SyncBoost,
+		},
+		"power": common.MapStr{
+			"draw": common.MapStr{
+				"watts": device.Power,
+			},
+			"limit": common.MapStr{
+				"watts": device.PowerLimit,
+			},
+			"enforced_limit": common.MapStr{
+				"watts": device.PowerEnforcedLimit,
+			},
+		},
+	}
+
+	if device.Index != nil {
+		event.Put("gpu.index", *device.Index)
+	}
+	if device.MinorNumber != nil {
+		event["minor_number"] = *device.MinorNumber
+	}
+	if device.MemoryTemperature != nil {
+		event.Put("memory.temperature", *device.MemoryTemperature)
+	}
+	if device.HotspotTemperature != nil {
+		event["temperature_hotspot"] = *device.HotspotTemperature
+	}
+	if device.Profiling != nil {
+		event["profiling"] = profilingMapping(device.Profiling)
+	}
+	if device.ComputeMode != "" {
+		event["compute_mode"] = device.ComputeMode
+	}
+	if device.PersistenceMode != nil {
+		event["persistence_mode"] = *device.PersistenceMode
+	}
+	if len(device.Raw) > 0 {
+		event["raw"] = device.Raw
+	}
+
+	// The headroom to the slowdown temperature lets alerts be independent of
+	// the GPU model.
+	thresholds := device.TemperatureThresholds
+	if thresholds.Slowdown > 0 || thresholds.Shutdown > 0 {
+		event["temperature_threshold"] = common.MapStr{
+			"slowdown": thresholds.Slowdown,
+			"shutdown": thresholds.Shutdown,
+		}
+	}
+	if thresholds.Slowdown > 0 {
+		event["temperature_headroom"] = int(thresholds.Slowdown) - int(device.Temperature)
+	}
+
+	if link := device.PCI.MaxLink; link.Gen > 0 || link.Width > 0 {
+		event.Put("pci.link", pciLinkMapping(device))
+	}
+
+	if len(device.InvalidFields) > 0 {
+		for key := range invalidKeys(device) {
+			event.Delete(key)
+		}
+		event["parse_warnings"] = parseWarnings(device.InvalidFields)
+	}
+	for _, name := range device.Unsupported {
+		for _, key := range smiFieldKeys[name] {
+			event.Delete(key)
+		}
+		if key, found := smiSupportedKeys[name]; found {
+			event.Put(key, false)
+		}
+	}
+	return event
+}
+
+// pciLinkMapping reports the PCIe link the GPU negotiated next to the fastest
+// one it supports. The link is degraded if it has fewer lanes, like a GPU in
+// a x4 slot or on a bad riser, or a lower generation while the GPU is busy.
+// Idle GPUs lower the generation of their link to save power.
+func pciLinkMapping(device *nvidiadocker.DeviceStatus) common.MapStr {
+	link, max := device.PCI.Link, device.PCI.MaxLink
+	degraded := link.Width > 0 && link.Width < max.Width ||
+		link.Gen > 0 && link.Gen < max.Gen && device.Utilization.GPU > 0
+
+	return common.MapStr{
+		"gen": common.MapStr{
+			"current": link.Gen,
+			"max":     max.Gen,
+		},
+		"width": common.MapStr{
+			"current": link.Width,
+			"max":     max.Width,
+		},
+		"degraded": degraded,
+	}
+}
+
+// fahrenheitKeys maps the temperatures of the events, in degrees Celsius, to
+// the fields reporting them in degrees Fahrenheit.
+var fahrenheitKeys = map[string]string{
+	"temperature":         "temperature_fahrenheit",
+	"temperature_hotspot": "temperature_hotspot_fahrenheit",
+	"memory.temperature":  "memory.temperature_fahrenheit",
+}
+
+// addFahrenheit adds the temperatures of the event in degrees Fahrenheit,
+// rounded to a tenth of a degree. The temperatures left out of the event,
+// because the GPU does not report them, are left out in Fahrenheit too.
+func addFahrenheit(event common.MapStr) {
+	for key, fahrenheitKey := range fahrenheitKeys {
+		value, err := event.GetValue(key)
+		if err != nil {
+			continue
+		}
+		celsius, ok := value.(uint)
+		if !ok {
+			continue
+		}
+		event.Put(fahrenheitKey, math.Floor((float64(celsius)*9/5+32)*10+0.5)/10)
+	}
+}
+
+// smiFieldKeys maps the nvidia-smi query fields to the event fields they
+// fill, which are left out if nvidia-smi reports a value that cannot be
+// parsed or that the GPU does not support.
+var smiFieldKeys = map[string][]string{
+	"power.draw":                                      {"power.draw.watts"},
+	"power.limit":                                     {"power.limit.watts"},
+	"enforced.power.limit":                            {"power.enforced_limit.watts"},
+	"ecc.errors.corrected.volatile.total":             {"ecc.volatile.single_bit"},
+	"ecc.errors.uncorrected.volatile.total":           {"ecc.volatile.double_bit"},
+	"ecc.errors.corrected.aggregate.total":            {"ecc.aggregate.single_bit"},
+	"ecc.errors.uncorrected.aggregate.total":          {"ecc.aggregate.double_bit"},
+	"fan.speed":                                       {"fan.speed"},
+	"clocks_throttle_reasons.hw_thermal_slowdown":     {"throttle.hw_thermal_slowdown"},
+	"clocks_throttle_reasons.hw_power_brake_slowdown": {"throttle.hw_power_brake_slowdown"},
+	"clocks_throttle_reasons.sw_thermal_slowdown":     {"throttle.sw_thermal_slowdown"},
+	"utilization.gpu":                                 {"utilization.gpu", "usage.gpu.pct"},
+	"utilization.memory":                              {"utilization.memory", "usage.memory.pct"},
+	"temperature.gpu":                                 {"temperature", "temperature_headroom"},
+	"temperature.memory":                              {"memory.temperature"},
+	"temperature.hotspot":                             {"temperature_hotspot"},
+	"encoder.stats.sessionCount":                      {"encoder.sessions"},
+	"encoder.stats.averageFps":                        {"encoder.fps"},
+	"encoder.stats.averageLatency":                    {"encoder.latency.us"},
+	"memory.used":                                     {"memory.used.bytes"},
+	"memory.total":                                    {"memory.total.bytes"},
+	"memory.free":                                     {"memory.free.bytes"},
+}
+
+// smiSupportedKeys maps the nvidia-smi query fields a GPU may not support to
+// the event field set to false if it does not.
+var smiSupportedKeys = map[string]string{
+	"utilization.gpu":    "supported.utilization.gpu",
+	"utilization.memory": "supported.utilization.memory",
+	"temperature.gpu":    "supported.temperature",
+	"memory.used":        "supported.memory",
+	"memory.total":       "supported.memory",
+	"memory.free":        "supported.memory",
+}
+
+// invalidKeys returns the event fields of the values of the device that
+// could not be parsed.
+func invalidKeys(device *nvidiadocker.DeviceStatus) map[string]bool {
+	keys := map[string]bool{}
+	for name := range device.InvalidFields {
+		for _, key := range smiFieldKeys[name] {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+func parseWarnings(invalidFields map[string]string) []string {
+	names := make([]string, 0, len(invalidFields))
+	for name := range invalidFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	warnings := make([]string, len(names))
+	for i, name := range names {
+		warnings[i] = fmt.Sprintf("invalid %s value %q", name, invalidFields[name])
+	}
+	return warnings
+}
+
+func profilingMapping(p *nvidiadocker.ProfilingInfo) common.MapStr {
+	return common.MapStr{
+		"graphics": common.MapStr{"active": p.GraphicsActive},
+		"sm": common.MapStr{
+			"active":    p.SMActive,
+			"occupancy": p.SMOccupancy,
+		},
+		"tensor": common.MapStr{"active": p.TensorActive},
+		"dram":   common.MapStr{"active": p.DRAMActive},
+		"fp64":   common.MapStr{"active": p.FP64Active},
+		"fp32":   common.MapStr{"active": p.FP32Active},
+		"fp16":   common.MapStr{"active": p.FP16Active},
+	}
+}
@@ -0,0 +1,561 @@
+package gpu
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	index := uint(3)
+	memoryTemperature := uint(45)
+	hotspotTemperature := uint(24)
+	persistenceMode := true
+	event := eventMapping(&nvidiadocker.DeviceStatus{
+		Index: &index,
+		UUID:  "GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67",
+		Name:  "Tesla P40",
+		PCI: nvidiadocker.PCIStatusInfo{
+			BusID:      "0000:11:00.0",
+			Throughput: nvidiadocker.PCIThroughputInfo{RX: 1532, TX: 48},
+			Link:       nvidiadocker.PCILinkInfo{Gen: 3, Width: 8},
+			MaxLink:    nvidiadocker.PCILinkInfo{Gen: 3, Width: 16},
+		},
+		Temperature:        16,
+		MemoryTemperature:  &memoryTemperature,
+		HotspotTemperature: &hotspotTemperature,
+		TemperatureThresholds: nvidiadocker.TemperatureThresholds{
+			Slowdown: 92,
+			Shutdown: 95,
+		},
+		FanSpeed:         48,
+		PerformanceState: "P2",
+		ComputeMode:      "Exclusive_Process",
+		PersistenceMode:  &persistenceMode,
+		ThrottleReasons: nvidiadocker.ThrottleReasonsInfo{
+			SWPowerCap: true,
+		},
+		Power:              75.5,
+		PowerLimit:         250,
+		PowerEnforcedLimit: 200,
+		Utilization: nvidiadocker.UtilizationInfo{
+			GPU:     45,
+			Memory:  12,
+			Encoder: 87,
+			Decoder: 5,
+		},
+		EncoderStats: nvidiadocker.EncoderStatsInfo{
+			SessionCount:   3,
+			AverageFPS:     60,
+			AverageLatency: 1200,
+		},
+		Memory: nvidiadocker.MemoryInfo{
+			GlobalUsed:  7,
+			GlobalTotal: 22912,
+			GlobalFree:  22905,
+		},
+		Profiling: &nvidiadocker.ProfilingInfo{
+			SMActive:     0.875,
+			SMOccupancy:  0.5,
+			TensorActive: 0.25,
+		},
+		Raw: map[string]string{"clocks.max.sm": "1531"},
+	})
+
+	testDatas := map[string]interface{}{
+		"gpu.index":                      uint(3),
+		"uuid":                           "GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67",
+		"name":                           "Tesla P40",
+		"pci.bus_id":                     "0000:11:00.0",
+		"pci.throughput.rx.bytes":        uint64(1532 * 1024 * 1024),
+		"pci.throughput.tx.bytes":        uint64(48 * 1024 * 1024),
+		"pci.link.gen.current":           uint(3),
+		"pci.link.width.current":         uint(8),
+		"pci.link.width.max":             uint(16),
+		"pci.link.degraded":              true,
+		"temperature":                    uint(16),
+		"temperature_threshold.slowdown": uint(92),
+		"temperature_threshold.shutdown": uint(95),
+		"temperature_headroom":           76,
+		"fan.speed":                      uint(48),
+		"pstate":                         "P2",
+		"compute_mode":                   "Exclusive_Process",
+		"persistence_mode":               true,
+		"utilization.gpu":                uint(45),
+		"utilization.memory":             uint(12),
+		"utilization.encoder":            uint(87),
+		"utilization.decoder":            uint(5),
+		"usage.gpu.pct":                  0.45,
+		"usage.memory.pct":               0.12,
+		"usage.encoder.pct":              0.87,
+		"usage.decoder.pct":              0.05,
+		"encoder.sessions":               uint(3),
+		"encoder.fps":                    uint(60),
+		"encoder.latency.us":             uint(1200),
+		"memory.used.bytes":              uint64(7 * 1024 * 1024),
+		"memory.total.bytes":             uint64(22912 * 1024 * 1024),
+		"memory.free.bytes":              uint64(22905 * 1024 * 1024),
+		"memory.temperature":             uint(45),
+		"temperature_hotspot":            uint(24),
+		"throttle.sw_power_cap":          true,
+		"throttle.hw_thermal_slowdown":   false,
+		"power.draw.watts":               75.5,
+		"power.limit.watts":              float64(250),
+		"power.enforced_limit.watts":     float64(200),
+		"profiling.sm.active":            0.875,
+		"profiling.sm.occupancy":         0.5,
+		"profiling.tensor.active":        0.25,
+		"profiling.fp64.active":          float64(0),
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	if raw, ok := event["raw"].(map[string]string); !ok || raw["clocks.max.sm"] != "1531" {
+		t.Fatalf("unexpected raw values %v", event["raw"])
+	}
+
+	empty := eventMapping(&nvidiadocker.DeviceStatus{})
+	if _, found := empty["profiling"]; found {
+		t.Fatal("expected no profiling without DCGM")
+	}
+	if _, found := empty["temperature_headroom"]; found {
+		t.Fatal("expected no temperature headroom without thresholds")
+	}
+	if _, err := empty.GetValue("memory.temperature"); err == nil {
+		t.Fatal("expected no memory temperature if not reported")
+	}
+	if _, err := empty.GetValue("pci.link"); err == nil {
+		t.Fatal("expected no PCIe link if not reported")
+	}
+	if _, found := empty["temperature_hotspot"]; found {
+		t.Fatal("expected no hotspot temperature if not reported")
+	}
+	if _, found := empty["persistence_mode"]; found {
+		t.Fatal("expected no persistence mode if not reported")
+	}
+	if _, found := empty["raw"]; found {
+		t.Fatal("expected no raw values without smi_extra_fields")
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
+
+func TestEventMappingInvalidFields(t *testing.T) {
+	event := eventMapping(&nvidiadocker.DeviceStatus{
+		Temperature:           0,
+		TemperatureThresholds: nvidiadocker.TemperatureThresholds{Slowdown: 92},
+		Utilization:           nvidiadocker.UtilizationInfo{GPU: 45},
+		InvalidFields:         map[string]string{"temperature.gpu": "[N/A]", "memory.used": "abc"},
+	})
+
+	for _, key := range []string{"temperature", "temperature_headroom", "memory.used.bytes"} {
+		if _, err := event.GetValue(key); err == nil {
+			t.Fatalf("expected invalid %s to be left out", key)
+		}
+	}
+	if value, _ := event.GetValue("utilization.gpu"); value != uint(45) {
+		t.Fatalf("unexpected utilization %v", value)
+	}
+
+	expected := []string{`invalid memory.used value "abc"`, `invalid temperature.gpu value "[N/A]"`}
+	if warnings, _ := event["parse_warnings"].([]string); !reflect.DeepEqual(warnings, expected) {
+		t.Fatalf("expected parse warnings %v, got %v", expected, event["parse_warnings"])
+	}
+}
+
+func TestEventMappingUnsupported(t *testing.T) {
+	event := eventMapping(&nvidiadocker.DeviceStatus{
+		Memory:      nvidiadocker.MemoryInfo{GlobalTotal: 8192},
+		Unsupported: []string{"utilization.gpu", "utilization.memory", "temperature.gpu"},
+	})
+
+	for _, key := range []string{"temperature", "utilization.gpu", "utilization.memory"} {
+		if _, err := event.GetValue(key); err == nil {
+			t.Fatalf("expected unsupported %s to be left out", key)
+		}
+		if supported, _ := event.GetValue("supported." + key); supported != false {
+			t.Fatalf("expected %s to be flagged as unsupported, got %v", key, supported)
+		}
+	}
+	if _, err := event.GetValue("usage.gpu.pct"); err == nil {
+		t.Fatal("expected unsupported usage.gpu.pct to be left out")
+	}
+	if _, err := event.GetValue("supported.memory"); err == nil {
+		t.Fatal("expected no flag for supported fields")
+	}
+	if _, found := event["parse_warnings"]; found {
+		t.Fatal("expected no parse warnings for unsupported fields")
+	}
+}
+
+func TestPCILinkMapping(t *testing.T) {
+	max := nvidiadocker.PCILinkInfo{Gen: 4, Width: 16}
+	for _, test := range []struct {
+		link        nvidiadocker.PCILinkInfo
+		utilization uint
+		degraded    bool
+	}{
+		{nvidiadocker.PCILinkInfo{Gen: 4, Width: 16}, 80, false},
+		{nvidiadocker.PCILinkInfo{Gen: 4, Width: 4}, 0, true},
+		{nvidiadocker.PCILinkInfo{Gen: 1, Width: 16}, 80, true},
+		// Idle GPUs lower their link generation.
+		{nvidiadocker.PCILinkInfo{Gen: 1, Width: 16}, 0, false},
+		// vGPUs do not report their current link.
+		{nvidiadocker.PCILinkInfo{}, 80, false},
+	} {
+		link := pciLinkMapping(&nvidiadocker.DeviceStatus{
+			PCI:         nvidiadocker.PCIStatusInfo{Link: test.link, MaxLink: max},
+			Utilization: nvidiadocker.UtilizationInfo{GPU: test.utilization},
+		})
+		if link["degraded"] != test.degraded {
+			t.Fatalf("%+v at %d%%: expected degraded %v, got %v", test.link, test.utilization, test.degraded, link["degraded"])
+		}
+	}
+}
+
+func TestSamplesMapping(t *testing.T) {
+	event := common.MapStr{"samples": samplesMapping(nvidiadocker.SampleSummary{
+		Count:      10,
+		GPU:        nvidiadocker.SampleStats{Min: 0, Max: 100, Avg: 50, P95: 100},
+		MemoryUsed: nvidiadocker.SampleStats{Min: 1024, Max: 4096, Avg: 2048, P95: 4096},
+		Power:      nvidiadocker.SampleStats{Min: 50, Max: 250, Avg: 150, P95: 250},
+		GPUBuckets: nvidiadocker.UtilizationBuckets{5, 0, 0, 5},
+	})}
+
+	testDatas := map[string]interface{}{
+		"samples.count":                          10,
+		"samples.utilization.gpu.avg":            50.0,
+		"samples.utilization.gpu.buckets.0_25":   5,
+		"samples.utilization.gpu.buckets.25_50":  0,
+		"samples.utilization.gpu.buckets.75_100": 5,
+		"samples.memory.used.max":                4096.0,
+		"samples.power.draw.min":                 50.0,
+	}
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
+
+func TestAddFahrenheit(t *testing.T) {
+	memoryTemperature, hotspotTemperature := uint(45), uint(50)
+	event := eventMapping(&nvidiadocker.DeviceStatus{
+		Temperature:        36,
+		MemoryTemperature:  &memoryTemperature,
+		HotspotTemperature: &hotspotTemperature,
+	})
+	addFahrenheit(event)
+
+	for key, expected := range map[string]float64{
+		"temperature_fahrenheit":         96.8,
+		"memory.temperature_fahrenheit":  113,
+		"temperature_hotspot_fahrenheit": 122,
+	} {
+		if value, _ := event.GetValue(key); value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+	fieldstest.CheckEvent(t, event)
+
+	event = eventMapping(&nvidiadocker.DeviceStatus{
+		Unsupported: []string{"temperature.gpu"},
+	})
+	addFahrenheit(event)
+	for _, key := range []string{"temperature_fahrenheit", "memory.temperature_fahrenheit", "temperature_hotspot_fahrenheit"} {
+		if _, err := event.GetValue(key); err == nil {
+			t.Fatalf("expected no %s without the temperature", key)
+		}
+	}
+}
+
+func TestFetchInvalidCounter(t *testing.T) {
+	collector := &mockCollector{}
+	m := &MetricSet{
+		collector: collector,
+		versions:  nvidiadocker.NewVersionCache(collector),
+		counters:  nvidiadocker.NewCounterStore(),
+		tracker:   nvidiadocker.NewDeviceTracker(),
+		stale:     nvidiadocker.NewStaleSampleDetector(),
+	}
+
+	for i, device := range []nvidiadocker.DeviceStatus{
+		{ECC: nvidiadocker.ECCInfo{Aggregate: nvidiadocker.ECCErrorCounts{SingleBit: 10}}},
+		{InvalidFields: map[string]string{"ecc.errors.corrected.aggregate.total": "[Unknown Error]"}},
+		{ECC: nvidiadocker.ECCInfo{Aggregate: nvidiadocker.ECCErrorCounts{SingleBit: 12}}},
+	} {
+		device.UUID = "GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67"
+		collector.devices = []nvidiadocker.DeviceStatus{device}
+
+		events, err := m.Fetch()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = events[0].GetValue("ecc.aggregate.single_bit")
+		if i == 1 && err == nil {
+			t.Fatal("expected invalid counter to be left out")
+		}
+		if i == 2 {
+			if delta, _ := events[0].GetValue("ecc.aggregate.single_bit.delta"); delta != uint64(2) {
+				t.Fatalf("expected delta from the last valid value, got %v", delta)
+			}
+		}
+	}
+}
+
+func TestFetchECCDelta(t *testing.T) {
+	collector := &mockCollector{}
+	m := &MetricSet{
+		collector: collector,
+		versions:  nvidiadocker.NewVersionCache(collector),
+		counters:  nvidiadocker.NewCounterStore(),
+		tracker:   nvidiadocker.NewDeviceTracker(),
+		stale:     nvidiadocker.NewStaleSampleDetector(),
+	}
+
+	testDatas := []struct {
+		Volatile  nvidiadocker.ECCErrorCounts
+		Aggregate nvidiadocker.ECCErrorCounts
+		Deltas    map[string]interface{}
+	}{
+		{
+			nvidiadocker.ECCErrorCounts{SingleBit: 3},
+			nvidiadocker.ECCErrorCounts{SingleBit: 10, DoubleBit: 1},
+			nil,
+		},
+		{
+			nvidiadocker.ECCErrorCounts{SingleBit: 5, DoubleBit: 1},
+			nvidiadocker.ECCErrorCounts{SingleBit: 12, DoubleBit: 2},
+			map[string]interface{}{
+				"ecc.volatile.single_bit.delta":  uint64(2),
+				"ecc.volatile.double_bit.delta":  uint64(1),
+				"ecc.aggregate.single_bit.delta": uint64(2),
+				"ecc.aggregate.double_bit.delta": uint64(1),
+			},
+		},
+		{
+			// Volatile counters reset on driver reload.
+			nvidiadocker.ECCErrorCounts{SingleBit: 1},
+			nvidiadocker.ECCErrorCounts{SingleBit: 13, DoubleBit: 2},
+			map[string]interface{}{
+				"ecc.volatile.single_bit.delta":  uint64(1),
+				"ecc.volatile.double_bit.delta":  uint64(0),
+				"ecc.aggregate.single_bit.delta": uint64(1),
+				"ecc.aggregate.double_bit.delta": uint64(0),
+			},
+		},
+	}
+
+	for i, testData := range testDatas {
+		collector.devices = []nvidiadocker.DeviceStatus{{
+			UUID: "GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67",
+			ECC: nvidiadocker.ECCInfo{
+				Volatile:  testData.Volatile,
+				Aggregate: testData.Aggregate,
+			},
+		}}
+
+		events, err := m.Fetch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("expected 1 event, got %d", len(events))
+		}
+
+		if count, _ := events[0].GetValue("ecc.aggregate.single_bit.count"); count != testData.Aggregate.SingleBit {
+			t.Fatalf("fetch %d: unexpected aggregate count %v", i, count)
+		}
+		if testData.Deltas == nil {
+			if _, err := events[0].GetValue("ecc.volatile.single_bit.delta"); err == nil {
+				t.Fatalf("fetch %d: unexpected delta on first fetch", i)
+			}
+		}
+		for key, expected := range testData.Deltas {
+			value, err := events[0].GetValue(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != expected {
+				t.Fatalf("fetch %d: %s: expected %v, got %v", i, key, expected, value)
+			}
+		}
+	}
+}
+
+func TestFetchEnergyAndReplays(t *testing.T) {
+	collector := &mockCollector{}
+	m := &MetricSet{
+		collector: collector,
+		versions:  nvidiadocker.NewVersionCache(collector),
+		counters:  nvidiadocker.NewCounterStore(),
+		tracker:   nvidiadocker.NewDeviceTracker(),
+		stale:     nvidiadocker.NewStaleSampleDetector(),
+	}
+
+	device := nvidiadocker.DeviceStatus{
+		UUID:        "GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67",
+		Energy:      1500000,
+		PCI:         nvidiadocker.PCIStatusInfo{Replays: 7},
+		Temperature: 60,
+	}
+	collector.devices = []nvidiadocker.DeviceStatus{device}
+	events, err := m.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total, _ := events[0].GetValue("energy.total.joules"); total != float64(1500) {
+		t.Fatalf("unexpected energy %v", total)
+	}
+	if _, err := events[0].GetValue("power.average.watts"); err == nil {
+		t.Fatal("unexpected average power on first fetch")
+	}
+	if _, err := events[0].GetValue("temperature_delta_per_min"); err == nil {
+		t.Fatal("unexpected temperature trend on first fetch")
+	}
+
+	device.Energy = 2250000
+	device.PCI.Replays = 9
+	device.Temperature = 62
+	collector.devices = []nvidiadocker.DeviceStatus{device}
+	if events, err = m.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]interface{}{
+		"energy.delta.joules": float64(750),
+		"pci.replays.count":   uint64(9),
+		"pci.replays.delta":   uint64(2),
+	} {
+		value, err := events[0].GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+	if _, err := events[0].GetValue("power.average.watts"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := events[0].GetValue("temperature_delta_per_min"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type mockCollector struct {
+	devices []nvidiadocker.DeviceStatus
+}
+
+func (c *mockCollector) List() ([]uint, error) {
+	indices := make([]uint, len(c.devices))
+	for i := range indices {
+		indices[i] = uint(i)
+	}
+	return indices, nil
+}
+
+func (c *mockCollector) Query(indices []uint) ([]nvidiadocker.DeviceStatus, error) {
+	return c.devices, nil
+}
+
+type mockProcessCollector struct {
+	mockCollector
+	processes []nvidiadocker.ProcessInfo
+}
+
+func (c *mockProcessCollector) Processes() ([]nvidiadocker.ProcessInfo, error) {
+	return c.processes, nil
+}
+
+func TestFetchMPS(t *testing.T) {
+	collector := &mockProcessCollector{
+		mockCollector: mockCollector{devices: []nvidiadocker.DeviceStatus{{UUID: "GPU-0"}, {UUID: "GPU-1"}}},
+		processes: []nvidiadocker.ProcessInfo{
+			{PID: 10, Name: "nvidia-cuda-mps-server", GPUUUID: "GPU-1"},
+			{PID: 11, Name: "python", GPUUUID: "GPU-1"},
+		},
+	}
+	m := &MetricSet{
+		collector: collector,
+		versions:  nvidiadocker.NewVersionCache(collector),
+		counters:  nvidiadocker.NewCounterStore(),
+		tracker:   nvidiadocker.NewDeviceTracker(),
+		stale:     nvidiadocker.NewStaleSampleDetector(),
+	}
+
+	events, err := m.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []bool{false, true} {
+		if enabled, _ := events[i].GetValue("mps.enabled"); enabled != expected {
+			t.Fatalf("%d: unexpected mps.enabled %v", i, enabled)
+		}
+	}
+}
+
+func TestFetchStaleSample(t *testing.T) {
+	for _, dropStale := range []bool{false, true} {
+		collector := &mockCollector{}
+		m := &MetricSet{
+			collector: collector,
+			versions:  nvidiadocker.NewVersionCache(collector),
+			counters:  nvidiadocker.NewCounterStore(),
+			tracker:   nvidiadocker.NewDeviceTracker(),
+			stale:     nvidiadocker.NewStaleSampleDetector(),
+			dropStale: dropStale,
+		}
+
+		for i, utilization := range []uint{45, 45, 46} {
+			// The timestamps of the samples of a hung driver still change.
+			sampled := time.Date(2017, time.January, 31, 10, 20, 30+i, 0, time.UTC)
+			collector.devices = []nvidiadocker.DeviceStatus{
+				{UUID: "GPU-0", Timestamp: sampled, Utilization: nvidiadocker.UtilizationInfo{GPU: utilization}},
+				{UUID: "GPU-1", Timestamp: sampled, Utilization: nvidiadocker.UtilizationInfo{GPU: uint(i)}},
+			}
+			events, err := m.Fetch()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stale := i == 1
+			if dropStale && stale {
+				if len(events) != 1 || events[0]["uuid"] != "GPU-1" {
+					t.Fatalf("%d: expected the stale GPU to be dropped, got %v", i, events)
+				}
+				continue
+			}
+			if len(events) != 2 {
+				t.Fatalf("%d: expected 2 events, got %d", i, len(events))
+			}
+			if value, _ := events[0].GetValue("sample.stale"); value != stale {
+				t.Fatalf("%d: expected sample.stale %v, got %v", i, stale, value)
+			}
+			if value, _ := events[1].GetValue("sample.stale"); value != false {
+				t.Fatalf("%d: expected GPU-1 not to be stale, got %v", i, value)
+			}
+			if value, _ := events[1].GetValue("sample.timestamp"); value != common.Time(sampled) {
+				t.Fatalf("%d: unexpected sample.timestamp %v", i, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package nvidiadocker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// Statuses of the health endpoint. The endpoint answers 503 Service
+// Unavailable when failing, so that liveness probes and load balancers gate
+// on it, and 200 OK otherwise.
+const (
+	HealthStarting = "starting"
+	HealthOK       = "ok"
+	HealthFailing  = "failing"
+)
+
+// FetchHealth is the outcome of a fetch of a MetricSet. GPUs is the number of
+// GPUs read, nil if the fetch failed before reading them. ContainersErr is the
+// error reaching the container runtime, nil if it was reached.
+type FetchHealth struct {
+	Err           error
+	GPUs          *int
+	ContainersErr error
+}
+
+// HealthServer serves the health of the latest fetch of the MetricSets at
+// /healthz, as JSON. The MetricSets listening on the same address share a
+// server, each one replacing its own health on every fetch. The health of a
+// MetricSet that has not fetched for StalePeriods periods, like after its
+// module was reloaded, is dropped.
+type HealthServer struct {
+	mutex   sync.Mutex
+	sources map[string]*healthSource
+}
+
+type healthSource struct {
+	health  FetchHealth
+	period  time.Duration
+	updated time.Time
+}
+
+var (
+	healthServersMu sync.Mutex
+	healthServers   = map[string]*HealthServer{}
+)
+
+// NewHealthServer returns the server listening on the host and port of the
+// config, starting to serve on the first call.
+func NewHealthServer(config HealthConfig) (*HealthServer, error) {
+	healthServersMu.Lock()
+	defer healthServersMu.Unlock()
+	if s, found := healthServers[config.Host]; found {
+		return s, nil
+	}
+
+	listener, err := net.Listen("tcp", config.Host)
+	if err != nil {
+		return nil, fmt.Errorf("health endpoint: %v", err)
+	}
+	s := &HealthServer{sources: map[string]*healthSource{}}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", s)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logp.Err("Health endpoint on %s stopped: %v", config.Host, err)
+		}
+	}()
+	logp.Info("Serving the health of the fetches on http://%s/healthz", config.Host)
+
+	healthServers[config.Host] = s
+	return s, nil
+}
+
+// Report replaces the health of the given source, like the host a MetricSet
+// reads, with the outcome of its latest fetch. period is the period of the
+// MetricSet.
+func (s *HealthServer) Report(source string, period time.Duration, health FetchHealth) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sources[source] = &healthSource{
+		health:  health,
+		period:  period,
+		updated: time.Now(),
+	}
+}
+
+// ServeHTTP writes the health of all sources. The status is failing if the
+// latest fetch of a source failed or could not reach the container runtime,
+// and starting until a source fetched.
+func (s *HealthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	status := HealthStarting
+	sources := make(map[string]interface{}, len(s.sources))
+	for name, source := range s.sources {
+		if Stale(source.updated, source.period) {
+			delete(s.sources, name)
+			continue
+		}
+		if status == HealthStarting {
+			status = HealthOK
+		}
+
+		health := source.health
+		fetch := map[string]interface{}{"ok": health.Err == nil}
+		if health.Err != nil {
+			fetch["error"] = health.Err.Error()
+			status = HealthFailing
+		}
+		containers := map[string]interface{}{"connected": health.ContainersErr == nil}
+		if health.ContainersErr != nil {
+			containers["error"] = health.ContainersErr.Error()
+			status = HealthFailing
+		}
+		report := map[string]interface{}{
+			"last_fetch": source.updated.UTC().Format(time.RFC3339),
+			"fetch":      fetch,
+			"containers": containers,
+		}
+		if health.GPUs != nil {
+			report["gpu"] = map[string]interface{}{"count": *health.GPUs}
+		}
+		sources[name] = report
+	}
+	s.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status == HealthFailing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  status,
+		"sources": sources,
+	})
+}
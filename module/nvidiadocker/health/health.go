@@ -0,0 +1,107 @@
+package health
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "health", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports the memory health of every GPU of the host.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector nvidiadocker.HealthCollector
+	versions  *nvidiadocker.VersionCache
+	driver    *nvidiadocker.DriverCheck
+	gate      *nvidiadocker.FetchGate
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	healthCollector, ok := collector.(nvidiadocker.HealthCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support reporting GPU health", config.GPUSource)
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		collector:     healthCollector,
+		versions:      nvidiadocker.NewVersionCache(collector),
+		driver:        nvidiadocker.NewDriverCheck(config),
+		gate:          nvidiadocker.NewFetchGate(config, base),
+	}, nil
+}
+
+// Fetch returns one event per GPU.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	devices, err := m.collector.Health()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]common.MapStr, 0, len(devices))
+	for i := range devices {
+		events = append(events, eventMapping(&devices[i]))
+	}
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+func eventMapping(device *nvidiadocker.DeviceHealth) common.MapStr {
+	event := common.MapStr{
+		"uuid": device.UUID,
+		"retired_pages": common.MapStr{
+			"single_bit": common.MapStr{
+				"count": device.RetiredPages.SingleBit,
+			},
+			"double_bit": common.MapStr{
+				"count": device.RetiredPages.DoubleBit,
+			},
+			"pending": device.RetiredPages.Pending,
+		},
+	}
+
+	if device.Index != nil {
+		event.Put("gpu.index", *device.Index)
+	}
+	if rows := device.RemappedRows; rows != nil {
+		event["remapped_rows"] = common.MapStr{
+			"correctable": common.MapStr{
+				"count": rows.Correctable,
+			},
+			"uncorrectable": common.MapStr{
+				"count": rows.Uncorrectable,
+			},
+			"pending": rows.Pending,
+			"failure": rows.Failure,
+		}
+	}
+	return event
+}
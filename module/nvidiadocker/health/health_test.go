@@ -0,0 +1,55 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	index := uint(1)
+	event := eventMapping(&nvidiadocker.DeviceHealth{
+		Index: &index,
+		UUID:  "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6",
+		RetiredPages: nvidiadocker.RetiredPagesInfo{
+			SingleBit: 12,
+			DoubleBit: 1,
+			Pending:   true,
+		},
+		RemappedRows: &nvidiadocker.RemappedRowsInfo{
+			Correctable:   3,
+			Uncorrectable: 1,
+			Failure:       true,
+		},
+	})
+
+	testDatas := map[string]interface{}{
+		"gpu.index":                         uint(1),
+		"uuid":                              "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6",
+		"retired_pages.single_bit.count":    uint64(12),
+		"retired_pages.double_bit.count":    uint64(1),
+		"retired_pages.pending":             true,
+		"remapped_rows.correctable.count":   uint64(3),
+		"remapped_rows.uncorrectable.count": uint64(1),
+		"remapped_rows.pending":             false,
+		"remapped_rows.failure":             true,
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	fieldstest.CheckEvent(t, event)
+
+	event = eventMapping(&nvidiadocker.DeviceHealth{UUID: "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6"})
+	if _, found := event["remapped_rows"]; found {
+		t.Fatal("expected no remapped rows without row remapping")
+	}
+}
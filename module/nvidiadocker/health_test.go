@@ -0,0 +1,59 @@
+package nvidiadocker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthServer(t *testing.T) {
+	s := &HealthServer{sources: map[string]*healthSource{}}
+
+	get := func() (int, map[string]interface{}) {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return recorder.Code, body
+	}
+
+	if code, body := get(); code != http.StatusOK || body["status"] != HealthStarting {
+		t.Fatalf("unexpected health %d %v", code, body)
+	}
+
+	gpus := 2
+	s.Report("localhost", 10*time.Second, FetchHealth{GPUs: &gpus})
+	code, body := get()
+	if code != http.StatusOK || body["status"] != HealthOK {
+		t.Fatalf("unexpected health %d %v", code, body)
+	}
+	source := body["sources"].(map[string]interface{})["localhost"].(map[string]interface{})
+	if count := source["gpu"].(map[string]interface{})["count"]; count != 2.0 {
+		t.Fatalf("unexpected GPU count %v", count)
+	}
+
+	err := errors.New("Cannot connect to the Docker daemon")
+	s.Report("gpu1:2376", 10*time.Second, FetchHealth{Err: err, ContainersErr: err})
+	code, body = get()
+	if code != http.StatusServiceUnavailable || body["status"] != HealthFailing {
+		t.Fatalf("unexpected health %d %v", code, body)
+	}
+	source = body["sources"].(map[string]interface{})["gpu1:2376"].(map[string]interface{})
+	if connected := source["containers"].(map[string]interface{})["connected"]; connected != false {
+		t.Fatalf("unexpected containers %v", source["containers"])
+	}
+	if _, found := source["gpu"]; found {
+		t.Fatalf("unexpected GPU count %v", source["gpu"])
+	}
+
+	// The failing host stopped fetching.
+	s.sources["gpu1:2376"].updated = time.Now().Add(-time.Hour)
+	if code, body := get(); code != http.StatusOK || len(body["sources"].(map[string]interface{})) != 1 {
+		t.Fatalf("unexpected health %d %v", code, body)
+	}
+}
@@ -0,0 +1,83 @@
+package nvidiadocker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// hostBinaryDirs are the directories the command line tools of the GPU
+// sources are looked up in under hostfs.
+var hostBinaryDirs = []string{
+	"/usr/bin",
+	"/usr/local/bin",
+	"/usr/local/nvidia/bin",
+	"/bin",
+	"/usr/sbin",
+}
+
+// hostLibraryDirs are the directories the NVML library is looked up in under
+// hostfs.
+var hostLibraryDirs = []string{
+	"/usr/lib/x86_64-linux-gnu",
+	"/usr/lib/aarch64-linux-gnu",
+	"/usr/lib/powerpc64le-linux-gnu",
+	"/usr/lib64",
+	"/usr/lib",
+	"/usr/local/nvidia/lib64",
+}
+
+// HostPath returns the path of a host file as seen by the beat: under the
+// hostfs mountpoint if the beat runs in a container, unchanged otherwise.
+func HostPath(hostFS, path string) string {
+	if hostFS == "" {
+		return path
+	}
+	return filepath.Join(hostFS, path)
+}
+
+// hostBinary returns the path to run the binary with the given name from, as
+// lookupHostBinary, or the name unchanged on a host read over SSH.
+func (c Config) hostBinary(name string) string {
+	if c.sshHost != nil {
+		return name
+	}
+	return lookupHostBinary(c.HostFS, name)
+}
+
+// lookupHostBinary returns the path to run the binary with the given name
+// from. The PATH of the beat is searched first, then the binary directories
+// of the host under hostfs. Paths are returned unchanged, and so is the name
+// if the binary is not found.
+func lookupHostBinary(hostFS, name string) string {
+	if hostFS == "" || strings.Contains(name, "/") {
+		return name
+	}
+	if _, err := exec.LookPath(name); err == nil {
+		return name
+	}
+	for _, dir := range hostBinaryDirs {
+		path := HostPath(hostFS, filepath.Join(dir, name))
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return path
+		}
+	}
+	return name
+}
+
+// hostLibraries returns the paths of the host library with the given name
+// under hostfs.
+func hostLibraries(hostFS, name string) []string {
+	if hostFS == "" {
+		return nil
+	}
+	var paths []string
+	for _, dir := range hostLibraryDirs {
+		path := HostPath(hostFS, filepath.Join(dir, name))
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,104 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeHostFile(t *testing.T, hostFS, path, content string, mode os.FileMode) {
+	path = filepath.Join(hostFS, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostPath(t *testing.T) {
+	if path := HostPath("", "/proc/1/cgroup"); path != "/proc/1/cgroup" {
+		t.Fatalf("unexpected path %s", path)
+	}
+	if path := HostPath("/hostfs", "/proc/1/cgroup"); path != "/hostfs/proc/1/cgroup" {
+		t.Fatalf("unexpected path %s", path)
+	}
+}
+
+func TestLookupHostBinary(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+	writeHostFile(t, hostFS, "/usr/local/nvidia/bin/nvidia-smi-test", "#!/bin/sh\n", 0755)
+	writeHostFile(t, hostFS, "/usr/bin/dcgmi-test", "", 0644)
+
+	testDatas := []struct {
+		HostFS   string
+		Name     string
+		Expected string
+	}{
+		{"", "nvidia-smi-test", "nvidia-smi-test"},
+		{hostFS, "nvidia-smi-test", filepath.Join(hostFS, "/usr/local/nvidia/bin/nvidia-smi-test")},
+		// Not executable.
+		{hostFS, "dcgmi-test", "dcgmi-test"},
+		// Found on the PATH of the beat.
+		{hostFS, "sh", "sh"},
+		{hostFS, "/opt/nvidia-smi", "/opt/nvidia-smi"},
+	}
+
+	for _, testData := range testDatas {
+		if path := lookupHostBinary(testData.HostFS, testData.Name); path != testData.Expected {
+			t.Fatalf("%s: expected %s, got %s", testData.Name, testData.Expected, path)
+		}
+	}
+}
+
+func TestHostLibraries(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+	writeHostFile(t, hostFS, "/usr/lib64/libnvidia-ml.so.1", "", 0644)
+
+	expected := []string{filepath.Join(hostFS, "/usr/lib64/libnvidia-ml.so.1")}
+	if paths := hostLibraries(hostFS, "libnvidia-ml.so.1"); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+	if paths := hostLibraries("", "libnvidia-ml.so.1"); paths != nil {
+		t.Fatalf("expected no paths without hostfs, got %v", paths)
+	}
+}
+
+func TestCgroupHostFS(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+
+	id := "9f3a4e5c2b1d0e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f"
+	writeHostFile(t, hostFS, "/proc/4242/cgroup", "4:devices:/docker/"+id+"\n", 0644)
+	writeHostFile(t, hostFS, "/sys/fs/cgroup/devices/docker/"+id+"/devices.list", "c 195:1 rwm\n", 0644)
+
+	containerID, err := ContainerIDFromPID(hostFS, 4242)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containerID != id {
+		t.Fatalf("unexpected container ID %s", containerID)
+	}
+
+	devices := []DeviceStatus{{Index: toUintP(0)}, {Index: toUintP(1)}}
+	indices, err := CgroupDevices(hostFS, 4242, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indices, []int{1}) {
+		t.Fatalf("unexpected devices %v", indices)
+	}
+}
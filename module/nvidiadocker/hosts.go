@@ -0,0 +1,143 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// defaultAPIPort is the port the nvidia-docker-plugin REST API listens on.
+const defaultAPIPort = "3476"
+
+// ParseHost is the HostParser of the MetricSets that can poll remote GPU
+// hosts. An entry of the hosts option is either a Docker endpoint, like
+// tcp://gpu1:2376, or a plain name like localhost, which keeps the
+// dockerendpoint option. The host and port of a remote endpoint identify the
+// host in the events.
+func ParseHost(module mb.Module, host string) (mb.HostData, error) {
+	data := mb.HostData{URI: host, SanitizedURI: host, Host: host}
+	if !strings.Contains(host, "://") {
+		return data, nil
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return mb.HostData{}, fmt.Errorf("invalid Docker endpoint '%s' in hosts: %v", host, err)
+	}
+	if u.Host != "" {
+		data.Host = u.Host
+	}
+	return data, nil
+}
+
+// ApplyHost makes the configuration poll the given entry of the hosts option.
+// The containers of a remote Docker endpoint are read from it, and its GPUs
+// from the nvidia-docker-plugin REST API on the same host, at the port of the
+// apiurl option, or from the agent on the same host, at the port of the
+// agent.url option. The other GPU sources only read the local GPUs, except on
+// hosts given as ssh://user@host:port, which run the command line tools of the
+// GPU source and the docker CLI over SSH.
+func (c *Config) ApplyHost(host string) error {
+	if !strings.Contains(host, "://") {
+		return nil
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return fmt.Errorf("invalid Docker endpoint '%s' in hosts: %v", host, err)
+	}
+	if u.Scheme == sshScheme {
+		return c.applySSHHost(host, u)
+	}
+	c.DockerEndpoint = host
+
+	if u.Scheme == "unix" {
+		return nil
+	}
+	switch strings.ToLower(c.GPUSource) {
+	case GPUSourceAPI:
+		c.APIURL, err = remoteURL(u.Hostname(), c.APIURL, defaultAPIPort)
+		if err != nil {
+			return fmt.Errorf("invalid apiurl '%s': %v", c.APIURL, err)
+		}
+	case GPUSourceAgent:
+		c.Agent.URL, err = remoteURL(u.Hostname(), c.Agent.URL, defaultAgentPort)
+		if err != nil {
+			return fmt.Errorf("invalid agent.url '%s': %v", c.Agent.URL, err)
+		}
+	default:
+		return fmt.Errorf("remote Docker endpoint '%s' in hosts requires gpu_source '%s' or '%s'", host, GPUSourceAPI, GPUSourceAgent)
+	}
+	return nil
+}
+
+// applySSHHost makes the configuration read the host over SSH.
+func (c *Config) applySSHHost(host string, u *url.URL) error {
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid SSH host '%s' in hosts: no host name", host)
+	}
+	if strings.ToLower(c.Runtime) != RuntimeDocker {
+		return fmt.Errorf("SSH host '%s' in hosts requires runtime '%s'", host, RuntimeDocker)
+	}
+	// Only the GPU sources running command line tools can read the GPUs of
+	// a host over SSH.
+	switch strings.ToLower(c.GPUSource) {
+	case GPUSourceSMI, GPUSourceDCGM, GPUSourceROCm:
+		c.sshHost = newSSHHost(u, c.SSH)
+		return nil
+	}
+	return fmt.Errorf("SSH host '%s' in hosts requires gpu_source '%s', '%s' or '%s'",
+		host, GPUSourceSMI, GPUSourceDCGM, GPUSourceROCm)
+}
+
+// remoteURL returns the configured URL with the given hostname, and the
+// default port if the URL has none. An empty URL is http on the default port.
+func remoteURL(hostname, configured, defaultPort string) (string, error) {
+	remote := &url.URL{Scheme: "http", Host: net.JoinHostPort(hostname, defaultPort)}
+	if configured != "" {
+		var err error
+		if remote, err = url.Parse(configured); err != nil {
+			return configured, err
+		}
+		port := remote.Port()
+		if port == "" {
+			port = defaultPort
+		}
+		remote.Host = net.JoinHostPort(hostname, port)
+	}
+	return strings.TrimRight(remote.String(), "/"), nil
+}
+
+// LocalHost tells whether the containers run on the host of the beat. The
+// containers of a remote Docker endpoint or of a host read over SSH are not
+// matched with the files of the local host, like /proc, /sys and the kubelet
+// checkpoint, where the same PIDs are unrelated local processes. Neither are
+// the GPU processes reported by the agent of a remote host.
+func (c Config) LocalHost() bool {
+	if c.sshHost != nil {
+		return false
+	}
+	if strings.ToLower(c.GPUSource) == GPUSourceAgent {
+		if u, err := url.Parse(c.Agent.URL); err == nil && !isLoopback(u.Hostname()) {
+			return false
+		}
+	}
+	u, err := url.Parse(dockerEndpoint(c, os.Getenv))
+	if err != nil || u.Scheme == "unix" || u.Scheme == "npipe" {
+		return true
+	}
+	return isLoopback(u.Hostname())
+}
+
+// isLoopback tells whether the host name is the local host.
+func isLoopback(hostname string) bool {
+	if strings.ToLower(hostname) == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,115 @@
+package nvidiadocker
+
+import (
+	"testing"
+)
+
+func TestParseHost(t *testing.T) {
+	testDatas := map[string]string{
+		"localhost":                   "localhost",
+		"tcp://gpu1:2376":             "gpu1:2376",
+		"unix:///var/run/docker.sock": "unix:///var/run/docker.sock",
+	}
+
+	for host, expected := range testDatas {
+		data, err := ParseHost(nil, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Host != expected || data.URI != host {
+			t.Fatalf("%s: expected host %s, got %+v", host, expected, data)
+		}
+	}
+}
+
+func TestApplyHost(t *testing.T) {
+	config := DefaultConfig()
+	config.DockerEndpoint = "unix:///var/run/docker.sock"
+	config.APIURL = "http://localhost:3476"
+	if err := config.ApplyHost("localhost"); err != nil {
+		t.Fatal(err)
+	}
+	if config.DockerEndpoint != "unix:///var/run/docker.sock" || config.APIURL != "http://localhost:3476" {
+		t.Fatalf("expected localhost to keep the configuration, got %+v", config)
+	}
+
+	remote := config
+	if err := remote.ApplyHost("tcp://gpu1:2376"); err != nil {
+		t.Fatal(err)
+	}
+	if remote.DockerEndpoint != "tcp://gpu1:2376" || remote.APIURL != "http://gpu1:3476" {
+		t.Fatalf("unexpected remote configuration %+v", remote)
+	}
+
+	remote = config
+	remote.APIURL = ""
+	if err := remote.ApplyHost("tcp://10.0.0.2:2375"); err != nil {
+		t.Fatal(err)
+	}
+	if remote.APIURL != "http://10.0.0.2:3476" {
+		t.Fatalf("expected default API URL, got %s", remote.APIURL)
+	}
+
+	remote = config
+	remote.GPUSource = GPUSourceNVML
+	if err := remote.ApplyHost("tcp://gpu1:2376"); err == nil {
+		t.Fatal("expected error for remote host with local GPU source")
+	}
+	if err := remote.ApplyHost("unix:///run/docker.sock"); err != nil || remote.DockerEndpoint != "unix:///run/docker.sock" {
+		t.Fatalf("expected local socket with any GPU source, got %v %+v", err, remote)
+	}
+
+	remote = config
+	remote.GPUSource = GPUSourceAgent
+	if err := remote.ApplyHost("tcp://gpu1:2376"); err != nil {
+		t.Fatal(err)
+	}
+	if remote.Agent.URL != "http://gpu1:9480" || remote.APIURL != "http://localhost:3476" {
+		t.Fatalf("unexpected remote agent configuration %+v", remote)
+	}
+
+	remote = config
+	remote.GPUSource = GPUSourceSMI
+	if err := remote.ApplyHost("ssh://ops@gpu1:2222"); err != nil {
+		t.Fatal(err)
+	}
+	if remote.sshHost == nil || remote.sshHost.user != "ops" || remote.sshHost.host != "gpu1" || remote.sshHost.port != "2222" {
+		t.Fatalf("unexpected SSH host %+v", remote.sshHost)
+	}
+	if remote.DockerEndpoint != config.DockerEndpoint || remote.readsLocalGPUs() {
+		t.Fatalf("unexpected SSH configuration %+v", remote)
+	}
+	remote = config
+	if err := remote.ApplyHost("ssh://ops@gpu1"); err == nil {
+		t.Fatal("expected error for SSH host with the api GPU source")
+	}
+}
+
+func TestLocalHost(t *testing.T) {
+	config := DefaultConfig()
+	for endpoint, local := range map[string]bool{
+		"unix:///var/run/docker.sock": true,
+		"tcp://localhost:2375":        true,
+		"tcp://127.0.0.1:2375":        true,
+		"tcp://[::1]:2375":            true,
+		"tcp://gpu1:2376":             false,
+		"tcp://10.0.0.2:2375":         false,
+	} {
+		config.DockerEndpoint = endpoint
+		if config.LocalHost() != local {
+			t.Fatalf("%s: expected local %v", endpoint, local)
+		}
+	}
+
+	// The PIDs reported by the agent of another host are not local, even
+	// with the containers of the local Docker daemon.
+	config = DefaultConfig()
+	config.GPUSource = GPUSourceAgent
+	if !config.LocalHost() {
+		t.Fatal("expected the agent on localhost to be local")
+	}
+	config.Agent.URL = "https://gpu1:9480"
+	if config.LocalHost() {
+		t.Fatal("expected the agent of another host not to be local")
+	}
+}
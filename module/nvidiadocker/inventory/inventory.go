@@ -0,0 +1,114 @@
+package inventory
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "inventory", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports the serial numbers, firmware versions and hardware
+// specification of every GPU of the host.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector nvidiadocker.InventoryCollector
+	versions  *nvidiadocker.VersionCache
+	driver    *nvidiadocker.DriverCheck
+	gate      *nvidiadocker.FetchGate
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	inventoryCollector, ok := collector.(nvidiadocker.InventoryCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support reporting the GPU inventory", config.GPUSource)
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		collector:     inventoryCollector,
+		versions:      nvidiadocker.NewVersionCache(collector),
+		driver:        nvidiadocker.NewDriverCheck(config),
+		gate:          nvidiadocker.NewFetchGate(config, base),
+	}, nil
+}
+
+// Fetch returns one event per GPU.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	devices, err := m.collector.Inventory()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]common.MapStr, 0, len(devices))
+	for i := range devices {
+		events = append(events, eventMapping(&devices[i]))
+	}
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+func eventMapping(device *nvidiadocker.DeviceInventory) common.MapStr {
+	event := common.MapStr{
+		"uuid": device.UUID,
+		"name": device.Name,
+		"pci": common.MapStr{
+			"bus_id": device.PCIBusID,
+		},
+		"memory": common.MapStr{
+			"total": common.MapStr{
+				"bytes": device.MemoryTotal * nvidiadocker.MiB,
+			},
+		},
+	}
+
+	if device.Index != nil {
+		event.Put("gpu.index", *device.Index)
+	}
+	// Consumer GPUs do not report a serial or board part number, nor a power
+	// limit without power management.
+	if device.Serial != "" {
+		event["serial"] = device.Serial
+	}
+	if device.VBIOSVersion != "" {
+		event["vbios_version"] = device.VBIOSVersion
+	}
+	if device.BoardPartNumber != "" {
+		event["board_part_number"] = device.BoardPartNumber
+	}
+	if device.MaxPowerLimit > 0 {
+		event["power"] = common.MapStr{
+			"max_limit": common.MapStr{
+				"watts": device.MaxPowerLimit,
+			},
+		}
+	}
+	return event
+}
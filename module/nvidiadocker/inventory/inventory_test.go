@@ -0,0 +1,62 @@
+package inventory
+
+import (
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	index := uint(1)
+	event := eventMapping(&nvidiadocker.DeviceInventory{
+		Index:           &index,
+		UUID:            "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6",
+		Name:            "Tesla P40",
+		Serial:          "0324217045672",
+		VBIOSVersion:    "86.02.23.00.01",
+		BoardPartNumber: "900-2G610-0000-000",
+		PCIBusID:        "00000000:0B:00.0",
+		MaxPowerLimit:   250,
+		MemoryTotal:     22912,
+	})
+
+	testDatas := map[string]interface{}{
+		"gpu.index":             uint(1),
+		"uuid":                  "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6",
+		"name":                  "Tesla P40",
+		"serial":                "0324217045672",
+		"vbios_version":         "86.02.23.00.01",
+		"board_part_number":     "900-2G610-0000-000",
+		"pci.bus_id":            "00000000:0B:00.0",
+		"power.max_limit.watts": float64(250),
+		"memory.total.bytes":    uint64(22912 * nvidiadocker.MiB),
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
+
+func TestEventMappingUnsupported(t *testing.T) {
+	event := eventMapping(&nvidiadocker.DeviceInventory{
+		UUID:         "GPU-66a2874a-837d-cd53-ab26-0d2d842d9822",
+		Name:         "GeForce GTX 1080",
+		VBIOSVersion: "86.04.17.00.80",
+		MemoryTotal:  8119,
+	})
+
+	for _, key := range []string{"gpu", "serial", "board_part_number", "power"} {
+		if _, err := event.GetValue(key); err == nil {
+			t.Fatalf("expected %s to be omitted, got %v", key, event)
+		}
+	}
+}
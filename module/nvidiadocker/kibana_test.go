@@ -0,0 +1,166 @@
+package nvidiadocker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// numericAggs are the aggregations of the visualizations that only work on
+// fields mapped as numbers.
+var numericAggs = map[string]bool{
+	"avg":         true,
+	"sum":         true,
+	"min":         true,
+	"max":         true,
+	"percentiles": true,
+}
+
+var numericMappings = map[string]bool{
+	"long":         true,
+	"integer":      true,
+	"short":        true,
+	"byte":         true,
+	"double":       true,
+	"float":        true,
+	"half_float":   true,
+	"scaled_float": true,
+}
+
+type savedObject struct {
+	VisState              string `json:"visState"`
+	PanelsJSON            string `json:"panelsJSON"`
+	KibanaSavedObjectMeta struct {
+		SearchSourceJSON string `json:"searchSourceJSON"`
+	} `json:"kibanaSavedObjectMeta"`
+}
+
+func readSavedObject(t *testing.T, path string) savedObject {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var object savedObject
+	if err := json.Unmarshal(data, &object); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return object
+}
+
+// templateTypes returns the types of the fields of the index template of the
+// beat, by dotted name.
+func templateTypes(t *testing.T) map[string]string {
+	data, err := ioutil.ReadFile(filepath.Join("..", "..", "nvidiadockerbeat.template.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var template struct {
+		Mappings struct {
+			Default mapping `json:"_default_"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal(data, &template); err != nil {
+		t.Fatal(err)
+	}
+
+	types := map[string]string{}
+	template.Mappings.Default.addTypes(types, "")
+	return types
+}
+
+type mapping struct {
+	Type       string             `json:"type"`
+	Properties map[string]mapping `json:"properties"`
+}
+
+func (m mapping) addTypes(types map[string]string, prefix string) {
+	for name, property := range m.Properties {
+		if property.Type != "" {
+			types[prefix+name] = property.Type
+		}
+		property.addTypes(types, prefix+name+".")
+	}
+}
+
+// TestKibanaDashboards checks that the bundled dashboards only hold panels
+// bundled with them, and that their visualizations aggregate fields of the
+// index template with a type the aggregations support, since a visualization
+// of a field missing from the template fails when the dashboards are loaded.
+func TestKibanaDashboards(t *testing.T) {
+	types := templateTypes(t)
+	dir := filepath.Join("_meta", "kibana")
+
+	dashboards, err := filepath.Glob(filepath.Join(dir, "dashboard", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dashboards) == 0 {
+		t.Fatal("no dashboards")
+	}
+	for _, path := range dashboards {
+		var panels []struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(readSavedObject(t, path).PanelsJSON), &panels); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		for _, panel := range panels {
+			if _, err := os.Stat(filepath.Join(dir, panel.Type, panel.ID+".json")); err != nil {
+				t.Errorf("%s: panel %s: %v", path, panel.ID, err)
+			}
+		}
+	}
+
+	visualizations, err := filepath.Glob(filepath.Join(dir, "visualization", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	searches, err := filepath.Glob(filepath.Join(dir, "search", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range append(visualizations, searches...) {
+		object := readSavedObject(t, path)
+		var source struct {
+			Index string `json:"index"`
+		}
+		if err := json.Unmarshal([]byte(object.KibanaSavedObjectMeta.SearchSourceJSON), &source); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if source.Index != "nvidiadockerbeat-*" {
+			t.Errorf("%s: unexpected index pattern %q", path, source.Index)
+		}
+		if object.VisState == "" {
+			continue
+		}
+
+		var state struct {
+			Aggs []struct {
+				Type   string `json:"type"`
+				Params struct {
+					Field string `json:"field"`
+				} `json:"params"`
+			} `json:"aggs"`
+		}
+		if err := json.Unmarshal([]byte(object.VisState), &state); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		for _, agg := range state.Aggs {
+			if agg.Params.Field == "" {
+				continue
+			}
+			mapped, found := types[agg.Params.Field]
+			switch {
+			case !found:
+				t.Errorf("%s: %s is not in the index template", path, agg.Params.Field)
+			case numericAggs[agg.Type] && !numericMappings[mapped]:
+				t.Errorf("%s: %s aggregation of %s mapped as %s", path, agg.Type, agg.Params.Field, mapped)
+			case mapped == "text":
+				t.Errorf("%s: %s aggregation of text field %s", path, agg.Type, agg.Params.Field)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package nvidiadocker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// DefaultKubeletCheckpoint is where the kubelet records the devices its
+// device plugins allocated to every container.
+const DefaultKubeletCheckpoint = "/var/lib/kubelet/device-plugins/kubelet_internal_checkpoint"
+
+// Labels the kubelet sets on the containers of a pod.
+const (
+	KubernetesPodUIDLabel        = "io.kubernetes.pod.uid"
+	KubernetesContainerNameLabel = "io.kubernetes.container.name"
+)
+
+// KubeletAllocations holds the GPU device IDs the kubelet allocated to every
+// container, by pod UID and container name. The GPUs are the resources of the
+// device plugins of the backends, like nvidia.com/gpu.
+type KubeletAllocations map[podContainer][]string
+
+type podContainer struct {
+	podUID        string
+	containerName string
+}
+
+// ReadKubeletCheckpoint reads the device allocations from a kubelet device
+// manager checkpoint file.
+func ReadKubeletCheckpoint(path string) (KubeletAllocations, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseKubeletCheckpoint(data)
+}
+
+// KubeletCheckpointPath returns the path of the kubelet checkpoint under
+// hostfs, or an empty path if it is not set or the containers run on a remote
+// host, whose kubelet is not the local one.
+func (c Config) KubeletCheckpointPath() string {
+	if c.KubeletCheckpoint == "" || !c.LocalHost() {
+		return ""
+	}
+	return HostPath(c.HostFS, c.KubeletCheckpoint)
+}
+
+// LoadKubeletAllocations returns the GPUs the kubelet allocated to the
+// containers of pods from the checkpoint at path, or nil if path is empty or
+// the host does not run a kubelet.
+func LoadKubeletAllocations(path string) KubeletAllocations {
+	if path == "" {
+		return nil
+	}
+	allocations, err := ReadKubeletCheckpoint(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logp.Debug("nvidiadocker", "Cannot read kubelet checkpoint %s: %v", path, err)
+		}
+		return nil
+	}
+	return allocations
+}
+
+func parseKubeletCheckpoint(data []byte) (KubeletAllocations, error) {
+	var checkpoint struct {
+		Data struct {
+			PodDeviceEntries []struct {
+				PodUID        string
+				ContainerName string
+				ResourceName  string
+				DeviceIDs     json.RawMessage
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+
+	allocations := KubeletAllocations{}
+	for _, entry := range checkpoint.Data.PodDeviceEntries {
+		if !gpuResource(entry.ResourceName) {
+			continue
+		}
+
+		// Before Kubernetes 1.20 the device IDs are a list, since then they
+		// are grouped by NUMA node.
+		var ids []string
+		if err := json.Unmarshal(entry.DeviceIDs, &ids); err != nil {
+			var byNode map[string][]string
+			if err := json.Unmarshal(entry.DeviceIDs, &byNode); err != nil {
+				return nil, err
+			}
+			nodes := make([]string, 0, len(byNode))
+			for node := range byNode {
+				nodes = append(nodes, node)
+			}
+			sort.Strings(nodes)
+			for _, node := range nodes {
+				ids = append(ids, byNode[node]...)
+			}
+		}
+
+		key := podContainer{podUID: entry.PodUID, containerName: entry.ContainerName}
+		allocations[key] = append(allocations[key], ids...)
+	}
+	return allocations, nil
+}
+
+// gpuResource tells whether the Kubernetes resource is advertised by the
+// device plugin of the backend of one of the GPU sources.
+func gpuResource(name string) bool {
+	for _, backend := range backends {
+		if strings.HasPrefix(name, backend.ResourcePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Devices returns the positions in devices of the GPUs allocated to the
+// container with the given labels, and whether the kubelet allocated GPUs to
+// it at all. The NVIDIA device plugin identifies GPUs by UUID, or by index
+// with DEVICE_ID_STRATEGY=index, the AMD device plugin by PCI bus ID.
+func (a KubeletAllocations) Devices(labels map[string]string, devices []DeviceStatus) ([]int, bool) {
+	key := podContainer{
+		podUID:        labels[KubernetesPodUIDLabel],
+		containerName: labels[KubernetesContainerNameLabel],
+	}
+	if key.podUID == "" || key.containerName == "" {
+		return nil, false
+	}
+
+	ids, found := a[key]
+	if !found {
+		return nil, false
+	}
+	return resolveDevices(ids, devices), true
+}
@@ -0,0 +1,59 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKubeletCheckpoint(t *testing.T) {
+	devices := []DeviceStatus{
+		{Index: toUintP(0), UUID: "GPU-66a2874a-837d-cd53-ab26-0d2d842d9822"},
+		{Index: toUintP(1), UUID: "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6", PCI: PCIStatusInfo{BusID: "00000000:43:00.0"}},
+		{Index: toUintP(2), UUID: "GPU-8f6c4d07-2e8a-4a1c-9b59-f0d1b4e5b3a2", PCI: PCIStatusInfo{BusID: "00000000:83:00.0"}},
+	}
+
+	testDatas := []struct {
+		name       string
+		checkpoint string
+	}{
+		{"list", `{"Data":{"PodDeviceEntries":[` +
+			`{"PodUID":"pod-a","ContainerName":"train","ResourceName":"nvidia.com/gpu","DeviceIDs":["GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6","GPU-8f6c4d07-2e8a-4a1c-9b59-f0d1b4e5b3a2"],"AllocResp":"CgA="},` +
+			`{"PodUID":"pod-a","ContainerName":"train","ResourceName":"example.com/fpga","DeviceIDs":["fpga0"],"AllocResp":"CgA="}],` +
+			`"RegisteredDevices":{"nvidia.com/gpu":["GPU-66a2874a-837d-cd53-ab26-0d2d842d9822"]}},"Checksum":1234}`},
+		{"numa", `{"Data":{"PodDeviceEntries":[` +
+			`{"PodUID":"pod-a","ContainerName":"train","ResourceName":"nvidia.com/gpu","DeviceIDs":{"1":["1","2"]},"AllocResp":"CgA="}],` +
+			`"RegisteredDevices":{"nvidia.com/gpu":["0","1","2"]}},"Checksum":1234}`},
+		{"amd", `{"Data":{"PodDeviceEntries":[` +
+			`{"PodUID":"pod-a","ContainerName":"train","ResourceName":"amd.com/gpu","DeviceIDs":{"0":["0000:43:00.0","0000:83:00.0"]},"AllocResp":"CgA="}],` +
+			`"RegisteredDevices":{"amd.com/gpu":["0000:03:00.0","0000:43:00.0","0000:83:00.0"]}},"Checksum":1234}`},
+	}
+
+	for _, testData := range testDatas {
+		allocations, err := parseKubeletCheckpoint([]byte(testData.checkpoint))
+		if err != nil {
+			t.Fatalf("%s: %v", testData.name, err)
+		}
+
+		positions, found := allocations.Devices(map[string]string{
+			KubernetesPodUIDLabel:        "pod-a",
+			KubernetesContainerNameLabel: "train",
+		}, devices)
+		if !found || !reflect.DeepEqual(positions, []int{1, 2}) {
+			t.Fatalf("%s: unexpected devices %v", testData.name, positions)
+		}
+
+		if _, found := allocations.Devices(map[string]string{
+			KubernetesPodUIDLabel:        "pod-a",
+			KubernetesContainerNameLabel: "POD",
+		}, devices); found {
+			t.Fatalf("%s: expected no allocation for the pod sandbox", testData.name)
+		}
+		if _, found := allocations.Devices(nil, devices); found {
+			t.Fatalf("%s: expected no allocation for a container outside a pod", testData.name)
+		}
+	}
+
+	if _, err := parseKubeletCheckpoint([]byte(`{"Data":{"PodDeviceEntries":[{"ResourceName":"nvidia.com/gpu","DeviceIDs":"GPU-0"}]}}`)); err == nil {
+		t.Fatal("expected error for invalid device IDs")
+	}
+}
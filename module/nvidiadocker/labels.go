@@ -0,0 +1,85 @@
+package nvidiadocker
+
+import (
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/match"
+)
+
+// LabelsConfig selects the container labels reported in the events. Labels
+// matching any Include pattern are reported, all of them if there is none,
+// unless they match an Exclude pattern. Dedot replaces the dots of the label
+// names with underscores, so that labels like com.docker.compose.project are
+// not indexed as nested objects conflicting with a label named
+// com.docker.compose. OwnerLabels lists the labels holding the owner of the
+// container, like its team or project, reported as owner fields.
+type LabelsConfig struct {
+	Dedot       bool            `config:"dedot"`
+	Include     []match.Matcher `config:"include"`
+	Exclude     []match.Matcher `config:"exclude"`
+	OwnerLabels []string        `config:"owner"`
+}
+
+// Labels returns the labels to report of the given container labels. They are
+// returned unchanged if no option is set.
+func (c LabelsConfig) Labels(labels map[string]string) map[string]string {
+	if !c.Dedot && len(c.Include) == 0 && len(c.Exclude) == 0 {
+		return labels
+	}
+
+	selected := make(map[string]string, len(labels))
+	for name, value := range labels {
+		if len(c.Include) > 0 && !matchAny(c.Include, name) {
+			continue
+		}
+		if matchAny(c.Exclude, name) {
+			continue
+		}
+		if c.Dedot {
+			name = strings.Replace(name, ".", "_", -1)
+		}
+		selected[name] = value
+	}
+	return selected
+}
+
+// Owner returns the owner fields of the given container labels, one per
+// owner label the container has, named after the label in lowercase with
+// the characters other than letters, digits and underscores replaced by
+// underscores. It returns nil if the container has none of the labels.
+func (c LabelsConfig) Owner(labels map[string]string) common.MapStr {
+	var owner common.MapStr
+	for _, name := range c.OwnerLabels {
+		value, found := labels[name]
+		if !found {
+			continue
+		}
+		if owner == nil {
+			owner = common.MapStr{}
+		}
+		owner[ownerField(name)] = value
+	}
+	return owner
+}
+
+func ownerField(label string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, label)
+}
+
+func matchAny(matchers []match.Matcher, name string) bool {
+	for _, m := range matchers {
+		if m.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestLabels(t *testing.T) {
+	labels := map[string]string{
+		"com.docker.compose.project": "train",
+		"com.docker.compose.service": "worker",
+		"com.nvidia.cuda.version":    "8.0.61",
+		"maintainer":                 "NVIDIA CORPORATION",
+	}
+
+	testDatas := []struct {
+		Config   map[string]interface{}
+		Expected map[string]string
+	}{
+		{
+			map[string]interface{}{},
+			labels,
+		},
+		{
+			map[string]interface{}{"labels.dedot": true},
+			map[string]string{
+				"com_docker_compose_project": "train",
+				"com_docker_compose_service": "worker",
+				"com_nvidia_cuda_version":    "8.0.61",
+				"maintainer":                 "NVIDIA CORPORATION",
+			},
+		},
+		{
+			map[string]interface{}{
+				"labels.include": []string{"^com\\.docker\\.compose\\."},
+				"labels.exclude": []string{"service$"},
+			},
+			map[string]string{"com.docker.compose.project": "train"},
+		},
+	}
+
+	for _, testData := range testDatas {
+		c, err := common.NewConfigFrom(testData.Config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := DefaultConfig()
+		if err := c.Unpack(&config); err != nil {
+			t.Fatal(err)
+		}
+		if selected := config.Labels.Labels(labels); !reflect.DeepEqual(selected, testData.Expected) {
+			t.Fatalf("%v: expected %v, got %v", testData.Config, testData.Expected, selected)
+		}
+	}
+}
+
+func TestLabelsOwner(t *testing.T) {
+	config := LabelsConfig{OwnerLabels: []string{"team", "Owner", "ai.project", "cost-center"}}
+
+	owner := config.Owner(map[string]string{
+		"team":       "vision",
+		"Owner":      "jdoe",
+		"ai.project": "resnet",
+		"maintainer": "NVIDIA CORPORATION",
+	})
+	expected := common.MapStr{"team": "vision", "owner": "jdoe", "ai_project": "resnet"}
+	if !reflect.DeepEqual(owner, expected) {
+		t.Fatalf("expected %v, got %v", expected, owner)
+	}
+
+	if owner := config.Owner(map[string]string{"maintainer": "NVIDIA CORPORATION"}); owner != nil {
+		t.Fatalf("expected no owner, got %v", owner)
+	}
+}
@@ -0,0 +1,185 @@
+package mig
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "mig", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports the status of every MIG device of the host, attributed to
+// the containers it is exposed to.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector  nvidiadocker.MIGCollector
+	containers *nvidiadocker.ContainerCache
+	versions   *nvidiadocker.VersionCache
+	driver     *nvidiadocker.DriverCheck
+	gate       *nvidiadocker.FetchGate
+	labels     nvidiadocker.LabelsConfig
+	hostFS     string
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	migCollector, ok := collector.(nvidiadocker.MIGCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support listing MIG devices", config.GPUSource)
+	}
+
+	containerClient, err := nvidiadocker.NewContainerClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	gate := nvidiadocker.NewFetchGate(config, base)
+	return &MetricSet{
+		BaseMetricSet: base,
+		collector:     migCollector,
+		containers:    nvidiadocker.NewContainerCache(containerClient, nvidiadocker.NewContainerFilter(config), gate.Period()),
+		versions:      nvidiadocker.NewVersionCache(collector),
+		driver:        nvidiadocker.NewDriverCheck(config),
+		gate:          gate,
+		labels:        config.Labels,
+		hostFS:        config.HostFS,
+	}, nil
+}
+
+// Fetch returns one event per MIG device and container it is exposed to, and
+// one event without container for the MIG devices not exposed to any.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	migs, err := m.collector.MIGDevices()
+	if err != nil {
+		return nil, err
+	}
+	if len(migs) == 0 {
+		return []common.MapStr{}, nil
+	}
+
+	// The containers that could not be inspected are reported by the status
+	// MetricSet.
+	cached, _, err := m.containers.Containers(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities, err := nvidiadocker.LoadMIGCapabilities(m.hostFS)
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot read MIG capabilities: %v", err)
+	}
+
+	containers := make([][]*docker.Container, len(migs))
+	for _, c := range cached {
+		positions := nvidiadocker.VisibleMIGDevices(c.Container.Config.Env, c.Runtime, migs)
+		if len(positions) == 0 && len(nvidiadocker.CapabilityDevices(c.Container)) > 0 {
+			positions = nvidiadocker.MIGCapabilityDevices(capabilities.Container(c.Container), migs)
+		}
+		for _, position := range positions {
+			containers[position] = append(containers[position], c.Container)
+		}
+	}
+
+	events := make([]common.MapStr, 0, len(migs))
+	for i := range migs {
+		if len(containers[i]) == 0 {
+			events = append(events, eventMapping(&migs[i]))
+			continue
+		}
+		for _, container := range containers[i] {
+			event := eventMapping(&migs[i])
+			event["container"] = containerMapping(container, m.labels)
+			events = append(events, event)
+		}
+	}
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+func eventMapping(mig *nvidiadocker.MIGDevice) common.MapStr {
+	gpu := common.MapStr{
+		"uuid": mig.GPUUUID,
+	}
+	if mig.GPUIndex != nil {
+		gpu["index"] = *mig.GPUIndex
+	}
+
+	return common.MapStr{
+		"uuid": mig.UUID,
+		"name": mig.Name,
+		"device": common.MapStr{
+			"index": mig.Index,
+		},
+		"gpu": gpu,
+		"gpu_instance": common.MapStr{
+			"id": mig.GPUInstanceID,
+		},
+		"compute_instance": common.MapStr{
+			"id": mig.ComputeInstanceID,
+		},
+		"utilization": common.MapStr{
+			"gpu":    mig.Utilization.GPU,
+			"memory": mig.Utilization.Memory,
+		},
+		"usage": common.MapStr{
+			"gpu":    common.MapStr{"pct": nvidiadocker.Percent(mig.Utilization.GPU)},
+			"memory": common.MapStr{"pct": nvidiadocker.Percent(mig.Utilization.Memory)},
+		},
+		"memory": common.MapStr{
+			"used": common.MapStr{
+				"bytes": mig.Memory.GlobalUsed * nvidiadocker.MiB,
+			},
+			"total": common.MapStr{
+				"bytes": mig.Memory.GlobalTotal * nvidiadocker.MiB,
+			},
+			"free": common.MapStr{
+				"bytes": mig.Memory.GlobalFree * nvidiadocker.MiB,
+			},
+		},
+	}
+}
+
+func containerMapping(container *docker.Container, labels nvidiadocker.LabelsConfig) common.MapStr {
+	event := common.MapStr{
+		"id":     container.ID,
+		"name":   strings.TrimPrefix(container.Name, "/"),
+		"labels": labels.Labels(container.Config.Labels),
+	}
+	if image := nvidiadocker.Image(container); image != nil {
+		event["image"] = image
+	}
+	if owner := labels.Owner(container.Config.Labels); owner != nil {
+		event["owner"] = owner
+	}
+	nvidiadocker.AddOrchestration(event, container.Config.Labels)
+	return event
+}
@@ -0,0 +1,58 @@
+package mig
+
+import (
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestEventMapping(t *testing.T) {
+	gpuIndex := uint(0)
+	event := eventMapping(&nvidiadocker.MIGDevice{
+		UUID:              "MIG-c6d4f1ef-42e4-5de3-91c7-45d71c87eb3f",
+		Name:              "NVIDIA A100-SXM4-40GB MIG 1g.5gb",
+		Index:             2,
+		GPUIndex:          &gpuIndex,
+		GPUUUID:           "GPU-5d5ba0d6-d33d-2b2c-524d-e4e8e8e3d0ba",
+		GPUInstanceID:     13,
+		ComputeInstanceID: 0,
+		Memory: nvidiadocker.MemoryInfo{
+			GlobalUsed:  1024,
+			GlobalTotal: 4864,
+			GlobalFree:  3840,
+		},
+	})
+	event["container"] = containerMapping(&docker.Container{
+		ID:     "id1",
+		Name:   "/name1",
+		Config: &docker.Config{},
+	}, nvidiadocker.LabelsConfig{})
+
+	testDatas := map[string]interface{}{
+		"uuid":                "MIG-c6d4f1ef-42e4-5de3-91c7-45d71c87eb3f",
+		"name":                "NVIDIA A100-SXM4-40GB MIG 1g.5gb",
+		"device.index":        uint(2),
+		"gpu.index":           uint(0),
+		"gpu.uuid":            "GPU-5d5ba0d6-d33d-2b2c-524d-e4e8e8e3d0ba",
+		"gpu_instance.id":     uint(13),
+		"compute_instance.id": uint(0),
+		"memory.used.bytes":   uint64(1024 * 1024 * 1024),
+		"memory.total.bytes":  uint64(4864 * 1024 * 1024),
+		"container.id":        "id1",
+		"container.name":      "name1",
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
@@ -0,0 +1,159 @@
+package nvidiadocker
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// migMinorsPath lists the minor numbers of the /dev/nvidia-caps/nvidia-capN
+// devices granting access to the GPU and compute instances of the GPUs in MIG
+// mode.
+const migMinorsPath = "/proc/driver/nvidia-caps/mig-minors"
+
+var (
+	nvidiaCapDeviceRegexp = regexp.MustCompile("^/dev/nvidia-caps/nvidia-cap([0-9]+)$")
+	migMinorRegexp        = regexp.MustCompile("^gpu([0-9]+)/gi([0-9]+)(?:/ci([0-9]+))?/access$")
+)
+
+// MIGCapability is the GPU or compute instance a capability device grants
+// access to. ComputeInstanceID is nil for the access to a GPU instance.
+type MIGCapability struct {
+	GPUMinorNumber    uint
+	GPUInstanceID     uint
+	ComputeInstanceID *uint
+}
+
+// MIGCapabilities maps the minor numbers of the capability devices to the
+// instances they grant access to.
+type MIGCapabilities map[uint]MIGCapability
+
+// LoadMIGCapabilities reads the capability devices of the MIG instances from
+// under hostFS. Without MIG support in the driver the file is missing and nil
+// is returned.
+func LoadMIGCapabilities(hostFS string) (MIGCapabilities, error) {
+	f, err := os.Open(HostPath(hostFS, migMinorsPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMIGMinors(f)
+}
+
+// parseMIGMinors parses the content of the mig-minors file, with lines like
+// "gpu0/gi1/access 12" and "gpu0/gi1/ci0/access 13". The config and monitor
+// capabilities are skipped.
+func parseMIGMinors(r io.Reader) (MIGCapabilities, error) {
+	capabilities := MIGCapabilities{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		match := migMinorRegexp.FindStringSubmatch(fields[0])
+		if match == nil {
+			continue
+		}
+		minor, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		gpu, _ := strconv.ParseUint(match[1], 10, 32)
+		gpuInstance, _ := strconv.ParseUint(match[2], 10, 32)
+		capability := MIGCapability{GPUMinorNumber: uint(gpu), GPUInstanceID: uint(gpuInstance)}
+		if match[3] != "" {
+			computeInstance, _ := strconv.ParseUint(match[3], 10, 32)
+			capability.ComputeInstanceID = toUintP(uint(computeInstance))
+		}
+		capabilities[uint(minor)] = capability
+	}
+	return capabilities, scanner.Err()
+}
+
+// CapabilityDevices returns the minor numbers of the
+// /dev/nvidia-caps/nvidia-capN devices mapped into the container.
+func CapabilityDevices(container *docker.Container) []uint {
+	var minors []uint
+	for _, device := range container.HostConfig.Devices {
+		findStrs := nvidiaCapDeviceRegexp.FindStringSubmatch(device.PathOnHost)
+		if len(findStrs) != 2 {
+			continue
+		}
+		if minor, err := strconv.ParseUint(findStrs[1], 10, 32); err == nil {
+			minors = append(minors, uint(minor))
+		}
+	}
+	return minors
+}
+
+// Container returns the instances the capability devices mapped into the
+// container grant access to.
+func (c MIGCapabilities) Container(container *docker.Container) []MIGCapability {
+	var capabilities []MIGCapability
+	for _, minor := range CapabilityDevices(container) {
+		if capability, found := c[minor]; found {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// MIGCapabilityDevices returns the positions in migs of the MIG devices whose
+// compute instance the container was granted access to by its capability
+// devices. This covers the containers getting MIG devices without the NVIDIA
+// container runtime. The GPU of a MIG device is matched by its minor number,
+// or by its index if the GPU source does not report minor numbers.
+func MIGCapabilityDevices(capabilities []MIGCapability, migs []MIGDevice) []int {
+	var positions []int
+	for i := range migs {
+		for _, capability := range capabilities {
+			if capability.ComputeInstanceID != nil && capability.matches(&migs[i]) {
+				positions = append(positions, i)
+				break
+			}
+		}
+	}
+	return positions
+}
+
+func (c MIGCapability) matches(mig *MIGDevice) bool {
+	gpu := mig.GPUMinorNumber
+	if gpu == nil {
+		gpu = mig.GPUIndex
+	}
+	return gpu != nil && *gpu == c.GPUMinorNumber &&
+		mig.GPUInstanceID == c.GPUInstanceID && mig.ComputeInstanceID == *c.ComputeInstanceID
+}
+
+// migCapabilityGPUs returns the positions in gpuDevices of the GPUs of the
+// MIG instances the capability devices mapped into the container grant access
+// to, read from under hostFS.
+func migCapabilityGPUs(container *docker.Container, gpuDevices []DeviceStatus, hostFS string) ([]int, error) {
+	if len(CapabilityDevices(container)) == 0 {
+		return nil, nil
+	}
+	capabilities, err := LoadMIGCapabilities(hostFS)
+	if err != nil {
+		return nil, err
+	}
+
+	var minors []uint
+	seen := map[uint]bool{}
+	for _, capability := range capabilities.Container(container) {
+		if !seen[capability.GPUMinorNumber] {
+			seen[capability.GPUMinorNumber] = true
+			minors = append(minors, capability.GPUMinorNumber)
+		}
+	}
+	return minorPositions(minors, gpuDevices), nil
+}
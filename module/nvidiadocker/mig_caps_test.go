@@ -0,0 +1,112 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const migMinors = `config 1
+monitor 2
+gpu0/gi1/access 12
+gpu0/gi1/ci0/access 13
+gpu0/gi2/access 21
+gpu0/gi2/ci0/access 22
+gpu1/gi1/access 147
+gpu1/gi1/ci0/access 148
+`
+
+func capContainer(minors ...string) *docker.Container {
+	container := &docker.Container{HostConfig: &docker.HostConfig{}, Config: &docker.Config{}}
+	for _, minor := range minors {
+		path := "/dev/nvidia-caps/nvidia-cap" + minor
+		container.HostConfig.Devices = append(container.HostConfig.Devices, docker.Device{PathOnHost: path, PathInContainer: path})
+	}
+	return container
+}
+
+func TestParseMIGMinors(t *testing.T) {
+	capabilities, err := parseMIGMinors(strings.NewReader(migMinors))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(capabilities) != 6 {
+		t.Fatalf("unexpected capabilities %v", capabilities)
+	}
+	if capability := capabilities[12]; capability.GPUMinorNumber != 0 || capability.GPUInstanceID != 1 || capability.ComputeInstanceID != nil {
+		t.Fatalf("unexpected GPU instance capability %v", capability)
+	}
+	if capability := capabilities[148]; capability.GPUMinorNumber != 1 || capability.GPUInstanceID != 1 || *capability.ComputeInstanceID != 0 {
+		t.Fatalf("unexpected compute instance capability %v", capability)
+	}
+}
+
+func TestMIGCapabilityDevices(t *testing.T) {
+	capabilities, err := parseMIGMinors(strings.NewReader(migMinors))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migs := []MIGDevice{
+		{GPUIndex: toUintP(0), GPUMinorNumber: toUintP(0), GPUInstanceID: 1, ComputeInstanceID: 0},
+		{GPUIndex: toUintP(0), GPUMinorNumber: toUintP(0), GPUInstanceID: 2, ComputeInstanceID: 0},
+		// The second GPU is matched by its index without minor number.
+		{GPUIndex: toUintP(1), GPUInstanceID: 1, ComputeInstanceID: 0},
+	}
+
+	testDatas := []struct {
+		Container *docker.Container
+		Positions []int
+	}{
+		{capContainer("21", "22"), []int{1}},
+		{capContainer("147", "148", "12", "13"), []int{0, 2}},
+		// The access to a GPU instance alone grants no compute instance.
+		{capContainer("12"), nil},
+		{capContainer(), nil},
+	}
+	for _, testData := range testDatas {
+		positions := MIGCapabilityDevices(capabilities.Container(testData.Container), migs)
+		if !reflect.DeepEqual(positions, testData.Positions) {
+			t.Fatalf("expected %v, got %v", testData.Positions, positions)
+		}
+	}
+}
+
+func TestContainerDeviceIndicesMIGCapabilities(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+
+	if err := os.MkdirAll(filepath.Join(hostFS, filepath.Dir(migMinorsPath)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(hostFS, migMinorsPath), []byte(migMinors), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gpuDevices := []DeviceStatus{
+		{UUID: "GPU-0", MinorNumber: toUintP(0)},
+		{UUID: "GPU-1", MinorNumber: toUintP(1)},
+	}
+	container := capContainer("147", "148")
+	container.HostConfig.Devices = append(container.HostConfig.Devices,
+		docker.Device{PathOnHost: "/dev/nvidiactl", PathInContainer: "/dev/nvidiactl"},
+		docker.Device{PathOnHost: "/dev/nvidia-uvm", PathInContainer: "/dev/nvidia-uvm"})
+
+	indices := NVIDIABackend.ContainerDeviceIndices(container, &ContainerRuntime{}, nil, gpuDevices, hostFS)
+	if !reflect.DeepEqual(indices, []int{1}) {
+		t.Fatalf("unexpected indices %v", indices)
+	}
+
+	// Without MIG support in the driver, no GPU is attributed.
+	indices = NVIDIABackend.ContainerDeviceIndices(container, &ContainerRuntime{}, nil, gpuDevices, filepath.Join(hostFS, "missing"))
+	if len(indices) != 0 {
+		t.Fatalf("unexpected indices without mig-minors %v", indices)
+	}
+}
@@ -0,0 +1,27 @@
+package nvidiadocker
+
+import (
+	"path"
+)
+
+// MPSServerName is the name of the MPS server process, which the MPS control
+// daemon starts on the GPUs when the first client connects. It runs the CUDA
+// contexts of all clients of the GPUs.
+const MPSServerName = "nvidia-cuda-mps-server"
+
+// IsMPSServer tells whether the process is an MPS server. The GPU sources
+// report the name of the process either as is or as the path of its binary.
+func IsMPSServer(process *ProcessInfo) bool {
+	return path.Base(process.Name) == MPSServerName
+}
+
+// MPSDevices returns the UUIDs of the GPUs an MPS server runs on.
+func MPSDevices(processes []ProcessInfo) map[string]bool {
+	devices := map[string]bool{}
+	for i := range processes {
+		if IsMPSServer(&processes[i]) {
+			devices[processes[i].GPUUUID] = true
+		}
+	}
+	return devices
+}
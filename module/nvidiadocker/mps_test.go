@@ -0,0 +1,23 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMPSDevices(t *testing.T) {
+	processes := []ProcessInfo{
+		{PID: 10, Name: "/usr/bin/nvidia-cuda-mps-server", GPUUUID: "GPU-0"},
+		{PID: 11, Name: "python", GPUUUID: "GPU-0"},
+		{PID: 12, Name: "nvidia-cuda-mps-server", GPUUUID: "GPU-1"},
+		{PID: 13, Name: "python", GPUUUID: "GPU-2"},
+	}
+
+	if !IsMPSServer(&processes[0]) || IsMPSServer(&processes[1]) {
+		t.Fatal("unexpected MPS server detection")
+	}
+	expected := map[string]bool{"GPU-0": true, "GPU-1": true}
+	if devices := MPSDevices(processes); !reflect.DeepEqual(devices, expected) {
+		t.Fatalf("expected %v, got %v", expected, devices)
+	}
+}
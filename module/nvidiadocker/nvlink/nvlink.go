@@ -0,0 +1,128 @@
+package nvlink
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "nvlink", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports the traffic and errors of every NVLink of the host.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector nvidiadocker.NVLinkCollector
+	versions  *nvidiadocker.VersionCache
+	driver    *nvidiadocker.DriverCheck
+	gate      *nvidiadocker.FetchGate
+
+	// counters holds the counters of the previous fetch, to report the rates
+	// since.
+	counters *nvidiadocker.CounterStore
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	nvlinkCollector, ok := collector.(nvidiadocker.NVLinkCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support reporting NVLinks", config.GPUSource)
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		collector:     nvlinkCollector,
+		versions:      nvidiadocker.NewVersionCache(collector),
+		driver:        nvidiadocker.NewDriverCheck(config),
+		gate:          nvidiadocker.NewFetchGate(config, base),
+		counters:      nvidiadocker.NewCounterStore(),
+	}, nil
+}
+
+// Fetch returns one event per NVLink.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	links, err := m.collector.NVLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]common.MapStr, 0, len(links))
+	for i := range links {
+		events = append(events, eventMapping(&links[i], m.counters))
+	}
+	m.counters.Commit()
+
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+// eventMapping reports the counters of a link. The data rates and the errors
+// counted since the previous fetch are left out on the first fetch of a link.
+func eventMapping(link *nvidiadocker.NVLink, counters *nvidiadocker.CounterStore) common.MapStr {
+	gpu := common.MapStr{
+		"uuid": link.GPUUUID,
+	}
+	if link.GPUIndex != nil {
+		gpu["index"] = *link.GPUIndex
+	}
+
+	key := fmt.Sprintf("%s/%d/", link.GPUUUID, link.Link)
+	data := func(name string, value uint64) common.MapStr {
+		d := common.MapStr{
+			"bytes": value * 1024,
+		}
+		if delta, ok := counters.Update(key+name, value, nvidiadocker.Counter64); ok {
+			d["bytes_per_sec"] = delta.Rate * 1024
+		}
+		return d
+	}
+	errors := func(name string, value uint64) common.MapStr {
+		e := common.MapStr{
+			"count": value,
+		}
+		if delta, ok := counters.Update(key+name, value, nvidiadocker.Counter64); ok {
+			e["delta"] = delta.Delta
+		}
+		return e
+	}
+
+	return common.MapStr{
+		"gpu":    gpu,
+		"link":   link.Link,
+		"active": link.Active,
+		"data": common.MapStr{
+			"tx": data("data.tx", link.DataTX),
+			"rx": data("data.rx", link.DataRX),
+		},
+		"errors": common.MapStr{
+			"replay":   errors("errors.replay", link.ReplayErrors),
+			"recovery": errors("errors.recovery", link.RecoveryErrors),
+			"crc":      errors("errors.crc", link.CRCErrors),
+		},
+	}
+}
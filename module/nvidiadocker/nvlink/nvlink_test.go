@@ -0,0 +1,71 @@
+package nvlink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	index := uint(1)
+	previous := &nvidiadocker.NVLink{
+		GPUIndex:     &index,
+		GPUUUID:      "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6",
+		Link:         2,
+		Active:       true,
+		DataTX:       1000,
+		DataRX:       2000,
+		ReplayErrors: 3,
+		CRCErrors:    10,
+	}
+	current := *previous
+	current.DataTX = 11000
+	current.DataRX = 2000
+	current.ReplayErrors = 5
+	current.CRCErrors = 4
+
+	counters := nvidiadocker.NewCounterStore()
+	first := eventMapping(previous, counters)
+	for _, key := range []string{"data.tx.bytes_per_sec", "errors.replay.delta"} {
+		if _, err := first.GetValue(key); err == nil {
+			t.Fatalf("%s: expected no rate on the first fetch", key)
+		}
+	}
+	counters.Commit()
+
+	time.Sleep(time.Millisecond)
+	event := eventMapping(&current, counters)
+
+	testDatas := map[string]interface{}{
+		"gpu.index":             uint(1),
+		"gpu.uuid":              "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6",
+		"link":                  uint(2),
+		"active":                true,
+		"data.tx.bytes":         uint64(11000 * 1024),
+		"data.rx.bytes":         uint64(2000 * 1024),
+		"data.rx.bytes_per_sec": float64(0),
+		"errors.replay.count":   uint64(5),
+		"errors.replay.delta":   uint64(2),
+		"errors.recovery.delta": uint64(0),
+		"errors.crc.count":      uint64(4),
+		"errors.crc.delta":      uint64(4),
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	if rate, _ := event.GetValue("data.tx.bytes_per_sec"); rate == nil || rate.(float64) <= 0 {
+		t.Fatalf("expected a transmit rate, got %v", rate)
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
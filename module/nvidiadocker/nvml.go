@@ -0,0 +1,1650 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package nvidiadocker
+
+/*
+#cgo LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stddef.h>
+#include <stdlib.h>
+
+#define NVML_SUCCESS                   0
+#define NVML_ERROR_INVALID_ARGUMENT    2
+#define NVML_ERROR_NOT_SUPPORTED       3
+#define NVML_ERROR_NOT_FOUND           6
+#define NVML_ERROR_TIMEOUT             10
+#define NVML_ERROR_INSUFFICIENT_SIZE   7
+#define NVML_ERROR_LIBRARY_NOT_FOUND   12
+#define NVML_ERROR_FUNCTION_NOT_FOUND  13
+#define NVML_TEMPERATURE_GPU           0
+#define NVML_COMPUTEMODE_DEFAULT           0
+#define NVML_COMPUTEMODE_EXCLUSIVE_THREAD  1
+#define NVML_COMPUTEMODE_PROHIBITED        2
+#define NVML_COMPUTEMODE_EXCLUSIVE_PROCESS 3
+#define NVML_TEMPERATURE_THRESHOLD_SHUTDOWN 0
+#define NVML_TEMPERATURE_THRESHOLD_SLOWDOWN 1
+#define NVML_DEVICE_UUID_BUFFER_SIZE   80
+#define NVML_DEVICE_NAME_BUFFER_SIZE   96
+#define NVML_DEVICE_PCI_BUS_ID_BUFFER_SIZE 16
+#define NVML_DEVICE_SERIAL_BUFFER_SIZE 30
+#define NVML_DEVICE_VBIOS_VERSION_BUFFER_SIZE 32
+#define NVML_DEVICE_PART_NUMBER_BUFFER_SIZE 80
+#define NVML_SYSTEM_DRIVER_VERSION_BUFFER_SIZE 80
+
+#define NVML_EVENT_TYPE_XID_CRITICAL_ERROR 0x8ULL
+#define NVML_DEVICE_MIG_ENABLE             1
+#define NVML_NVLINK_MAX_LINKS              18
+#define NVML_FEATURE_DISABLED              0
+#define NVML_NVLINK_ERROR_DL_REPLAY        0
+#define NVML_NVLINK_ERROR_DL_RECOVERY      1
+#define NVML_NVLINK_ERROR_DL_CRC_FLIT      2
+#define NVML_FI_DEV_NVLINK_THROUGHPUT_DATA_TX 138
+#define NVML_FI_DEV_NVLINK_THROUGHPUT_DATA_RX 139
+#define NVML_FI_DEV_MEMORY_TEMP            82
+#define NVML_PSTATE_UNKNOWN                32
+#define NVML_FEATURE_ENABLED               1
+#define NVML_PAGE_RETIREMENT_CAUSE_MULTIPLE_SINGLE_BIT_ECC_ERRORS 0
+#define NVML_PAGE_RETIREMENT_CAUSE_DOUBLE_BIT_ECC_ERROR           1
+#define NVML_PCIE_UTIL_TX_BYTES            0
+#define NVML_PCIE_UTIL_RX_BYTES            1
+#define NVML_GPU_FABRIC_UUID_LEN           16
+#define NVML_GPU_FABRIC_STATE_NOT_SUPPORTED 0
+#define NVML_GPU_FABRIC_STATE_NOT_STARTED   1
+#define NVML_GPU_FABRIC_STATE_IN_PROGRESS   2
+#define NVML_GPU_FABRIC_STATE_COMPLETED     3
+
+#define NVML_MEMORY_ERROR_TYPE_CORRECTED   0
+#define NVML_MEMORY_ERROR_TYPE_UNCORRECTED 1
+#define NVML_VOLATILE_ECC                  0
+#define NVML_AGGREGATE_ECC                 1
+
+#define nvmlClocksThrottleReasonGpuIdle                   0x01ULL
+#define nvmlClocksThrottleReasonApplicationsClocksSetting 0x02ULL
+#define nvmlClocksThrottleReasonSwPowerCap                0x04ULL
+#define nvmlClocksThrottleReasonHwSlowdown                0x08ULL
+#define nvmlClocksThrottleReasonSyncBoost                 0x10ULL
+#define nvmlClocksThrottleReasonSwThermalSlowdown         0x20ULL
+#define nvmlClocksThrottleReasonHwThermalSlowdown         0x40ULL
+#define nvmlClocksThrottleReasonHwPowerBrakeSlowdown      0x80ULL
+
+typedef int nvmlReturn_t;
+typedef struct nvmlDevice_st *nvmlDevice_t;
+typedef struct nvmlEventSet_st *nvmlEventSet_t;
+
+typedef struct {
+	nvmlDevice_t device;
+	unsigned long long eventType;
+	unsigned long long eventData;
+} nvmlEventData_t;
+
+typedef struct {
+	unsigned int gpu;
+	unsigned int memory;
+} nvmlUtilization_t;
+
+typedef struct {
+	unsigned long long total;
+	unsigned long long free;
+	unsigned long long used;
+} nvmlMemory_t;
+
+typedef struct {
+	unsigned char clusterUuid[NVML_GPU_FABRIC_UUID_LEN];
+	nvmlReturn_t status;
+	unsigned int cliqueId;
+	unsigned char state;
+} nvmlGpuFabricInfo_t;
+
+typedef struct {
+	char busId[NVML_DEVICE_PCI_BUS_ID_BUFFER_SIZE];
+	unsigned int domain;
+	unsigned int bus;
+	unsigned int device;
+	unsigned int pciDeviceId;
+	unsigned int pciSubSystemId;
+	unsigned int reserved[4];
+} nvmlPciInfo_t;
+
+typedef struct {
+	unsigned int fieldId;
+	unsigned int scopeId;
+	long long timestamp;
+	long long latencyUsec;
+	int valueType;
+	nvmlReturn_t nvmlReturn;
+	union {
+		double dVal;
+		unsigned int uiVal;
+		unsigned long ulVal;
+		unsigned long long ullVal;
+		signed long long sllVal;
+	} value;
+} nvmlFieldValue_t;
+
+typedef struct {
+	unsigned int pid;
+	unsigned long long usedGpuMemory;
+} nvmlProcessInfo_t;
+
+typedef struct {
+	unsigned int gpuUtilization;
+	unsigned int memoryUtilization;
+	unsigned long long maxMemoryUsage;
+	unsigned long long time;
+	unsigned long long startTime;
+	unsigned int isRunning;
+	unsigned int reserved[5];
+} nvmlAccountingStats_t;
+
+// The library is loaded at runtime so that the beat starts on hosts
+// without the NVIDIA driver installed.
+static void *nvmlLib = NULL;
+
+static void *nvmlSym(const char *name) {
+	return nvmlLib == NULL ? NULL : dlsym(nvmlLib, name);
+}
+
+static nvmlReturn_t nvmlLoad(const char *path) {
+	nvmlReturn_t (*fn)(void);
+
+	if (nvmlLib == NULL) {
+		nvmlLib = dlopen(path, RTLD_LAZY | RTLD_GLOBAL);
+	}
+	if (nvmlLib == NULL) {
+		return NVML_ERROR_LIBRARY_NOT_FOUND;
+	}
+	fn = nvmlSym("nvmlInit_v2");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn();
+}
+
+static const char *nvmlErrorStringW(nvmlReturn_t ret) {
+	const char *(*fn)(nvmlReturn_t) = nvmlSym("nvmlErrorString");
+	if (fn == NULL) {
+		return NULL;
+	}
+	return fn(ret);
+}
+
+static nvmlReturn_t nvmlDeviceGetCountW(unsigned int *count) {
+	nvmlReturn_t (*fn)(unsigned int *) = nvmlSym("nvmlDeviceGetCount_v2");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(count);
+}
+
+static nvmlReturn_t nvmlDeviceGetHandleByIndexW(unsigned int index, nvmlDevice_t *device) {
+	nvmlReturn_t (*fn)(unsigned int, nvmlDevice_t *) = nvmlSym("nvmlDeviceGetHandleByIndex_v2");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(index, device);
+}
+
+static nvmlReturn_t nvmlDeviceGetUUIDW(nvmlDevice_t device, char *uuid, unsigned int length) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, char *, unsigned int) = nvmlSym("nvmlDeviceGetUUID");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, uuid, length);
+}
+
+static nvmlReturn_t nvmlDeviceGetNameW(nvmlDevice_t device, char *name, unsigned int length) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, char *, unsigned int) = nvmlSym("nvmlDeviceGetName");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, name, length);
+}
+
+static nvmlReturn_t nvmlDeviceGetPciInfoW(nvmlDevice_t device, nvmlPciInfo_t *pci) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, nvmlPciInfo_t *) = nvmlSym("nvmlDeviceGetPciInfo");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, pci);
+}
+
+static nvmlReturn_t nvmlDeviceGetMinorNumberW(nvmlDevice_t device, unsigned int *minor) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetMinorNumber");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, minor);
+}
+
+static nvmlReturn_t nvmlDeviceGetUtilizationRatesW(nvmlDevice_t device, nvmlUtilization_t *utilization) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, nvmlUtilization_t *) = nvmlSym("nvmlDeviceGetUtilizationRates");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, utilization);
+}
+
+static nvmlReturn_t nvmlDeviceGetMemoryInfoW(nvmlDevice_t device, nvmlMemory_t *memory) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, nvmlMemory_t *) = nvmlSym("nvmlDeviceGetMemoryInfo");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, memory);
+}
+
+static nvmlReturn_t nvmlDeviceGetTemperatureW(nvmlDevice_t device, unsigned int *temp) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int, unsigned int *) = nvmlSym("nvmlDeviceGetTemperature");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, NVML_TEMPERATURE_GPU, temp);
+}
+
+static nvmlReturn_t nvmlDeviceGetTemperatureThresholdW(nvmlDevice_t device, int threshold, unsigned int *temp) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int, unsigned int *) = nvmlSym("nvmlDeviceGetTemperatureThreshold");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, threshold, temp);
+}
+
+static nvmlReturn_t nvmlDeviceGetComputeModeW(nvmlDevice_t device, int *mode) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int *) = nvmlSym("nvmlDeviceGetComputeMode");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, mode);
+}
+
+static nvmlReturn_t nvmlDeviceGetPersistenceModeW(nvmlDevice_t device, int *mode) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int *) = nvmlSym("nvmlDeviceGetPersistenceMode");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, mode);
+}
+
+static nvmlReturn_t nvmlDeviceGetPowerUsageW(nvmlDevice_t device, unsigned int *power) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetPowerUsage");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, power);
+}
+
+static nvmlReturn_t nvmlDeviceGetTotalEnergyConsumptionW(nvmlDevice_t device, unsigned long long *energy) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned long long *) = nvmlSym("nvmlDeviceGetTotalEnergyConsumption");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, energy);
+}
+
+static nvmlReturn_t nvmlDeviceGetPcieReplayCounterW(nvmlDevice_t device, unsigned int *value) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetPcieReplayCounter");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, value);
+}
+
+static nvmlReturn_t nvmlDeviceGetCurrPcieLinkGenerationW(nvmlDevice_t device, unsigned int *gen) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetCurrPcieLinkGeneration");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, gen);
+}
+
+static nvmlReturn_t nvmlDeviceGetMaxPcieLinkGenerationW(nvmlDevice_t device, unsigned int *gen) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetMaxPcieLinkGeneration");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, gen);
+}
+
+static nvmlReturn_t nvmlDeviceGetCurrPcieLinkWidthW(nvmlDevice_t device, unsigned int *width) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetCurrPcieLinkWidth");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, width);
+}
+
+static nvmlReturn_t nvmlDeviceGetMaxPcieLinkWidthW(nvmlDevice_t device, unsigned int *width) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetMaxPcieLinkWidth");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, width);
+}
+
+static nvmlReturn_t nvmlDeviceGetPowerManagementLimitW(nvmlDevice_t device, unsigned int *limit) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetPowerManagementLimit");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, limit);
+}
+
+static nvmlReturn_t nvmlDeviceGetEnforcedPowerLimitW(nvmlDevice_t device, unsigned int *limit) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetEnforcedPowerLimit");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, limit);
+}
+
+static nvmlReturn_t nvmlDeviceGetPowerManagementLimitConstraintsW(nvmlDevice_t device, unsigned int *minLimit, unsigned int *maxLimit) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *, unsigned int *) = nvmlSym("nvmlDeviceGetPowerManagementLimitConstraints");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, minLimit, maxLimit);
+}
+
+static nvmlReturn_t nvmlDeviceGetSerialW(nvmlDevice_t device, char *serial, unsigned int length) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, char *, unsigned int) = nvmlSym("nvmlDeviceGetSerial");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, serial, length);
+}
+
+static nvmlReturn_t nvmlDeviceGetVbiosVersionW(nvmlDevice_t device, char *version, unsigned int length) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, char *, unsigned int) = nvmlSym("nvmlDeviceGetVbiosVersion");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, version, length);
+}
+
+static nvmlReturn_t nvmlDeviceGetBoardPartNumberW(nvmlDevice_t device, char *partNumber, unsigned int length) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, char *, unsigned int) = nvmlSym("nvmlDeviceGetBoardPartNumber");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, partNumber, length);
+}
+
+static nvmlReturn_t nvmlDeviceGetCurrentClocksThrottleReasonsW(nvmlDevice_t device, unsigned long long *reasons) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned long long *) = nvmlSym("nvmlDeviceGetCurrentClocksThrottleReasons");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, reasons);
+}
+
+static nvmlReturn_t nvmlDeviceGetTotalEccErrorsW(nvmlDevice_t device, int errorType, int counterType, unsigned long long *count) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int, int, unsigned long long *) = nvmlSym("nvmlDeviceGetTotalEccErrors");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, errorType, counterType, count);
+}
+
+static nvmlReturn_t nvmlDeviceGetFanSpeedW(nvmlDevice_t device, unsigned int *speed) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetFanSpeed");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, speed);
+}
+
+static nvmlReturn_t nvmlDeviceGetPerformanceStateW(nvmlDevice_t device, int *pstate) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int *) = nvmlSym("nvmlDeviceGetPerformanceState");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, pstate);
+}
+
+static nvmlReturn_t nvmlDeviceGetPcieThroughputW(nvmlDevice_t device, int counter, unsigned int *value) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int, unsigned int *) = nvmlSym("nvmlDeviceGetPcieThroughput");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, counter, value);
+}
+
+static nvmlReturn_t nvmlDeviceGetEncoderUtilizationW(nvmlDevice_t device, unsigned int *utilization, unsigned int *samplingPeriodUs) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *, unsigned int *) = nvmlSym("nvmlDeviceGetEncoderUtilization");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, utilization, samplingPeriodUs);
+}
+
+static nvmlReturn_t nvmlDeviceGetDecoderUtilizationW(nvmlDevice_t device, unsigned int *utilization, unsigned int *samplingPeriodUs) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *, unsigned int *) = nvmlSym("nvmlDeviceGetDecoderUtilization");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, utilization, samplingPeriodUs);
+}
+
+static nvmlReturn_t nvmlDeviceGetEncoderStatsW(nvmlDevice_t device, unsigned int *sessionCount, unsigned int *averageFps, unsigned int *averageLatency) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *, unsigned int *, unsigned int *) = nvmlSym("nvmlDeviceGetEncoderStats");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, sessionCount, averageFps, averageLatency);
+}
+
+static nvmlReturn_t nvmlDeviceGetRetiredPagesW(nvmlDevice_t device, int cause, unsigned int *pageCount) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int, unsigned int *, unsigned long long *) = nvmlSym("nvmlDeviceGetRetiredPages");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	// Only the page count is needed, a zero sized buffer makes NVML report
+	// it without the addresses.
+	*pageCount = 0;
+	nvmlReturn_t ret = fn(device, cause, pageCount, NULL);
+	return ret == NVML_ERROR_INSUFFICIENT_SIZE ? NVML_SUCCESS : ret;
+}
+
+static nvmlReturn_t nvmlDeviceGetRemappedRowsW(nvmlDevice_t device, unsigned int *corrRows, unsigned int *uncRows, unsigned int *isPending, unsigned int *failureOccurred) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *, unsigned int *, unsigned int *, unsigned int *) = nvmlSym("nvmlDeviceGetRemappedRows");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, corrRows, uncRows, isPending, failureOccurred);
+}
+
+static nvmlReturn_t nvmlDeviceGetGpuFabricInfoW(nvmlDevice_t device, nvmlGpuFabricInfo_t *info) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, nvmlGpuFabricInfo_t *) = nvmlSym("nvmlDeviceGetGpuFabricInfo");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, info);
+}
+
+static nvmlReturn_t nvmlDeviceGetRetiredPagesPendingStatusW(nvmlDevice_t device, int *isPending) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int *) = nvmlSym("nvmlDeviceGetRetiredPagesPendingStatus");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, isPending);
+}
+
+static nvmlReturn_t nvmlDeviceGetIndexW(nvmlDevice_t device, unsigned int *index) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetIndex");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, index);
+}
+
+static nvmlReturn_t nvmlEventSetCreateW(nvmlEventSet_t *set) {
+	nvmlReturn_t (*fn)(nvmlEventSet_t *) = nvmlSym("nvmlEventSetCreate");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(set);
+}
+
+static nvmlReturn_t nvmlDeviceRegisterEventsW(nvmlDevice_t device, unsigned long long eventTypes, nvmlEventSet_t set) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned long long, nvmlEventSet_t) = nvmlSym("nvmlDeviceRegisterEvents");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, eventTypes, set);
+}
+
+static nvmlReturn_t nvmlEventSetWaitW(nvmlEventSet_t set, nvmlEventData_t *data, unsigned int timeoutms) {
+	nvmlReturn_t (*fn)(nvmlEventSet_t, nvmlEventData_t *, unsigned int) = nvmlSym("nvmlEventSetWait");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(set, data, timeoutms);
+}
+
+static nvmlReturn_t nvmlSystemGetDriverVersionW(char *version, unsigned int length) {
+	nvmlReturn_t (*fn)(char *, unsigned int) = nvmlSym("nvmlSystemGetDriverVersion");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(version, length);
+}
+
+static nvmlReturn_t nvmlSystemGetCudaDriverVersionW(int *version) {
+	nvmlReturn_t (*fn)(int *) = nvmlSym("nvmlSystemGetCudaDriverVersion");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(version);
+}
+
+static nvmlReturn_t nvmlDeviceGetMigModeW(nvmlDevice_t device, unsigned int *currentMode, unsigned int *pendingMode) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *, unsigned int *) = nvmlSym("nvmlDeviceGetMigMode");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, currentMode, pendingMode);
+}
+
+static nvmlReturn_t nvmlDeviceGetMaxMigDeviceCountW(nvmlDevice_t device, unsigned int *count) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetMaxMigDeviceCount");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, count);
+}
+
+static nvmlReturn_t nvmlDeviceGetMigDeviceHandleByIndexW(nvmlDevice_t device, unsigned int index, nvmlDevice_t *migDevice) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int, nvmlDevice_t *) = nvmlSym("nvmlDeviceGetMigDeviceHandleByIndex");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, index, migDevice);
+}
+
+static nvmlReturn_t nvmlDeviceGetGpuInstanceIdW(nvmlDevice_t device, unsigned int *id) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetGpuInstanceId");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, id);
+}
+
+static nvmlReturn_t nvmlDeviceGetComputeInstanceIdW(nvmlDevice_t device, unsigned int *id) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *) = nvmlSym("nvmlDeviceGetComputeInstanceId");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, id);
+}
+
+static nvmlReturn_t nvmlDeviceGetNvLinkStateW(nvmlDevice_t device, unsigned int link, int *isActive) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int, int *) = nvmlSym("nvmlDeviceGetNvLinkState");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, link, isActive);
+}
+
+static nvmlReturn_t nvmlDeviceGetNvLinkErrorCounterW(nvmlDevice_t device, unsigned int link, int counter, unsigned long long *value) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int, int, unsigned long long *) = nvmlSym("nvmlDeviceGetNvLinkErrorCounter");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, link, counter, value);
+}
+
+// nvmlDeviceGetMemoryTemperatureW reads the temperature of the HBM in degrees
+// Celsius.
+static nvmlReturn_t nvmlDeviceGetMemoryTemperatureW(nvmlDevice_t device, unsigned int *temp) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int, nvmlFieldValue_t *) = nvmlSym("nvmlDeviceGetFieldValues");
+	nvmlFieldValue_t value = {0};
+	nvmlReturn_t ret;
+
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	value.fieldId = NVML_FI_DEV_MEMORY_TEMP;
+	ret = fn(device, 1, &value);
+	if (ret != NVML_SUCCESS) {
+		return ret;
+	}
+	if (value.nvmlReturn != NVML_SUCCESS) {
+		return value.nvmlReturn;
+	}
+	*temp = value.value.uiVal;
+	return NVML_SUCCESS;
+}
+
+// nvmlDeviceGetNvLinkThroughputW reads the data counters of a link in KiB.
+static nvmlReturn_t nvmlDeviceGetNvLinkThroughputW(nvmlDevice_t device, unsigned int link, unsigned long long *tx, unsigned long long *rx) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, int, nvmlFieldValue_t *) = nvmlSym("nvmlDeviceGetFieldValues");
+	nvmlFieldValue_t values[2] = {{0}};
+	nvmlReturn_t ret;
+
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	values[0].fieldId = NVML_FI_DEV_NVLINK_THROUGHPUT_DATA_TX;
+	values[0].scopeId = link;
+	values[1].fieldId = NVML_FI_DEV_NVLINK_THROUGHPUT_DATA_RX;
+	values[1].scopeId = link;
+	ret = fn(device, 2, values);
+	if (ret != NVML_SUCCESS) {
+		return ret;
+	}
+	if (values[0].nvmlReturn != NVML_SUCCESS) {
+		return values[0].nvmlReturn;
+	}
+	if (values[1].nvmlReturn != NVML_SUCCESS) {
+		return values[1].nvmlReturn;
+	}
+	*tx = values[0].value.ullVal;
+	*rx = values[1].value.ullVal;
+	return NVML_SUCCESS;
+}
+
+static nvmlReturn_t nvmlDeviceGetComputeRunningProcessesW(nvmlDevice_t device, unsigned int *count, nvmlProcessInfo_t *infos) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *, nvmlProcessInfo_t *) = nvmlSym("nvmlDeviceGetComputeRunningProcesses");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, count, infos);
+}
+
+static nvmlReturn_t nvmlDeviceGetAccountingPidsW(nvmlDevice_t device, unsigned int *count, unsigned int *pids) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int *, unsigned int *) = nvmlSym("nvmlDeviceGetAccountingPids");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, count, pids);
+}
+
+static nvmlReturn_t nvmlDeviceGetAccountingStatsW(nvmlDevice_t device, unsigned int pid, nvmlAccountingStats_t *stats) {
+	nvmlReturn_t (*fn)(nvmlDevice_t, unsigned int, nvmlAccountingStats_t *) = nvmlSym("nvmlDeviceGetAccountingStats");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(device, pid, stats);
+}
+
+static nvmlReturn_t nvmlSystemGetProcessNameW(unsigned int pid, char *name, unsigned int length) {
+	nvmlReturn_t (*fn)(unsigned int, char *, unsigned int) = nvmlSym("nvmlSystemGetProcessName");
+	if (fn == NULL) {
+		return NVML_ERROR_FUNCTION_NOT_FOUND;
+	}
+	return fn(pid, name, length);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+func init() {
+	if err := AddCollector(GPUSourceNVML, NVIDIABackend, newNVMLCollector); err != nil {
+		panic(err)
+	}
+}
+
+// The initialization of the library is retried on the queries after it
+// failed, like when the beat starts before the driver is loaded, waiting twice
+// as long after every failure up to nvmlMaxRetryDelay.
+const (
+	nvmlMinRetryDelay = 10 * time.Second
+	nvmlMaxRetryDelay = 5 * time.Minute
+)
+
+var (
+	nvmlInitMu     sync.Mutex
+	nvmlReady      bool
+	nvmlInitErr    error
+	nvmlRetryDelay time.Duration
+	nvmlRetryAt    time.Time
+)
+
+func nvmlError(ret C.nvmlReturn_t) error {
+	if ret == C.NVML_SUCCESS {
+		return nil
+	}
+	if ret == C.NVML_ERROR_LIBRARY_NOT_FOUND {
+		return fmt.Errorf("nvml: libnvidia-ml.so.1 not found")
+	}
+	if msg := C.nvmlErrorStringW(ret); msg != nil {
+		return fmt.Errorf("nvml: %s", C.GoString(msg))
+	}
+	return fmt.Errorf("nvml: error code %d", int(ret))
+}
+
+// nvmlOptional is like nvmlError but ignores errors of queries the GPU does
+// not support.
+func nvmlOptional(ret C.nvmlReturn_t) error {
+	if ret == C.NVML_ERROR_NOT_SUPPORTED {
+		return nil
+	}
+	return nvmlError(ret)
+}
+
+// nvmlCollector reads the GPU status through the NVML library. The library is
+// loaded and initialized on first use, again on later uses until it succeeds,
+// and kept open afterwards.
+type nvmlCollector struct {
+	// libraries are the paths the library is loaded from, the first one
+	// found is used.
+	libraries []string
+
+	// eventSet receives the XID errors of all GPUs once XIDEvents has been
+	// called.
+	eventSet C.nvmlEventSet_t
+
+	// devices caches the static properties of the GPUs between queries.
+	devices *DeviceCache
+}
+
+func newNVMLCollector(config Config) (GPUCollector, error) {
+	libraries := append([]string{"libnvidia-ml.so.1"}, hostLibraries(config.HostFS, "libnvidia-ml.so.1")...)
+	return &nvmlCollector{
+		libraries: libraries,
+		devices:   NewDeviceCache(config.DeviceCacheTTL),
+	}, nil
+}
+
+// nvmlComputeModes names the compute modes as nvidia-smi does.
+var nvmlComputeModes = map[C.int]string{
+	C.NVML_COMPUTEMODE_DEFAULT:           "Default",
+	C.NVML_COMPUTEMODE_EXCLUSIVE_THREAD:  "Exclusive_Thread",
+	C.NVML_COMPUTEMODE_PROHIBITED:        "Prohibited",
+	C.NVML_COMPUTEMODE_EXCLUSIVE_PROCESS: "Exclusive_Process",
+}
+
+func (c *nvmlCollector) init() error {
+	nvmlInitMu.Lock()
+	defer nvmlInitMu.Unlock()
+	if nvmlReady {
+		return nil
+	}
+	if time.Now().Before(nvmlRetryAt) {
+		return nvmlInitErr
+	}
+
+	ret := C.nvmlReturn_t(C.NVML_ERROR_LIBRARY_NOT_FOUND)
+	for _, library := range c.libraries {
+		path := C.CString(library)
+		ret = C.nvmlLoad(path)
+		C.free(unsafe.Pointer(path))
+		if ret != C.NVML_ERROR_LIBRARY_NOT_FOUND {
+			break
+		}
+	}
+	if nvmlInitErr = nvmlError(ret); nvmlInitErr == nil {
+		nvmlReady = true
+		return nil
+	}
+
+	switch {
+	case nvmlRetryDelay == 0:
+		nvmlRetryDelay = nvmlMinRetryDelay
+	case nvmlRetryDelay < nvmlMaxRetryDelay:
+		nvmlRetryDelay *= 2
+		if nvmlRetryDelay > nvmlMaxRetryDelay {
+			nvmlRetryDelay = nvmlMaxRetryDelay
+		}
+	}
+	nvmlRetryAt = time.Now().Add(nvmlRetryDelay)
+	logp.Debug("nvidiadocker", "Cannot initialize NVML, retrying in %v: %v", nvmlRetryDelay, nvmlInitErr)
+	return nvmlInitErr
+}
+
+func (c *nvmlCollector) List() ([]uint, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	var count C.uint
+	if err := nvmlError(C.nvmlDeviceGetCountW(&count)); err != nil {
+		return nil, err
+	}
+	return indexList(int(count)), nil
+}
+
+// Query reads the GPUs at the given indices, all GPUs if indices is nil. The
+// static properties of the GPUs are cached, the cache is invalidated if a
+// query fails as the GPU may have fallen off the bus.
+func (c *nvmlCollector) Query(indices []uint) ([]DeviceStatus, error) {
+	start := time.Now()
+	devices, err := c.query(indices)
+	observeGPUSourceCall(start, err)
+	if err != nil {
+		c.devices.Invalidate()
+	}
+	return devices, err
+}
+
+func (c *nvmlCollector) query(indices []uint) ([]DeviceStatus, error) {
+	all, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	if indices == nil {
+		indices = all
+	}
+
+	devices := make([]DeviceStatus, 0, len(indices))
+	for _, i := range indices {
+		var device C.nvmlDevice_t
+		if err := nvmlError(C.nvmlDeviceGetHandleByIndexW(C.uint(i), &device)); err != nil {
+			return nil, err
+		}
+
+		properties, ok := c.devices.Get(i, len(all))
+		if !ok {
+			if properties, err = nvmlDeviceProperties(device); err != nil {
+				return nil, err
+			}
+			c.devices.Put(i, properties)
+		}
+
+		// NVML reads the values of the GPU on every call.
+		timestamp := time.Now()
+
+		// vGPUs and older GPUs do not report the utilization or the
+		// temperature.
+		var unsupported []string
+		var utilization C.nvmlUtilization_t
+		ret := C.nvmlDeviceGetUtilizationRatesW(device, &utilization)
+		if ret == C.NVML_ERROR_NOT_SUPPORTED {
+			unsupported = append(unsupported, "utilization.gpu", "utilization.memory")
+		} else if err := nvmlError(ret); err != nil {
+			return nil, err
+		}
+
+		var memory C.nvmlMemory_t
+		if err := nvmlError(C.nvmlDeviceGetMemoryInfoW(device, &memory)); err != nil {
+			return nil, err
+		}
+
+		var temperature C.uint
+		ret = C.nvmlDeviceGetTemperatureW(device, &temperature)
+		if ret == C.NVML_ERROR_NOT_SUPPORTED {
+			unsupported = append(unsupported, "temperature.gpu")
+		} else if err := nvmlError(ret); err != nil {
+			return nil, err
+		}
+
+		// Only GPUs with HBM report their memory temperature, older drivers
+		// do not know the field.
+		var memoryTemperature *uint
+		var memoryTemp C.uint
+		if C.nvmlDeviceGetMemoryTemperatureW(device, &memoryTemp) == C.NVML_SUCCESS {
+			memoryTemperature = toUintP(uint(memoryTemp))
+		}
+
+		// Power management is not supported by every GPU, the values are
+		// left at zero then.
+		var power, powerLimit, powerEnforcedLimit C.uint
+		if err := nvmlOptional(C.nvmlDeviceGetPowerUsageW(device, &power)); err != nil {
+			return nil, err
+		}
+		if err := nvmlOptional(C.nvmlDeviceGetPowerManagementLimitW(device, &powerLimit)); err != nil {
+			return nil, err
+		}
+		if err := nvmlOptional(C.nvmlDeviceGetEnforcedPowerLimitW(device, &powerEnforcedLimit)); err != nil {
+			return nil, err
+		}
+		// The energy counter is only supported from Volta on.
+		var energy C.ulonglong
+		if err := nvmlOptional(C.nvmlDeviceGetTotalEnergyConsumptionW(device, &energy)); err != nil {
+			return nil, err
+		}
+
+		// Passively cooled GPUs have no fan.
+		var fanSpeed C.uint
+		if err := nvmlOptional(C.nvmlDeviceGetFanSpeedW(device, &fanSpeed)); err != nil {
+			return nil, err
+		}
+
+		pstate := C.int(C.NVML_PSTATE_UNKNOWN)
+		if err := nvmlOptional(C.nvmlDeviceGetPerformanceStateW(device, &pstate)); err != nil {
+			return nil, err
+		}
+		performanceState := ""
+		if pstate != C.NVML_PSTATE_UNKNOWN {
+			performanceState = fmt.Sprintf("P%d", int(pstate))
+		}
+
+		computeMode := C.int(-1)
+		if err := nvmlOptional(C.nvmlDeviceGetComputeModeW(device, &computeMode)); err != nil {
+			return nil, err
+		}
+		// Persistence mode is only supported on Linux.
+		var persistenceMode *bool
+		var persistence C.int
+		ret = C.nvmlDeviceGetPersistenceModeW(device, &persistence)
+		if ret == C.NVML_SUCCESS {
+			persistenceMode = toBoolP(persistence != C.NVML_FEATURE_DISABLED)
+		} else if err := nvmlOptional(ret); err != nil {
+			return nil, err
+		}
+
+		// Video encoding and decoding is not supported by every GPU.
+		var encoder, decoder, samplingPeriod C.uint
+		if err := nvmlOptional(C.nvmlDeviceGetEncoderUtilizationW(device, &encoder, &samplingPeriod)); err != nil {
+			return nil, err
+		}
+		if err := nvmlOptional(C.nvmlDeviceGetDecoderUtilizationW(device, &decoder, &samplingPeriod)); err != nil {
+			return nil, err
+		}
+		var encoderSessions, encoderFPS, encoderLatency C.uint
+		ret = C.nvmlDeviceGetEncoderStatsW(device, &encoderSessions, &encoderFPS, &encoderLatency)
+		if ret == C.NVML_ERROR_NOT_SUPPORTED {
+			unsupported = append(unsupported, "encoder.stats.sessionCount", "encoder.stats.averageFps", "encoder.stats.averageLatency")
+		} else if err := nvmlError(ret); err != nil {
+			return nil, err
+		}
+
+		// The PCIe throughput is reported in KB/s, sampled over 20ms.
+		var pcieRX, pcieTX C.uint
+		if err := nvmlOptional(C.nvmlDeviceGetPcieThroughputW(device, C.NVML_PCIE_UTIL_RX_BYTES, &pcieRX)); err != nil {
+			return nil, err
+		}
+		if err := nvmlOptional(C.nvmlDeviceGetPcieThroughputW(device, C.NVML_PCIE_UTIL_TX_BYTES, &pcieTX)); err != nil {
+			return nil, err
+		}
+		var pcieReplays C.uint
+		if err := nvmlOptional(C.nvmlDeviceGetPcieReplayCounterW(device, &pcieReplays)); err != nil {
+			return nil, err
+		}
+		var pcieGen, pcieWidth C.uint
+		if err := nvmlOptional(C.nvmlDeviceGetCurrPcieLinkGenerationW(device, &pcieGen)); err != nil {
+			return nil, err
+		}
+		if err := nvmlOptional(C.nvmlDeviceGetCurrPcieLinkWidthW(device, &pcieWidth)); err != nil {
+			return nil, err
+		}
+
+		// ECC counters are only supported when ECC is enabled.
+		var eccCounters [4]C.ulonglong
+		for i, counter := range []struct {
+			errorType, counterType C.int
+		}{
+			{C.NVML_MEMORY_ERROR_TYPE_CORRECTED, C.NVML_VOLATILE_ECC},
+			{C.NVML_MEMORY_ERROR_TYPE_UNCORRECTED, C.NVML_VOLATILE_ECC},
+			{C.NVML_MEMORY_ERROR_TYPE_CORRECTED, C.NVML_AGGREGATE_ECC},
+			{C.NVML_MEMORY_ERROR_TYPE_UNCORRECTED, C.NVML_AGGREGATE_ECC},
+		} {
+			if err := nvmlOptional(C.nvmlDeviceGetTotalEccErrorsW(device, counter.errorType, counter.counterType, &eccCounters[i])); err != nil {
+				return nil, err
+			}
+		}
+
+		var throttleReasons C.ulonglong
+		if err := nvmlOptional(C.nvmlDeviceGetCurrentClocksThrottleReasonsW(device, &throttleReasons)); err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, DeviceStatus{
+			Timestamp:             timestamp,
+			Index:                 toUintP(i),
+			MinorNumber:           properties.MinorNumber,
+			UUID:                  properties.UUID,
+			Name:                  properties.Name,
+			Temperature:           uint(temperature),
+			MemoryTemperature:     memoryTemperature,
+			TemperatureThresholds: properties.TemperatureThresholds,
+			Unsupported:           unsupported,
+			FanSpeed:              uint(fanSpeed),
+			PerformanceState:      performanceState,
+			ComputeMode:           nvmlComputeModes[computeMode],
+			PersistenceMode:       persistenceMode,
+			Power:                 float64(power) / 1000,
+			PowerLimit:            float64(powerLimit) / 1000,
+			PowerEnforcedLimit:    float64(powerEnforcedLimit) / 1000,
+			Energy:                uint64(energy),
+			PCI: PCIStatusInfo{
+				BusID: properties.BusID,
+				Throughput: PCIThroughputInfo{
+					RX: uint(pcieRX) / 1024,
+					TX: uint(pcieTX) / 1024,
+				},
+				Replays: uint64(pcieReplays),
+				Link: PCILinkInfo{
+					Gen:   uint(pcieGen),
+					Width: uint(pcieWidth),
+				},
+				MaxLink: properties.MaxPCILink,
+			},
+			ECC: ECCInfo{
+				Volatile: ECCErrorCounts{
+					SingleBit: uint64(eccCounters[0]),
+					DoubleBit: uint64(eccCounters[1]),
+				},
+				Aggregate: ECCErrorCounts{
+					SingleBit: uint64(eccCounters[2]),
+					DoubleBit: uint64(eccCounters[3]),
+				},
+			},
+			ThrottleReasons: ThrottleReasonsInfo{
+				GPUIdle:                   throttleReasons&C.nvmlClocksThrottleReasonGpuIdle != 0,
+				ApplicationsClocksSetting: throttleReasons&C.nvmlClocksThrottleReasonApplicationsClocksSetting != 0,
+				SWPowerCap:                throttleReasons&C.nvmlClocksThrottleReasonSwPowerCap != 0,
+				HWSlowdown:                throttleReasons&C.nvmlClocksThrottleReasonHwSlowdown != 0,
+				HWThermalSlowdown:         throttleReasons&C.nvmlClocksThrottleReasonHwThermalSlowdown != 0,
+				HWPowerBrakeSlowdown:      throttleReasons&C.nvmlClocksThrottleReasonHwPowerBrakeSlowdown != 0,
+				SWThermalSlowdown:         throttleReasons&C.nvmlClocksThrottleReasonSwThermalSlowdown != 0,
+				SyncBoost:                 throttleReasons&C.nvmlClocksThrottleReasonSyncBoost != 0,
+			},
+			Utilization: UtilizationInfo{
+				GPU:     uint(utilization.gpu),
+				Memory:  uint(utilization.memory),
+				Encoder: uint(encoder),
+				Decoder: uint(decoder),
+			},
+			EncoderStats: EncoderStatsInfo{
+				SessionCount:   uint(encoderSessions),
+				AverageFPS:     uint(encoderFPS),
+				AverageLatency: uint(encoderLatency),
+			},
+			Memory: MemoryInfo{
+				GlobalUsed:  uint64(memory.used) / MiB,
+				GlobalTotal: uint64(memory.total) / MiB,
+				GlobalFree:  uint64(memory.free) / MiB,
+			},
+		})
+	}
+	return devices, nil
+}
+
+// nvmlDeviceProperties reads the static properties of the GPU.
+func nvmlDeviceProperties(device C.nvmlDevice_t) (DeviceProperties, error) {
+	var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+	if err := nvmlError(C.nvmlDeviceGetUUIDW(device, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE)); err != nil {
+		return DeviceProperties{}, err
+	}
+
+	var name [C.NVML_DEVICE_NAME_BUFFER_SIZE]C.char
+	if err := nvmlError(C.nvmlDeviceGetNameW(device, &name[0], C.NVML_DEVICE_NAME_BUFFER_SIZE)); err != nil {
+		return DeviceProperties{}, err
+	}
+
+	var pci C.nvmlPciInfo_t
+	if err := nvmlError(C.nvmlDeviceGetPciInfoW(device, &pci)); err != nil {
+		return DeviceProperties{}, err
+	}
+
+	var slowdownTemperature, shutdownTemperature C.uint
+	if err := nvmlOptional(C.nvmlDeviceGetTemperatureThresholdW(device, C.NVML_TEMPERATURE_THRESHOLD_SLOWDOWN, &slowdownTemperature)); err != nil {
+		return DeviceProperties{}, err
+	}
+	if err := nvmlOptional(C.nvmlDeviceGetTemperatureThresholdW(device, C.NVML_TEMPERATURE_THRESHOLD_SHUTDOWN, &shutdownTemperature)); err != nil {
+		return DeviceProperties{}, err
+	}
+
+	var minorNumber *uint
+	var minor C.uint
+	if C.nvmlDeviceGetMinorNumberW(device, &minor) == C.NVML_SUCCESS {
+		minorNumber = toUintP(uint(minor))
+	}
+
+	var maxPCIeGen, maxPCIeWidth C.uint
+	if err := nvmlOptional(C.nvmlDeviceGetMaxPcieLinkGenerationW(device, &maxPCIeGen)); err != nil {
+		return DeviceProperties{}, err
+	}
+	if err := nvmlOptional(C.nvmlDeviceGetMaxPcieLinkWidthW(device, &maxPCIeWidth)); err != nil {
+		return DeviceProperties{}, err
+	}
+
+	return DeviceProperties{
+		UUID:        C.GoString(&uuid[0]),
+		Name:        C.GoString(&name[0]),
+		BusID:       C.GoString(&pci.busId[0]),
+		MinorNumber: minorNumber,
+		TemperatureThresholds: TemperatureThresholds{
+			Slowdown: uint(slowdownTemperature),
+			Shutdown: uint(shutdownTemperature),
+		},
+		MaxPCILink: PCILinkInfo{
+			Gen:   uint(maxPCIeGen),
+			Width: uint(maxPCIeWidth),
+		},
+	}, nil
+}
+
+func (c *nvmlCollector) Processes() ([]ProcessInfo, error) {
+	indices, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []ProcessInfo
+	for _, i := range indices {
+		var device C.nvmlDevice_t
+		if err := nvmlError(C.nvmlDeviceGetHandleByIndexW(C.uint(i), &device)); err != nil {
+			return nil, err
+		}
+
+		var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+		if err := nvmlError(C.nvmlDeviceGetUUIDW(device, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+
+		// The process count can change between calls, grow the buffer until
+		// every process fits.
+		infos := make([]C.nvmlProcessInfo_t, 32)
+		count := C.uint(len(infos))
+		ret := C.nvmlDeviceGetComputeRunningProcessesW(device, &count, &infos[0])
+		for ret == C.NVML_ERROR_INSUFFICIENT_SIZE {
+			infos = make([]C.nvmlProcessInfo_t, int(count)*2)
+			count = C.uint(len(infos))
+			ret = C.nvmlDeviceGetComputeRunningProcessesW(device, &count, &infos[0])
+		}
+		if err := nvmlError(ret); err != nil {
+			return nil, err
+		}
+
+		for _, info := range infos[:int(count)] {
+			var name [256]C.char
+			processName := ""
+			if C.nvmlSystemGetProcessNameW(info.pid, &name[0], C.uint(len(name))) == C.NVML_SUCCESS {
+				processName = C.GoString(&name[0])
+			}
+
+			processes = append(processes, ProcessInfo{
+				PID:        uint(info.pid),
+				Name:       processName,
+				MemoryUsed: uint64(info.usedGpuMemory) / MiB,
+				GPUUUID:    C.GoString(&uuid[0]),
+				GPUIndex:   toUintP(i),
+			})
+		}
+	}
+	return processes, nil
+}
+
+func (c *nvmlCollector) AccountedProcesses() ([]AccountedProcess, error) {
+	indices, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []AccountedProcess
+	for _, i := range indices {
+		var device C.nvmlDevice_t
+		if err := nvmlError(C.nvmlDeviceGetHandleByIndexW(C.uint(i), &device)); err != nil {
+			return nil, err
+		}
+
+		var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+		if err := nvmlError(C.nvmlDeviceGetUUIDW(device, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+
+		// GPUs with accounting mode disabled are not supported.
+		pids := make([]C.uint, 256)
+		count := C.uint(len(pids))
+		ret := C.nvmlDeviceGetAccountingPidsW(device, &count, &pids[0])
+		for ret == C.NVML_ERROR_INSUFFICIENT_SIZE {
+			pids = make([]C.uint, int(count)*2)
+			count = C.uint(len(pids))
+			ret = C.nvmlDeviceGetAccountingPidsW(device, &count, &pids[0])
+		}
+		if ret == C.NVML_ERROR_NOT_SUPPORTED {
+			continue
+		}
+		if err := nvmlError(ret); err != nil {
+			return nil, err
+		}
+
+		for _, pid := range pids[:int(count)] {
+			// The stats of a pid can be dropped from the buffer in between.
+			var stats C.nvmlAccountingStats_t
+			ret := C.nvmlDeviceGetAccountingStatsW(device, pid, &stats)
+			if ret == C.NVML_ERROR_NOT_FOUND {
+				continue
+			}
+			if err := nvmlError(ret); err != nil {
+				return nil, err
+			}
+
+			processes = append(processes, AccountedProcess{
+				PID:      uint(pid),
+				GPUUUID:  C.GoString(&uuid[0]),
+				GPUIndex: toUintP(i),
+				Utilization: UtilizationInfo{
+					GPU:    uint(stats.gpuUtilization),
+					Memory: uint(stats.memoryUtilization),
+				},
+				MaxMemoryUsed: uint64(stats.maxMemoryUsage) / MiB,
+				Time:          uint64(stats.time),
+				Running:       stats.isRunning != 0,
+			})
+		}
+	}
+	return processes, nil
+}
+
+func (c *nvmlCollector) Health() ([]DeviceHealth, error) {
+	indices, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]DeviceHealth, 0, len(indices))
+	for _, i := range indices {
+		var device C.nvmlDevice_t
+		if err := nvmlError(C.nvmlDeviceGetHandleByIndexW(C.uint(i), &device)); err != nil {
+			return nil, err
+		}
+
+		var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+		if err := nvmlError(C.nvmlDeviceGetUUIDW(device, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+
+		// Page retirement is only supported by data center GPUs.
+		var singleBit, doubleBit C.uint
+		if err := nvmlOptional(C.nvmlDeviceGetRetiredPagesW(device, C.NVML_PAGE_RETIREMENT_CAUSE_MULTIPLE_SINGLE_BIT_ECC_ERRORS, &singleBit)); err != nil {
+			return nil, err
+		}
+		if err := nvmlOptional(C.nvmlDeviceGetRetiredPagesW(device, C.NVML_PAGE_RETIREMENT_CAUSE_DOUBLE_BIT_ECC_ERROR, &doubleBit)); err != nil {
+			return nil, err
+		}
+		var pending C.int
+		if err := nvmlOptional(C.nvmlDeviceGetRetiredPagesPendingStatusW(device, &pending)); err != nil {
+			return nil, err
+		}
+
+		// Row remapping replaces page retirement from Ampere on, and is not
+		// known to drivers before R450.
+		var remappedRows *RemappedRowsInfo
+		var correctableRows, uncorrectableRows, rowsPending, rowsFailure C.uint
+		if C.nvmlDeviceGetRemappedRowsW(device, &correctableRows, &uncorrectableRows, &rowsPending, &rowsFailure) == C.NVML_SUCCESS {
+			remappedRows = &RemappedRowsInfo{
+				Correctable:   uint64(correctableRows),
+				Uncorrectable: uint64(uncorrectableRows),
+				Pending:       rowsPending != 0,
+				Failure:       rowsFailure != 0,
+			}
+		}
+
+		devices = append(devices, DeviceHealth{
+			Index: toUintP(i),
+			UUID:  C.GoString(&uuid[0]),
+			RetiredPages: RetiredPagesInfo{
+				SingleBit: uint64(singleBit),
+				DoubleBit: uint64(doubleBit),
+				Pending:   pending == C.NVML_FEATURE_ENABLED,
+			},
+			RemappedRows: remappedRows,
+		})
+	}
+	return devices, nil
+}
+
+// nvmlFabricStates names the fabric states of the GPUs.
+var nvmlFabricStates = map[C.uchar]string{
+	C.NVML_GPU_FABRIC_STATE_NOT_STARTED: "not_started",
+	C.NVML_GPU_FABRIC_STATE_IN_PROGRESS: "in_progress",
+	C.NVML_GPU_FABRIC_STATE_COMPLETED:   "completed",
+}
+
+func (c *nvmlCollector) GPUFabric() ([]GPUFabric, error) {
+	indices, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var fabric []GPUFabric
+	for _, i := range indices {
+		var device C.nvmlDevice_t
+		if err := nvmlError(C.nvmlDeviceGetHandleByIndexW(C.uint(i), &device)); err != nil {
+			return nil, err
+		}
+
+		// The fabric state is only known to drivers from R525 on, and only
+		// supported by the GPUs attached to NVSwitches.
+		var info C.nvmlGpuFabricInfo_t
+		if C.nvmlDeviceGetGpuFabricInfoW(device, &info) != C.NVML_SUCCESS {
+			continue
+		}
+		state, found := nvmlFabricStates[info.state]
+		if !found {
+			continue
+		}
+
+		var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+		if err := nvmlError(C.nvmlDeviceGetUUIDW(device, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+		var pci C.nvmlPciInfo_t
+		if err := nvmlError(C.nvmlDeviceGetPciInfoW(device, &pci)); err != nil {
+			return nil, err
+		}
+
+		gpu := GPUFabric{
+			Index: toUintP(i),
+			UUID:  C.GoString(&uuid[0]),
+			BusID: C.GoString(&pci.busId[0]),
+			State: state,
+		}
+		if info.state == C.NVML_GPU_FABRIC_STATE_COMPLETED {
+			gpu.Status = "success"
+			if info.status != C.NVML_SUCCESS {
+				gpu.Status = fmt.Sprintf("error_%d", int(info.status))
+				if msg := C.nvmlErrorStringW(info.status); msg != nil {
+					gpu.Status = normalizeFabricValue(C.GoString(msg))
+				}
+			}
+		}
+		fabric = append(fabric, gpu)
+	}
+	return fabric, nil
+}
+
+func (c *nvmlCollector) Inventory() ([]DeviceInventory, error) {
+	indices, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]DeviceInventory, 0, len(indices))
+	for _, i := range indices {
+		var device C.nvmlDevice_t
+		if err := nvmlError(C.nvmlDeviceGetHandleByIndexW(C.uint(i), &device)); err != nil {
+			return nil, err
+		}
+
+		var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+		if err := nvmlError(C.nvmlDeviceGetUUIDW(device, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+
+		var name [C.NVML_DEVICE_NAME_BUFFER_SIZE]C.char
+		if err := nvmlError(C.nvmlDeviceGetNameW(device, &name[0], C.NVML_DEVICE_NAME_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+
+		var pci C.nvmlPciInfo_t
+		if err := nvmlError(C.nvmlDeviceGetPciInfoW(device, &pci)); err != nil {
+			return nil, err
+		}
+
+		var memory C.nvmlMemory_t
+		if err := nvmlError(C.nvmlDeviceGetMemoryInfoW(device, &memory)); err != nil {
+			return nil, err
+		}
+
+		var vbios [C.NVML_DEVICE_VBIOS_VERSION_BUFFER_SIZE]C.char
+		if err := nvmlError(C.nvmlDeviceGetVbiosVersionW(device, &vbios[0], C.NVML_DEVICE_VBIOS_VERSION_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+
+		// The serial and board part numbers are only supported by data center
+		// GPUs, and the power limit only by GPUs with power management.
+		var serial [C.NVML_DEVICE_SERIAL_BUFFER_SIZE]C.char
+		if err := nvmlOptional(C.nvmlDeviceGetSerialW(device, &serial[0], C.NVML_DEVICE_SERIAL_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+		var partNumber [C.NVML_DEVICE_PART_NUMBER_BUFFER_SIZE]C.char
+		if err := nvmlOptional(C.nvmlDeviceGetBoardPartNumberW(device, &partNumber[0], C.NVML_DEVICE_PART_NUMBER_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+		var minPowerLimit, maxPowerLimit C.uint
+		if err := nvmlOptional(C.nvmlDeviceGetPowerManagementLimitConstraintsW(device, &minPowerLimit, &maxPowerLimit)); err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, DeviceInventory{
+			Index:           toUintP(i),
+			UUID:            C.GoString(&uuid[0]),
+			Name:            C.GoString(&name[0]),
+			Serial:          C.GoString(&serial[0]),
+			VBIOSVersion:    C.GoString(&vbios[0]),
+			BoardPartNumber: C.GoString(&partNumber[0]),
+			PCIBusID:        C.GoString(&pci.busId[0]),
+			MaxPowerLimit:   float64(maxPowerLimit) / 1000,
+			MemoryTotal:     uint64(memory.total) / MiB,
+		})
+	}
+	return devices, nil
+}
+
+func (c *nvmlCollector) XIDEvents() ([]XIDEvent, error) {
+	if c.eventSet == nil {
+		return nil, c.registerXIDEvents()
+	}
+
+	// The driver queues the events in the set, drain it without waiting.
+	var events []XIDEvent
+	for {
+		var data C.nvmlEventData_t
+		ret := C.nvmlEventSetWaitW(c.eventSet, &data, 0)
+		if ret == C.NVML_ERROR_TIMEOUT {
+			return events, nil
+		}
+		if err := nvmlError(ret); err != nil {
+			return events, err
+		}
+		if data.eventType != C.NVML_EVENT_TYPE_XID_CRITICAL_ERROR {
+			continue
+		}
+		// An XID error like a GPU falling off the bus may precede its
+		// re-enumeration.
+		c.devices.Invalidate()
+
+		event := XIDEvent{Code: uint64(data.eventData)}
+		var index C.uint
+		if C.nvmlDeviceGetIndexW(data.device, &index) == C.NVML_SUCCESS {
+			event.GPUIndex = toUintP(uint(index))
+		}
+		var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+		if C.nvmlDeviceGetUUIDW(data.device, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE) == C.NVML_SUCCESS {
+			event.GPUUUID = C.GoString(&uuid[0])
+		}
+		events = append(events, event)
+	}
+}
+
+func (c *nvmlCollector) registerXIDEvents() error {
+	indices, err := c.List()
+	if err != nil {
+		return err
+	}
+
+	var set C.nvmlEventSet_t
+	if err := nvmlError(C.nvmlEventSetCreateW(&set)); err != nil {
+		return err
+	}
+
+	for _, i := range indices {
+		var device C.nvmlDevice_t
+		if err := nvmlError(C.nvmlDeviceGetHandleByIndexW(C.uint(i), &device)); err != nil {
+			return err
+		}
+		// Consumer GPUs do not support events.
+		if err := nvmlOptional(C.nvmlDeviceRegisterEventsW(device, C.NVML_EVENT_TYPE_XID_CRITICAL_ERROR, set)); err != nil {
+			return err
+		}
+	}
+
+	c.eventSet = set
+	return nil
+}
+
+func (c *nvmlCollector) Versions() (Versions, error) {
+	if err := c.init(); err != nil {
+		return Versions{}, err
+	}
+
+	var driver [C.NVML_SYSTEM_DRIVER_VERSION_BUFFER_SIZE]C.char
+	if err := nvmlError(C.nvmlSystemGetDriverVersionW(&driver[0], C.NVML_SYSTEM_DRIVER_VERSION_BUFFER_SIZE)); err != nil {
+		return Versions{}, err
+	}
+	versions := Versions{Driver: C.GoString(&driver[0])}
+
+	// The CUDA version is encoded as 1000 * major + 10 * minor, drivers
+	// before CUDA 10 do not report it.
+	var cuda C.int
+	if C.nvmlSystemGetCudaDriverVersionW(&cuda) == C.NVML_SUCCESS {
+		versions.CUDA = fmt.Sprintf("%d.%d", int(cuda)/1000, int(cuda)%1000/10)
+	}
+	return versions, nil
+}
+
+func (c *nvmlCollector) MIGDevices() ([]MIGDevice, error) {
+	indices, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var migs []MIGDevice
+	for _, i := range indices {
+		var device C.nvmlDevice_t
+		if err := nvmlError(C.nvmlDeviceGetHandleByIndexW(C.uint(i), &device)); err != nil {
+			return nil, err
+		}
+
+		// GPUs before Ampere and drivers before R450 do not support MIG.
+		var currentMode, pendingMode C.uint
+		ret := C.nvmlDeviceGetMigModeW(device, &currentMode, &pendingMode)
+		if ret == C.NVML_ERROR_NOT_SUPPORTED || ret == C.NVML_ERROR_FUNCTION_NOT_FOUND {
+			continue
+		}
+		if err := nvmlError(ret); err != nil {
+			return nil, err
+		}
+		if currentMode != C.NVML_DEVICE_MIG_ENABLE {
+			continue
+		}
+
+		var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+		if err := nvmlError(C.nvmlDeviceGetUUIDW(device, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+
+		// The minor number matches the MIG devices to the capability devices
+		// mapped into the containers.
+		var gpuMinorNumber *uint
+		var minor C.uint
+		if C.nvmlDeviceGetMinorNumberW(device, &minor) == C.NVML_SUCCESS {
+			gpuMinorNumber = toUintP(uint(minor))
+		}
+
+		var count C.uint
+		if err := nvmlError(C.nvmlDeviceGetMaxMigDeviceCountW(device, &count)); err != nil {
+			return nil, err
+		}
+
+		for j := C.uint(0); j < count; j++ {
+			var mig C.nvmlDevice_t
+			ret := C.nvmlDeviceGetMigDeviceHandleByIndexW(device, j, &mig)
+			if ret == C.NVML_ERROR_NOT_FOUND {
+				continue
+			}
+			if err := nvmlError(ret); err != nil {
+				return nil, err
+			}
+
+			migDevice, err := nvmlMIGDevice(mig)
+			if err != nil {
+				return nil, err
+			}
+			migDevice.Index = uint(j)
+			migDevice.GPUIndex = toUintP(i)
+			migDevice.GPUMinorNumber = gpuMinorNumber
+			migDevice.GPUUUID = C.GoString(&uuid[0])
+			migs = append(migs, migDevice)
+		}
+	}
+	return migs, nil
+}
+
+func nvmlMIGDevice(mig C.nvmlDevice_t) (MIGDevice, error) {
+	var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+	if err := nvmlError(C.nvmlDeviceGetUUIDW(mig, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE)); err != nil {
+		return MIGDevice{}, err
+	}
+
+	var name [C.NVML_DEVICE_NAME_BUFFER_SIZE]C.char
+	if err := nvmlError(C.nvmlDeviceGetNameW(mig, &name[0], C.NVML_DEVICE_NAME_BUFFER_SIZE)); err != nil {
+		return MIGDevice{}, err
+	}
+
+	var gpuInstanceID, computeInstanceID C.uint
+	if err := nvmlError(C.nvmlDeviceGetGpuInstanceIdW(mig, &gpuInstanceID)); err != nil {
+		return MIGDevice{}, err
+	}
+	if err := nvmlError(C.nvmlDeviceGetComputeInstanceIdW(mig, &computeInstanceID)); err != nil {
+		return MIGDevice{}, err
+	}
+
+	var memory C.nvmlMemory_t
+	if err := nvmlError(C.nvmlDeviceGetMemoryInfoW(mig, &memory)); err != nil {
+		return MIGDevice{}, err
+	}
+
+	// Utilization is not reported for MIG devices by most drivers.
+	var utilization C.nvmlUtilization_t
+	if err := nvmlOptional(C.nvmlDeviceGetUtilizationRatesW(mig, &utilization)); err != nil {
+		return MIGDevice{}, err
+	}
+
+	return MIGDevice{
+		UUID:              C.GoString(&uuid[0]),
+		Name:              C.GoString(&name[0]),
+		GPUInstanceID:     uint(gpuInstanceID),
+		ComputeInstanceID: uint(computeInstanceID),
+		Utilization: UtilizationInfo{
+			GPU:    uint(utilization.gpu),
+			Memory: uint(utilization.memory),
+		},
+		Memory: MemoryInfo{
+			GlobalUsed:  uint64(memory.used) / MiB,
+			GlobalTotal: uint64(memory.total) / MiB,
+			GlobalFree:  uint64(memory.free) / MiB,
+		},
+	}, nil
+}
+
+func (c *nvmlCollector) NVLinks() ([]NVLink, error) {
+	indices, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var links []NVLink
+	for _, i := range indices {
+		var device C.nvmlDevice_t
+		if err := nvmlError(C.nvmlDeviceGetHandleByIndexW(C.uint(i), &device)); err != nil {
+			return nil, err
+		}
+
+		var uuid [C.NVML_DEVICE_UUID_BUFFER_SIZE]C.char
+		if err := nvmlError(C.nvmlDeviceGetUUIDW(device, &uuid[0], C.NVML_DEVICE_UUID_BUFFER_SIZE)); err != nil {
+			return nil, err
+		}
+
+		for l := C.uint(0); l < C.NVML_NVLINK_MAX_LINKS; l++ {
+			// Links the GPU does not have are not supported.
+			var active C.int
+			ret := C.nvmlDeviceGetNvLinkStateW(device, l, &active)
+			if ret == C.NVML_ERROR_NOT_SUPPORTED || ret == C.NVML_ERROR_INVALID_ARGUMENT {
+				continue
+			}
+			if err := nvmlError(ret); err != nil {
+				return nil, err
+			}
+
+			var tx, rx, replay, recovery, crc C.ulonglong
+			if err := nvmlOptional(C.nvmlDeviceGetNvLinkThroughputW(device, l, &tx, &rx)); err != nil {
+				return nil, err
+			}
+			if err := nvmlOptional(C.nvmlDeviceGetNvLinkErrorCounterW(device, l, C.NVML_NVLINK_ERROR_DL_REPLAY, &replay)); err != nil {
+				return nil, err
+			}
+			if err := nvmlOptional(C.nvmlDeviceGetNvLinkErrorCounterW(device, l, C.NVML_NVLINK_ERROR_DL_RECOVERY, &recovery)); err != nil {
+				return nil, err
+			}
+			if err := nvmlOptional(C.nvmlDeviceGetNvLinkErrorCounterW(device, l, C.NVML_NVLINK_ERROR_DL_CRC_FLIT, &crc)); err != nil {
+				return nil, err
+			}
+
+			links = append(links, NVLink{
+				GPUIndex:       toUintP(i),
+				GPUUUID:        C.GoString(&uuid[0]),
+				Link:           uint(l),
+				Active:         active != C.NVML_FEATURE_DISABLED,
+				DataTX:         uint64(tx),
+				DataRX:         uint64(rx),
+				ReplayErrors:   uint64(replay),
+				RecoveryErrors: uint64(recovery),
+				CRCErrors:      uint64(crc),
+			})
+		}
+	}
+	return links, nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux || !cgo
+// +build !linux !cgo
+
+package nvidiadocker
+
+import "errors"
+
+func init() {
+	if err := AddCollector(GPUSourceNVML, NVIDIABackend, newNVMLCollector); err != nil {
+		panic(err)
+	}
+}
+
+func newNVMLCollector(config Config) (GPUCollector, error) {
+	return nil, errors.New("nvml: gpu_source nvml requires a linux build with cgo enabled")
+}
@@ -0,0 +1,131 @@
+package nvidiadocker
+
+import (
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Labels Docker sets on the containers of the tasks of Swarm services, and on
+// the services deployed as part of a stack.
+const (
+	SwarmServiceLabel = "com.docker.swarm.service.name"
+	SwarmTaskLabel    = "com.docker.swarm.task.name"
+	SwarmStackLabel   = "com.docker.stack.namespace"
+)
+
+// Labels Docker Compose sets on the containers of a project.
+const (
+	ComposeProjectLabel = "com.docker.compose.project"
+	ComposeServiceLabel = "com.docker.compose.service"
+)
+
+// Labels the Amazon ECS container agent sets on the containers of a task.
+const (
+	AWSECSClusterLabel       = "com.amazonaws.ecs.cluster"
+	AWSECSTaskARNLabel       = "com.amazonaws.ecs.task-arn"
+	AWSECSTaskFamilyLabel    = "com.amazonaws.ecs.task-definition-family"
+	AWSECSTaskRevisionLabel  = "com.amazonaws.ecs.task-definition-version"
+	AWSECSContainerNameLabel = "com.amazonaws.ecs.container-name"
+)
+
+// AddOrchestration adds the Swarm service, the Compose project and the
+// Amazon ECS task of a container, read from its labels, to the mapping of the
+// container.
+func AddOrchestration(container common.MapStr, labels map[string]string) {
+	if swarm := Swarm(labels); swarm != nil {
+		container["swarm"] = swarm
+	}
+	if compose := Compose(labels); compose != nil {
+		container["compose"] = compose
+	}
+	if task := AWSECSTask(labels); task != nil {
+		container["aws"] = common.MapStr{"ecs": task}
+	}
+}
+
+// Swarm returns the Swarm service, stack and task of a container from its
+// labels, or nil if the container is not a task of a Swarm service.
+func Swarm(labels map[string]string) common.MapStr {
+	service, found := labels[SwarmServiceLabel]
+	if !found {
+		return nil
+	}
+
+	swarm := common.MapStr{"service": service}
+	if task := labels[SwarmTaskLabel]; task != "" {
+		swarm["task"] = task
+	}
+	if stack := labels[SwarmStackLabel]; stack != "" {
+		swarm["stack"] = stack
+	}
+	return swarm
+}
+
+// Compose returns the Compose project and service of a container from its
+// labels, or nil if the container is not part of a Compose project.
+func Compose(labels map[string]string) common.MapStr {
+	project, found := labels[ComposeProjectLabel]
+	if !found {
+		return nil
+	}
+
+	compose := common.MapStr{"project": project}
+	if service := labels[ComposeServiceLabel]; service != "" {
+		compose["service"] = service
+	}
+	return compose
+}
+
+// AWSECSTask returns the Amazon ECS cluster, task and task definition of a
+// container from the labels of the ECS container agent, or nil if the
+// container is not part of an ECS task.
+func AWSECSTask(labels map[string]string) common.MapStr {
+	arn, found := labels[AWSECSTaskARNLabel]
+	if !found {
+		return nil
+	}
+
+	task := common.MapStr{"arn": arn}
+	if family := labels[AWSECSTaskFamilyLabel]; family != "" {
+		task["family"] = family
+	}
+	if revision := labels[AWSECSTaskRevisionLabel]; revision != "" {
+		task["revision"] = revision
+	}
+
+	ecs := common.MapStr{"task": task}
+	if cluster := labels[AWSECSClusterLabel]; cluster != "" {
+		ecs["cluster"] = cluster
+	}
+	if container := labels[AWSECSContainerNameLabel]; container != "" {
+		ecs["container"] = container
+	}
+	return ecs
+}
+
+// Image returns the name and tag of the image of a container, from the
+// reference it was created from, or nil if the runtime does not report it.
+// A reference without tag or digest is tagged latest.
+func Image(container *docker.Container) common.MapStr {
+	if container.Config == nil || container.Config.Image == "" {
+		return nil
+	}
+
+	name, tag := container.Config.Image, ""
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	} else {
+		tag = "latest"
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+
+	image := common.MapStr{"name": name}
+	if tag != "" {
+		image["tag"] = tag
+	}
+	return image
+}
@@ -0,0 +1,83 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestSwarm(t *testing.T) {
+	swarm := Swarm(map[string]string{
+		"com.docker.stack.namespace":    "ml",
+		"com.docker.swarm.node.id":      "q1w2e3r4t5y6",
+		"com.docker.swarm.service.id":   "u7i8o9p0a1s2",
+		"com.docker.swarm.service.name": "ml_train",
+		"com.docker.swarm.task.id":      "d3f4g5h6j7k8",
+		"com.docker.swarm.task.name":    "ml_train.1.d3f4g5h6j7k8",
+	})
+	expected := common.MapStr{"service": "ml_train", "task": "ml_train.1.d3f4g5h6j7k8", "stack": "ml"}
+	if !reflect.DeepEqual(swarm, expected) {
+		t.Fatalf("expected %v, got %v", expected, swarm)
+	}
+
+	if swarm := Swarm(map[string]string{"com.docker.compose.project": "ml"}); swarm != nil {
+		t.Fatalf("expected no swarm fields, got %v", swarm)
+	}
+}
+
+func TestAddOrchestration(t *testing.T) {
+	container := common.MapStr{"id": "id1"}
+	AddOrchestration(container, map[string]string{
+		"com.docker.compose.project": "ml",
+		"com.docker.compose.service": "train",
+	})
+	expected := common.MapStr{"id": "id1", "compose": common.MapStr{"project": "ml", "service": "train"}}
+	if !reflect.DeepEqual(container, expected) {
+		t.Fatalf("expected %v, got %v", expected, container)
+	}
+}
+
+func TestAWSECSTask(t *testing.T) {
+	task := AWSECSTask(map[string]string{
+		"com.amazonaws.ecs.cluster":                 "gpu-cluster",
+		"com.amazonaws.ecs.container-name":          "train",
+		"com.amazonaws.ecs.task-arn":                "arn:aws:ecs:us-east-1:123456789012:task/gpu-cluster/0f9de1a2b3c4",
+		"com.amazonaws.ecs.task-definition-family":  "resnet",
+		"com.amazonaws.ecs.task-definition-version": "7",
+	})
+	expected := common.MapStr{
+		"cluster":   "gpu-cluster",
+		"container": "train",
+		"task": common.MapStr{
+			"arn":      "arn:aws:ecs:us-east-1:123456789012:task/gpu-cluster/0f9de1a2b3c4",
+			"family":   "resnet",
+			"revision": "7",
+		},
+	}
+	if !reflect.DeepEqual(task, expected) {
+		t.Fatalf("expected %v, got %v", expected, task)
+	}
+
+	if task := AWSECSTask(map[string]string{"com.amazonaws.ecs.cluster": "gpu-cluster"}); task != nil {
+		t.Fatalf("expected no task without task ARN, got %v", task)
+	}
+}
+
+func TestImage(t *testing.T) {
+	for reference, expected := range map[string]common.MapStr{
+		"nvidia/cuda:8.0-cudnn5-devel":          {"name": "nvidia/cuda", "tag": "8.0-cudnn5-devel"},
+		"tensorflow/tensorflow":                 {"name": "tensorflow/tensorflow", "tag": "latest"},
+		"registry.local:5000/ml/resnet:v2":      {"name": "registry.local:5000/ml/resnet", "tag": "v2"},
+		"registry.local:5000/ml/resnet":         {"name": "registry.local:5000/ml/resnet", "tag": "latest"},
+		"nvidia/cuda@sha256:4c2b1f":             {"name": "nvidia/cuda"},
+		"nvidia/cuda:11.4.2-base@sha256:4c2b1f": {"name": "nvidia/cuda", "tag": "11.4.2-base"},
+		"":                                      nil,
+	} {
+		image := Image(&docker.Container{Config: &docker.Config{Image: reference}})
+		if !reflect.DeepEqual(image, expected) {
+			t.Fatalf("%s: expected %v, got %v", reference, expected, image)
+		}
+	}
+}
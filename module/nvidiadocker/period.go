@@ -0,0 +1,63 @@
+package nvidiadocker
+
+import (
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// FetchGate skips the fetches of a MetricSet whose period is overridden in the
+// periods option, like the inventory and topology of the GPUs that rarely
+// change, as the MetricSets of a module are all fetched at the period of the
+// module. A MetricSet with a longer period returns no events until its period
+// elapsed since its previous fetch, so the period is rounded up to a multiple
+// of the module period.
+type FetchGate struct {
+	period       time.Duration
+	modulePeriod time.Duration
+	now          func() time.Time
+	last         time.Time
+}
+
+// NewFetchGate creates the FetchGate of the MetricSet. An override shorter
+// than the module period has no effect.
+func NewFetchGate(config Config, base mb.BaseMetricSet) *FetchGate {
+	modulePeriod := base.Module().Config().Period
+	return newFetchGate(base.Name(), config.Periods[base.Name()], modulePeriod)
+}
+
+func newFetchGate(name string, period, modulePeriod time.Duration) *FetchGate {
+	if period != 0 && period < modulePeriod {
+		logp.Warn("periods.%s %v is shorter than the module period %v, which is used instead", name, period, modulePeriod)
+	}
+	if period < modulePeriod {
+		period = modulePeriod
+	}
+	return &FetchGate{
+		period:       period,
+		modulePeriod: modulePeriod,
+		now:          time.Now,
+	}
+}
+
+// Period returns the period of the MetricSet.
+func (g *FetchGate) Period() time.Duration {
+	return g.period
+}
+
+// Due tells whether the MetricSet fetches now, and records the fetch if it
+// does. The first fetch is always due. Half a module period of slack absorbs
+// the jitter of the module timer.
+func (g *FetchGate) Due() bool {
+	if g == nil || g.period <= g.modulePeriod {
+		return true
+	}
+
+	now := g.now()
+	if !g.last.IsZero() && now.Sub(g.last) < g.period-g.modulePeriod/2 {
+		return false
+	}
+	g.last = now
+	return true
+}
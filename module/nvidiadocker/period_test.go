@@ -0,0 +1,33 @@
+package nvidiadocker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFetchGate(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	gate := newFetchGate("inventory", 10*time.Minute, 10*time.Second)
+	gate.now = func() time.Time { return now }
+
+	for i, c := range []struct {
+		elapsed time.Duration
+		due     bool
+	}{
+		{0, true},
+		{10 * time.Second, false},
+		{9*time.Minute + 40*time.Second, false},
+		// The module timer fires slightly early.
+		{9*time.Second + 900*time.Millisecond, true},
+		{10 * time.Minute, true},
+	} {
+		now = now.Add(c.elapsed)
+		if due := gate.Due(); due != c.due {
+			t.Fatalf("%d: expected due %v, got %v", i, c.due, due)
+		}
+	}
+
+	if gate := newFetchGate("status", time.Second, 10*time.Second); gate.Period() != 10*time.Second || !gate.Due() || !gate.Due() {
+		t.Fatal("expected a shorter override to keep the module period")
+	}
+}
@@ -0,0 +1,33 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ProcessName returns the name of the process with the given PID from
+// /proc/<pid>/comm, read from under hostFS.
+func ProcessName(hostFS string, pid uint) (string, error) {
+	comm, err := ioutil.ReadFile(HostPath(hostFS, fmt.Sprintf("/proc/%d/comm", pid)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(comm)), nil
+}
+
+// ProcessCommandLine returns the command line of the process with the given
+// PID from /proc/<pid>/cmdline, read from under hostFS, with its arguments
+// separated by spaces and truncated to maxLength bytes if maxLength is
+// positive.
+func ProcessCommandLine(hostFS string, pid uint, maxLength int) (string, error) {
+	cmdline, err := ioutil.ReadFile(HostPath(hostFS, fmt.Sprintf("/proc/%d/cmdline", pid)))
+	if err != nil {
+		return "", err
+	}
+	commandLine := strings.Replace(strings.TrimRight(string(cmdline), "\x00"), "\x00", " ", -1)
+	if maxLength > 0 && len(commandLine) > maxLength {
+		commandLine = commandLine[:maxLength]
+	}
+	return commandLine, nil
+}
@@ -0,0 +1,40 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessNameAndCommandLine(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+
+	dir := filepath.Join(hostFS, "/proc/4242")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "comm"), []byte("python\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte("python\x00train.py\x00--epochs=10\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if name, err := ProcessName(hostFS, 4242); err != nil || name != "python" {
+		t.Fatalf("unexpected name %q, %v", name, err)
+	}
+	if commandLine, err := ProcessCommandLine(hostFS, 4242, 0); err != nil || commandLine != "python train.py --epochs=10" {
+		t.Fatalf("unexpected command line %q, %v", commandLine, err)
+	}
+	if commandLine, _ := ProcessCommandLine(hostFS, 4242, 15); commandLine != "python train.py" {
+		t.Fatalf("unexpected truncated command line %q", commandLine)
+	}
+	if _, err := ProcessCommandLine(hostFS, 4343, 0); err == nil {
+		t.Fatal("expected an error for a missing process")
+	}
+}
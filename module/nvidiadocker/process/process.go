@@ -0,0 +1,205 @@
+package process
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "process", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports the GPU memory used by every compute process, attributed
+// to the container the process runs in.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector       nvidiadocker.ProcessCollector
+	containerClient nvidiadocker.ContainerClient
+	versions        *nvidiadocker.VersionCache
+	driver          *nvidiadocker.DriverCheck
+	gate            *nvidiadocker.FetchGate
+	hostFS          string
+	localHost       bool
+	labels          nvidiadocker.LabelsConfig
+	filter          *nvidiadocker.ContainerFilter
+	commandLine     nvidiadocker.ProcessCommandLineConfig
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	processCollector, ok := collector.(nvidiadocker.ProcessCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support listing GPU processes", config.GPUSource)
+	}
+
+	if config.ProcessCommandLine.MaxLength < 0 {
+		return nil, fmt.Errorf("process_command_line.max_length must not be negative")
+	}
+
+	containerClient, err := nvidiadocker.NewContainerClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MetricSet{
+		BaseMetricSet:   base,
+		collector:       processCollector,
+		containerClient: containerClient,
+		hostFS:          config.HostFS,
+		localHost:       config.LocalHost(),
+		labels:          config.Labels,
+		filter:          nvidiadocker.NewContainerFilter(config),
+		commandLine:     config.ProcessCommandLine,
+		versions:        nvidiadocker.NewVersionCache(collector),
+		driver:          nvidiadocker.NewDriverCheck(config),
+		gate:            nvidiadocker.NewFetchGate(config, base),
+	}, nil
+}
+
+// Fetch returns one event per GPU compute process.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	processes, err := m.collector.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	mpsDevices := nvidiadocker.MPSDevices(processes)
+
+	containers := map[string]*docker.Container{}
+	events := make([]common.MapStr, 0, len(processes))
+	for i := range processes {
+		event := eventMapping(&processes[i])
+		mpsMapping(event, &processes[i], mpsDevices)
+
+		// The processes of a remote host are not looked up in the local
+		// /proc, where their PIDs are unrelated processes.
+		var containerID string
+		if m.localHost {
+			m.addCommand(event, processes[i].PID)
+			containerID, err = nvidiadocker.ContainerIDFromPID(m.hostFS, processes[i].PID)
+			if err != nil {
+				// The process may have exited since the GPU was queried.
+				logp.Debug("nvidiadocker", "Cannot read cgroup of pid %d: %v", processes[i].PID, err)
+			}
+		}
+
+		if containerID != "" {
+			container, found := containers[containerID]
+			if !found {
+				if container, err = m.containerClient.InspectContainer(containerID); err != nil {
+					logp.Debug("nvidiadocker", "Cannot inspect container %s: %v", containerID, err)
+				}
+				containers[containerID] = container
+			}
+			if container != nil && !m.filter.MatchContainer(container) {
+				continue
+			}
+			event["container"] = containerMapping(containerID, container, m.labels)
+		}
+
+		events = append(events, event)
+	}
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+// addCommand sets the name of the process from /proc when the GPU source did
+// not report it, and adds its command line if process_command_line is
+// enabled.
+func (m *MetricSet) addCommand(event common.MapStr, pid uint) {
+	if event["name"] == "" {
+		if name, err := nvidiadocker.ProcessName(m.hostFS, pid); err == nil {
+			event["name"] = name
+		}
+	}
+	if !m.commandLine.Enabled {
+		return
+	}
+	commandLine, err := nvidiadocker.ProcessCommandLine(m.hostFS, pid, m.commandLine.MaxLength)
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot read command line of pid %d: %v", pid, err)
+		return
+	}
+	if commandLine != "" {
+		event["command_line"] = commandLine
+	}
+}
+
+// mpsMapping tells whether the GPU of the process runs an MPS server, and
+// whether the process is that server. The clients are attributed to the
+// containers they run in like any other process.
+func mpsMapping(event common.MapStr, process *nvidiadocker.ProcessInfo, mpsDevices map[string]bool) {
+	mps := common.MapStr{"enabled": mpsDevices[process.GPUUUID]}
+	if mpsDevices[process.GPUUUID] {
+		mps["server"] = nvidiadocker.IsMPSServer(process)
+	}
+	event["mps"] = mps
+}
+
+func eventMapping(process *nvidiadocker.ProcessInfo) common.MapStr {
+	gpu := common.MapStr{
+		"uuid": process.GPUUUID,
+	}
+	if process.GPUIndex != nil {
+		gpu["index"] = *process.GPUIndex
+	}
+
+	return common.MapStr{
+		"pid":  process.PID,
+		"name": process.Name,
+		"gpu":  gpu,
+		"memory": common.MapStr{
+			"used": common.MapStr{
+				"bytes": process.MemoryUsed * nvidiadocker.MiB,
+			},
+		},
+	}
+}
+
+func containerMapping(containerID string, container *docker.Container, labels nvidiadocker.LabelsConfig) common.MapStr {
+	event := common.MapStr{
+		"id": containerID,
+	}
+	if container != nil {
+		event["name"] = strings.TrimPrefix(container.Name, "/")
+		if image := nvidiadocker.Image(container); image != nil {
+			event["image"] = image
+		}
+		if container.Config != nil {
+			event["labels"] = labels.Labels(container.Config.Labels)
+			if owner := labels.Owner(container.Config.Labels); owner != nil {
+				event["owner"] = owner
+			}
+			nvidiadocker.AddOrchestration(event, container.Config.Labels)
+		}
+	}
+	return event
+}
@@ -0,0 +1,109 @@
+package process
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestEventMapping(t *testing.T) {
+	index := uint(1)
+	event := eventMapping(&nvidiadocker.ProcessInfo{
+		PID:        2781,
+		Name:       "python",
+		MemoryUsed: 10873,
+		GPUUUID:    "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6",
+		GPUIndex:   &index,
+	})
+	event["container"] = containerMapping("id1", &docker.Container{
+		Name:   "/name1",
+		Config: &docker.Config{},
+	}, nvidiadocker.LabelsConfig{})
+
+	testDatas := map[string]interface{}{
+		"pid":               uint(2781),
+		"name":              "python",
+		"gpu.uuid":          "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6",
+		"gpu.index":         uint(1),
+		"memory.used.bytes": uint64(10873 * 1024 * 1024),
+		"container.id":      "id1",
+		"container.name":    "name1",
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
+
+func TestAddCommand(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+
+	dir := filepath.Join(hostFS, "/proc/2781")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "comm"), []byte("python3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte("python3\x00train.py\x00--lr=0.1\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &MetricSet{hostFS: hostFS}
+	event := common.MapStr{"name": ""}
+	m.addCommand(event, 2781)
+	if event["name"] != "python3" {
+		t.Fatalf("unexpected name %v", event["name"])
+	}
+	if _, found := event["command_line"]; found {
+		t.Fatal("command line reported without process_command_line.enabled")
+	}
+
+	m.commandLine = nvidiadocker.ProcessCommandLineConfig{Enabled: true, MaxLength: 16}
+	event = common.MapStr{"name": "/usr/bin/python3"}
+	m.addCommand(event, 2781)
+	if event["name"] != "/usr/bin/python3" || event["command_line"] != "python3 train.py" {
+		t.Fatalf("unexpected event %v", event)
+	}
+}
+
+func TestMPSMapping(t *testing.T) {
+	processes := []nvidiadocker.ProcessInfo{
+		{PID: 10, Name: "nvidia-cuda-mps-server", GPUUUID: "GPU-0"},
+		{PID: 11, Name: "python", GPUUUID: "GPU-0"},
+		{PID: 12, Name: "python", GPUUUID: "GPU-1"},
+	}
+	mpsDevices := nvidiadocker.MPSDevices(processes)
+
+	for i, expected := range []common.MapStr{
+		{"enabled": true, "server": true},
+		{"enabled": true, "server": false},
+		{"enabled": false},
+	} {
+		event := common.MapStr{}
+		mpsMapping(event, &processes[i], mpsDevices)
+		if !reflect.DeepEqual(event["mps"], expected) {
+			t.Fatalf("%d: expected %v, got %v", i, expected, event["mps"])
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package nvidiadocker
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// PrometheusMetric is a gauge sample served by a PrometheusExporter.
+type PrometheusMetric struct {
+	Name   string
+	Help   string
+	Labels map[string]string
+	Value  float64
+}
+
+// PrometheusExporter serves the metrics of the latest fetch of the
+// MetricSets at /metrics in the Prometheus text exposition format, for
+// clusters that scrape Prometheus metrics instead of reading Elasticsearch.
+// The MetricSets listening on the same address share an exporter, each one
+// replacing its own metrics on every fetch. The metrics of a MetricSet that
+// has not fetched for StalePeriods periods, like after its module was
+// reloaded, are dropped.
+type PrometheusExporter struct {
+	mutex   sync.Mutex
+	sources map[string]*prometheusSource
+}
+
+type prometheusSource struct {
+	metrics []PrometheusMetric
+	period  time.Duration
+	updated time.Time
+}
+
+var (
+	prometheusExportersMu sync.Mutex
+	prometheusExporters   = map[string]*PrometheusExporter{}
+)
+
+// NewPrometheusExporter returns the exporter listening on the host and port
+// of the config, starting to serve on the first call.
+func NewPrometheusExporter(config PrometheusConfig) (*PrometheusExporter, error) {
+	prometheusExportersMu.Lock()
+	defer prometheusExportersMu.Unlock()
+	if e, found := prometheusExporters[config.Host]; found {
+		return e, nil
+	}
+
+	listener, err := net.Listen("tcp", config.Host)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus endpoint: %v", err)
+	}
+	e := &PrometheusExporter{sources: map[string]*prometheusSource{}}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logp.Err("Prometheus endpoint on %s stopped: %v", config.Host, err)
+		}
+	}()
+	logp.Info("Serving Prometheus metrics on http://%s/metrics", config.Host)
+
+	prometheusExporters[config.Host] = e
+	return e, nil
+}
+
+// Set replaces the metrics of the given source, like the host a MetricSet
+// reads, with the metrics of its latest fetch. period is the period of the
+// MetricSet.
+func (e *PrometheusExporter) Set(source string, period time.Duration, metrics []PrometheusMetric) {
+	if e == nil {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.sources[source] = &prometheusSource{
+		metrics: metrics,
+		period:  period,
+		updated: time.Now(),
+	}
+}
+
+// ServeHTTP writes the metrics of all sources, grouped by name.
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mutex.Lock()
+	byName := map[string][]PrometheusMetric{}
+	for name, source := range e.sources {
+		if Stale(source.updated, source.period) {
+			delete(e.sources, name)
+			continue
+		}
+		for _, metric := range source.metrics {
+			byName[metric.Name] = append(byName[metric.Name], metric)
+		}
+	}
+	e.mutex.Unlock()
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		metrics := byName[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, prometheusHelpEscaper.Replace(metrics[0].Help))
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+		for _, metric := range metrics {
+			buf.WriteString(name)
+			buf.WriteString(prometheusLabels(metric.Labels))
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatFloat(metric.Value, 'g', -1, 64))
+			buf.WriteByte('\n')
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// prometheusLabels formats the labels sorted by name, or returns an empty
+// string without labels.
+func prometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+`="`+prometheusLabelEscaper.Replace(labels[name])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	prometheusHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
@@ -0,0 +1,37 @@
+package nvidiadocker
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPrometheusExporter(t *testing.T) {
+	e := &PrometheusExporter{sources: map[string]*prometheusSource{}}
+	e.Set("", 10*time.Second, []PrometheusMetric{
+		{Name: "nvidiadocker_container_gpu_count", Help: "Number of GPUs.", Labels: map[string]string{"container_name": `a"b`, "container_id": "a"}, Value: 2},
+		{Name: "nvidiadocker_host_gpu_total", Help: "Number of GPUs of the host.", Value: 4},
+	})
+	e.Set("tcp://gpu1:2376", 10*time.Second, []PrometheusMetric{
+		{Name: "nvidiadocker_container_gpu_count", Help: "Number of GPUs.", Labels: map[string]string{"container_id": "b"}, Value: 0.5},
+	})
+	e.Set("tcp://gpu1:2376", 10*time.Second, nil)
+	// The MetricSet of a reloaded module stopped fetching.
+	e.Set("tcp://gpu2:2376", 10*time.Second, []PrometheusMetric{
+		{Name: "nvidiadocker_container_gpu_count", Help: "Number of GPUs.", Labels: map[string]string{"container_id": "c"}, Value: 1},
+	})
+	e.sources["tcp://gpu2:2376"].updated = time.Now().Add(-time.Minute)
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# HELP nvidiadocker_container_gpu_count Number of GPUs.
+# TYPE nvidiadocker_container_gpu_count gauge
+nvidiadocker_container_gpu_count{container_id="a",container_name="a\"b"} 2
+# HELP nvidiadocker_host_gpu_total Number of GPUs of the host.
+# TYPE nvidiadocker_host_gpu_total gauge
+nvidiadocker_host_gpu_total 4
+`
+	if body := recorder.Body.String(); body != expected {
+		t.Fatalf("unexpected metrics:\n%s", body)
+	}
+}
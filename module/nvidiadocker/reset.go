@@ -0,0 +1,64 @@
+package nvidiadocker
+
+import (
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// DeviceTracker detects the GPUs that fell off the bus or were reset between
+// fetches. The driver enumerates the GPUs again when one disappears, which
+// shifts the indices of the GPUs after it, and again when it comes back.
+// GPUs are identified by UUID, GPUs without one are not tracked.
+type DeviceTracker struct {
+	started bool
+	// indices holds the indices of the GPUs of the previous fetch by UUID.
+	indices map[string]uint
+	// missing holds the GPUs that disappeared and have not come back.
+	missing map[string]bool
+}
+
+// NewDeviceTracker creates a DeviceTracker without known GPUs.
+func NewDeviceTracker() *DeviceTracker {
+	return &DeviceTracker{
+		indices: map[string]uint{},
+		missing: map[string]bool{},
+	}
+}
+
+// Update records the GPUs of the current fetch, as returned by a query of all
+// GPUs, and returns the UUIDs of the GPUs that came back after disappearing
+// or whose index changed since the previous fetch. Nothing is returned on the
+// first fetch.
+func (t *DeviceTracker) Update(devices []DeviceStatus) map[string]bool {
+	reset := map[string]bool{}
+	indices := make(map[string]uint, len(devices))
+	for i := range devices {
+		device := &devices[i]
+		if device.UUID == "" {
+			continue
+		}
+		index := uint(i)
+		if device.Index != nil {
+			index = *device.Index
+		}
+		indices[device.UUID] = index
+
+		if !t.started {
+			continue
+		}
+		if previous, found := t.indices[device.UUID]; t.missing[device.UUID] || found && previous != index {
+			logp.Warn("GPU %s is back at index %d after a reset or re-enumeration", device.UUID, index)
+			reset[device.UUID] = true
+		}
+		delete(t.missing, device.UUID)
+	}
+
+	for uuid, index := range t.indices {
+		if _, found := indices[uuid]; !found {
+			logp.Warn("GPU %s at index %d disappeared, the indices of the other GPUs may have shifted", uuid, index)
+			t.missing[uuid] = true
+		}
+	}
+	t.indices = indices
+	t.started = true
+	return reset
+}
@@ -0,0 +1,34 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeviceTracker(t *testing.T) {
+	devices := func(uuids ...string) []DeviceStatus {
+		var devices []DeviceStatus
+		for _, uuid := range uuids {
+			devices = append(devices, DeviceStatus{UUID: uuid})
+		}
+		return devices
+	}
+
+	tracker := NewDeviceTracker()
+	for i, c := range []struct {
+		devices  []DeviceStatus
+		expected map[string]bool
+	}{
+		{devices("GPU-0", "GPU-1", "GPU-2"), map[string]bool{}},
+		{devices("GPU-0", "GPU-1", "GPU-2"), map[string]bool{}},
+		// GPU-1 falls off the bus and GPU-2 moves to its index.
+		{devices("GPU-0", "GPU-2"), map[string]bool{"GPU-2": true}},
+		{devices("GPU-0", "GPU-2"), map[string]bool{}},
+		{devices("GPU-0", "GPU-1", "GPU-2"), map[string]bool{"GPU-1": true, "GPU-2": true}},
+		{devices("GPU-0", "GPU-1", "GPU-2"), map[string]bool{}},
+	} {
+		if reset := tracker.Update(c.devices); !reflect.DeepEqual(reset, c.expected) {
+			t.Fatalf("%d: expected %v, got %v", i, c.expected, reset)
+		}
+	}
+}
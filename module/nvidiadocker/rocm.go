@@ -0,0 +1,210 @@
+package nvidiadocker
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	if err := AddCollector(GPUSourceROCm, AMDBackend, newROCmCollector); err != nil {
+		panic(err)
+	}
+}
+
+// rocmSMIArgs are the rocm-smi options reporting the values of
+// rocmSMIFields.
+var rocmSMIArgs = []string{
+	"--showproductname", "--showbus", "--showuniqueid", "--showuse", "--showmemuse",
+	"--showmeminfo", "vram", "--showtemp", "--showpower", "--json",
+}
+
+// rocmSMIField maps the keys of a value in the rocm-smi JSON output, which
+// differ between ROCm releases, to the DeviceStatus. name is the nvidia-smi
+// query field of the value, listed in the Unsupported fields of the GPUs that
+// do not report it.
+type rocmSMIField struct {
+	name  string
+	keys  []string
+	parse func(device *DeviceStatus, value string) error
+}
+
+var rocmSMIFields = []rocmSMIField{
+	{"name", []string{"Card series", "Card Series", "Card model"}, func(d *DeviceStatus, v string) error {
+		d.Name = v
+		return nil
+	}},
+	{"pci.bus_id", []string{"PCI Bus"}, func(d *DeviceStatus, v string) error {
+		d.PCI.BusID = v
+		return nil
+	}},
+	{"uuid", []string{"Unique ID"}, func(d *DeviceStatus, v string) error {
+		d.UUID = v
+		return nil
+	}},
+	{"utilization.gpu", []string{"GPU use (%)"}, func(d *DeviceStatus, v string) error {
+		value, err := strconv.ParseUint(v, 10, 32)
+		d.Utilization.GPU = uint(value)
+		return err
+	}},
+	{"utilization.memory", []string{"GPU memory use (%)", "GPU Memory Allocated (VRAM%)"}, func(d *DeviceStatus, v string) error {
+		value, err := strconv.ParseUint(v, 10, 32)
+		d.Utilization.Memory = uint(value)
+		return err
+	}},
+	{"temperature.gpu", []string{"Temperature (Sensor edge) (C)", "Temperature (Sensor junction) (C)"}, func(d *DeviceStatus, v string) error {
+		value, err := strconv.ParseFloat(v, 64)
+		d.Temperature = uint(math.Floor(value + 0.5))
+		return err
+	}},
+	{"temperature.hotspot", []string{"Temperature (Sensor junction) (C)"}, func(d *DeviceStatus, v string) error {
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		d.HotspotTemperature = toUintP(uint(math.Floor(value + 0.5)))
+		return nil
+	}},
+	{"temperature.memory", []string{"Temperature (Sensor memory) (C)"}, func(d *DeviceStatus, v string) error {
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		d.MemoryTemperature = toUintP(uint(math.Floor(value + 0.5)))
+		return nil
+	}},
+	{"power.draw", []string{"Average Graphics Package Power (W)", "Current Socket Graphics Package Power (W)"}, func(d *DeviceStatus, v string) error {
+		value, err := strconv.ParseFloat(v, 64)
+		d.Power = value
+		return err
+	}},
+	{"memory.total", []string{"VRAM Total Memory (B)"}, func(d *DeviceStatus, v string) error {
+		value, err := strconv.ParseUint(v, 10, 64)
+		d.Memory.GlobalTotal = value / MiB
+		return err
+	}},
+	{"memory.used", []string{"VRAM Total Used Memory (B)"}, func(d *DeviceStatus, v string) error {
+		value, err := strconv.ParseUint(v, 10, 64)
+		d.Memory.GlobalUsed = value / MiB
+		return err
+	}},
+}
+
+// rocmCollector reads the status of AMD GPUs by running rocm-smi.
+type rocmCollector struct {
+	runner commandRunner
+	path   string
+}
+
+func newROCmCollector(config Config) (GPUCollector, error) {
+	path := config.ROCmSMIPath
+	if path == "" {
+		path = "rocm-smi"
+	}
+	return &rocmCollector{
+		runner: newCommandRunner(config),
+		path:   config.hostBinary(path),
+	}, nil
+}
+
+func (c *rocmCollector) List() ([]uint, error) {
+	devices, err := c.Query(nil)
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]uint, len(devices))
+	for i := range devices {
+		indices[i] = *devices[i].Index
+	}
+	return indices, nil
+}
+
+func (c *rocmCollector) Query(indices []uint) ([]DeviceStatus, error) {
+	if indices != nil && len(indices) == 0 {
+		return []DeviceStatus{}, nil
+	}
+
+	output, err := c.runner.run(c.path, rocmSMIArgs...)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	devices, err := parseROCmSMIOutput(output)
+	tracePhase("parse", start, "source", "rocm-smi", "bytes", len(output), "gpus", len(devices))
+	countParseFailures(devices, err)
+	if err != nil {
+		return nil, err
+	}
+	// rocm-smi does not report when it read the values, which is at most
+	// the time it ran.
+	for i := range devices {
+		devices[i].Timestamp = start
+	}
+	return filterDevices(devices, indices)
+}
+
+// parseROCmSMIOutput parses the JSON output of rocm-smi, which holds the
+// values of every GPU as strings under its card name:
+//
+//	{"card0": {"GPU use (%)": "12", "PCI Bus": "0000:03:00.0", ...}}
+//
+// The GPUs are ordered by card index. Values reported as N/A or missing are
+// left at zero, and listed in the Unsupported fields of the GPU.
+func parseROCmSMIOutput(output []byte) ([]DeviceStatus, error) {
+	var cards map[string]map[string]interface{}
+	if err := json.Unmarshal(output, &cards); err != nil {
+		return nil, fmt.Errorf("rocm-smi: invalid output: %v", err)
+	}
+
+	var indices []int
+	for card := range cards {
+		if index, err := strconv.Atoi(strings.TrimPrefix(card, "card")); err == nil && strings.HasPrefix(card, "card") {
+			indices = append(indices, index)
+		}
+	}
+	sort.Ints(indices)
+
+	devices := make([]DeviceStatus, 0, len(indices))
+	for _, index := range indices {
+		values := cards["card"+strconv.Itoa(index)]
+		device := DeviceStatus{Index: toUintP(uint(index))}
+		for _, field := range rocmSMIFields {
+			value := rocmSMIValue(values, field.keys)
+			if value == "" {
+				device.Unsupported = append(device.Unsupported, field.name)
+				continue
+			}
+			if err := field.parse(&device, value); err != nil {
+				if device.InvalidFields == nil {
+					device.InvalidFields = map[string]string{}
+				}
+				device.InvalidFields[field.name] = value
+			}
+		}
+		if device.Memory.GlobalTotal > device.Memory.GlobalUsed {
+			device.Memory.GlobalFree = device.Memory.GlobalTotal - device.Memory.GlobalUsed
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// rocmSMIValue returns the first of the keys reported with a value, or an
+// empty string.
+func rocmSMIValue(values map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		value, found := values[key]
+		if !found {
+			continue
+		}
+		s := strings.TrimSpace(fmt.Sprint(value))
+		if s != "" && s != "N/A" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseROCmSMIOutput(t *testing.T) {
+	output := []byte(`{
+		"card1": {"Card series": "Instinct MI210", "PCI Bus": "0000:43:00.0", "Unique ID": "0x8a1c2e3f4b5d6e7f",
+			"GPU use (%)": "87", "GPU memory use (%)": "41", "Temperature (Sensor edge) (C)": "61.5",
+			"Temperature (Sensor junction) (C)": "74.8", "Temperature (Sensor memory) (C)": "70.0",
+			"Average Graphics Package Power (W)": "231.0", "VRAM Total Memory (B)": "68702699520",
+			"VRAM Total Used Memory (B)": "34359738368"},
+		"card0": {"Card series": "Radeon Pro W6800", "PCI Bus": "0000:03:00.0", "Unique ID": "N/A",
+			"GPU use (%)": "3", "Temperature (Sensor edge) (C)": "35.0",
+			"Current Socket Graphics Package Power (W)": "x", "VRAM Total Memory (B)": "34342961152",
+			"VRAM Total Used Memory (B)": "12578816"},
+		"system": {"Driver version": "6.3.6"}
+	}`)
+
+	devices, err := parseROCmSMIOutput(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 || *devices[0].Index != 0 || *devices[1].Index != 1 {
+		t.Fatalf("unexpected devices %+v", devices)
+	}
+
+	device := devices[1]
+	if device.Name != "Instinct MI210" || device.UUID != "0x8a1c2e3f4b5d6e7f" || device.PCI.BusID != "0000:43:00.0" ||
+		device.Utilization.GPU != 87 || device.Utilization.Memory != 41 || device.Temperature != 62 || *device.HotspotTemperature != 75 || *device.MemoryTemperature != 70 || device.Power != 231 ||
+		device.Memory.GlobalTotal != 65520 || device.Memory.GlobalUsed != 32768 || device.Memory.GlobalFree != 32752 {
+		t.Fatalf("unexpected device %+v", device)
+	}
+
+	device = devices[0]
+	if !reflect.DeepEqual(device.Unsupported, []string{"uuid", "utilization.memory", "temperature.hotspot", "temperature.memory"}) ||
+		!reflect.DeepEqual(device.InvalidFields, map[string]string{"power.draw": "x"}) || device.Memory.GlobalUsed != 11 {
+		t.Fatalf("unexpected device %+v", device)
+	}
+
+	if _, err := parseROCmSMIOutput([]byte("ERROR: No AMD GPUs")); err == nil {
+		t.Fatal("expected an error for an invalid output")
+	}
+}
+
+func TestNormalizeBusID(t *testing.T) {
+	for busID, expected := range map[string]string{
+		"0000:03:00.0":     "00000000:03:00.0",
+		"00000000:0B:00.0": "00000000:0b:00.0",
+		"invalid":          "",
+	} {
+		if normalized := normalizeBusID(busID); normalized != expected {
+			t.Fatalf("%s: expected %q, got %q", busID, expected, normalized)
+		}
+	}
+}
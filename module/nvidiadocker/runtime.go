@@ -0,0 +1,107 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ContainerClient lists and inspects the containers of a container runtime.
+// Containers of runtimes other than Docker are described with the Docker
+// types, holding the values the MetricSets read.
+type ContainerClient interface {
+	// ListContainers returns the running containers.
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+
+	// InspectContainer returns the container with the given ID.
+	InspectContainer(id string) (*docker.Container, error)
+
+	// InspectContainerWithRuntime returns the container with the given ID
+	// along with its NVIDIA runtime settings.
+	InspectContainerWithRuntime(id string) (*docker.Container, *ContainerRuntime, error)
+}
+
+// NewContainerClient creates the client of the container runtime selected by
+// the runtime option.
+func NewContainerClient(config Config) (ContainerClient, error) {
+	switch strings.ToLower(config.Runtime) {
+	case RuntimeDocker:
+		if config.sshHost != nil {
+			return &sshDockerClient{runner: commandRunner{timeout: config.DockerTimeout, ssh: config.sshHost}}, nil
+		}
+		return NewDockerClient(config)
+	case RuntimeContainerd:
+		return &containerdClient{endpoint: config.RuntimeEndpoint, namespace: config.ContainerdNamespace}, nil
+	case RuntimeCRI:
+		return &criClient{endpoint: config.RuntimeEndpoint}, nil
+	}
+	return nil, fmt.Errorf("unknown runtime '%s', must be one of %s, %s, %s",
+		config.Runtime, RuntimeCRI, RuntimeContainerd, RuntimeDocker)
+}
+
+// ociSpec holds the parts of the OCI runtime spec of a container that tell
+// which GPUs it can use.
+type ociSpec struct {
+	Process struct {
+		Env []string `json:"env"`
+	} `json:"process"`
+	Hooks struct {
+		Prestart      []ociHook `json:"prestart"`
+		CreateRuntime []ociHook `json:"createRuntime"`
+	} `json:"hooks"`
+	Linux struct {
+		Devices []struct {
+			Path string `json:"path"`
+		} `json:"devices"`
+	} `json:"linux"`
+}
+
+type ociHook struct {
+	Path string `json:"path"`
+}
+
+// usesNvidiaHook reports whether the NVIDIA container runtime hook, which
+// exposes the GPUs of NVIDIA_VISIBLE_DEVICES, runs before the container.
+func (s *ociSpec) usesNvidiaHook() bool {
+	for _, hook := range append(s.Hooks.Prestart, s.Hooks.CreateRuntime...) {
+		if strings.Contains(hook.Path, "nvidia-container") {
+			return true
+		}
+	}
+	return false
+}
+
+// ociContainer describes a container of an OCI runtime with the Docker
+// types. The devices of the spec are mapped at the same path in the
+// container and on the host. nvidiaRuntime tells whether the container runs
+// with the NVIDIA container runtime.
+func ociContainer(id, name, image string, labels map[string]string, pid int, spec *ociSpec, nvidiaRuntime bool) (*docker.Container, *ContainerRuntime) {
+	devices := make([]docker.Device, 0, len(spec.Linux.Devices))
+	for _, device := range spec.Linux.Devices {
+		devices = append(devices, docker.Device{PathOnHost: device.Path, PathInContainer: device.Path})
+	}
+
+	container := &docker.Container{
+		ID:   id,
+		Name: "/" + name,
+		Config: &docker.Config{
+			Image:  image,
+			Env:    spec.Process.Env,
+			Labels: labels,
+		},
+		HostConfig: &docker.HostConfig{
+			Devices: devices,
+		},
+		State: docker.State{
+			Running: pid > 0,
+			Pid:     pid,
+		},
+	}
+
+	runtime := &ContainerRuntime{}
+	if nvidiaRuntime || spec.usesNvidiaHook() {
+		runtime.Runtime = "nvidia"
+	}
+	return container, runtime
+}
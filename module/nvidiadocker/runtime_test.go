@@ -0,0 +1,39 @@
+package nvidiadocker
+
+import (
+	"testing"
+)
+
+func TestNewContainerClient(t *testing.T) {
+	config := DefaultConfig()
+	config.DockerEndpoint = "unix:///var/run/docker.sock"
+	client, err := NewContainerClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.(*DockerClient); !ok {
+		t.Fatalf("expected a Docker client by default, got %T", client)
+	}
+
+	config.Runtime = "CRI"
+	config.RuntimeEndpoint = "unix:///run/containerd/containerd.sock"
+	if client, err = NewContainerClient(config); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := client.(*criClient); !ok || c.endpoint != config.RuntimeEndpoint {
+		t.Fatalf("unexpected CRI client %#v", client)
+	}
+
+	config.Runtime = "containerd"
+	if client, err = NewContainerClient(config); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := client.(*containerdClient); !ok || c.namespace != "k8s.io" {
+		t.Fatalf("unexpected containerd client %#v", client)
+	}
+
+	config.Runtime = "podman"
+	if _, err := NewContainerClient(config); err == nil {
+		t.Fatal("expected error for unknown runtime")
+	}
+}
@@ -0,0 +1,229 @@
+package nvidiadocker
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// Sampler queries the GPUs at an interval shorter than the fetch period and
+// summarizes the utilization, memory used, power draw and temperature samples
+// taken between two fetches, which a single sample misrepresents for bursty
+// workloads.
+type Sampler struct {
+	collector GPUCollector
+	interval  time.Duration
+	period    time.Duration
+
+	mutex   sync.Mutex
+	samples map[string]*deviceSamples
+	running bool
+	// fetched is the time of the latest fetch, sampling stops when the
+	// MetricSet stopped fetching.
+	fetched time.Time
+}
+
+type deviceSamples struct {
+	gpu         []float64
+	memory      []float64
+	memoryUsed  []float64
+	power       []float64
+	temperature []float64
+}
+
+// SampleSummary summarizes the samples of a GPU. MemoryUsed is in bytes and
+// Power in watts.
+type SampleSummary struct {
+	Count       int
+	GPU         SampleStats
+	Memory      SampleStats
+	MemoryUsed  SampleStats
+	Power       SampleStats
+	Temperature SampleStats
+	// GPUBuckets counts the GPU utilization samples by quarter.
+	GPUBuckets UtilizationBuckets
+}
+
+// SampleStats holds the statistics of the samples of a value.
+type SampleStats struct {
+	Min float64
+	Max float64
+	Avg float64
+	P95 float64
+}
+
+// UtilizationBuckets counts utilization samples by quarter: below 25%, from 25
+// to below 50%, from 50 to below 75% and from 75%. The time spent in every
+// quarter tells a GPU busy half of the time from one half busy all the time,
+// which share the same average.
+type UtilizationBuckets [4]int
+
+// utilizationBucketNames are the names of the quarters of UtilizationBuckets
+// in the events.
+var utilizationBucketNames = [4]string{"0_25", "25_50", "50_75", "75_100"}
+
+func utilizationBuckets(values []float64) UtilizationBuckets {
+	var buckets UtilizationBuckets
+	for _, value := range values {
+		bucket := int(value / 25)
+		if bucket < 0 {
+			bucket = 0
+		} else if bucket >= len(buckets) {
+			bucket = len(buckets) - 1
+		}
+		buckets[bucket]++
+	}
+	return buckets
+}
+
+// Add adds the counts of other to the buckets.
+func (b *UtilizationBuckets) Add(other UtilizationBuckets) {
+	for i := range b {
+		b[i] += other[i]
+	}
+}
+
+// MapStr returns the counts of the buckets by quarter name, like 25_50.
+func (b UtilizationBuckets) MapStr() common.MapStr {
+	buckets := common.MapStr{}
+	for i, name := range utilizationBucketNames {
+		buckets[name] = b[i]
+	}
+	return buckets
+}
+
+// NewSampler creates a Sampler querying the collector at the given interval,
+// for a MetricSet fetching at the given period.
+func NewSampler(collector GPUCollector, interval, period time.Duration) *Sampler {
+	return &Sampler{
+		collector: collector,
+		interval:  interval,
+		period:    period,
+		samples:   map[string]*deviceSamples{},
+	}
+}
+
+// Start is called on every fetch and starts sampling in the background if it
+// is not running. Sampling stops once the MetricSet has not fetched for
+// StalePeriods periods, like after its module was reloaded.
+func (s *Sampler) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fetched = time.Now()
+	if s.running {
+		return
+	}
+	s.running = true
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if s.stop() {
+				return
+			}
+			s.sample()
+		}
+	}()
+}
+
+// stop stops sampling and drops the samples if the MetricSet stopped
+// fetching.
+func (s *Sampler) stop() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !Stale(s.fetched, s.period) {
+		return false
+	}
+	logp.Debug("nvidiadocker", "Stopping to sample GPUs, no fetch since %v", s.fetched)
+	s.running = false
+	s.samples = map[string]*deviceSamples{}
+	return true
+}
+
+func (s *Sampler) sample() {
+	devices, err := s.collector.Query(nil)
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot sample GPUs: %v", err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range devices {
+		key := DeviceKey(&devices[i])
+		samples, found := s.samples[key]
+		if !found {
+			samples = &deviceSamples{}
+			s.samples[key] = samples
+		}
+		samples.gpu = append(samples.gpu, float64(devices[i].Utilization.GPU))
+		samples.memory = append(samples.memory, float64(devices[i].Utilization.Memory))
+		samples.memoryUsed = append(samples.memoryUsed, float64(devices[i].Memory.GlobalUsed*MiB))
+		samples.power = append(samples.power, devices[i].Power)
+		samples.temperature = append(samples.temperature, float64(devices[i].Temperature))
+	}
+}
+
+// Summaries returns the summaries of the samples taken since the previous
+// call, by DeviceKey.
+func (s *Sampler) Summaries() map[string]SampleSummary {
+	s.mutex.Lock()
+	samples := s.samples
+	s.samples = map[string]*deviceSamples{}
+	s.mutex.Unlock()
+
+	summaries := make(map[string]SampleSummary, len(samples))
+	for key, device := range samples {
+		summaries[key] = SampleSummary{
+			Count:       len(device.gpu),
+			GPU:         sampleStats(device.gpu),
+			Memory:      sampleStats(device.memory),
+			MemoryUsed:  sampleStats(device.memoryUsed),
+			Power:       sampleStats(device.power),
+			Temperature: sampleStats(device.temperature),
+			GPUBuckets:  utilizationBuckets(device.gpu),
+		}
+	}
+	return summaries
+}
+
+// sampleStats computes the statistics of the values, with the nearest-rank
+// 95th percentile.
+func sampleStats(values []float64) SampleStats {
+	if len(values) == 0 {
+		return SampleStats{}
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	var total float64
+	for _, value := range sorted {
+		total += value
+	}
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+
+	return SampleStats{
+		Min: sorted[0],
+		Max: sorted[len(sorted)-1],
+		Avg: total / float64(len(sorted)),
+		P95: sorted[rank],
+	}
+}
+
+// DeviceKey identifies a GPU across queries, by UUID if available.
+func DeviceKey(device *DeviceStatus) string {
+	if device.UUID != "" {
+		return device.UUID
+	}
+	if device.Index != nil {
+		return strconv.FormatUint(uint64(*device.Index), 10)
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package nvidiadocker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	collector := &mockCollector{
+		devices: []DeviceStatus{
+			{Index: toUintP(0), UUID: "GPU-0"},
+			{Index: toUintP(1)},
+		},
+	}
+	sampler := NewSampler(collector, 0, 0)
+
+	for i := uint(1); i <= 20; i++ {
+		collector.devices[0].Utilization.GPU = i * 5
+		collector.devices[0].Temperature = 40
+		collector.devices[0].Memory.GlobalUsed = uint64(i)
+		collector.devices[0].Power = float64(i * 10)
+		sampler.sample()
+	}
+
+	summaries := sampler.Summaries()
+	if len(summaries) != 2 {
+		t.Fatalf("expected 2 summaries, got %v", summaries)
+	}
+
+	summary := summaries["GPU-0"]
+	expected := SampleStats{Min: 5, Max: 100, Avg: 52.5, P95: 95}
+	if summary.Count != 20 || summary.GPU != expected {
+		t.Fatalf("expected %+v, got %+v", expected, summary)
+	}
+	if summary.Temperature != (SampleStats{Min: 40, Max: 40, Avg: 40, P95: 40}) {
+		t.Fatalf("unexpected temperature %+v", summary.Temperature)
+	}
+	if summary.MemoryUsed.Min != MiB || summary.MemoryUsed.Max != 20*MiB {
+		t.Fatalf("unexpected memory used %+v", summary.MemoryUsed)
+	}
+	if summary.Power.Min != 10 || summary.Power.Max != 200 {
+		t.Fatalf("unexpected power %+v", summary.Power)
+	}
+	if summary.GPUBuckets != (UtilizationBuckets{4, 5, 5, 6}) {
+		t.Fatalf("unexpected GPU utilization buckets %v", summary.GPUBuckets)
+	}
+	if summaries["1"].Count != 20 {
+		t.Fatalf("expected GPU without UUID to be keyed by index, got %v", summaries)
+	}
+
+	if summaries := sampler.Summaries(); len(summaries) != 0 {
+		t.Fatalf("expected samples to be reset, got %v", summaries)
+	}
+}
+
+func TestSamplerStop(t *testing.T) {
+	sampler := NewSampler(&mockCollector{}, time.Second, 10*time.Second)
+	sampler.running = true
+	sampler.fetched = time.Now()
+	if sampler.stop() {
+		t.Fatal("expected sampling to go on while the MetricSet fetches")
+	}
+
+	sampler.fetched = time.Now().Add(-StalePeriods * 10 * time.Second).Add(-time.Second)
+	if !sampler.stop() || sampler.running {
+		t.Fatal("expected sampling to stop without fetches")
+	}
+}
+
+func TestUtilizationBuckets(t *testing.T) {
+	buckets := utilizationBuckets([]float64{0, 24.9, 25, 50, 74, 75, 100})
+	if buckets != (UtilizationBuckets{2, 1, 2, 2}) {
+		t.Fatalf("unexpected buckets %v", buckets)
+	}
+
+	buckets.Add(UtilizationBuckets{1, 0, 0, 3})
+	event := buckets.MapStr()
+	for name, expected := range map[string]int{"0_25": 3, "25_50": 1, "50_75": 2, "75_100": 5} {
+		if event[name] != expected {
+			t.Fatalf("%s: expected %d, got %v", name, expected, event[name])
+		}
+	}
+}
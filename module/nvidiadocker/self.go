@@ -0,0 +1,89 @@
+package nvidiadocker
+
+import (
+	"sync"
+	"time"
+)
+
+// CallStats counts the calls of the beat to a GPU source or to the container
+// runtime since it started.
+type CallStats struct {
+	Calls   int64
+	Errors  int64
+	Latency time.Duration
+}
+
+func (s *CallStats) observe(start time.Time, err error) {
+	s.Calls++
+	if err != nil {
+		s.Errors++
+	}
+	s.Latency += time.Since(start)
+}
+
+// SelfStats counts how the beat itself performs on the host, shared by all
+// the MetricSets of the process. The counters only increase, the selfmonitor
+// MetricSet reports their increase per period.
+type SelfStats struct {
+	// GPUSource counts the runs of the command line tools of the GPU sources
+	// and the NVML queries of the GPUs.
+	GPUSource CallStats
+
+	// Docker counts the requests to the Docker daemon.
+	Docker CallStats
+
+	// ParseFailures counts the GPU source outputs and values that could not
+	// be parsed.
+	ParseFailures int64
+}
+
+var (
+	selfStatsMu sync.Mutex
+	selfStats   SelfStats
+)
+
+// ReadSelfStats returns the counters of the beat since it started.
+func ReadSelfStats() SelfStats {
+	selfStatsMu.Lock()
+	defer selfStatsMu.Unlock()
+	return selfStats
+}
+
+// observeGPUSourceCall counts a call to the GPU source that started at start.
+func observeGPUSourceCall(start time.Time, err error) {
+	selfStatsMu.Lock()
+	defer selfStatsMu.Unlock()
+	selfStats.GPUSource.observe(start, err)
+}
+
+// observeDockerCall counts a request to the Docker daemon that started at
+// start.
+func observeDockerCall(start time.Time, err error) {
+	selfStatsMu.Lock()
+	defer selfStatsMu.Unlock()
+	selfStats.Docker.observe(start, err)
+}
+
+// addParseFailures counts n parse failures of GPU source outputs.
+func addParseFailures(n int) {
+	if n == 0 {
+		return
+	}
+	selfStatsMu.Lock()
+	defer selfStatsMu.Unlock()
+	selfStats.ParseFailures += int64(n)
+}
+
+// countParseFailures counts the invalid values of the parsed devices, or the
+// output as a whole if it could not be parsed.
+func countParseFailures(devices []DeviceStatus, err error) {
+	if err != nil {
+		addParseFailures(1)
+		return
+	}
+	failures := 0
+	for _, device := range devices {
+		failures += len(device.InvalidFields)
+	}
+	addParseFailures(failures)
+}
@@ -0,0 +1,137 @@
+package selfmonitor
+
+import (
+	"expvar"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "selfmonitor", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports how the beat itself performs, to detect when it degrades
+// on busy hosts: the latency of its calls to the GPU source and the Docker
+// daemon, the GPU source outputs it could not parse, its failed fetches and
+// the events its outputs dropped.
+type MetricSet struct {
+	mb.BaseMetricSet
+	gate *nvidiadocker.FetchGate
+
+	// previous holds the counters of the previous fetch, to report their
+	// increase since.
+	previous counters
+}
+
+// counters are the counters the MetricSet reports the increase of.
+type counters struct {
+	self          nvidiadocker.SelfStats
+	fetchFailures int64
+	eventsDropped int64
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		gate:          nvidiadocker.NewFetchGate(config, base),
+		previous:      readCounters(),
+	}, nil
+}
+
+// Fetch returns a single event with the increase of the counters since the
+// previous fetch.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	current := readCounters()
+	event := eventMapping(current, m.previous)
+	m.previous = current
+	return []common.MapStr{event}, nil
+}
+
+func readCounters() counters {
+	return counters{
+		self:          nvidiadocker.ReadSelfStats(),
+		fetchFailures: fetchFailures(),
+		eventsDropped: expvarInt("libbeat.outputs.messages_dropped"),
+	}
+}
+
+// fetchFailures sums the failed fetches of the MetricSets of the module,
+// counted by Metricbeat under fetches.nvidiadocker-<metricset>.failures.
+func fetchFailures() int64 {
+	fetches, ok := expvar.Get("fetches").(*expvar.Map)
+	if !ok {
+		return 0
+	}
+
+	var failures int64
+	fetches.Do(func(kv expvar.KeyValue) {
+		stats, ok := kv.Value.(*expvar.Map)
+		if !ok || !strings.HasPrefix(kv.Key, "nvidiadocker-") {
+			return
+		}
+		if count, ok := stats.Get("failures").(*expvar.Int); ok {
+			failures += count.Value()
+		}
+	})
+	return failures
+}
+
+// expvarInt returns the value of the expvar counter with the given name, or 0
+// if it is not registered, like the counters of outputs that are not used.
+func expvarInt(name string) int64 {
+	if count, ok := expvar.Get(name).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
+
+func eventMapping(current, previous counters) common.MapStr {
+	return common.MapStr{
+		"gpu_source": callsEvent(current.self.GPUSource, previous.self.GPUSource),
+		"docker":     callsEvent(current.self.Docker, previous.self.Docker),
+		"parse": common.MapStr{
+			"failures": current.self.ParseFailures - previous.self.ParseFailures,
+		},
+		"fetch": common.MapStr{
+			"failures": current.fetchFailures - previous.fetchFailures,
+		},
+		"events": common.MapStr{
+			"dropped": current.eventsDropped - previous.eventsDropped,
+		},
+	}
+}
+
+// callsEvent reports the calls since the previous fetch, and their average
+// latency if there were any.
+func callsEvent(current, previous nvidiadocker.CallStats) common.MapStr {
+	calls := current.Calls - previous.Calls
+	event := common.MapStr{
+		"calls":  calls,
+		"errors": current.Errors - previous.Errors,
+	}
+	if calls > 0 {
+		latency := (current.Latency - previous.Latency) / time.Duration(calls)
+		event["latency"] = common.MapStr{
+			"avg": common.MapStr{"ms": float64(latency) / float64(time.Millisecond)},
+		}
+	}
+	return event
+}
@@ -0,0 +1,76 @@
+package selfmonitor
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	previous := counters{
+		self: nvidiadocker.SelfStats{
+			GPUSource:     nvidiadocker.CallStats{Calls: 10, Errors: 1, Latency: time.Second},
+			Docker:        nvidiadocker.CallStats{Calls: 20, Latency: 200 * time.Millisecond},
+			ParseFailures: 2,
+		},
+		fetchFailures: 1,
+		eventsDropped: 5,
+	}
+	current := counters{
+		self: nvidiadocker.SelfStats{
+			GPUSource:     nvidiadocker.CallStats{Calls: 14, Errors: 2, Latency: 2 * time.Second},
+			Docker:        nvidiadocker.CallStats{Calls: 20, Latency: 200 * time.Millisecond},
+			ParseFailures: 5,
+		},
+		fetchFailures: 1,
+		eventsDropped: 12,
+	}
+	event := eventMapping(current, previous)
+
+	testDatas := map[string]interface{}{
+		"gpu_source.calls":          int64(4),
+		"gpu_source.errors":         int64(1),
+		"gpu_source.latency.avg.ms": 250.0,
+		"docker.calls":              int64(0),
+		"docker.errors":             int64(0),
+		"parse.failures":            int64(3),
+		"fetch.failures":            int64(0),
+		"events.dropped":            int64(7),
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	// Without calls, there is no latency to average.
+	if _, err := event.GetValue("docker.latency"); err == nil {
+		t.Fatal("expected no docker latency")
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
+
+func TestFetchFailures(t *testing.T) {
+	fetches, ok := expvar.Get("fetches").(*expvar.Map)
+	if !ok {
+		fetches = expvar.NewMap("fetches")
+	}
+	for key, failures := range map[string]int64{"nvidiadocker-gpu": 2, "nvidiadocker-status": 1, "system-cpu": 4} {
+		stats := new(expvar.Map).Init()
+		stats.Add("failures", failures)
+		fetches.Set(key, stats)
+	}
+
+	if failures := fetchFailures(); failures != 3 {
+		t.Fatalf("expected 3 failures, got %d", failures)
+	}
+}
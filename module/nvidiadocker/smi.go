@@ -0,0 +1,1339 @@
+package nvidiadocker
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+func init() {
+	if err := AddCollector(GPUSourceSMI, NVIDIABackend, newSMICollector); err != nil {
+		panic(err)
+	}
+}
+
+// errSMINotSupported is returned for the "[N/A]" and "[Not Supported]" values
+// nvidia-smi reports for the fields a GPU does not support, and for the
+// "[Insufficient Permissions]" values of the fields the guests of vGPU hosts
+// cannot read.
+var errSMINotSupported = errors.New("not supported")
+
+// smiField maps a nvidia-smi --query-gpu field to the DeviceStatus.
+type smiField struct {
+	name  string
+	parse func(device *DeviceStatus, value string) error
+}
+
+var nvidiaSMIQueryFields = []smiField{
+	{"index", func(d *DeviceStatus, v string) error {
+		index, err := parseSMIUint(v)
+		if err != nil {
+			return err
+		}
+		d.Index = toUintP(uint(index))
+		return nil
+	}},
+	{"uuid", func(d *DeviceStatus, v string) error {
+		d.UUID = v
+		return nil
+	}},
+	{"name", func(d *DeviceStatus, v string) error {
+		d.Name = v
+		return nil
+	}},
+	{"pci.bus_id", func(d *DeviceStatus, v string) error {
+		d.PCI.BusID = v
+		return nil
+	}},
+	{"power.draw", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIPower(v)
+		d.Power = value
+		return err
+	}},
+	{"power.limit", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIPower(v)
+		d.PowerLimit = value
+		return err
+	}},
+	{"enforced.power.limit", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIPower(v)
+		d.PowerEnforcedLimit = value
+		return err
+	}},
+	{"clocks_throttle_reasons.gpu_idle", func(d *DeviceStatus, v string) error {
+		d.ThrottleReasons.GPUIdle = parseSMIActive(v)
+		return nil
+	}},
+	{"clocks_throttle_reasons.applications_clocks_setting", func(d *DeviceStatus, v string) error {
+		d.ThrottleReasons.ApplicationsClocksSetting = parseSMIActive(v)
+		return nil
+	}},
+	{"clocks_throttle_reasons.sw_power_cap", func(d *DeviceStatus, v string) error {
+		d.ThrottleReasons.SWPowerCap = parseSMIActive(v)
+		return nil
+	}},
+	{"clocks_throttle_reasons.hw_slowdown", func(d *DeviceStatus, v string) error {
+		d.ThrottleReasons.HWSlowdown = parseSMIActive(v)
+		return nil
+	}},
+	{"clocks_throttle_reasons.sync_boost", func(d *DeviceStatus, v string) error {
+		d.ThrottleReasons.SyncBoost = parseSMIActive(v)
+		return nil
+	}},
+	{"ecc.errors.corrected.volatile.total", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIOptionalUint(v)
+		d.ECC.Volatile.SingleBit = value
+		return err
+	}},
+	{"ecc.errors.uncorrected.volatile.total", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIOptionalUint(v)
+		d.ECC.Volatile.DoubleBit = value
+		return err
+	}},
+	{"ecc.errors.corrected.aggregate.total", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIOptionalUint(v)
+		d.ECC.Aggregate.SingleBit = value
+		return err
+	}},
+	{"ecc.errors.uncorrected.aggregate.total", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIOptionalUint(v)
+		d.ECC.Aggregate.DoubleBit = value
+		return err
+	}},
+	{"fan.speed", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIOptionalUint(v)
+		d.FanSpeed = uint(value)
+		return err
+	}},
+	{"pstate", func(d *DeviceStatus, v string) error {
+		if !strings.HasPrefix(v, "[") {
+			d.PerformanceState = v
+		}
+		return nil
+	}},
+	{"compute_mode", func(d *DeviceStatus, v string) error {
+		if !strings.HasPrefix(v, "[") {
+			d.ComputeMode = v
+		}
+		return nil
+	}},
+	{"persistence_mode", func(d *DeviceStatus, v string) error {
+		// Persistence mode is only supported on Linux.
+		switch v {
+		case "Enabled":
+			d.PersistenceMode = toBoolP(true)
+		case "Disabled":
+			d.PersistenceMode = toBoolP(false)
+		}
+		return nil
+	}},
+	{"utilization.gpu", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		d.Utilization.GPU = uint(value)
+		return err
+	}},
+	{"utilization.memory", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		d.Utilization.Memory = uint(value)
+		return err
+	}},
+	{"temperature.gpu", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		d.Temperature = uint(value)
+		return err
+	}},
+	{"memory.used", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		d.Memory.GlobalUsed = value
+		return err
+	}},
+	{"memory.total", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		d.Memory.GlobalTotal = value
+		return err
+	}},
+	{"memory.free", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		d.Memory.GlobalFree = value
+		return err
+	}},
+	{"minor_number", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		if err == nil {
+			d.MinorNumber = toUintP(uint(value))
+		}
+		return err
+	}},
+	{"timestamp", func(d *DeviceStatus, v string) error {
+		// nvidia-smi reports the time of the query in the local time zone.
+		timestamp, err := time.ParseInLocation(smiTimestampLayout, v, time.Local)
+		d.Timestamp = timestamp
+		return err
+	}},
+	{"pcie.link.gen.current", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIOptionalUint(v)
+		d.PCI.Link.Gen = uint(value)
+		return err
+	}},
+	{"pcie.link.gen.max", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIOptionalUint(v)
+		d.PCI.MaxLink.Gen = uint(value)
+		return err
+	}},
+	{"pcie.link.width.current", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIOptionalUint(v)
+		d.PCI.Link.Width = uint(value)
+		return err
+	}},
+	{"pcie.link.width.max", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIOptionalUint(v)
+		d.PCI.MaxLink.Width = uint(value)
+		return err
+	}},
+}
+
+// nvidiaSMIOptionalQueryFields follow nvidiaSMIQueryFields in the query, unless
+// the driver does not know them. nvidia-smi rejects the whole query if one of
+// its fields is unknown, like the throttle reasons older drivers do not break
+// down or the encoder stats.
+var nvidiaSMIOptionalQueryFields = []smiField{
+	{"clocks_throttle_reasons.hw_thermal_slowdown", func(d *DeviceStatus, v string) error {
+		d.ThrottleReasons.HWThermalSlowdown = parseSMIActive(v)
+		return nil
+	}},
+	{"clocks_throttle_reasons.hw_power_brake_slowdown", func(d *DeviceStatus, v string) error {
+		d.ThrottleReasons.HWPowerBrakeSlowdown = parseSMIActive(v)
+		return nil
+	}},
+	{"clocks_throttle_reasons.sw_thermal_slowdown", func(d *DeviceStatus, v string) error {
+		d.ThrottleReasons.SWThermalSlowdown = parseSMIActive(v)
+		return nil
+	}},
+	{"encoder.stats.sessionCount", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		d.EncoderStats.SessionCount = uint(value)
+		return err
+	}},
+	{"encoder.stats.averageFps", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		d.EncoderStats.AverageFPS = uint(value)
+		return err
+	}},
+	{"encoder.stats.averageLatency", func(d *DeviceStatus, v string) error {
+		value, err := parseSMIUint(v)
+		d.EncoderStats.AverageLatency = uint(value)
+		return err
+	}},
+}
+
+// smiUnknownFieldRegexp matches the error of nvidia-smi for a query field the
+// driver does not know.
+var smiUnknownFieldRegexp = regexp.MustCompile(`is not a valid field to query`)
+
+// smiTimestampLayout is the layout of the timestamp field of nvidia-smi.
+const smiTimestampLayout = "2006/01/02 15:04:05.000"
+
+// smiCollector reads the GPU status by running nvidia-smi.
+type smiCollector struct {
+	runner      commandRunner
+	path        string
+	extraFields []string
+
+	// withoutOptional is set once the driver rejected the
+	// nvidiaSMIOptionalQueryFields, which are not queried anymore.
+	mutex           sync.Mutex
+	withoutOptional bool
+}
+
+func newSMICollector(config Config) (GPUCollector, error) {
+	return newSMICollectorFromConfig(config)
+}
+
+// newSMICollectorFromConfig creates the smiCollector the smi and dcgm GPU
+// sources query nvidia-smi with.
+func newSMICollectorFromConfig(config Config) (*smiCollector, error) {
+	for _, name := range config.SMIExtraFields {
+		if name == "" || strings.ContainsAny(name, ", ") {
+			return nil, fmt.Errorf("invalid smi_extra_fields entry %q", name)
+		}
+	}
+
+	path := config.SMIPath
+	if path == "" {
+		path = "nvidia-smi"
+	}
+	return &smiCollector{
+		runner:      newCommandRunner(config),
+		path:        config.hostBinary(path),
+		extraFields: config.SMIExtraFields,
+	}, nil
+}
+
+func (c *smiCollector) List() ([]uint, error) {
+	output, err := c.execNvidiaSMICommand("--query-gpu=index", "--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, err
+	}
+
+	var indices []uint
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+		index, err := parseSMIUint(line)
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid index value %q: %v", line, err)
+		}
+		indices = append(indices, uint(index))
+	}
+	return indices, nil
+}
+
+func (c *smiCollector) Query(indices []uint) ([]DeviceStatus, error) {
+	if indices != nil && len(indices) == 0 {
+		return []DeviceStatus{}, nil
+	}
+
+	var ids string
+	if indices != nil {
+		idList := make([]string, len(indices))
+		for i, index := range indices {
+			idList[i] = strconv.FormatUint(uint64(index), 10)
+		}
+		ids = strings.Join(idList, ",")
+	}
+
+	c.mutex.Lock()
+	optional := !c.withoutOptional
+	c.mutex.Unlock()
+
+	output, err := c.queryGPUs(ids, optional)
+	if err != nil && optional && smiUnknownFieldRegexp.MatchString(err.Error()) {
+		logp.Info("Querying nvidia-smi without the query fields the driver does not know: %v", err)
+		c.mutex.Lock()
+		c.withoutOptional = true
+		c.mutex.Unlock()
+		optional = false
+		output, err = c.queryGPUs(ids, optional)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := nvidiaSMIQueryFields
+	if optional {
+		fields = append(append([]smiField{}, fields...), nvidiaSMIOptionalQueryFields...)
+	}
+	start := time.Now()
+	devices, err := parseNvidiaSMIFields(output, fields, c.extraFields)
+	tracePhase("parse", start, "source", "nvidia-smi", "bytes", len(output), "gpus", len(devices))
+	countParseFailures(devices, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if !optional {
+		for i := range devices {
+			for _, field := range nvidiaSMIOptionalQueryFields {
+				devices[i].Unsupported = append(devices[i].Unsupported, field.name)
+			}
+		}
+	}
+
+	// The encoder, decoder and PCIe throughput values are not part of
+	// --query-gpu, they are sampled with nvidia-smi dmon. Drivers without
+	// dmon support still report the rest.
+	if err := c.queryDmon(devices, ids); err != nil {
+		logp.Debug("nvidiadocker", "Cannot read nvidia-smi dmon samples: %v", err)
+	}
+	// The temperature thresholds and the memory temperature are only part of
+	// the nvidia-smi -q report, the temperature.memory query field is not
+	// known to older drivers.
+	if err := c.queryTemperatures(devices, ids); err != nil {
+		logp.Debug("nvidiadocker", "Cannot read nvidia-smi temperatures: %v", err)
+	}
+	return devices, nil
+}
+
+// queryGPUs runs the --query-gpu query of the GPUs with the given ids, all of
+// them if empty, with the nvidiaSMIOptionalQueryFields if optional.
+func (c *smiCollector) queryGPUs(ids string, optional bool) ([]byte, error) {
+	names := make([]string, 0, len(nvidiaSMIQueryFields)+len(nvidiaSMIOptionalQueryFields)+len(c.extraFields))
+	for _, field := range nvidiaSMIQueryFields {
+		names = append(names, field.name)
+	}
+	if optional {
+		for _, field := range nvidiaSMIOptionalQueryFields {
+			names = append(names, field.name)
+		}
+	}
+	names = append(names, c.extraFields...)
+
+	args := []string{
+		"--query-gpu=" + strings.Join(names, ","),
+		"--format=csv,noheader,nounits",
+	}
+	if ids != "" {
+		args = append(args, "--id="+ids)
+	}
+	return c.execNvidiaSMICommand(args...)
+}
+
+func (c *smiCollector) queryTemperatures(devices []DeviceStatus, ids string) error {
+	args := []string{"--query", "--display=TEMPERATURE"}
+	if ids != "" {
+		args = append(args, "--id="+ids)
+	}
+
+	output, err := c.execNvidiaSMICommand(args...)
+	if err != nil {
+		return err
+	}
+	temperatures := parseNvidiaSMITemperatures(output)
+	for i := range devices {
+		if t, found := temperatures[devices[i].PCI.BusID]; found {
+			devices[i].TemperatureThresholds = t.Thresholds
+			devices[i].MemoryTemperature = t.Memory
+		}
+	}
+	return nil
+}
+
+func (c *smiCollector) queryDmon(devices []DeviceStatus, ids string) error {
+	args := []string{"dmon", "--select", "ut", "--count", "1"}
+	if ids != "" {
+		args = append(args, "--id", ids)
+	}
+
+	output, err := c.execNvidiaSMICommand(args...)
+	if err != nil {
+		return err
+	}
+	samples, err := parseNvidiaSMIDmon(output)
+	if err != nil {
+		return err
+	}
+
+	for i := range devices {
+		if devices[i].Index == nil {
+			continue
+		}
+		sample, found := samples[*devices[i].Index]
+		if !found {
+			continue
+		}
+		devices[i].Utilization.Encoder = uint(sample["enc"])
+		devices[i].Utilization.Decoder = uint(sample["dec"])
+		devices[i].PCI.Throughput = PCIThroughputInfo{
+			RX: uint(sample["rxpci"]),
+			TX: uint(sample["txpci"]),
+		}
+	}
+	return nil
+}
+
+func (c *smiCollector) Processes() ([]ProcessInfo, error) {
+	output, err := c.execNvidiaSMICommand(
+		"--query-compute-apps=pid,process_name,used_memory,gpu_uuid",
+		"--format=csv,noheader,nounits",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return parseNvidiaSMIProcesses(output)
+}
+
+// AccountedProcesses lists the processes recorded by the driver. nvidia-smi
+// does not report whether a process still runs, so the accounted processes are
+// matched against the running compute processes.
+func (c *smiCollector) AccountedProcesses() ([]AccountedProcess, error) {
+	output, err := c.execNvidiaSMICommand(
+		"--query-accounted-apps=pid,gpu_uuid,gpu_utilization,mem_utilization,max_memory_usage,time",
+		"--format=csv,noheader,nounits",
+	)
+	if err != nil {
+		return nil, err
+	}
+	processes, err := parseNvidiaSMIAccountedApps(output)
+	if err != nil {
+		return nil, err
+	}
+
+	running, err := c.Processes()
+	if err != nil {
+		return nil, err
+	}
+	for i := range processes {
+		for _, r := range running {
+			if r.PID == processes[i].PID && r.GPUUUID == processes[i].GPUUUID {
+				processes[i].Running = true
+			}
+		}
+	}
+	return processes, nil
+}
+
+func (c *smiCollector) Health() ([]DeviceHealth, error) {
+	output, err := c.execNvidiaSMICommand(
+		"--query-gpu=index,uuid,retired_pages.sbe,retired_pages.dbe,retired_pages.pending",
+		"--format=csv,noheader,nounits",
+	)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := parseNvidiaSMIHealth(output)
+	if err != nil {
+		return nil, err
+	}
+
+	// Row remapping is only known to drivers from R450 on, which report it
+	// with a query of its own.
+	if err := c.queryRemappedRows(devices); err != nil {
+		logp.Debug("nvidiadocker", "Cannot read nvidia-smi remapped rows: %v", err)
+	}
+	return devices, nil
+}
+
+func (c *smiCollector) queryRemappedRows(devices []DeviceHealth) error {
+	output, err := c.execNvidiaSMICommand(
+		"--query-remapped-rows=gpu_uuid,remapped_rows.correctable,remapped_rows.uncorrectable,remapped_rows.pending,remapped_rows.failure",
+		"--format=csv,noheader,nounits",
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := parseNvidiaSMIRemappedRows(output)
+	if err != nil {
+		return err
+	}
+	for i := range devices {
+		if r, found := rows[devices[i].UUID]; found {
+			devices[i].RemappedRows = &r
+		}
+	}
+	return nil
+}
+
+func (c *smiCollector) Versions() (Versions, error) {
+	output, err := c.execNvidiaSMICommand("--query")
+	if err != nil {
+		return Versions{}, err
+	}
+	return parseNvidiaSMIVersions(output), nil
+}
+
+func (c *smiCollector) VGPUs() ([]VGPU, error) {
+	output, err := c.execNvidiaSMICommand("vgpu", "--query")
+	if err != nil {
+		return nil, err
+	}
+	return parseNvidiaSMIVGPUs(output), nil
+}
+
+func (c *smiCollector) NVLinks() ([]NVLink, error) {
+	links := &nvlinkParser{}
+	for _, args := range [][]string{
+		{"nvlink", "--status"},
+		{"nvlink", "--getthroughput", "d"},
+		{"nvlink", "--errorcounters"},
+	} {
+		output, err := c.execNvidiaSMICommand(args...)
+		if err != nil {
+			return nil, err
+		}
+		if err := links.parse(output); err != nil {
+			return nil, err
+		}
+	}
+	return links.links, nil
+}
+
+func (c *smiCollector) Topology() ([]GPUTopology, error) {
+	output, err := c.execNvidiaSMICommand("topo", "--matrix")
+	if err != nil {
+		return nil, err
+	}
+	return parseNvidiaSMITopology(output)
+}
+
+func (c *smiCollector) Inventory() ([]DeviceInventory, error) {
+	output, err := c.execNvidiaSMICommand(
+		"--query-gpu=index,uuid,name,serial,vbios_version,pci.bus_id,power.max_limit,memory.total",
+		"--format=csv,noheader,nounits",
+	)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := parseNvidiaSMIInventory(output)
+	if err != nil {
+		return nil, err
+	}
+
+	// The board part number is only part of the nvidia-smi -q report.
+	output, err = c.execNvidiaSMICommand("--query")
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot read nvidia-smi board part numbers: %v", err)
+		return devices, nil
+	}
+	partNumbers := parseNvidiaSMIBoardPartNumbers(output)
+	for i := range devices {
+		devices[i].BoardPartNumber = partNumbers[devices[i].PCIBusID]
+	}
+	return devices, nil
+}
+
+func (c *smiCollector) GPUFabric() ([]GPUFabric, error) {
+	output, err := c.execNvidiaSMICommand("--query")
+	if err != nil {
+		return nil, err
+	}
+	return parseNvidiaSMIFabric(output), nil
+}
+
+func (c *smiCollector) execNvidiaSMICommand(args ...string) ([]byte, error) {
+	return c.runner.run(c.path, args...)
+}
+
+// parseNvidiaSMIOutput parses the --query-gpu output of nvidiaSMIQueryFields
+// and nvidiaSMIOptionalQueryFields followed by extraFields.
+func parseNvidiaSMIOutput(output []byte, extraFields []string) ([]DeviceStatus, error) {
+	fields := append(append([]smiField{}, nvidiaSMIQueryFields...), nvidiaSMIOptionalQueryFields...)
+	return parseNvidiaSMIFields(output, fields, extraFields)
+}
+
+// parseNvidiaSMIFields parses the --query-gpu output of fields followed by
+// extraFields. A value that cannot be parsed is recorded in the InvalidFields
+// of its GPU instead of failing the whole output, and the fields the GPU does
+// not support in its Unsupported fields.
+func parseNvidiaSMIFields(output []byte, fields []smiField, extraFields []string) ([]DeviceStatus, error) {
+	reader := csv.NewReader(bytes.NewReader(output))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = len(fields) + len(extraFields)
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]DeviceStatus, 0, len(records))
+	for _, record := range records {
+		device := DeviceStatus{}
+		for i, value := range record[:len(fields)] {
+			field := fields[i]
+			value = strings.TrimSpace(value)
+			err := field.parse(&device, value)
+			if err == errSMINotSupported {
+				device.Unsupported = append(device.Unsupported, field.name)
+			} else if err != nil {
+				logp.Debug("nvidiadocker", "nvidia-smi: invalid %s value %q: %v", field.name, value, err)
+				if device.InvalidFields == nil {
+					device.InvalidFields = map[string]string{}
+				}
+				device.InvalidFields[field.name] = value
+			}
+		}
+		for i, value := range record[len(fields):] {
+			if value = parseSMIOptionalString(value); value == "" {
+				continue
+			}
+			if device.Raw == nil {
+				device.Raw = map[string]string{}
+			}
+			device.Raw[extraFields[i]] = value
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+func parseSMIUint(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	if value == "[N/A]" || value == "[Not Supported]" || value == "[Insufficient Permissions]" {
+		return 0, errSMINotSupported
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// parseSMIPower parses a power value in watts. GPUs without power management
+// report "[N/A]" or "[Not Supported]", for which errSMINotSupported is
+// returned.
+func parseSMIPower(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") {
+		return 0, errSMINotSupported
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// parseSMIOptionalUint parses a value not every GPU supports, such as the ECC
+// error counters or the fan speed. Unsupported values are reported as "[N/A]"
+// or "[Not Supported]", and as "-" by nvidia-smi dmon, which is read as zero.
+func parseSMIOptionalUint(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") || value == "-" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// parseSMIOptionalString parses a value not every GPU supports, such as the
+// serial number. Unsupported values are reported as "[N/A]" or
+// "[Not Supported]", which is read as empty.
+func parseSMIOptionalString(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") {
+		return ""
+	}
+	return value
+}
+
+// parseSMIActive parses a throttle reason, which is reported as "Active" or
+// "Not Active".
+func parseSMIActive(value string) bool {
+	return strings.TrimSpace(value) == "Active"
+}
+
+// parseSMIYes parses a flag nvidia-smi reports as Yes or No, or as 1 or 0
+// with nounits.
+func parseSMIYes(value string) bool {
+	value = strings.TrimSpace(value)
+	return value == "Yes" || value == "1"
+}
+
+// parseNvidiaSMIDmon parses the output of nvidia-smi dmon into the values of
+// each column by GPU index. The columns are named by the first header line:
+//
+//	# gpu    sm   mem   enc   dec  rxpci  txpci
+//	# Idx     %     %     %     %   MB/s   MB/s
+//	    0     3     1     0     0     12      3
+func parseNvidiaSMIDmon(output []byte) (map[uint]map[string]uint64, error) {
+	var columns []string
+	samples := map[uint]map[string]uint64{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "#") {
+			if columns == nil {
+				columns = append([]string{strings.TrimPrefix(fields[0], "#")}, fields[1:]...)
+				if columns[0] == "" {
+					columns = columns[1:]
+				}
+			}
+			continue
+		}
+		if len(columns) == 0 || columns[0] != "gpu" {
+			return nil, fmt.Errorf("nvidia-smi dmon: missing header before line %q", line)
+		}
+		if len(fields) != len(columns) {
+			return nil, fmt.Errorf("nvidia-smi dmon: unexpected line %q", line)
+		}
+
+		index, err := parseSMIUint(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi dmon: invalid gpu value %q: %v", fields[0], err)
+		}
+		sample := make(map[string]uint64, len(columns)-1)
+		for i, column := range columns[1:] {
+			value, err := parseSMIOptionalUint(fields[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("nvidia-smi dmon: invalid %s value %q: %v", column, fields[i+1], err)
+			}
+			sample[column] = value
+		}
+		samples[uint(index)] = sample
+	}
+	return samples, nil
+}
+
+func parseNvidiaSMIHealth(output []byte) ([]DeviceHealth, error) {
+	reader := csv.NewReader(bytes.NewReader(output))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = 5
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]DeviceHealth, 0, len(records))
+	for _, record := range records {
+		index, err := parseSMIUint(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid index value %q: %v", record[0], err)
+		}
+		singleBit, err := parseSMIOptionalUint(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid retired_pages.sbe value %q: %v", record[2], err)
+		}
+		doubleBit, err := parseSMIOptionalUint(record[3])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid retired_pages.dbe value %q: %v", record[3], err)
+		}
+
+		devices = append(devices, DeviceHealth{
+			Index: toUintP(uint(index)),
+			UUID:  strings.TrimSpace(record[1]),
+			RetiredPages: RetiredPagesInfo{
+				SingleBit: singleBit,
+				DoubleBit: doubleBit,
+				Pending:   strings.TrimSpace(record[4]) == "Yes",
+			},
+		})
+	}
+	return devices, nil
+}
+
+// parseNvidiaSMIRemappedRows parses the output of nvidia-smi
+// --query-remapped-rows by GPU UUID. The GPUs without row remapping, which
+// report "[N/A]", are left out.
+func parseNvidiaSMIRemappedRows(output []byte) (map[string]RemappedRowsInfo, error) {
+	reader := csv.NewReader(bytes.NewReader(output))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = 5
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := map[string]RemappedRowsInfo{}
+	for _, record := range records {
+		if strings.HasPrefix(strings.TrimSpace(record[1]), "[") {
+			continue
+		}
+		correctable, err := parseSMIUint(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid remapped_rows.correctable value %q: %v", record[1], err)
+		}
+		uncorrectable, err := parseSMIUint(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid remapped_rows.uncorrectable value %q: %v", record[2], err)
+		}
+
+		rows[strings.TrimSpace(record[0])] = RemappedRowsInfo{
+			Correctable:   correctable,
+			Uncorrectable: uncorrectable,
+			Pending:       parseSMIYes(record[3]),
+			Failure:       parseSMIYes(record[4]),
+		}
+	}
+	return rows, nil
+}
+
+// parseNvidiaSMIVersions reads the versions from the output of nvidia-smi -q.
+// Drivers before CUDA 10 do not report the CUDA version.
+func parseNvidiaSMIVersions(output []byte) Versions {
+	var versions Versions
+	for _, line := range strings.Split(string(output), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "Driver Version":
+			versions.Driver = strings.TrimSpace(parts[1])
+		case "CUDA Version":
+			versions.CUDA = strings.TrimSpace(parts[1])
+		}
+	}
+	return versions
+}
+
+// smiTemperatures holds the temperatures of a GPU only nvidia-smi -q reports.
+type smiTemperatures struct {
+	Thresholds TemperatureThresholds
+	// Memory is nil if the GPU does not report its memory temperature.
+	Memory *uint
+}
+
+// parseNvidiaSMITemperatures reads the temperature thresholds and the memory
+// temperature by PCI bus ID from the output of nvidia-smi -q -d TEMPERATURE:
+//
+//	GPU 00000000:08:00.0
+//	    Temperature
+//	        GPU Current Temp                  : 35 C
+//	        GPU Shutdown Temp                 : 95 C
+//	        GPU Slowdown Temp                 : 92 C
+//	        Memory Current Temp               : 41 C
+//
+// GPUs without HBM report the memory temperature as N/A.
+func parseNvidiaSMITemperatures(output []byte) map[string]smiTemperatures {
+	temperatures := map[string]smiTemperatures{}
+	var busID string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "GPU ") {
+			busID = strings.TrimSpace(strings.TrimPrefix(line, "GPU "))
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if busID == "" || len(parts) != 2 {
+			continue
+		}
+		value, err := parseSMIOptionalUint(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(parts[1]), "C")))
+		if err != nil {
+			continue
+		}
+
+		t := temperatures[busID]
+		switch strings.TrimSpace(parts[0]) {
+		case "GPU Slowdown Temp":
+			t.Thresholds.Slowdown = uint(value)
+		case "GPU Shutdown Temp":
+			t.Thresholds.Shutdown = uint(value)
+		case "Memory Current Temp":
+			t.Memory = toUintP(uint(value))
+		default:
+			continue
+		}
+		temperatures[busID] = t
+	}
+	return temperatures
+}
+
+// parseNvidiaSMIVGPUs parses the vGPUs of the output of nvidia-smi vgpu -q,
+// which lists the active vGPUs of every GPU:
+//
+//	GPU 00000000:3B:00.0
+//	    Active vGPUs                      : 1
+//	    vGPU ID                           : 3251634191
+//	        VM UUID                       : ee7b7a4b-388a-4357-a425-5318b2c65b3f
+//	        vGPU Name                     : GRID V100-4C
+//	        FB Memory Usage
+//	            Used                      : 312 MiB
+//	            Free                      : 3784 MiB
+//	        Utilization
+//	            Gpu                       : 12 %
+//
+// Values the vGPU does not report, reported as "N/A", are read as zero or
+// empty.
+func parseNvidiaSMIVGPUs(output []byte) []VGPU {
+	var vgpus []VGPU
+	var busID, section string
+	var sectionIndent int
+	var vgpu *VGPU
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "GPU ") {
+			busID = strings.TrimSpace(strings.TrimPrefix(line, "GPU "))
+			vgpu = nil
+			continue
+		}
+
+		// The values of a section, like FB Memory Usage, are indented below
+		// its name.
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if section != "" && indent <= sectionIndent {
+			section = ""
+		}
+		parts := strings.SplitN(line, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 {
+			if name != "" {
+				section, sectionIndent = name, indent
+			}
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		if value == "N/A" {
+			value = ""
+		}
+		if name == "vGPU ID" {
+			vgpus = append(vgpus, VGPU{ID: value, GPUBusID: busID})
+			vgpu = &vgpus[len(vgpus)-1]
+			section = ""
+			continue
+		}
+		if vgpu == nil {
+			continue
+		}
+
+		number := func(unit string) uint64 {
+			n, _ := parseSMIOptionalUint(strings.TrimSpace(strings.TrimSuffix(value, unit)))
+			return n
+		}
+		switch section + "/" + name {
+		case "/VM UUID", "/VM ID":
+			vgpu.VMUUID = parseSMIOptionalString(value)
+		case "/VM Name":
+			vgpu.VMName = parseSMIOptionalString(value)
+		case "/vGPU Name":
+			vgpu.Name = parseSMIOptionalString(value)
+		case "/vGPU Type":
+			vgpu.Type = parseSMIOptionalString(value)
+		case "/vGPU UUID":
+			vgpu.UUID = parseSMIOptionalString(value)
+		case "/Guest Driver Version":
+			vgpu.GuestDriverVersion = parseSMIOptionalString(value)
+		case "/License Status":
+			vgpu.LicenseStatus = parseSMIOptionalString(value)
+		case "FB Memory Usage/Total":
+			vgpu.Memory.GlobalTotal = number("MiB")
+		case "FB Memory Usage/Used":
+			vgpu.Memory.GlobalUsed = number("MiB")
+		case "FB Memory Usage/Free":
+			vgpu.Memory.GlobalFree = number("MiB")
+		case "Utilization/Gpu":
+			vgpu.Utilization.GPU = uint(number("%"))
+		case "Utilization/Memory":
+			vgpu.Utilization.Memory = uint(number("%"))
+		case "Utilization/Encoder":
+			vgpu.Utilization.Encoder = uint(number("%"))
+		case "Utilization/Decoder":
+			vgpu.Utilization.Decoder = uint(number("%"))
+		}
+	}
+
+	// Older drivers report the total memory, newer ones the free memory.
+	for i := range vgpus {
+		memory := &vgpus[i].Memory
+		if memory.GlobalTotal == 0 {
+			memory.GlobalTotal = memory.GlobalUsed + memory.GlobalFree
+		} else if memory.GlobalFree == 0 && memory.GlobalTotal > memory.GlobalUsed {
+			memory.GlobalFree = memory.GlobalTotal - memory.GlobalUsed
+		}
+	}
+	return vgpus
+}
+
+var (
+	nvidiaSMIGPURegexp  = regexp.MustCompile(`^GPU ([0-9]+): .*\(UUID: ([^)]+)\)`)
+	nvidiaSMILinkRegexp = regexp.MustCompile(`^Link ([0-9]+): (.*)$`)
+)
+
+// nvlinkParser merges the outputs of the nvidia-smi nvlink commands, which
+// list the links of every GPU:
+//
+//	GPU 0: Tesla V100-SXM2-16GB (UUID: GPU-...)
+//	     Link 0: 25.781 GB/s
+//	     Link 0: Data Tx: 3204 KiB
+//	     Link 0: Replay Errors: 0
+type nvlinkParser struct {
+	links []NVLink
+}
+
+func (p *nvlinkParser) parse(output []byte) error {
+	var gpuIndex *uint
+	var gpuUUID string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if match := nvidiaSMIGPURegexp.FindStringSubmatch(line); match != nil {
+			index, err := parseSMIUint(match[1])
+			if err != nil {
+				return fmt.Errorf("nvidia-smi nvlink: invalid gpu index %q: %v", match[1], err)
+			}
+			gpuIndex = toUintP(uint(index))
+			gpuUUID = match[2]
+			continue
+		}
+
+		match := nvidiaSMILinkRegexp.FindStringSubmatch(line)
+		if match == nil || gpuIndex == nil {
+			continue
+		}
+		number, err := parseSMIUint(match[1])
+		if err != nil {
+			return fmt.Errorf("nvidia-smi nvlink: invalid link %q: %v", match[1], err)
+		}
+		link := p.link(*gpuIndex, gpuUUID, uint(number))
+
+		parts := strings.SplitN(match[2], ":", 2)
+		if len(parts) == 1 {
+			// Link status, the link speed or <inactive>.
+			link.Active = !strings.Contains(parts[0], "inactive")
+			continue
+		}
+
+		value := strings.Fields(parts[1])
+		if len(value) == 0 {
+			continue
+		}
+		counter, err := parseSMIOptionalUint(value[0])
+		if err != nil {
+			return fmt.Errorf("nvidia-smi nvlink: invalid %s value %q: %v", parts[0], parts[1], err)
+		}
+		switch parts[0] {
+		case "Data Tx":
+			link.DataTX = counter
+		case "Data Rx":
+			link.DataRX = counter
+		case "Replay Errors":
+			link.ReplayErrors = counter
+		case "Recovery Errors":
+			link.RecoveryErrors = counter
+		case "CRC Errors":
+			link.CRCErrors = counter
+		}
+	}
+	return nil
+}
+
+func (p *nvlinkParser) link(gpuIndex uint, gpuUUID string, number uint) *NVLink {
+	for i := range p.links {
+		if *p.links[i].GPUIndex == gpuIndex && p.links[i].Link == number {
+			return &p.links[i]
+		}
+	}
+	p.links = append(p.links, NVLink{GPUIndex: toUintP(gpuIndex), GPUUUID: gpuUUID, Link: number})
+	return &p.links[len(p.links)-1]
+}
+
+var ansiEscapeRegexp = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// parseNvidiaSMITopology reads the matrix of nvidia-smi topo -m, a tab
+// separated table with a row per GPU or network device, followed by a legend:
+//
+//	        GPU0    GPU1    mlx5_0  CPU Affinity    NUMA Affinity
+//	GPU0     X      NV2     PIX     0-19,40-59      0
+//	GPU1    NV2      X      SYS     0-19,40-59      0
+//	mlx5_0  PIX     SYS      X
+//
+// Only the rows of the GPUs are returned.
+func parseNvidiaSMITopology(output []byte) ([]GPUTopology, error) {
+	var header []string
+	var topology []GPUTopology
+	for _, line := range strings.Split(ansiEscapeRegexp.ReplaceAllString(string(output), ""), "\n") {
+		if strings.TrimSpace(line) == "" {
+			if header != nil {
+				break
+			}
+			continue
+		}
+
+		columns := strings.Split(line, "\t")
+		for i := range columns {
+			columns[i] = strings.TrimSpace(columns[i])
+		}
+		if header == nil {
+			header = columns
+			continue
+		}
+
+		if !strings.HasPrefix(columns[0], "GPU") {
+			continue
+		}
+		index, err := parseSMIUint(strings.TrimPrefix(columns[0], "GPU"))
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi topo: invalid gpu %q: %v", columns[0], err)
+		}
+
+		gpu := GPUTopology{Index: uint(index)}
+		for i := 1; i < len(columns) && i < len(header); i++ {
+			switch header[i] {
+			case "CPU Affinity":
+				gpu.CPUAffinity = columns[i]
+			case "NUMA Affinity":
+				gpu.NUMAAffinity = columns[i]
+			default:
+				if columns[i] == "X" || columns[i] == "" || strings.Contains(header[i], " ") {
+					continue
+				}
+				gpu.Links = append(gpu.Links, TopologyLink{Peer: header[i], Type: columns[i]})
+			}
+		}
+		topology = append(topology, gpu)
+	}
+
+	if header == nil {
+		return nil, fmt.Errorf("nvidia-smi topo: no topology matrix in output")
+	}
+	return topology, nil
+}
+
+func parseNvidiaSMIInventory(output []byte) ([]DeviceInventory, error) {
+	reader := csv.NewReader(bytes.NewReader(output))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = 8
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]DeviceInventory, 0, len(records))
+	for _, record := range records {
+		index, err := parseSMIUint(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid index value %q: %v", record[0], err)
+		}
+		maxPowerLimit, err := parseSMIPower(record[6])
+		if err != nil && err != errSMINotSupported {
+			return nil, fmt.Errorf("nvidia-smi: invalid power.max_limit value %q: %v", record[6], err)
+		}
+		memoryTotal, err := parseSMIUint(record[7])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid memory.total value %q: %v", record[7], err)
+		}
+
+		devices = append(devices, DeviceInventory{
+			Index:         toUintP(uint(index)),
+			UUID:          strings.TrimSpace(record[1]),
+			Name:          strings.TrimSpace(record[2]),
+			Serial:        parseSMIOptionalString(record[3]),
+			VBIOSVersion:  parseSMIOptionalString(record[4]),
+			PCIBusID:      strings.TrimSpace(record[5]),
+			MaxPowerLimit: maxPowerLimit,
+			MemoryTotal:   memoryTotal,
+		})
+	}
+	return devices, nil
+}
+
+// parseNvidiaSMIBoardPartNumbers parses the board part numbers of the
+// nvidia-smi -q report by PCI bus ID. GPUs that do not report one are left
+// out.
+func parseNvidiaSMIBoardPartNumbers(output []byte) map[string]string {
+	partNumbers := map[string]string{}
+	var busID string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "GPU ") {
+			busID = strings.TrimSpace(strings.TrimPrefix(line, "GPU "))
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if busID == "" || len(parts) != 2 || strings.TrimSpace(parts[0]) != "Board Part Number" {
+			continue
+		}
+		if value := strings.TrimSpace(parts[1]); value != "" && value != "N/A" {
+			partNumbers[busID] = value
+		}
+	}
+	return partNumbers
+}
+
+// parseNvidiaSMIFabric parses the fabric state of the GPUs from the Fabric
+// section of the nvidia-smi -q report, which drivers report from R525 on:
+//
+//	GPU 00000000:07:00.0
+//	    GPU UUID                              : GPU-5b6c1b1a-...
+//	    Fabric
+//	        State                             : Completed
+//	        Status                            : Success
+//
+// GPUs that are not attached to NVSwitches report N/A and are left out.
+func parseNvidiaSMIFabric(output []byte) []GPUFabric {
+	var gpus []GPUFabric
+	var gpu *GPUFabric
+	fabricIndent := -1
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "GPU ") {
+			gpus = append(gpus, GPUFabric{BusID: strings.TrimSpace(strings.TrimPrefix(line, "GPU "))})
+			gpu = &gpus[len(gpus)-1]
+			fabricIndent = -1
+			continue
+		}
+		if gpu == nil {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if strings.TrimSpace(line) == "Fabric" {
+			fabricIndent = indent
+			continue
+		}
+		if fabricIndent >= 0 && indent <= fabricIndent {
+			fabricIndent = -1
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch {
+		case key == "GPU UUID":
+			gpu.UUID = value
+		case fabricIndent >= 0 && key == "State" && value != "N/A":
+			gpu.State = normalizeFabricValue(value)
+		case fabricIndent >= 0 && key == "Status" && value != "N/A":
+			gpu.Status = normalizeFabricValue(value)
+		}
+	}
+
+	fabric := make([]GPUFabric, 0, len(gpus))
+	for _, gpu := range gpus {
+		if gpu.State != "" {
+			fabric = append(fabric, gpu)
+		}
+	}
+	return fabric
+}
+
+func parseNvidiaSMIAccountedApps(output []byte) ([]AccountedProcess, error) {
+	reader := csv.NewReader(bytes.NewReader(output))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = 6
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make([]AccountedProcess, 0, len(records))
+	for _, record := range records {
+		pid, err := parseSMIUint(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid pid value %q: %v", record[0], err)
+		}
+
+		// Values the driver did not record are reported as [N/A].
+		var values [4]uint64
+		for i, name := range []string{"gpu_utilization", "mem_utilization", "max_memory_usage", "time"} {
+			if values[i], err = parseSMIOptionalUint(record[i+2]); err != nil {
+				return nil, fmt.Errorf("nvidia-smi: invalid %s value %q: %v", name, record[i+2], err)
+			}
+		}
+
+		processes = append(processes, AccountedProcess{
+			PID:     uint(pid),
+			GPUUUID: strings.TrimSpace(record[1]),
+			Utilization: UtilizationInfo{
+				GPU:    uint(values[0]),
+				Memory: uint(values[1]),
+			},
+			MaxMemoryUsed: values[2],
+			Time:          values[3],
+		})
+	}
+	return processes, nil
+}
+
+func parseNvidiaSMIProcesses(output []byte) ([]ProcessInfo, error) {
+	reader := csv.NewReader(bytes.NewReader(output))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = 4
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make([]ProcessInfo, 0, len(records))
+	for _, record := range records {
+		pid, err := parseSMIUint(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid pid value %q: %v", record[0], err)
+		}
+		memory, err := parseSMIUint(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid used_memory value %q: %v", record[2], err)
+		}
+
+		processes = append(processes, ProcessInfo{
+			PID:        uint(pid),
+			Name:       strings.TrimSpace(record[1]),
+			MemoryUsed: memory,
+			GPUUUID:    strings.TrimSpace(record[3]),
+		})
+	}
+	return processes, nil
+}
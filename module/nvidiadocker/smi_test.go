@@ -0,0 +1,604 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseNvidiaSMIOutput(t *testing.T) {
+	output := []byte("0, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, Tesla P40, 00000000:08:00.0, [N/A], [N/A], [N/A], " +
+		"Active, Not Active, Not Active, Not Active, Not Active, " +
+		"[N/A], [N/A], [N/A], [N/A], [Not Supported], P8, Default, Disabled, 10, 2, 35, 1024, 22912, 21888, 0, 2017/01/31 10:20:30.123, 1, 3, 16, 16, Not Active, Not Active, Not Active, 0, 0, 0\n" +
+		"1, GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6, Tesla P40, 00000000:0B:00.0, 187.52, 250.00, 225.00, " +
+		"Not Active, Not Active, Active, Not Active, Not Active, " +
+		"3, 0, 112, 1, 100, P0, Exclusive_Process, Enabled, 87, 45, 71, 20480, 22912, 2432, 1, 2017/01/31 10:20:30.123, 3, 3, 8, 16, Active, [N/A], Not Active, 4, 59, 1840\n")
+
+	devices, err := parseNvidiaSMIOutput(output, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+
+	if devices[0].Power != 0 || devices[0].PowerLimit != 0 {
+		t.Fatalf("expected unsupported power values to be zero, got %+v", devices[0])
+	}
+
+	if devices[0].FanSpeed != 0 || devices[0].PerformanceState != "P8" ||
+		devices[0].ComputeMode != "Default" || *devices[0].PersistenceMode {
+		t.Fatalf("unexpected fan speed or performance state %+v", devices[0])
+	}
+
+	if !devices[0].ThrottleReasons.GPUIdle {
+		t.Fatalf("expected gpu idle throttle reason, got %+v", devices[0].ThrottleReasons)
+	}
+
+	device := devices[1]
+	if *device.Index != 1 || device.UUID != "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6" ||
+		device.Name != "Tesla P40" || device.PCI.BusID != "00000000:0B:00.0" || device.Power != 187.52 ||
+		device.PowerLimit != 250 || device.PowerEnforcedLimit != 225 || device.Utilization.GPU != 87 || device.Utilization.Memory != 45 ||
+		device.Temperature != 71 || device.Memory.GlobalUsed != 20480 ||
+		device.Memory.GlobalTotal != 22912 || device.Memory.GlobalFree != 2432 ||
+		device.ThrottleReasons != (ThrottleReasonsInfo{SWPowerCap: true, HWThermalSlowdown: true}) ||
+		device.ECC != (ECCInfo{Volatile: ECCErrorCounts{3, 0}, Aggregate: ECCErrorCounts{112, 1}}) ||
+		device.FanSpeed != 100 || device.PerformanceState != "P0" ||
+		device.ComputeMode != "Exclusive_Process" || !*device.PersistenceMode ||
+		device.EncoderStats != (EncoderStatsInfo{SessionCount: 4, AverageFPS: 59, AverageLatency: 1840}) ||
+		device.MinorNumber == nil || *device.MinorNumber != 1 ||
+		device.PCI.Link != (PCILinkInfo{Gen: 3, Width: 8}) || device.PCI.MaxLink != (PCILinkInfo{Gen: 3, Width: 16}) {
+		t.Fatalf("unexpected device status %+v", device)
+	}
+
+	timestamp := time.Date(2017, time.January, 31, 10, 20, 30, 123000000, time.Local)
+	if !device.Timestamp.Equal(timestamp) {
+		t.Fatalf("expected timestamp %v, got %v", timestamp, device.Timestamp)
+	}
+}
+
+func TestParseNvidiaSMIOutputInvalid(t *testing.T) {
+	testData := "0, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, Tesla P40, 10, 2, 35\n"
+	if _, err := parseNvidiaSMIOutput([]byte(testData), nil); err == nil {
+		t.Fatalf("expected error for %q", testData)
+	}
+}
+
+func TestParseNvidiaSMIOutputInvalidFields(t *testing.T) {
+	output := []byte("0, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, Tesla P40, 00000000:08:00.0, 50.00, 250.00, 250.00, " +
+		"Active, Not Active, Not Active, Not Active, Not Active, " +
+		"0, 0, 0, 0, 23, P0, Default, Enabled, 10, 2, [Unknown Error], 1024, 22912, 21888, 0, 2017/01/31 10:20:30.123, 3, 3, 16, 16, Not Active, Not Active, Not Active, 0, 0, 0\n" +
+		"x, GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6, Tesla P40, 00000000:0B:00.0, 187.52, 250.00, 225.00, " +
+		"Not Active, Not Active, Active, Not Active, Not Active, " +
+		"3, 0, 112, 1, 100, P0, Exclusive_Process, Enabled, 87, 45, 71, 20480, 22912, 2432, 1, 2017/01/31 10:20:30.123, 3, 3, 8, 16, Active, [N/A], Not Active, 4, 59, 1840\n")
+
+	devices, err := parseNvidiaSMIOutput(output, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+
+	if devices[0].Temperature != 0 || devices[0].Utilization.GPU != 10 || devices[0].Memory.GlobalTotal != 22912 ||
+		len(devices[0].InvalidFields) != 1 || devices[0].InvalidFields["temperature.gpu"] != "[Unknown Error]" {
+		t.Fatalf("expected only the temperature to be invalid, got %+v", devices[0])
+	}
+
+	if devices[1].Index != nil || devices[1].Temperature != 71 || devices[1].InvalidFields["index"] != "x" {
+		t.Fatalf("expected only the index to be invalid, got %+v", devices[1])
+	}
+}
+
+func TestParseNvidiaSMIOutputNotSupported(t *testing.T) {
+	output := []byte("0, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, GRID T4-8Q, 00000000:08:00.0, [N/A], [N/A], [N/A], " +
+		"Not Active, Not Active, Not Active, Not Active, Not Active, " +
+		"[N/A], [N/A], [N/A], [N/A], [N/A], P0, Default, Enabled, [Not Supported], [Not Supported], [Insufficient Permissions], 1024, 8192, 7168, 0, 2017/01/31 10:20:30.123, [N/A], [N/A], [N/A], [N/A], Not Active, Not Active, Not Active, [Not Supported], [Not Supported], [Not Supported]\n")
+
+	devices, err := parseNvidiaSMIOutput(output, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"power.draw", "power.limit", "enforced.power.limit", "utilization.gpu", "utilization.memory", "temperature.gpu",
+		"encoder.stats.sessionCount", "encoder.stats.averageFps", "encoder.stats.averageLatency"}
+	if len(devices) != 1 || !reflect.DeepEqual(devices[0].Unsupported, expected) || devices[0].InvalidFields != nil {
+		t.Fatalf("expected unsupported %v, got %+v", expected, devices)
+	}
+}
+
+func TestSMICollectorWithoutOptionalFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An older driver, rejecting the throttle reasons it does not know.
+	path := filepath.Join(dir, "nvidia-smi")
+	script := `#!/bin/sh
+case "$1" in
+*hw_thermal_slowdown*)
+	echo 'Field "clocks_throttle_reasons.hw_thermal_slowdown" is not a valid field to query.'
+	exit 2;;
+--query-gpu=*)
+	echo '0, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, Tesla P40, 00000000:08:00.0, 50.00, 250.00, 250.00, Active, Not Active, Not Active, Not Active, Not Active, 0, 0, 0, 0, 23, P0, Default, Enabled, 10, 2, 35, 1024, 22912, 21888, 0, 2017/01/31 10:20:30.123, 3, 3, 16, 16';;
+*)
+	exit 1;;
+esac
+`
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	collector := &smiCollector{runner: commandRunner{timeout: 5 * time.Second}, path: path}
+	for i := 0; i < 2; i++ {
+		devices, err := collector.Query(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(devices) != 1 || devices[0].Utilization.GPU != 10 || len(devices[0].Unsupported) != len(nvidiaSMIOptionalQueryFields) {
+			t.Fatalf("unexpected devices %+v", devices)
+		}
+	}
+	if !collector.withoutOptional {
+		t.Fatal("expected the optional fields to be dropped")
+	}
+}
+
+func TestParseNvidiaSMIProcesses(t *testing.T) {
+	output := []byte("2781, python, 10873, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822\n" +
+		"3012, /usr/bin/ffmpeg, 312, GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6\n")
+
+	processes, err := parseNvidiaSMIProcesses(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(processes) != 2 {
+		t.Fatalf("expected 2 processes, got %d", len(processes))
+	}
+
+	process := processes[0]
+	if process.PID != 2781 || process.Name != "python" || process.MemoryUsed != 10873 ||
+		process.GPUUUID != "GPU-66a2874a-837d-cd53-ab26-0d2d842d9822" {
+		t.Fatalf("unexpected process %+v", process)
+	}
+}
+
+func TestParseNvidiaSMIDmon(t *testing.T) {
+	output := []byte("# gpu    sm   mem   enc   dec  rxpci  txpci\n" +
+		"# Idx     %     %     %     %   MB/s   MB/s\n" +
+		"    0     3     1    87    12   1532     48\n" +
+		"    1     -     -     -     -      -      -\n")
+
+	samples, err := parseNvidiaSMIDmon(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(samples))
+	}
+	if samples[0]["enc"] != 87 || samples[0]["dec"] != 12 ||
+		samples[0]["rxpci"] != 1532 || samples[0]["txpci"] != 48 {
+		t.Fatalf("unexpected sample %v", samples[0])
+	}
+	if samples[1]["enc"] != 0 || samples[1]["rxpci"] != 0 {
+		t.Fatalf("unexpected sample %v", samples[1])
+	}
+
+	testDatas := []string{
+		"    0   abc     48\n",
+		"# gpu  rxpci  txpci\n    0   abc     48\n",
+		"# gpu  rxpci  txpci\n    0   12\n",
+	}
+	for _, testData := range testDatas {
+		if _, err := parseNvidiaSMIDmon([]byte(testData)); err == nil {
+			t.Fatalf("expected error for %q", testData)
+		}
+	}
+}
+
+func TestParseNvidiaSMIHealth(t *testing.T) {
+	output := []byte("0, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, 0, 0, No\n" +
+		"1, GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6, 12, 1, Yes\n" +
+		"2, GPU-149648d8-7e32-715a-b5c3-fe6df5976c7e, [N/A], [N/A], [N/A]\n")
+
+	devices, err := parseNvidiaSMIHealth(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 3 {
+		t.Fatalf("expected 3 devices, got %d", len(devices))
+	}
+
+	device := devices[1]
+	if *device.Index != 1 || device.UUID != "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6" ||
+		device.RetiredPages != (RetiredPagesInfo{SingleBit: 12, DoubleBit: 1, Pending: true}) {
+		t.Fatalf("unexpected device health %+v", device)
+	}
+	if devices[2].RetiredPages != (RetiredPagesInfo{}) {
+		t.Fatalf("expected unsupported values to be zero, got %+v", devices[2])
+	}
+
+	if _, err := parseNvidiaSMIHealth([]byte("0, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, abc, 0, No\n")); err == nil {
+		t.Fatal("expected error for invalid retired_pages.sbe value")
+	}
+}
+
+func TestParseNvidiaSMIRemappedRows(t *testing.T) {
+	output := []byte("GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, 0, 0, 0, 0\n" +
+		"GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6, 3, 1, 1, 0\n" +
+		"GPU-149648d8-7e32-715a-b5c3-fe6df5976c7e, [N/A], [N/A], [N/A], [N/A]\n")
+
+	rows, err := parseNvidiaSMIRemappedRows(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]RemappedRowsInfo{
+		"GPU-66a2874a-837d-cd53-ab26-0d2d842d9822": {},
+		"GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6": {Correctable: 3, Uncorrectable: 1, Pending: true},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, rows)
+	}
+
+	if _, err := parseNvidiaSMIRemappedRows([]byte("GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, abc, 0, 0, 0\n")); err == nil {
+		t.Fatal("expected error for invalid remapped_rows.correctable value")
+	}
+}
+
+func TestParseNvidiaSMIFabric(t *testing.T) {
+	output := "==============NVSMI LOG==============\n\n" +
+		"Driver Version                            : 535.129.03\n" +
+		"Attached GPUs                             : 3\n" +
+		"GPU 00000000:07:00.0\n" +
+		"    Product Name                          : NVIDIA H100 80GB HBM3\n" +
+		"    GPU UUID                              : GPU-5b6c1b1a-2f4c-4a1e-9d7b-0c8f3e6a1d22\n" +
+		"    Fabric\n" +
+		"        State                             : Completed\n" +
+		"        Status                            : Success\n" +
+		"    Inforom Version\n" +
+		"        Image Version                     : G520.0200.00.05\n" +
+		"GPU 00000000:0F:00.0\n" +
+		"    GPU UUID                              : GPU-8e1f0c3d-6a2b-4c5d-8e9f-1a2b3c4d5e6f\n" +
+		"    Fabric\n" +
+		"        State                             : In Progress\n" +
+		"        Status                            : N/A\n" +
+		"    GPU Operation Mode\n" +
+		"        State                             : N/A\n" +
+		"GPU 00000000:47:00.0\n" +
+		"    GPU UUID                              : GPU-66a2874a-837d-cd53-ab26-0d2d842d9822\n" +
+		"    Fabric\n" +
+		"        State                             : N/A\n" +
+		"        Status                            : N/A\n"
+
+	expected := []GPUFabric{
+		{UUID: "GPU-5b6c1b1a-2f4c-4a1e-9d7b-0c8f3e6a1d22", BusID: "00000000:07:00.0", State: "completed", Status: "success"},
+		{UUID: "GPU-8e1f0c3d-6a2b-4c5d-8e9f-1a2b3c4d5e6f", BusID: "00000000:0F:00.0", State: "in_progress"},
+	}
+	if fabric := parseNvidiaSMIFabric([]byte(output)); !reflect.DeepEqual(fabric, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, fabric)
+	}
+}
+
+func TestParseNvidiaSMIVersions(t *testing.T) {
+	output := []byte("\n==============NVSMI LOG==============\n\n" +
+		"Timestamp                                 : Mon Oct 12 09:41:02 2026\n" +
+		"Driver Version                            : 535.104.05\n" +
+		"CUDA Version                              : 12.2\n\n" +
+		"Attached GPUs                             : 1\n" +
+		"GPU 00000000:08:00.0\n" +
+		"    Product Name                          : Tesla P40\n")
+
+	versions := parseNvidiaSMIVersions(output)
+	if versions != (Versions{Driver: "535.104.05", CUDA: "12.2"}) {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+}
+
+func TestNVLinkParser(t *testing.T) {
+	outputs := []string{
+		"GPU 0: Tesla V100-SXM2-16GB (UUID: GPU-66a2874a-837d-cd53-ab26-0d2d842d9822)\n" +
+			"\t Link 0: 25.781 GB/s\n" +
+			"\t Link 1: <inactive>\n" +
+			"GPU 1: Tesla V100-SXM2-16GB (UUID: GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6)\n" +
+			"\t Link 0: 25.781 GB/s\n",
+		"GPU 0: Tesla V100-SXM2-16GB (UUID: GPU-66a2874a-837d-cd53-ab26-0d2d842d9822)\n" +
+			"\t Link 0: Data Tx: 3204 KiB\n" +
+			"\t Link 0: Data Rx: 3202 KiB\n" +
+			"GPU 1: Tesla V100-SXM2-16GB (UUID: GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6)\n" +
+			"\t Link 0: Data Tx: 18 KiB\n" +
+			"\t Link 0: Data Rx: 20 KiB\n",
+		"GPU 0: Tesla V100-SXM2-16GB (UUID: GPU-66a2874a-837d-cd53-ab26-0d2d842d9822)\n" +
+			"\t Link 0: Replay Errors: 2\n" +
+			"\t Link 0: Recovery Errors: 0\n" +
+			"\t Link 0: CRC Errors: 5\n",
+	}
+
+	parser := &nvlinkParser{}
+	for _, output := range outputs {
+		if err := parser.parse([]byte(output)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(parser.links) != 3 {
+		t.Fatalf("expected 3 links, got %d", len(parser.links))
+	}
+
+	link := parser.links[0]
+	if *link.GPUIndex != 0 || link.GPUUUID != "GPU-66a2874a-837d-cd53-ab26-0d2d842d9822" || link.Link != 0 ||
+		!link.Active || link.DataTX != 3204 || link.DataRX != 3202 ||
+		link.ReplayErrors != 2 || link.RecoveryErrors != 0 || link.CRCErrors != 5 {
+		t.Fatalf("unexpected link %+v", link)
+	}
+	if parser.links[1].Link != 1 || parser.links[1].Active {
+		t.Fatalf("expected inactive link, got %+v", parser.links[1])
+	}
+	if *parser.links[2].GPUIndex != 1 || parser.links[2].DataTX != 18 {
+		t.Fatalf("unexpected link %+v", parser.links[2])
+	}
+
+	if err := parser.parse([]byte("GPU 0: Tesla V100 (UUID: GPU-0)\n\t Link 0: CRC Errors: abc\n")); err == nil {
+		t.Fatal("expected error for invalid counter")
+	}
+}
+
+func TestParseNvidiaSMITopology(t *testing.T) {
+	output := "\t\x1b[4mGPU0\tGPU1\tGPU2\tmlx5_0\tCPU Affinity\tNUMA Affinity\tGPU NUMA ID\x1b[0m\n" +
+		"GPU0\t X \tNV2\tSYS\tPIX\t0-19,40-59\t0\t\tN/A\n" +
+		"GPU1\tNV2\t X \tSYS\tPIX\t0-19,40-59\t0\t\tN/A\n" +
+		"GPU2\tSYS\tSYS\t X \tSYS\t20-39,60-79\t1\t\tN/A\n" +
+		"mlx5_0\tPIX\tPIX\tSYS\t X \n" +
+		"\n" +
+		"Legend:\n" +
+		"\n" +
+		"  X    = Self\n" +
+		"  SYS  = Connection traversing PCIe as well as the SMP interconnect between NUMA nodes\n"
+
+	topology, err := parseNvidiaSMITopology([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topology) != 3 {
+		t.Fatalf("expected 3 gpus, got %d", len(topology))
+	}
+
+	gpu := topology[2]
+	if gpu.Index != 2 || gpu.CPUAffinity != "20-39,60-79" || gpu.NUMAAffinity != "1" {
+		t.Fatalf("unexpected gpu %+v", gpu)
+	}
+
+	links := topology[0].Links
+	expected := []TopologyLink{
+		{Peer: "GPU1", Type: "NV2"},
+		{Peer: "GPU2", Type: "SYS"},
+		{Peer: "mlx5_0", Type: "PIX"},
+	}
+	if len(links) != len(expected) {
+		t.Fatalf("expected %d links, got %+v", len(expected), links)
+	}
+	for i := range expected {
+		if links[i] != expected[i] {
+			t.Fatalf("expected link %+v, got %+v", expected[i], links[i])
+		}
+	}
+
+	if _, err := parseNvidiaSMITopology([]byte("\n")); err == nil {
+		t.Fatal("expected error for empty output")
+	}
+}
+
+func TestParseNvidiaSMIAccountedApps(t *testing.T) {
+	output := "23421, GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67, 87, 42, 10240, 3600500\n" +
+		"23502, GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67, [N/A], [N/A], 512, 1200\n"
+
+	processes, err := parseNvidiaSMIAccountedApps([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := AccountedProcess{
+		PID:           23421,
+		GPUUUID:       "GPU-723aa4d3-9c36-dc2b-5455-c91110eb7d67",
+		Utilization:   UtilizationInfo{GPU: 87, Memory: 42},
+		MaxMemoryUsed: 10240,
+		Time:          3600500,
+	}
+	if len(processes) != 2 || processes[0] != expected {
+		t.Fatalf("unexpected processes %+v", processes)
+	}
+	if processes[1].Utilization.GPU != 0 || processes[1].MaxMemoryUsed != 512 {
+		t.Fatalf("unexpected process %+v", processes[1])
+	}
+
+	if _, err := parseNvidiaSMIAccountedApps([]byte("abc, GPU-0, 1, 2, 3, 4\n")); err == nil {
+		t.Fatal("expected error for invalid pid")
+	}
+}
+
+func TestParseNvidiaSMITemperatures(t *testing.T) {
+	output := "==============NVSMI LOG==============\n\n" +
+		"Driver Version                            : 470.82.01\n" +
+		"Attached GPUs                             : 2\n" +
+		"GPU 00000000:08:00.0\n" +
+		"    Temperature\n" +
+		"        GPU Current Temp                  : 35 C\n" +
+		"        GPU Shutdown Temp                 : 95 C\n" +
+		"        GPU Slowdown Temp                 : 92 C\n" +
+		"        GPU Max Operating Temp            : N/A\n" +
+		"        Memory Current Temp               : N/A\n" +
+		"\n" +
+		"GPU 00000000:0B:00.0\n" +
+		"    Temperature\n" +
+		"        GPU Current Temp                  : 41 C\n" +
+		"        GPU Shutdown Temp                 : N/A\n" +
+		"        GPU Slowdown Temp                 : 90 C\n" +
+		"        Memory Current Temp               : 47 C\n"
+
+	temperatures := parseNvidiaSMITemperatures([]byte(output))
+	expected := map[string]smiTemperatures{
+		"00000000:08:00.0": {Thresholds: TemperatureThresholds{Slowdown: 92, Shutdown: 95}},
+		"00000000:0B:00.0": {Thresholds: TemperatureThresholds{Slowdown: 90}, Memory: toUintP(47)},
+	}
+	if !reflect.DeepEqual(temperatures, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, temperatures)
+	}
+}
+
+func TestParseNvidiaSMIInventory(t *testing.T) {
+	output := []byte("0, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, Tesla P40, 0324217045672, 86.02.23.00.01, 00000000:08:00.0, 250.00, 22912\n" +
+		"1, GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6, GeForce GTX 1080, [N/A], 86.04.17.00.80, 00000000:0B:00.0, [N/A], 8119\n")
+
+	devices, err := parseNvidiaSMIInventory(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+
+	device := devices[0]
+	if *device.Index != 0 || device.UUID != "GPU-66a2874a-837d-cd53-ab26-0d2d842d9822" ||
+		device.Name != "Tesla P40" || device.Serial != "0324217045672" || device.VBIOSVersion != "86.02.23.00.01" ||
+		device.PCIBusID != "00000000:08:00.0" || device.MaxPowerLimit != 250 || device.MemoryTotal != 22912 {
+		t.Fatalf("unexpected device inventory %+v", device)
+	}
+
+	device = devices[1]
+	if *device.Index != 1 || device.Serial != "" || device.MaxPowerLimit != 0 || device.MemoryTotal != 8119 {
+		t.Fatalf("expected unsupported values to be empty, got %+v", device)
+	}
+
+	if _, err := parseNvidiaSMIInventory([]byte("0, GPU-66a2874a, Tesla P40, 0324217045672, 86.02.23.00.01, 00000000:08:00.0, 250.00, abc\n")); err == nil {
+		t.Fatal("expected error for invalid memory.total")
+	}
+}
+
+func TestParseNvidiaSMIBoardPartNumbers(t *testing.T) {
+	output := "==============NVSMI LOG==============\n\n" +
+		"Driver Version                            : 470.82.01\n" +
+		"Attached GPUs                             : 2\n" +
+		"GPU 00000000:08:00.0\n" +
+		"    Product Name                          : Tesla P40\n" +
+		"    Board Part Number                     : 900-2G610-0000-000\n" +
+		"    GPU Part Number                       : 1B38-400-A1\n" +
+		"\n" +
+		"GPU 00000000:0B:00.0\n" +
+		"    Product Name                          : GeForce GTX 1080\n" +
+		"    Board Part Number                     : N/A\n"
+
+	partNumbers := parseNvidiaSMIBoardPartNumbers([]byte(output))
+	if len(partNumbers) != 1 || partNumbers["00000000:08:00.0"] != "900-2G610-0000-000" {
+		t.Fatalf("unexpected board part numbers %v", partNumbers)
+	}
+}
+
+func TestParseNvidiaSMIOutputExtraFields(t *testing.T) {
+	output := []byte("0, GPU-66a2874a-837d-cd53-ab26-0d2d842d9822, Tesla P40, 00000000:08:00.0, 50.00, 250.00, 250.00, " +
+		"Active, Not Active, Not Active, Not Active, Not Active, " +
+		"0, 0, 0, 0, 23, P0, Default, Enabled, 10, 2, 35, 1024, 22912, 21888, 0, 2017/01/31 10:20:30.123, 3, 3, 16, 16, Not Active, Not Active, Not Active, 0, 0, 0, 1531, [N/A]\n")
+
+	devices, err := parseNvidiaSMIOutput(output, []string{"clocks.max.sm", "inforom.oem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 1 || len(devices[0].Raw) != 1 || devices[0].Raw["clocks.max.sm"] != "1531" {
+		t.Fatalf("unexpected raw values %+v", devices)
+	}
+
+	if _, err := parseNvidiaSMIOutput(output, nil); err == nil {
+		t.Fatal("expected error for unexpected extra values")
+	}
+}
+
+func TestNewSMICollectorExtraFields(t *testing.T) {
+	config := DefaultConfig()
+	config.SMIPath = "/usr/local/nvidia/bin/nvidia-smi"
+	config.SMIExtraFields = []string{"clocks.max.sm"}
+	collector, err := newSMICollectorFromConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collector.path != config.SMIPath || len(collector.extraFields) != 1 {
+		t.Fatalf("unexpected collector %+v", collector)
+	}
+
+	for _, fields := range [][]string{{""}, {"clocks.max.sm,inforom.oem"}, {"clocks max"}} {
+		config.SMIExtraFields = fields
+		if _, err := newSMICollectorFromConfig(config); err == nil {
+			t.Fatalf("expected error for smi_extra_fields %q", fields)
+		}
+	}
+}
+
+func TestParseNvidiaSMIVGPUs(t *testing.T) {
+	output := "GPU 00000000:3B:00.0\n" +
+		"    Active vGPUs                          : 2\n" +
+		"    vGPU ID                               : 3251634191\n" +
+		"        VM UUID                           : ee7b7a4b-388a-4357-a425-5318b2c65b3f\n" +
+		"        VM Name                           : vdi-01\n" +
+		"        vGPU Name                         : GRID V100-4C\n" +
+		"        vGPU Type                         : 299\n" +
+		"        vGPU UUID                         : d471c7f2-0a53-11ec-afd3-38b06df18e37\n" +
+		"        Guest Driver Version              : 460.91.03\n" +
+		"        License Status                    : Licensed\n" +
+		"        FB Memory Usage\n" +
+		"            Used                          : 312 MiB\n" +
+		"            Free                          : 3784 MiB\n" +
+		"        Utilization\n" +
+		"            Gpu                           : 12 %\n" +
+		"            Memory                        : 3 %\n" +
+		"            Encoder                       : 0 %\n" +
+		"            Decoder                       : 0 %\n" +
+		"        Encoder Stats\n" +
+		"            Active Sessions               : 0\n" +
+		"    vGPU ID                               : 3251634192\n" +
+		"        VM ID                             : 2390\n" +
+		"        vGPU Name                         : GRID V100-4C\n" +
+		"        Guest Driver Version              : N/A\n" +
+		"        FB Memory Usage\n" +
+		"            Total                         : 4096 MiB\n" +
+		"            Used                          : N/A\n" +
+		"        Utilization\n" +
+		"            Gpu                           : N/A\n" +
+		"\n" +
+		"GPU 00000000:5E:00.0\n" +
+		"    Active vGPUs                          : 0\n"
+
+	vgpus := parseNvidiaSMIVGPUs([]byte(output))
+	expected := []VGPU{
+		{
+			ID:                 "3251634191",
+			Name:               "GRID V100-4C",
+			Type:               "299",
+			UUID:               "d471c7f2-0a53-11ec-afd3-38b06df18e37",
+			GPUBusID:           "00000000:3B:00.0",
+			VMUUID:             "ee7b7a4b-388a-4357-a425-5318b2c65b3f",
+			VMName:             "vdi-01",
+			GuestDriverVersion: "460.91.03",
+			LicenseStatus:      "Licensed",
+			Utilization:        UtilizationInfo{GPU: 12, Memory: 3},
+			Memory:             MemoryInfo{GlobalUsed: 312, GlobalFree: 3784, GlobalTotal: 4096},
+		},
+		{
+			ID:       "3251634192",
+			Name:     "GRID V100-4C",
+			GPUBusID: "00000000:3B:00.0",
+			VMUUID:   "2390",
+			Memory:   MemoryInfo{GlobalFree: 4096, GlobalTotal: 4096},
+		},
+	}
+	if !reflect.DeepEqual(vgpus, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, vgpus)
+	}
+}
@@ -0,0 +1,157 @@
+package nvidiadocker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// sshScheme is the scheme of the entries of the hosts option read over SSH.
+const sshScheme = "ssh"
+
+// SSHConfig configures the hosts read over SSH, listed in hosts as
+// ssh://user@host:port. The beat authenticates with the private key of
+// KeyFile, and checks the key of the hosts against KnownHostsFile. Both
+// default to the settings of the ssh client of the user running the beat.
+type SSHConfig struct {
+	KeyFile        string `config:"key_file"`
+	KnownHostsFile string `config:"known_hosts_file"`
+}
+
+// sshHost is a host the command line tools of the GPU sources and the docker
+// CLI are run on with the ssh client, for hosts the beat cannot be installed
+// on.
+type sshHost struct {
+	user, host, port string
+	config           SSHConfig
+}
+
+func newSSHHost(u *url.URL, config SSHConfig) *sshHost {
+	return &sshHost{
+		user:   u.User.Username(),
+		host:   u.Hostname(),
+		port:   u.Port(),
+		config: config,
+	}
+}
+
+// command returns the ssh command line running the given command on the
+// host. The ssh client never prompts, so that a host missing from the known
+// hosts or a key requiring a passphrase fails the command instead of blocking
+// the fetch.
+func (h *sshHost) command(name string, args []string) (string, []string) {
+	sshArgs := []string{"-o", "BatchMode=yes"}
+	if h.config.KeyFile != "" {
+		sshArgs = append(sshArgs, "-i", h.config.KeyFile, "-o", "IdentitiesOnly=yes")
+	}
+	if h.config.KnownHostsFile != "" {
+		sshArgs = append(sshArgs, "-o", "UserKnownHostsFile="+h.config.KnownHostsFile, "-o", "StrictHostKeyChecking=yes")
+	}
+	if h.port != "" {
+		sshArgs = append(sshArgs, "-p", h.port)
+	}
+	if h.user != "" {
+		sshArgs = append(sshArgs, "-l", h.user)
+	}
+
+	// The remote shell joins the arguments, they are quoted to reach the
+	// command unchanged.
+	quoted := make([]string, 0, len(args)+1)
+	for _, arg := range append([]string{name}, args...) {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return "ssh", append(sshArgs, h.host, "--", strings.Join(quoted, " "))
+}
+
+// shellQuote quotes the argument for a POSIX shell.
+func shellQuote(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=,:+@", r))
+	}) < 0 {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// sshDockerClient reads the containers of a host read over SSH with the
+// docker CLI of the host.
+type sshDockerClient struct {
+	runner commandRunner
+}
+
+func (c *sshDockerClient) ListContainers(opts docker.ListContainersOptions) (_ []docker.APIContainers, err error) {
+	defer func(start time.Time) {
+		observeDockerCall(start, err)
+	}(time.Now())
+
+	args := []string{"ps", "--quiet", "--no-trunc"}
+	if opts.All {
+		args = append(args, "--all")
+	}
+	output, _, err := c.runner.runOnce("docker", args...)
+	if err != nil {
+		return nil, err
+	}
+	ids := strings.Fields(string(output))
+	if len(ids) == 0 {
+		return []docker.APIContainers{}, nil
+	}
+
+	// The containers are listed from their inspection, which unlike the
+	// output of docker ps holds the labels unambiguously, with one command.
+	output, _, err = c.runner.runOnce("docker", append([]string{"inspect"}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	var inspected []json.RawMessage
+	if err := json.Unmarshal(output, &inspected); err != nil {
+		return nil, err
+	}
+
+	containers := make([]docker.APIContainers, 0, len(inspected))
+	for _, raw := range inspected {
+		container, _, err := parseContainerJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, docker.APIContainers{
+			ID:     container.ID,
+			Image:  container.Config.Image,
+			Names:  []string{container.Name},
+			Labels: container.Config.Labels,
+			State:  container.State.StateString(),
+		})
+	}
+	return containers, nil
+}
+
+func (c *sshDockerClient) InspectContainer(id string) (*docker.Container, error) {
+	container, _, err := c.InspectContainerWithRuntime(id)
+	return container, err
+}
+
+func (c *sshDockerClient) InspectContainerWithRuntime(id string) (_ *docker.Container, _ *ContainerRuntime, err error) {
+	defer func(start time.Time) {
+		observeDockerCall(start, err)
+	}(time.Now())
+
+	output, _, err := c.runner.runOnce("docker", "inspect", id)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such object") || strings.Contains(err.Error(), "No such container") {
+			return nil, nil, &docker.NoSuchContainer{ID: id}
+		}
+		return nil, nil, err
+	}
+	var inspected []json.RawMessage
+	if err := json.Unmarshal(output, &inspected); err != nil {
+		return nil, nil, err
+	}
+	if len(inspected) != 1 {
+		return nil, nil, fmt.Errorf("docker inspect %s: %d containers", id, len(inspected))
+	}
+	return parseContainerJSON(inspected[0])
+}
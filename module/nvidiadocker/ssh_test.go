@@ -0,0 +1,134 @@
+package nvidiadocker
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestSSHCommand(t *testing.T) {
+	u, _ := url.Parse("ssh://ops@gpu1:2222")
+	host := newSSHHost(u, SSHConfig{KeyFile: "/etc/beat/id_ed25519", KnownHostsFile: "/etc/beat/known_hosts"})
+
+	name, args := host.command("nvidia-smi", []string{"--query-gpu=index,name", "--format=csv,noheader,nounits"})
+	expected := []string{
+		"-o", "BatchMode=yes",
+		"-i", "/etc/beat/id_ed25519", "-o", "IdentitiesOnly=yes",
+		"-o", "UserKnownHostsFile=/etc/beat/known_hosts", "-o", "StrictHostKeyChecking=yes",
+		"-p", "2222", "-l", "ops", "gpu1", "--",
+		"nvidia-smi --query-gpu=index,name --format=csv,noheader,nounits",
+	}
+	if name != "ssh" || !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected command %s %q", name, args)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	testDatas := map[string]string{
+		"--id=0,1":            "--id=0,1",
+		"":                    "''",
+		"a b":                 "'a b'",
+		"$(reboot)":           "'$(reboot)'",
+		"it's":                `'it'\''s'`,
+		"{{json .State}}":     "'{{json .State}}'",
+		"/usr/bin/nvidia-smi": "/usr/bin/nvidia-smi",
+	}
+	for arg, expected := range testDatas {
+		if quoted := shellQuote(arg); quoted != expected {
+			t.Fatalf("%s: expected %s, got %s", arg, expected, quoted)
+		}
+	}
+}
+
+// fakeSSH puts an ssh client running the remote command locally, and a
+// docker CLI, first on the PATH.
+func fakeSSH(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[string]string{
+		"ssh": `#!/bin/sh
+while [ "$1" != "--" ]; do shift; done
+exec sh -c "$2"
+`,
+		"docker": `#!/bin/sh
+case "$1" in
+ps) echo id1; echo id2 ;;
+inspect)
+	shift
+	if [ "$1" = "missing" ]; then echo "Error: No such object: missing" >&2; exit 1; fi
+	sep="["
+	for id in "$@"; do
+		printf '%s{"Id":"%s","Name":"/%s","Config":{"Image":"cuda","Labels":{"app":"train"}},"State":{"Running":true},"HostConfig":{"Runtime":"nvidia"}}' "$sep" "$id" "$id"
+		sep=","
+	done
+	echo "]"
+	;;
+esac
+`,
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSSHDockerClient(t *testing.T) {
+	defer fakeSSH(t)()
+
+	config := DefaultConfig()
+	config.GPUSource = GPUSourceSMI
+	if err := config.ApplyHost("ssh://ops@gpu1"); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewContainerClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containers, err := client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 2 || containers[1].ID != "id2" || containers[1].Names[0] != "/id2" ||
+		containers[1].State != "running" || containers[1].Labels["app"] != "train" {
+		t.Fatalf("unexpected containers %+v", containers)
+	}
+
+	container, runtime, err := client.InspectContainerWithRuntime("id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.ID != "id1" || !runtime.UsesNvidiaRuntime() {
+		t.Fatalf("unexpected container %+v %+v", container, runtime)
+	}
+
+	if _, err := client.InspectContainer("missing"); err == nil {
+		t.Fatal("expected error for missing container")
+	} else if _, ok := err.(*docker.NoSuchContainer); !ok {
+		t.Fatalf("expected NoSuchContainer, got %v", err)
+	}
+
+	// The GPU sources run their command line tools over SSH too.
+	output, err := newCommandRunner(config).run("echo", "it's")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "it's\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+}
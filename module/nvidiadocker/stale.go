@@ -0,0 +1,17 @@
+package nvidiadocker
+
+import (
+	"time"
+)
+
+// StalePeriods is the number of fetch periods without a fetch after which the
+// background work of a MetricSet stops and the data it published elsewhere is
+// dropped. Metricbeat stops the MetricSets of a module without telling them
+// when it reloads the module configuration, and starts new ones.
+const StalePeriods = 3
+
+// Stale tells whether a MetricSet fetching at the given period stopped
+// fetching since last. A period of 0 is never stale.
+func Stale(last time.Time, period time.Duration) bool {
+	return period > 0 && time.Since(last) > StalePeriods*period
+}
@@ -0,0 +1,63 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// StaleSampleDetector detects the GPUs whose sample is identical to the one of
+// the previous fetch. A hung driver keeps answering with the last values it
+// read, which look like a flatlined but healthy GPU, while the utilization,
+// power, clocks, PCIe throughput and energy counter of a working GPU keep
+// changing. GPUs are identified by DeviceKey, GPUs without a key are never
+// stale.
+type StaleSampleDetector struct {
+	// previous holds the samples of the previous fetch by GPU key.
+	previous map[string]DeviceStatus
+	// stale holds the GPUs whose sample was stale on the previous fetch.
+	stale map[string]bool
+}
+
+// NewStaleSampleDetector creates a StaleSampleDetector without previous
+// samples.
+func NewStaleSampleDetector() *StaleSampleDetector {
+	return &StaleSampleDetector{
+		previous: map[string]DeviceStatus{},
+		stale:    map[string]bool{},
+	}
+}
+
+// Update records the samples of the current fetch and returns the keys of the
+// GPUs whose sample is identical to the one of the previous fetch. Nothing is
+// returned on the first fetch.
+func (d *StaleSampleDetector) Update(devices []DeviceStatus) map[string]bool {
+	stale := map[string]bool{}
+	previous := make(map[string]DeviceStatus, len(devices))
+	for i := range devices {
+		device := devices[i]
+		key := DeviceKey(&device)
+		if key == "" {
+			continue
+		}
+		// The timestamp changes on every query, even of a hung driver.
+		device.Timestamp = time.Time{}
+		previous[key] = device
+
+		last, found := d.previous[key]
+		if !found || !reflect.DeepEqual(last, device) {
+			if d.stale[key] {
+				logp.Info("GPU %s reports new samples again", key)
+			}
+			continue
+		}
+		if !d.stale[key] {
+			logp.Warn("GPU %s reported the same sample as on the previous fetch, its driver may hang", key)
+		}
+		stale[key] = true
+	}
+	d.previous = previous
+	d.stale = stale
+	return stale
+}
@@ -0,0 +1,48 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStaleSampleDetector(t *testing.T) {
+	devices := func(utilizations ...uint) []DeviceStatus {
+		var devices []DeviceStatus
+		for i, utilization := range utilizations {
+			index := uint(i)
+			devices = append(devices, DeviceStatus{
+				Index:       &index,
+				UUID:        "GPU-" + string('0'+rune(i)),
+				Utilization: UtilizationInfo{GPU: utilization},
+				Raw:         map[string]string{"clocks.sm": "1531"},
+			})
+		}
+		return devices
+	}
+
+	detector := NewStaleSampleDetector()
+	for i, c := range []struct {
+		devices  []DeviceStatus
+		expected map[string]bool
+	}{
+		{devices(10, 20), map[string]bool{}},
+		{devices(10, 21), map[string]bool{"GPU-0": true}},
+		{devices(10, 21), map[string]bool{"GPU-0": true, "GPU-1": true}},
+		{devices(11, 21), map[string]bool{"GPU-1": true}},
+		// GPU-1 disappears and comes back with the same sample.
+		{devices(12), map[string]bool{}},
+		{devices(13, 21), map[string]bool{}},
+	} {
+		if stale := detector.Update(c.devices); !reflect.DeepEqual(stale, c.expected) {
+			t.Fatalf("%d: expected %v, got %v", i, c.expected, stale)
+		}
+	}
+
+	// GPUs without UUID nor index cannot be matched between fetches.
+	detector = NewStaleSampleDetector()
+	for i := 0; i < 2; i++ {
+		if stale := detector.Update([]DeviceStatus{{Name: "Tesla P40"}}); len(stale) != 0 {
+			t.Fatalf("unexpected stale GPUs %v", stale)
+		}
+	}
+}
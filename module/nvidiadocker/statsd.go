@@ -0,0 +1,166 @@
+package nvidiadocker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// statsdPacketSize bounds the size of the UDP packets, to stay below the MTU
+// of common networks.
+const statsdPacketSize = 1400
+
+// statsdNameRegexp matches the characters not allowed in a component of a
+// StatsD metric name, the dot separating the components.
+var statsdNameRegexp = regexp.MustCompile("[^A-Za-z0-9_-]")
+
+// StatsdPusher pushes gauges to a StatsD server at the flush interval, for
+// users that only need a few rollups in a Graphite stack fed by StatsD. The
+// gauges of the latest fetch are pushed until the next fetch replaces them.
+type StatsdPusher struct {
+	host     string
+	conn     io.Writer
+	prefix   string
+	interval time.Duration
+	period   time.Duration
+
+	mutex   sync.Mutex
+	gauges  map[string]float64
+	running bool
+	// updated is the time of the latest fetch, pushing stops when the
+	// MetricSet stopped fetching.
+	updated time.Time
+}
+
+// NewStatsdPusher creates a StatsdPusher sending to the host of the config,
+// for a MetricSet fetching at the given period.
+func NewStatsdPusher(config StatsdConfig, period time.Duration) (*StatsdPusher, error) {
+	if config.FlushInterval <= 0 {
+		return nil, fmt.Errorf("statsd.flush_interval %v must be positive", config.FlushInterval)
+	}
+	conn, err := net.Dial("udp", config.Host)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+	return &StatsdPusher{
+		host:     config.Host,
+		conn:     conn,
+		prefix:   config.Prefix,
+		interval: config.FlushInterval,
+		period:   period,
+		gauges:   map[string]float64{},
+	}, nil
+}
+
+// StatsdName joins the components of a metric name, replacing the characters
+// StatsD does not allow in a component, like the dots of a host name.
+func StatsdName(components ...string) string {
+	var buf bytes.Buffer
+	for i, component := range components {
+		if i > 0 {
+			buf.WriteByte('.')
+		}
+		buf.WriteString(statsdNameRegexp.ReplaceAllString(component, "_"))
+	}
+	return buf.String()
+}
+
+// Set replaces the gauges with the ones of the latest fetch, by metric name
+// without the prefix, and starts pushing them if it is not running. Pushing
+// stops once the MetricSet has not fetched for StalePeriods periods, like
+// after its module was reloaded, so that its gauges are not pushed forever,
+// and the connection is closed until the next fetch.
+func (p *StatsdPusher) Set(gauges map[string]float64) {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.gauges = gauges
+	p.updated = time.Now()
+	if p.running {
+		return
+	}
+	if p.conn == nil {
+		conn, err := net.Dial("udp", p.host)
+		if err != nil {
+			logp.Debug("nvidiadocker", "Cannot connect to StatsD: %v", err)
+			return
+		}
+		p.conn = conn
+	}
+	p.running = true
+
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if p.stop() {
+				return
+			}
+			p.flush()
+		}
+	}()
+}
+
+// stop stops pushing if the MetricSet stopped fetching.
+func (p *StatsdPusher) stop() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !Stale(p.updated, p.period) {
+		return false
+	}
+	logp.Debug("nvidiadocker", "Stopping to push gauges to StatsD, no fetch since %v", p.updated)
+	p.running = false
+	if closer, ok := p.conn.(io.Closer); ok {
+		closer.Close()
+		p.conn = nil
+	}
+	return true
+}
+
+// flush sends the gauges sorted by name, in as few packets as possible.
+func (p *StatsdPusher) flush() {
+	p.mutex.Lock()
+	names := make([]string, 0, len(p.gauges))
+	for name := range p.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		metric := name
+		if p.prefix != "" {
+			metric = p.prefix + "." + name
+		}
+		lines = append(lines, metric+":"+strconv.FormatFloat(p.gauges[name], 'f', -1, 64)+"|g\n")
+	}
+	p.mutex.Unlock()
+
+	var packet bytes.Buffer
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := p.conn.Write(packet.Bytes()); err != nil {
+			logp.Debug("nvidiadocker", "Cannot push gauges to StatsD: %v", err)
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if packet.Len()+len(line) > statsdPacketSize {
+			send()
+		}
+		packet.WriteString(line)
+	}
+	send()
+}
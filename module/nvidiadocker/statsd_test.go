@@ -0,0 +1,58 @@
+package nvidiadocker
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type packetRecorder struct {
+	packets []string
+}
+
+func (r *packetRecorder) Write(p []byte) (int, error) {
+	r.packets = append(r.packets, string(p))
+	return len(p), nil
+}
+
+func TestStatsdName(t *testing.T) {
+	if name := StatsdName("gpu1.example.com:2376", "containers", "train|job"); name != "gpu1_example_com_2376.containers.train_job" {
+		t.Fatalf("unexpected name %s", name)
+	}
+}
+
+func TestStatsdPusherFlush(t *testing.T) {
+	recorder := &packetRecorder{}
+	p := &StatsdPusher{conn: recorder, prefix: "nvidiadocker"}
+	p.gauges = map[string]float64{
+		"host.gpu.utilization":             0.25,
+		"containers.train.gpu.utilization": 1.5,
+		"containers.train.gpu.count":       2,
+		"containers.long." + strings.Repeat("x", statsdPacketSize) + ".gpu.count": 1,
+	}
+	p.flush()
+
+	// The gauge with the long name does not fit in a packet with the others.
+	if len(recorder.packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(recorder.packets))
+	}
+	if !strings.HasPrefix(recorder.packets[0], "nvidiadocker.containers.long.") {
+		t.Fatalf("unexpected packet %q", recorder.packets[0])
+	}
+	expected := "nvidiadocker.containers.train.gpu.count:2|g\nnvidiadocker.containers.train.gpu.utilization:1.5|g\nnvidiadocker.host.gpu.utilization:0.25|g\n"
+	if recorder.packets[1] != expected {
+		t.Fatalf("unexpected packet %q", recorder.packets[1])
+	}
+}
+
+func TestStatsdPusherStop(t *testing.T) {
+	p := &StatsdPusher{period: 10 * time.Second, running: true, updated: time.Now()}
+	if p.stop() {
+		t.Fatal("expected pushing to go on while the MetricSet fetches")
+	}
+
+	p.updated = time.Now().Add(-time.Minute)
+	if !p.stop() || p.running {
+		t.Fatal("expected pushing to stop without fetches")
+	}
+}
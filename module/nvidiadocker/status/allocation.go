@@ -0,0 +1,129 @@
+package status
+
+import (
+	"sort"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// allocationTracker records since when every container holds its GPUs, to
+// report how long it has been holding them.
+type allocationTracker struct {
+	now func() time.Time
+
+	previous map[string]allocation
+	current  map[string]allocation
+}
+
+// allocation is the container holding GPUs since the given time, as seen on
+// the latest fetch.
+type allocation struct {
+	container *docker.Container
+	since     time.Time
+}
+
+func newAllocationTracker() *allocationTracker {
+	return &allocationTracker{
+		now:      time.Now,
+		previous: map[string]allocation{},
+		current:  map[string]allocation{},
+	}
+}
+
+// since returns since when the container holds its GPUs: the time it was
+// first seen with GPUs, or its start time if the runtime reports it, as the
+// GPUs of a container are attached when it starts.
+func (t *allocationTracker) since(container *docker.Container) time.Time {
+	since := t.previous[container.ID].since
+	if since.IsZero() {
+		since = container.State.StartedAt
+		if since.IsZero() {
+			since = t.now()
+		}
+	}
+	t.current[container.ID] = allocation{container: container, since: since}
+	return since
+}
+
+// commit ends the fetch, forgetting the containers that are gone or no
+// longer hold GPUs, whose allocations it returns sorted by container ID.
+func (t *allocationTracker) commit() []allocation {
+	var ids []string
+	for id := range t.previous {
+		if _, found := t.current[id]; !found {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	released := make([]allocation, 0, len(ids))
+	for _, id := range ids {
+		released = append(released, t.previous[id])
+	}
+
+	t.previous = t.current
+	t.current = make(map[string]allocation, len(t.previous))
+	return released
+}
+
+// addTo adds the allocation of the GPUs held since the given time to the
+// events of a container.
+func (t *allocationTracker) addTo(events []common.MapStr, since time.Time) {
+	duration := t.now().Sub(since)
+	if duration < 0 {
+		duration = 0
+	}
+	for _, event := range events {
+		event.Put("gpu.allocation", common.MapStr{
+			"since":    common.Time(since),
+			"duration": common.MapStr{"ms": int64(duration / time.Millisecond)},
+		})
+	}
+}
+
+// releaseEvents returns the final event of every container that released its
+// GPUs since the previous fetch, with include_exited, marking the end of its
+// allocation. The allocation of a stopped container ends when it exited,
+// read by inspecting it, the allocation of a removed container or of a
+// container that no longer holds GPUs ends now.
+func (m *MetricSet) releaseEvents(released []allocation) []common.MapStr {
+	if !m.includeExited || len(released) == 0 {
+		return nil
+	}
+
+	stopped := map[string]bool{}
+	apiContainers, err := m.containerClient.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot list the stopped containers: %v", err)
+	}
+	for _, apiContainer := range apiContainers {
+		if apiContainer.State != "running" {
+			stopped[apiContainer.ID] = true
+		}
+	}
+
+	events := make([]common.MapStr, 0, len(released))
+	for _, a := range released {
+		container, until := a.container, m.allocations.now()
+		if stopped[container.ID] {
+			inspected, err := m.containerClient.InspectContainer(container.ID)
+			if err != nil {
+				logp.Debug("nvidiadocker", "Cannot inspect the stopped container %s: %v", container.ID, err)
+			} else if finished := inspected.State.FinishedAt; !finished.IsZero() && finished.After(a.since) {
+				until = finished
+			}
+		}
+
+		event := m.format.containerEvent(container)
+		event.Put("gpu.allocation", common.MapStr{
+			"since":    common.Time(a.since),
+			"until":    common.Time(until),
+			"duration": common.MapStr{"ms": int64(until.Sub(a.since) / time.Millisecond)},
+			"released": true,
+		})
+		events = append(events, event)
+	}
+	return events
+}
@@ -0,0 +1,147 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestAllocationTracker(t *testing.T) {
+	start := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	tracker := newAllocationTracker()
+	tracker.now = func() time.Time { return now }
+
+	started := &docker.Container{ID: "started", State: docker.State{StartedAt: start.Add(-time.Hour)}}
+	listed := &docker.Container{ID: "listed"}
+
+	if since := tracker.since(started); !since.Equal(start.Add(-time.Hour)) {
+		t.Fatalf("expected the start time, got %v", since)
+	}
+	if since := tracker.since(listed); !since.Equal(start) {
+		t.Fatalf("expected the first fetch, got %v", since)
+	}
+	tracker.commit()
+
+	now = start.Add(10 * time.Minute)
+	since := tracker.since(listed)
+	if !since.Equal(start) {
+		t.Fatalf("expected the first fetch, got %v", since)
+	}
+	events := []common.MapStr{{}, {"gpu": common.MapStr{"count": 1}}}
+	tracker.addTo(events, since)
+	for _, event := range events {
+		if duration, _ := event.GetValue("gpu.allocation.duration.ms"); duration != int64(600000) {
+			t.Fatalf("unexpected allocation duration %v", duration)
+		}
+	}
+	tracker.commit()
+
+	// A container that released its GPUs starts over.
+	tracker.commit()
+	if since := tracker.since(listed); !since.Equal(now) {
+		t.Fatalf("expected the allocation to start over, got %v", since)
+	}
+}
+
+func TestFetchFromContainersAllocation(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{{Index: toUintP(0), UUID: "GPU-0"}}
+	cached := []*nvidiadocker.CachedContainer{{Container: &docker.Container{
+		ID:         "train",
+		State:      docker.State{StartedAt: time.Now().Add(-time.Hour)},
+		HostConfig: &docker.HostConfig{Devices: []docker.Device{{PathOnHost: "/dev/nvidia0", PathInContainer: "/dev/nvidia0"}}},
+		Config:     &docker.Config{},
+	}}}
+
+	m := &MetricSet{format: legacyFormat, reportPerDevice: true, allocations: newAllocationTracker(), counters: nvidiadocker.NewCounterStore(), tracker: nvidiadocker.NewDeviceTracker()}
+	events, err := m.fetchFromContainers(cached, gpuDevices, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duration, err := events[0].GetValue("gpu.allocation.duration.ms")
+	if err != nil || duration.(int64) < int64(time.Hour/time.Millisecond) {
+		t.Fatalf("unexpected allocation duration %v", duration)
+	}
+	if _, err := events[len(events)-1].GetValue("gpu.allocation"); err == nil {
+		t.Fatal("expected no allocation in the host event")
+	}
+}
+
+type mockContainerClient struct {
+	containers []docker.APIContainers
+	inspected  map[string]*docker.Container
+}
+
+func (c *mockContainerClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	return c.containers, nil
+}
+
+func (c *mockContainerClient) InspectContainer(id string) (*docker.Container, error) {
+	return c.inspected[id], nil
+}
+
+func (c *mockContainerClient) InspectContainerWithRuntime(id string) (*docker.Container, *nvidiadocker.ContainerRuntime, error) {
+	return c.inspected[id], nil, nil
+}
+
+func TestReleaseEvents(t *testing.T) {
+	start := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	m := &MetricSet{
+		format:        legacyFormat,
+		allocations:   newAllocationTracker(),
+		includeExited: true,
+		containerClient: &mockContainerClient{
+			containers: []docker.APIContainers{{ID: "exited", State: "exited"}},
+			inspected: map[string]*docker.Container{
+				"exited": {ID: "exited", State: docker.State{FinishedAt: start.Add(5 * time.Minute)}},
+			},
+		},
+	}
+	m.allocations.now = func() time.Time { return now }
+
+	for _, id := range []string{"exited", "removed", "running"} {
+		m.allocations.since(&docker.Container{ID: id, Name: "/" + id, Config: &docker.Config{}})
+	}
+	if released := m.allocations.commit(); len(released) != 0 {
+		t.Fatalf("expected no released allocations, got %v", released)
+	}
+
+	now = start.Add(10 * time.Minute)
+	m.allocations.since(&docker.Container{ID: "running"})
+	events := m.releaseEvents(m.allocations.commit())
+	if len(events) != 2 {
+		t.Fatalf("expected 2 release events, got %v", events)
+	}
+
+	for i, expected := range []map[string]interface{}{
+		{
+			"containerid":                "exited",
+			"containername":              "exited",
+			"gpu.allocation.released":    true,
+			"gpu.allocation.until":       common.Time(start.Add(5 * time.Minute)),
+			"gpu.allocation.duration.ms": int64(300000),
+		},
+		{
+			"containerid":                "removed",
+			"gpu.allocation.until":       common.Time(now),
+			"gpu.allocation.duration.ms": int64(600000),
+		},
+	} {
+		for key, value := range expected {
+			if actual, _ := events[i].GetValue(key); actual != value {
+				t.Fatalf("event %d: %s: expected %v, got %v", i, key, value, actual)
+			}
+		}
+	}
+
+	m.includeExited = false
+	m.allocations.since(&docker.Container{ID: "running"})
+	m.allocations.commit()
+	if events := m.releaseEvents(m.allocations.commit()); len(events) != 0 {
+		t.Fatalf("expected no release events without include_exited, got %v", events)
+	}
+}
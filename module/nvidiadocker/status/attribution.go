@@ -0,0 +1,181 @@
+package status
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+func validateAttribution(attribution string, collector nvidiadocker.GPUCollector) (string, error) {
+	switch attribution = strings.ToLower(attribution); attribution {
+	case nvidiadocker.AttributionNone, nvidiadocker.AttributionEqual:
+		return attribution, nil
+	case nvidiadocker.AttributionMemory:
+		if _, ok := collector.(nvidiadocker.ProcessCollector); !ok {
+			return "", fmt.Errorf("shared_gpu_attribution '%s' requires a gpu_source that lists the GPU processes", attribution)
+		}
+		return attribution, nil
+	}
+	return "", fmt.Errorf("unknown shared_gpu_attribution '%s', must be one of %s, %s, %s",
+		attribution, nvidiadocker.AttributionEqual, nvidiadocker.AttributionMemory, nvidiadocker.AttributionNone)
+}
+
+// deviceShares returns, for every container, the share of the GPUs it shares
+// with other containers that is attributed to it, by device position. GPUs
+// used by a single container are left out, as are all GPUs with the none
+// attribution, which credits every container with the whole GPU. memory is
+// the GPU memory used by the processes of the containers, as returned by
+// processMemory.
+func (m *MetricSet) deviceShares(containerIDs []string, deviceIndices [][]int, users map[int]int, gpuDevices []nvidiadocker.DeviceStatus, memory map[string]map[string]uint64) []map[int]float64 {
+	if m.attribution == nvidiadocker.AttributionNone || m.attribution == "" {
+		return nil
+	}
+	if m.attribution != nvidiadocker.AttributionMemory {
+		memory = nil
+	}
+
+	shares := make([]map[int]float64, len(containerIDs))
+	for i, indices := range deviceIndices {
+		shares[i] = map[int]float64{}
+		for _, index := range indices {
+			if users[index] < 2 {
+				continue
+			}
+			shares[i][index] = 1 / float64(users[index])
+			if memory == nil {
+				continue
+			}
+
+			uuid := gpuDevices[index].UUID
+			var total uint64
+			for j, other := range deviceIndices {
+				if containsIndex(other, index) {
+					total += memory[containerIDs[j]][uuid]
+				}
+			}
+			// Without processes, like between two jobs, the GPU is split
+			// equally.
+			if total > 0 {
+				shares[i][index] = float64(memory[containerIDs[i]][uuid]) / float64(total)
+			}
+		}
+	}
+	return shares
+}
+
+// processMemory returns the GPU memory in MiB used by the processes of every
+// container, by container ID and GPU UUID, or nil if the GPU source cannot
+// list the GPU processes. The clients of an MPS server are attributed to
+// their own containers. The processes of a remote host are not attributed,
+// as their PIDs are not the ones of the local /proc.
+func (m *MetricSet) processMemory() map[string]map[string]uint64 {
+	collector, ok := m.collector.(nvidiadocker.ProcessCollector)
+	if !ok || !m.localHost {
+		return nil
+	}
+	processes, err := collector.Processes()
+	if err != nil {
+		logp.Debug("nvidiadocker", "Cannot list GPU processes: %v", err)
+		return nil
+	}
+
+	memory := map[string]map[string]uint64{}
+	for _, process := range processes {
+		// The memory of an MPS server holds the contexts of its clients,
+		// which are listed with their own memory from Volta on. It is not
+		// attributed to the container of the server.
+		if nvidiadocker.IsMPSServer(&process) {
+			continue
+		}
+		containerID, err := nvidiadocker.ContainerIDFromPID(m.hostFS, process.PID)
+		if err != nil || containerID == "" {
+			continue
+		}
+		if memory[containerID] == nil {
+			memory[containerID] = map[string]uint64{}
+		}
+		memory[containerID][process.GPUUUID] += process.MemoryUsed
+	}
+	return memory
+}
+
+func containsIndex(indices []int, index int) bool {
+	for _, i := range indices {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// attributedDevice returns the device with its utilization, used memory,
+// power draw and PCIe throughput scaled to the share of the container.
+func attributedDevice(device *nvidiadocker.DeviceStatus, share float64) *nvidiadocker.DeviceStatus {
+	scale := func(value uint) uint {
+		return uint(float64(value)*share + 0.5)
+	}
+
+	attributed := *device
+	attributed.Utilization = nvidiadocker.UtilizationInfo{
+		GPU:     scale(device.Utilization.GPU),
+		Memory:  scale(device.Utilization.Memory),
+		Encoder: scale(device.Utilization.Encoder),
+		Decoder: scale(device.Utilization.Decoder),
+	}
+	attributed.Memory.GlobalUsed = uint64(float64(device.Memory.GlobalUsed)*share + 0.5)
+	attributed.Power = device.Power * share
+	attributed.PCI.Throughput = nvidiadocker.PCIThroughputInfo{
+		RX: scale(device.PCI.Throughput.RX),
+		TX: scale(device.PCI.Throughput.TX),
+	}
+	return &attributed
+}
+
+// attributedEnergy returns the energy of the devices attributed to a
+// container, from the energy split equally between the users of every device
+// and the shares of the container.
+func attributedEnergy(energy map[int]float64, users map[int]int, shares map[int]float64) map[int]float64 {
+	if len(shares) == 0 {
+		return energy
+	}
+	attributed := make(map[int]float64, len(energy))
+	for index, joules := range energy {
+		if share, found := shares[index]; found {
+			joules *= float64(users[index]) * share
+		}
+		attributed[index] = joules
+	}
+	return attributed
+}
+
+// sharedDevice returns the device at the given position, scaled to its share
+// if it has one.
+func sharedDevice(device *nvidiadocker.DeviceStatus, shares map[int]float64, index int) *nvidiadocker.DeviceStatus {
+	if share, found := shares[index]; found {
+		return attributedDevice(device, share)
+	}
+	return device
+}
+
+// addProcessMemory adds the GPU memory the processes of a container use to its
+// events, the sum over its GPUs, or the memory on the GPU of the event with
+// report_per_device. containerMemory is the memory in MiB by GPU UUID.
+func addProcessMemory(events []common.MapStr, perDevice bool, indices []int, gpuDevices []nvidiadocker.DeviceStatus, containerMemory map[string]uint64) {
+	if perDevice {
+		for i, event := range events {
+			event.Put("gpu.memory.processes.bytes", containerMemory[gpuDevices[indices[i]].UUID]*nvidiadocker.MiB)
+		}
+		return
+	}
+
+	var total uint64
+	for _, index := range indices {
+		total += containerMemory[gpuDevices[index].UUID]
+	}
+	for _, event := range events {
+		event.Put("gpu.memory.processes.bytes", total*nvidiadocker.MiB)
+	}
+}
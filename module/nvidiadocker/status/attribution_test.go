@@ -0,0 +1,160 @@
+package status
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+type mockDeviceCollector struct{}
+
+func (c *mockDeviceCollector) List() ([]uint, error) {
+	return nil, nil
+}
+
+func (c *mockDeviceCollector) Query(indices []uint) ([]nvidiadocker.DeviceStatus, error) {
+	return nil, nil
+}
+
+type mockProcessCollector struct {
+	mockDeviceCollector
+	processes []nvidiadocker.ProcessInfo
+}
+
+func (c *mockProcessCollector) Processes() ([]nvidiadocker.ProcessInfo, error) {
+	return c.processes, nil
+}
+
+func TestValidateAttribution(t *testing.T) {
+	if attribution, err := validateAttribution("Equal", nil); err != nil || attribution != nvidiadocker.AttributionEqual {
+		t.Fatalf("unexpected attribution %v, %v", attribution, err)
+	}
+	if _, err := validateAttribution("memory", &mockDeviceCollector{}); err == nil {
+		t.Fatal("expected an error for a gpu_source without processes")
+	}
+	if _, err := validateAttribution("memory", &mockProcessCollector{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validateAttribution("weighted", nil); err == nil {
+		t.Fatal("expected an error for an unknown attribution")
+	}
+}
+
+func TestDeviceSharesEqual(t *testing.T) {
+	m := &MetricSet{attribution: nvidiadocker.AttributionEqual}
+	gpuDevices := []nvidiadocker.DeviceStatus{{UUID: "GPU-0"}, {UUID: "GPU-1"}}
+	deviceIndices := [][]int{{0, 1}, {1}}
+
+	shares := m.deviceShares([]string{"a", "b"}, deviceIndices, map[int]int{0: 1, 1: 2}, gpuDevices, nil)
+	expected := []map[int]float64{{1: 0.5}, {1: 0.5}}
+	if !reflect.DeepEqual(shares, expected) {
+		t.Fatalf("expected %v, got %v", expected, shares)
+	}
+
+	m.attribution = nvidiadocker.AttributionNone
+	if shares := m.deviceShares([]string{"a", "b"}, deviceIndices, map[int]int{0: 1, 1: 2}, gpuDevices, nil); shares != nil {
+		t.Fatalf("expected no shares, got %v", shares)
+	}
+}
+
+func TestDeviceSharesMemory(t *testing.T) {
+	hostFS, err := ioutil.TempDir("", "hostfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostFS)
+
+	containerIDs := []string{strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)}
+	for pid, id := range map[int]string{100: containerIDs[0], 200: containerIDs[1], 300: containerIDs[1]} {
+		path := filepath.Join(hostFS, fmt.Sprintf("/proc/%d/cgroup", pid))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("4:devices:/docker/"+id+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &MetricSet{
+		attribution: nvidiadocker.AttributionMemory,
+		hostFS:      hostFS,
+		localHost:   true,
+		collector: &mockProcessCollector{processes: []nvidiadocker.ProcessInfo{
+			{PID: 100, GPUUUID: "GPU-0", MemoryUsed: 1000},
+			{PID: 200, GPUUUID: "GPU-0", MemoryUsed: 2000},
+			{PID: 300, GPUUUID: "GPU-0", MemoryUsed: 1000},
+			{PID: 100, Name: "nvidia-cuda-mps-server", GPUUUID: "GPU-1", MemoryUsed: 500},
+		}},
+	}
+	gpuDevices := []nvidiadocker.DeviceStatus{{UUID: "GPU-0"}, {UUID: "GPU-1"}}
+
+	// The third container has no process on GPU-0, and neither container on
+	// GPU-1 but an MPS server, which is split equally.
+	shares := m.deviceShares(containerIDs, [][]int{{0, 1}, {0, 1}, {0}}, map[int]int{0: 3, 1: 2}, gpuDevices, m.processMemory())
+	expected := []map[int]float64{{0: 0.25, 1: 0.5}, {0: 0.75, 1: 0.5}, {0: 0}}
+	if !reflect.DeepEqual(shares, expected) {
+		t.Fatalf("expected %v, got %v", expected, shares)
+	}
+
+	// The PIDs of the processes of a remote host are not looked up in the
+	// local /proc.
+	m.localHost = false
+	if memory := m.processMemory(); memory != nil {
+		t.Fatalf("expected no process memory of a remote host, got %v", memory)
+	}
+}
+
+func TestFetchFromContainerShared(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{UUID: "GPU-0", Power: 200, Utilization: nvidiadocker.UtilizationInfo{GPU: 90}, Memory: nvidiadocker.MemoryInfo{GlobalUsed: 1000, GlobalTotal: 4000}},
+	}
+	container := &docker.Container{ID: "a", HostConfig: &docker.HostConfig{}, Config: &docker.Config{}}
+
+	event := legacyFormat.fetchFromContainer(container, []int{0}, gpuDevices, map[int]float64{0: 50}, map[int]float64{0: 0.25})
+	for key, expected := range map[string]interface{}{
+		"device.Utilization.GPU": uint(23),
+		"device.Memory.Used":     uint64(250 * nvidiadocker.MiB),
+		"device.Memory.Total":    uint64(4000 * nvidiadocker.MiB),
+		"device.Power.Draw":      float64(50),
+		"device.Energy.Joules":   float64(50),
+	} {
+		if value, _ := event.GetValue(key); value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+	devices, _ := event.GetValue("device.Devices")
+	if share := devices.([]common.MapStr)[0]["Share"]; share != 0.25 {
+		t.Fatalf("unexpected share %v", share)
+	}
+
+	if energy := attributedEnergy(map[int]float64{0: 50}, map[int]int{0: 2}, map[int]float64{0: 0.25}); energy[0] != 25 {
+		t.Fatalf("unexpected energy %v", energy)
+	}
+}
+
+func TestAddProcessMemory(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{{UUID: "GPU-0"}, {UUID: "GPU-1"}, {UUID: "GPU-2"}}
+	containerMemory := map[string]uint64{"GPU-0": 1024, "GPU-2": 3072}
+
+	event := common.MapStr{"gpu": common.MapStr{"count": 3}}
+	addProcessMemory([]common.MapStr{event}, false, []int{0, 1, 2}, gpuDevices, containerMemory)
+	if value, _ := event.GetValue("gpu.memory.processes.bytes"); value != uint64(4<<30) {
+		t.Fatalf("unexpected process memory %v", value)
+	}
+
+	events := []common.MapStr{{}, {}}
+	addProcessMemory(events, true, []int{1, 2}, gpuDevices, containerMemory)
+	for i, expected := range []uint64{0, 3 << 30} {
+		if value, _ := events[i].GetValue("gpu.memory.processes.bytes"); value != expected {
+			t.Fatalf("%d: unexpected process memory %v", i, value)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package status
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// eventFormat is the layout of the status events, selected with the
+// fields_format option, along with the container labels to report.
+type eventFormat struct {
+	ecs    bool
+	labels nvidiadocker.LabelsConfig
+}
+
+var (
+	// legacyFormat reports the container as containerid, containername and
+	// labels, and the GPUs under device with the original field names.
+	legacyFormat = eventFormat{}
+
+	// ecsFormat reports the container under nvidiadocker.container like the
+	// docker module, and the GPUs under gpu named like the gpu MetricSet,
+	// with the units in the field names and the utilization as percentages.
+	ecsFormat = eventFormat{ecs: true}
+)
+
+func newEventFormat(config nvidiadocker.Config) (eventFormat, error) {
+	var f eventFormat
+	switch strings.ToLower(config.FieldsFormat) {
+	case nvidiadocker.FieldsFormatLegacy:
+	case nvidiadocker.FieldsFormatECS:
+		f.ecs = true
+	default:
+		return f, fmt.Errorf("unknown fields_format '%s', must be one of %s, %s",
+			config.FieldsFormat, nvidiadocker.FieldsFormatECS, nvidiadocker.FieldsFormatLegacy)
+	}
+	f.labels = config.Labels
+	return f, nil
+}
+
+// containerEvent returns a new event of the given container.
+func (f eventFormat) containerEvent(container *docker.Container) common.MapStr {
+	if f.ecs {
+		event := f.containerIDEvent(container.ID)
+		ecsContainer := event[mb.ModuleData].(common.MapStr)["container"].(common.MapStr)
+		if name := strings.TrimPrefix(container.Name, "/"); name != "" {
+			ecsContainer["name"] = name
+		}
+		if image := nvidiadocker.Image(container); image != nil {
+			ecsContainer["image"] = image
+		}
+		if container.Config != nil {
+			if labels := f.labels.Labels(container.Config.Labels); len(labels) > 0 {
+				ecsContainer["labels"] = labels
+			}
+			if owner := f.labels.Owner(container.Config.Labels); owner != nil {
+				ecsContainer["owner"] = owner
+			}
+			nvidiadocker.AddOrchestration(ecsContainer, container.Config.Labels)
+		}
+		return event
+	}
+
+	event := legacyContainerEvent(container, f.labels.Labels(container.Config.Labels))
+	if image := nvidiadocker.Image(container); image != nil {
+		event["image"] = image
+	}
+	if owner := f.labels.Owner(container.Config.Labels); owner != nil {
+		event["owner"] = owner
+	}
+	nvidiadocker.AddOrchestration(event, container.Config.Labels)
+	return event
+}
+
+// containerIDEvent returns a new event of the container with the given ID,
+// for containers that could not be inspected.
+func (f eventFormat) containerIDEvent(id string) common.MapStr {
+	if f.ecs {
+		return common.MapStr{
+			mb.ModuleData: common.MapStr{
+				"container": common.MapStr{"id": id},
+			},
+		}
+	}
+	return common.MapStr{"containerid": id}
+}
+
+// deviceKey is the key the GPU values are reported under.
+func (f eventFormat) deviceKey() string {
+	if f.ecs {
+		return "gpu"
+	}
+	return "device"
+}
+
+func (f eventFormat) deviceIdentity(position int, device *nvidiadocker.DeviceStatus) common.MapStr {
+	if f.ecs {
+		return ecsDeviceIdentity(position, device)
+	}
+	return legacyDeviceIdentity(position, device)
+}
+
+func (f eventFormat) deviceMapping(cStatus *ContainerStatus) common.MapStr {
+	if f.ecs {
+		return ecsDeviceMapping(cStatus)
+	}
+	return deviceMapping(cStatus)
+}
+
+// ecsDeviceIdentity is legacyDeviceIdentity with the field names of the gpu
+// MetricSet.
+func ecsDeviceIdentity(position int, device *nvidiadocker.DeviceStatus) common.MapStr {
+	identity := common.MapStr{
+		"index":  uint(position),
+		"uuid":   device.UUID,
+		"name":   device.Name,
+		"bus_id": device.PCI.BusID,
+	}
+	if device.Index != nil {
+		identity["index"] = *device.Index
+	}
+	return identity
+}
+
+// ecsDeviceMapping is deviceMapping with the units in the field names. Like
+// system.cpu.total.pct, the utilization percentages are summed over the
+// devices and exceed 1 for containers with several GPUs.
+func ecsDeviceMapping(cStatus *ContainerStatus) common.MapStr {
+	device := common.MapStr{
+		"utilization": common.MapStr{
+			"pct":     nvidiadocker.Percent(cStatus.GPUSum()),
+			"memory":  common.MapStr{"pct": nvidiadocker.Percent(cStatus.GPUMemorySum())},
+			"encoder": common.MapStr{"pct": nvidiadocker.Percent(cStatus.EncoderSum())},
+			"decoder": common.MapStr{"pct": nvidiadocker.Percent(cStatus.DecoderSum())},
+		},
+		"encoder": common.MapStr{
+			"sessions": cStatus.EncoderSessionSum(),
+		},
+		"memory": common.MapStr{
+			"used":  common.MapStr{"bytes": cStatus.MemoryUsedSum()},
+			"total": common.MapStr{"bytes": cStatus.MemoryTotalSum()},
+			"free":  common.MapStr{"bytes": cStatus.MemoryFreeSum()},
+		},
+		"temperature": cStatus.TemperatureAverage(),
+		"pci": common.MapStr{
+			"throughput": common.MapStr{
+				"rx": common.MapStr{"bytes": cStatus.PCIRXSum()},
+				"tx": common.MapStr{"bytes": cStatus.PCITXSum()},
+			},
+		},
+		"power": common.MapStr{
+			"draw":           common.MapStr{"watts": cStatus.PowerSum()},
+			"limit":          common.MapStr{"watts": cStatus.PowerLimitSum()},
+			"enforced_limit": common.MapStr{"watts": cStatus.PowerEnforcedLimitSum()},
+		},
+	}
+
+	if cStatus.HasProfiling() {
+		profiling := profilingMapping(cStatus)
+		device["profiling"] = common.MapStr{
+			"graphics": common.MapStr{"active": profiling["GraphicsActive"]},
+			"sm": common.MapStr{
+				"active":    profiling["SMActive"],
+				"occupancy": profiling["SMOccupancy"],
+			},
+			"tensor": common.MapStr{"active": profiling["TensorActive"]},
+			"dram":   common.MapStr{"active": profiling["DRAMActive"]},
+			"fp64":   common.MapStr{"active": profiling["FP64Active"]},
+			"fp32":   common.MapStr{"active": profiling["FP32Active"]},
+			"fp16":   common.MapStr{"active": profiling["FP16Active"]},
+		}
+	}
+	if headroom, ok := cStatus.TemperatureHeadroomMin(); ok {
+		device["temperature_headroom"] = headroom
+	}
+	if cStatus.hasEnergy {
+		device["energy"] = common.MapStr{
+			"joules": cStatus.energy,
+		}
+	}
+	return device
+}
+
+// sharedDeviceIdentity is deviceIdentity with the share of the device
+// attributed to the container, if it has one, and the numbers of the device
+// in the container.
+func (f eventFormat) sharedDeviceIdentity(position int, device *nvidiadocker.DeviceStatus, shares map[int]float64, local containerIndices) common.MapStr {
+	identity := f.deviceIdentity(position, device)
+	if share, found := shares[position]; found {
+		if f.ecs {
+			identity["share"] = share
+		} else {
+			identity["Share"] = share
+		}
+	}
+	if number, found := local.devices[position]; found {
+		if f.ecs {
+			identity["container_index"] = number
+		} else {
+			identity["ContainerIndex"] = number
+		}
+	}
+	if ordinal, found := local.cuda[position]; found {
+		if f.ecs {
+			identity["cuda_ordinal"] = ordinal
+		} else {
+			identity["CUDAOrdinal"] = ordinal
+		}
+	}
+	return identity
+}
+
+// containerIndices holds the numbers the GPUs of a container go by in the
+// container, by position in the GPUs of the host.
+type containerIndices struct {
+	// devices are the N of the /dev/nvidiaN devices mapped explicitly.
+	devices map[int]uint
+	// cuda are the CUDA device ordinals.
+	cuda map[int]uint
+}
+
+func newContainerIndices(container *docker.Container, indices []int, gpuDevices []nvidiadocker.DeviceStatus) containerIndices {
+	return containerIndices{
+		devices: nvidiadocker.ContainerDeviceNumbers(container, gpuDevices),
+		cuda:    nvidiadocker.CUDAOrdinals(container.Config.Env, indices, gpuDevices),
+	}
+}
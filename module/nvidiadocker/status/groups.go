@@ -0,0 +1,100 @@
+package status
+
+import (
+	"sort"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// containerGroup returns the ID of the group of a container and the fields
+// identifying the group in its event, or an empty ID if the container is in
+// no group.
+type containerGroup func(container *docker.Container) (string, common.MapStr)
+
+// composeProject groups the containers by Docker Compose project.
+func composeProject(container *docker.Container) (string, common.MapStr) {
+	project, found := container.Config.Labels[nvidiadocker.ComposeProjectLabel]
+	if !found {
+		return "", nil
+	}
+	return project, common.MapStr{"project": project}
+}
+
+// containerImage groups the containers by the image they were created from.
+func containerImage(container *docker.Container) (string, common.MapStr) {
+	image := nvidiadocker.Image(container)
+	if image == nil {
+		return "", nil
+	}
+	return container.Config.Image, image
+}
+
+// groupEvents returns one event per group of containers with GPUs, with the
+// fields of the group and its number of containers under key, and the values
+// of the GPUs its containers have access to aggregated. A GPU used by several
+// containers of the group is only counted once.
+func (f eventFormat) groupEvents(key string, group containerGroup, containers []*docker.Container, deviceIndices [][]int, gpuDevices []nvidiadocker.DeviceStatus) []common.MapStr {
+	var (
+		groups  = map[string]common.MapStr{}
+		indices = map[string][]int{}
+		counts  = map[string]int{}
+		seen    = map[string]map[int]bool{}
+	)
+	for i, container := range containers {
+		if container.Config == nil || len(deviceIndices[i]) == 0 {
+			continue
+		}
+		id, fields := group(container)
+		if id == "" {
+			continue
+		}
+
+		groups[id] = fields
+		counts[id]++
+		if seen[id] == nil {
+			seen[id] = map[int]bool{}
+		}
+		for _, index := range deviceIndices[i] {
+			if !seen[id][index] {
+				seen[id][index] = true
+				indices[id] = append(indices[id], index)
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	events := make([]common.MapStr, 0, len(ids))
+	for _, id := range ids {
+		groupIndices := indices[id]
+		sort.Ints(groupIndices)
+
+		cStatus := &ContainerStatus{}
+		for _, index := range groupIndices {
+			cStatus.AddDevice(&gpuDevices[index])
+		}
+
+		fields := groups[id]
+		fields["containers"] = counts[id]
+		event := common.MapStr{key: fields}
+
+		device := f.deviceMapping(cStatus)
+		gpu := device
+		if !f.ecs {
+			event["device"] = device
+			gpu = common.MapStr{}
+		}
+		gpu["count"] = len(groupIndices)
+		gpu["devices"] = gpuIdentities(groupIndices, gpuDevices, nil, containerIndices{})
+		gpu["efficiency"] = cStatus.Efficiency()
+		event["gpu"] = gpu
+		events = append(events, event)
+	}
+	return events
+}
@@ -0,0 +1,67 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestGroupEvents(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{UUID: "GPU-0", Power: 200, Utilization: nvidiadocker.UtilizationInfo{GPU: 90}},
+		{UUID: "GPU-1", Power: 150, Utilization: nvidiadocker.UtilizationInfo{GPU: 30}},
+		{UUID: "GPU-2", Power: 50, Utilization: nvidiadocker.UtilizationInfo{GPU: 0}},
+	}
+	container := func(id, project, image string) *docker.Container {
+		labels := map[string]string{}
+		if project != "" {
+			labels[nvidiadocker.ComposeProjectLabel] = project
+		}
+		return &docker.Container{ID: id, Config: &docker.Config{Image: image, Labels: labels}}
+	}
+	containers := []*docker.Container{
+		container("train", "ml", "ml/resnet:v2"),
+		container("eval", "ml", "ml/resnet:v1"),
+		container("web", "app", "nginx"),
+		container("standalone", "", "ml/resnet:v2"),
+	}
+	deviceIndices := [][]int{{0, 1}, {1}, {}, {2}}
+
+	events := ecsFormat.groupEvents("compose", composeProject, containers, deviceIndices, gpuDevices)
+	if len(events) != 1 {
+		t.Fatalf("expected the ml project only, got %v", events)
+	}
+	for key, expected := range map[string]interface{}{
+		"compose.project":      "ml",
+		"compose.containers":   2,
+		"gpu.count":            2,
+		"gpu.utilization.pct":  1.2,
+		"gpu.power.draw.watts": float64(350),
+		"gpu.efficiency":       0.6,
+	} {
+		if value, _ := events[0].GetValue(key); value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	events = legacyFormat.groupEvents("compose", composeProject, containers, deviceIndices, gpuDevices)
+	if value, _ := events[0].GetValue("device.Utilization.GPU"); value != uint(120) {
+		t.Fatalf("unexpected legacy utilization %v", value)
+	}
+
+	events = ecsFormat.groupEvents("image", containerImage, containers, deviceIndices, gpuDevices)
+	if len(events) != 2 {
+		t.Fatalf("expected the two resnet images, got %v", events)
+	}
+	for key, expected := range map[string]interface{}{
+		"image.name":       "ml/resnet",
+		"image.tag":        "v2",
+		"image.containers": 2,
+		"gpu.count":        3,
+	} {
+		if value, _ := events[1].GetValue(key); value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package status
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// idleDetector tracks since when the GPUs of every container stayed below the
+// utilization threshold, to report the containers idle for the period once
+// per idle stretch.
+type idleDetector struct {
+	threshold float64
+	period    time.Duration
+	now       func() time.Time
+
+	previous map[string]idleState
+	current  map[string]idleState
+}
+
+type idleState struct {
+	since    time.Time
+	reported bool
+}
+
+// newIdleDetector returns the detector of the given configuration, nil if the
+// idle detection is disabled.
+func newIdleDetector(config nvidiadocker.IdleDetectionConfig) (*idleDetector, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.Threshold < 0 || config.Threshold > 1 {
+		return nil, fmt.Errorf("idle_detection.threshold must be between 0 and 1, got %v", config.Threshold)
+	}
+	if config.Period <= 0 {
+		return nil, fmt.Errorf("idle_detection.period must be positive, got %v", config.Period)
+	}
+	return &idleDetector{
+		threshold: config.Threshold,
+		period:    config.Period,
+		now:       time.Now,
+		previous:  map[string]idleState{},
+		current:   map[string]idleState{},
+	}, nil
+}
+
+// observe records the highest utilization of the GPUs of the container, as a
+// ratio, and returns whether the container just reached the idle period, and
+// since when it is idle.
+func (d *idleDetector) observe(id string, utilization float64) (since time.Time, idle bool) {
+	if utilization >= d.threshold {
+		return time.Time{}, false
+	}
+
+	now := d.now()
+	state, found := d.previous[id]
+	if !found {
+		state.since = now
+	}
+	if !state.reported && now.Sub(state.since) >= d.period {
+		state.reported = true
+		idle = true
+	}
+	d.current[id] = state
+	return state.since, idle
+}
+
+// commit ends the fetch, forgetting the containers that are gone or were
+// busy.
+func (d *idleDetector) commit() {
+	d.previous = d.current
+	d.current = make(map[string]idleState, len(d.previous))
+}
+
+// peakUtilization returns the highest utilization of the GPUs at the given
+// positions as a ratio.
+func peakUtilization(indices []int, gpuDevices []nvidiadocker.DeviceStatus) float64 {
+	var peak uint
+	for _, index := range indices {
+		if utilization := gpuDevices[index].Utilization.GPU; utilization > peak {
+			peak = utilization
+		}
+	}
+	return nvidiadocker.Percent(peak)
+}
+
+// idleEvent returns the gpu.idle event of a container whose GPUs stayed below
+// the threshold since the given time.
+func (f eventFormat) idleEvent(container *docker.Container, indices []int, gpuDevices []nvidiadocker.DeviceStatus, since time.Time, d *idleDetector) common.MapStr {
+	event := f.containerEvent(container)
+	event["gpu"] = common.MapStr{
+		"count":   len(indices),
+		"devices": gpuIdentities(indices, gpuDevices, nil, containerIndices{}),
+		"idle": common.MapStr{
+			"since":     common.Time(since),
+			"duration":  common.MapStr{"ms": int64(d.now().Sub(since) / time.Millisecond)},
+			"threshold": d.threshold,
+		},
+	}
+	return event
+}
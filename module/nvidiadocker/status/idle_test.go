@@ -0,0 +1,81 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestIdleDetector(t *testing.T) {
+	d, err := newIdleDetector(nvidiadocker.IdleDetectionConfig{Enabled: true, Threshold: 0.05, Period: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	d.now = func() time.Time { return now }
+
+	// The container is idle from 12:00, busy at 12:05, and idle again from
+	// 12:06 until it is reported at 12:16, only once.
+	var reported []time.Time
+	for minute, utilization := range []float64{0, 0.01, 0.02, 0.04, 0, 0.5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0} {
+		now = start.Add(time.Duration(minute) * time.Minute)
+		if since, idle := d.observe("train", utilization); idle {
+			if minute != 16 {
+				t.Fatalf("unexpected idle event at minute %d", minute)
+			}
+			reported = append(reported, since)
+		}
+		d.commit()
+	}
+	if len(reported) != 1 || !reported[0].Equal(start.Add(6*time.Minute)) {
+		t.Fatalf("unexpected idle events %v", reported)
+	}
+
+	if _, err := newIdleDetector(nvidiadocker.IdleDetectionConfig{Enabled: true, Threshold: 5, Period: time.Minute}); err == nil {
+		t.Fatal("expected an error for a threshold above 1")
+	}
+	if d, _ := newIdleDetector(nvidiadocker.DefaultConfig().IdleDetection); d != nil {
+		t.Fatal("expected the idle detection to be disabled by default")
+	}
+}
+
+func TestFetchFromContainersIdle(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{UUID: "GPU-0", Utilization: nvidiadocker.UtilizationInfo{GPU: 2}},
+		{UUID: "GPU-1", Utilization: nvidiadocker.UtilizationInfo{GPU: 1}},
+	}
+	cached := []*nvidiadocker.CachedContainer{{Container: &docker.Container{
+		ID: "train",
+		HostConfig: &docker.HostConfig{Devices: []docker.Device{
+			{PathOnHost: "/dev/nvidia0", PathInContainer: "/dev/nvidia0"},
+			{PathOnHost: "/dev/nvidia1", PathInContainer: "/dev/nvidia1"},
+		}},
+		Config: &docker.Config{},
+	}}}
+
+	idle, _ := newIdleDetector(nvidiadocker.IdleDetectionConfig{Enabled: true, Threshold: 0.05, Period: time.Minute})
+	now := time.Now()
+	idle.now = func() time.Time { return now }
+	m := &MetricSet{format: ecsFormat, idle: idle, allocations: newAllocationTracker(), counters: nvidiadocker.NewCounterStore(), tracker: nvidiadocker.NewDeviceTracker()}
+
+	if events, _ := m.fetchFromContainers(cached, gpuDevices, nil); len(events) != 2 {
+		t.Fatalf("expected no idle event yet, got %v", events)
+	}
+	now = now.Add(time.Minute)
+	events, err := m.fetchFromContainers(cached, gpuDevices, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected an idle event, got %v", events)
+	}
+	if duration, _ := events[0].GetValue("gpu.idle.duration.ms"); duration != int64(60000) {
+		t.Fatalf("unexpected idle duration %v", duration)
+	}
+	if count, _ := events[0].GetValue("gpu.count"); count != 2 {
+		t.Fatalf("unexpected GPU count %v", count)
+	}
+}
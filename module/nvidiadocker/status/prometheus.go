@@ -0,0 +1,61 @@
+package status
+
+import (
+	"strings"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// prometheusMetrics returns the Prometheus metrics of the containers with
+// GPUs, aggregated and scaled to the shares of the containers like their
+// events, and of the GPUs of the host. host labels the metrics of a remote
+// host.
+func prometheusMetrics(host string, containers []*docker.Container, deviceIndices [][]int, gpuDevices []nvidiadocker.DeviceStatus, shares []map[int]float64, users map[int]int) []nvidiadocker.PrometheusMetric {
+	var metrics []nvidiadocker.PrometheusMetric
+	add := func(name, help string, labels map[string]string, value float64) {
+		metrics = append(metrics, nvidiadocker.PrometheusMetric{
+			Name:   "nvidiadocker_" + name,
+			Help:   help,
+			Labels: labels,
+			Value:  value,
+		})
+	}
+
+	for i, container := range containers {
+		if len(deviceIndices[i]) == 0 {
+			continue
+		}
+		var containerShares map[int]float64
+		if shares != nil {
+			containerShares = shares[i]
+		}
+		cStatus := &ContainerStatus{}
+		for _, index := range deviceIndices[i] {
+			cStatus.AddDevice(sharedDevice(&gpuDevices[index], containerShares, index))
+		}
+
+		labels := map[string]string{
+			"container_id":   container.ID,
+			"container_name": strings.TrimPrefix(container.Name, "/"),
+		}
+		if host != "" {
+			labels["host"] = host
+		}
+		add("container_gpu_count", "Number of GPUs of the container.", labels, float64(len(deviceIndices[i])))
+		add("container_gpu_utilization_ratio", "GPU utilization summed over the GPUs of the container.", labels, nvidiadocker.Percent(cStatus.GPUSum()))
+		add("container_gpu_memory_utilization_ratio", "Memory controller utilization summed over the GPUs of the container.", labels, nvidiadocker.Percent(cStatus.GPUMemorySum()))
+		add("container_gpu_memory_used_bytes", "Used memory of the GPUs of the container.", labels, float64(cStatus.MemoryUsedSum()))
+		add("container_gpu_memory_total_bytes", "Total memory of the GPUs of the container.", labels, float64(cStatus.MemoryTotalSum()))
+		add("container_gpu_power_draw_watts", "Power drawn by the GPUs of the container.", labels, cStatus.PowerSum())
+		add("container_gpu_temperature_celsius", "Average temperature of the GPUs of the container.", labels, cStatus.TemperatureAverage())
+	}
+
+	var labels map[string]string
+	if host != "" {
+		labels = map[string]string{"host": host}
+	}
+	add("host_gpu_total", "Number of GPUs of the host.", labels, float64(len(gpuDevices)))
+	add("host_gpu_allocated", "Number of GPUs used by at least one container.", labels, float64(len(users)))
+	return metrics
+}
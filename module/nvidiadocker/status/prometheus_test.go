@@ -0,0 +1,42 @@
+package status
+
+import (
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{UUID: "GPU-0", Power: 200, Utilization: nvidiadocker.UtilizationInfo{GPU: 80}, Memory: nvidiadocker.MemoryInfo{GlobalUsed: 1000, GlobalTotal: 4000}},
+		{UUID: "GPU-1", Utilization: nvidiadocker.UtilizationInfo{GPU: 40}},
+	}
+	containers := []*docker.Container{{ID: "a", Name: "/train"}, {ID: "b", Name: "/sidecar"}}
+
+	metrics := prometheusMetrics("", containers, [][]int{{0, 1}, nil}, gpuDevices, []map[int]float64{{0: 0.5}, {}}, map[int]int{0: 2, 1: 1})
+	values := map[string]float64{}
+	for _, metric := range metrics {
+		if metric.Labels["container_id"] == "b" {
+			t.Fatal("expected no metrics of a container without GPUs")
+		}
+		if _, found := metric.Labels["host"]; found {
+			t.Fatal("expected no host label for the local host")
+		}
+		values[metric.Name] = metric.Value
+	}
+	for name, expected := range map[string]float64{
+		"nvidiadocker_container_gpu_count":               2,
+		"nvidiadocker_container_gpu_utilization_ratio":   0.8,
+		"nvidiadocker_container_gpu_memory_used_bytes":   float64(500 * nvidiadocker.MiB),
+		"nvidiadocker_container_gpu_power_draw_watts":    100,
+		"nvidiadocker_host_gpu_total":                    2,
+		"nvidiadocker_host_gpu_allocated":                2,
+		"nvidiadocker_container_gpu_memory_total_bytes":  float64(4000 * nvidiadocker.MiB),
+		"nvidiadocker_container_gpu_temperature_celsius": 0,
+	} {
+		if values[name] != expected {
+			t.Fatalf("%s: expected %v, got %v", name, expected, values[name])
+		}
+	}
+}
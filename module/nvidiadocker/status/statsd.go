@@ -0,0 +1,55 @@
+package status
+
+import (
+	"strings"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// statsdGauges returns the StatsD gauges of the GPU utilization of the host,
+// the mean over its GPUs, and of the containers with GPUs, summed over their
+// GPUs and scaled to their shares like their events. The gauges of a remote
+// host are named after it.
+func statsdGauges(host string, containers []*docker.Container, deviceIndices [][]int, gpuDevices []nvidiadocker.DeviceStatus, shares []map[int]float64, users map[int]int) map[string]float64 {
+	var prefix []string
+	if host != "" {
+		prefix = []string{host}
+	}
+	name := func(components ...string) string {
+		return nvidiadocker.StatsdName(append(prefix, components...)...)
+	}
+
+	hostStatus := &ContainerStatus{}
+	for i := range gpuDevices {
+		hostStatus.AddDevice(&gpuDevices[i])
+	}
+	gauges := map[string]float64{
+		name("host", "gpu", "total"):       float64(len(gpuDevices)),
+		name("host", "gpu", "allocated"):   float64(len(users)),
+		name("host", "gpu", "utilization"): hostStatus.Efficiency(),
+	}
+
+	for i, container := range containers {
+		if len(deviceIndices[i]) == 0 {
+			continue
+		}
+		var containerShares map[int]float64
+		if shares != nil {
+			containerShares = shares[i]
+		}
+		cStatus := &ContainerStatus{}
+		for _, index := range deviceIndices[i] {
+			cStatus.AddDevice(sharedDevice(&gpuDevices[index], containerShares, index))
+		}
+
+		containerName := strings.TrimPrefix(container.Name, "/")
+		if containerName == "" {
+			containerName = container.ID
+		}
+		gauges[name("containers", containerName, "gpu", "count")] = float64(len(deviceIndices[i]))
+		gauges[name("containers", containerName, "gpu", "utilization")] = nvidiadocker.Percent(cStatus.GPUSum())
+		gauges[name("containers", containerName, "gpu", "memory", "utilization")] = nvidiadocker.Percent(cStatus.GPUMemorySum())
+	}
+	return gauges
+}
@@ -0,0 +1,30 @@
+package status
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestStatsdGauges(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{UUID: "GPU-0", Utilization: nvidiadocker.UtilizationInfo{GPU: 80, Memory: 20}},
+		{UUID: "GPU-1", Utilization: nvidiadocker.UtilizationInfo{GPU: 40}},
+	}
+	containers := []*docker.Container{{ID: "a", Name: "/train.v2"}, {ID: "b", Name: "/sidecar"}}
+
+	gauges := statsdGauges("gpu1:2376", containers, [][]int{{0, 1}, nil}, gpuDevices, []map[int]float64{{0: 0.5}, {}}, map[int]int{0: 2, 1: 1})
+	expected := map[string]float64{
+		"gpu1_2376.host.gpu.total":                             2,
+		"gpu1_2376.host.gpu.allocated":                         2,
+		"gpu1_2376.host.gpu.utilization":                       0.6,
+		"gpu1_2376.containers.train_v2.gpu.count":              2,
+		"gpu1_2376.containers.train_v2.gpu.utilization":        0.8,
+		"gpu1_2376.containers.train_v2.gpu.memory.utilization": 0.1,
+	}
+	if !reflect.DeepEqual(gauges, expected) {
+		t.Fatalf("expected %v, got %v", expected, gauges)
+	}
+}
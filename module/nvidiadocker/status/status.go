@@ -1,27 +1,20 @@
 package status
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
 	docker "github.com/fsouza/go-dockerclient"
 )
 
-var (
-	nvidiaDeviceRegexp = regexp.MustCompile("^/dev/nvidia([0-9]+)$")
-)
-
 // init registers the MetricSet with the central registry.
 // The New method will be called after the setup of the module and before starting to fetch data
 func init() {
-	if err := mb.Registry.AddMetricSet("nvidiadocker", "status", New); err != nil {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "status", New, nvidiadocker.ParseHost); err != nil {
 		panic(err)
 	}
 }
@@ -32,37 +25,217 @@ func init() {
 // multiple fetch calls.
 type MetricSet struct {
 	mb.BaseMetricSet
-	apiURL       string
-	dockerClient *docker.Client
+	collector       nvidiadocker.GPUCollector
+	containers      *nvidiadocker.ContainerCache
+	reportPerDevice bool
+	format          eventFormat
+	emitNonGPU      bool
+	composeProjects bool
+	imageEvents     bool
+	attribution     string
+	idle            *idleDetector
+	allocations     *allocationTracker
+	versions        *nvidiadocker.VersionCache
+	driver          *nvidiadocker.DriverCheck
+	gate            *nvidiadocker.FetchGate
+	backend         *nvidiadocker.Backend
+	hostFS          string
+	localHost       bool
+
+	// kubeletCheckpoint is read on every fetch for the GPUs the kubelet
+	// allocated to the containers of pods.
+	kubeletCheckpoint string
+
+	// counters holds the energy counters of the previous fetch, to attribute
+	// the energy consumed since to the containers.
+	counters *nvidiadocker.CounterStore
+
+	// tracker detects the GPUs that were reset or fell off the bus since the
+	// previous fetch, whose containers may have been attributed wrong GPUs.
+	tracker *nvidiadocker.DeviceTracker
+
+	// prometheus serves the metrics of the latest fetch if the Prometheus
+	// endpoint is enabled, until they are stale after period.
+	prometheus *nvidiadocker.PrometheusExporter
+	period     time.Duration
+
+	// statsd pushes the GPU utilization of the latest fetch if the StatsD
+	// push is enabled.
+	statsd *nvidiadocker.StatsdPusher
+
+	// health serves the outcome of the latest fetch if the health endpoint
+	// is enabled.
+	health *nvidiadocker.HealthServer
+
+	// watchdog is notified after the fetches if the beat is run by systemd
+	// with a watchdog.
+	watchdog *nvidiadocker.Watchdog
+
+	// containerClient lists and inspects the stopped containers with
+	// includeExited, to report the end of their GPU allocation.
+	containerClient nvidiadocker.ContainerClient
+	includeExited   bool
+
+	// sampler samples the GPUs between fetches if sample_interval is set,
+	// independently of the sampler of the gpu MetricSet.
+	sampler *nvidiadocker.Sampler
 }
 
 type ContainerStatus struct {
-	devices []*DeviceStatus
+	devices []*nvidiadocker.DeviceStatus
+
+	energy    float64
+	hasEnergy bool
 }
 
-func (c *ContainerStatus) AddDevice(device *DeviceStatus) {
+func (c *ContainerStatus) AddDevice(device *nvidiadocker.DeviceStatus) {
 	c.devices = append(c.devices, device)
 }
 
+// AddEnergy adds the energy in joules attributed to the container on one of
+// its devices.
+func (c *ContainerStatus) AddEnergy(joules float64) {
+	c.energy += joules
+	c.hasEnergy = true
+}
+
 func (c *ContainerStatus) GPUSum() uint {
-	return c.PropSum(func(device *DeviceStatus) uint {
+	return c.PropSum(func(device *nvidiadocker.DeviceStatus) uint {
 		return device.Utilization.GPU
 	})
 }
 
 func (c *ContainerStatus) GPUMemorySum() uint {
-	return c.PropSum(func(device *DeviceStatus) uint {
+	return c.PropSum(func(device *nvidiadocker.DeviceStatus) uint {
 		return device.Utilization.Memory
 	})
 }
 
+func (c *ContainerStatus) EncoderSum() uint {
+	return c.PropSum(func(device *nvidiadocker.DeviceStatus) uint {
+		return device.Utilization.Encoder
+	})
+}
+
+func (c *ContainerStatus) DecoderSum() uint {
+	return c.PropSum(func(device *nvidiadocker.DeviceStatus) uint {
+		return device.Utilization.Decoder
+	})
+}
+
+func (c *ContainerStatus) EncoderSessionSum() uint {
+	return c.PropSum(func(device *nvidiadocker.DeviceStatus) uint {
+		return device.EncoderStats.SessionCount
+	})
+}
+
+func (c *ContainerStatus) MemoryUsedSum() uint64 {
+	return c.PropSum64(func(device *nvidiadocker.DeviceStatus) uint64 {
+		return device.Memory.GlobalUsed * nvidiadocker.MiB
+	})
+}
+
+func (c *ContainerStatus) MemoryTotalSum() uint64 {
+	return c.PropSum64(func(device *nvidiadocker.DeviceStatus) uint64 {
+		return device.Memory.GlobalTotal * nvidiadocker.MiB
+	})
+}
+
+func (c *ContainerStatus) MemoryFreeSum() uint64 {
+	return c.PropSum64(func(device *nvidiadocker.DeviceStatus) uint64 {
+		return device.Memory.GlobalFree * nvidiadocker.MiB
+	})
+}
+
+func (c *ContainerStatus) PowerSum() float64 {
+	return c.PropSumFloat(func(device *nvidiadocker.DeviceStatus) float64 {
+		return device.Power
+	})
+}
+
+func (c *ContainerStatus) PowerLimitSum() float64 {
+	return c.PropSumFloat(func(device *nvidiadocker.DeviceStatus) float64 {
+		return device.PowerLimit
+	})
+}
+
+func (c *ContainerStatus) PowerEnforcedLimitSum() float64 {
+	return c.PropSumFloat(func(device *nvidiadocker.DeviceStatus) float64 {
+		return device.PowerEnforcedLimit
+	})
+}
+
+func (c *ContainerStatus) PCIRXSum() uint64 {
+	return c.PropSum64(func(device *nvidiadocker.DeviceStatus) uint64 {
+		return uint64(device.PCI.Throughput.RX) * nvidiadocker.MiB
+	})
+}
+
+func (c *ContainerStatus) PCITXSum() uint64 {
+	return c.PropSum64(func(device *nvidiadocker.DeviceStatus) uint64 {
+		return uint64(device.PCI.Throughput.TX) * nvidiadocker.MiB
+	})
+}
+
 func (c *ContainerStatus) TemperatureAverage() float64 {
-	return c.PropAverage(func(device *DeviceStatus) uint {
+	return c.PropAverage(func(device *nvidiadocker.DeviceStatus) uint {
 		return device.Temperature
 	})
 }
 
-func (c *ContainerStatus) PropSum(getPropFunc func(device *DeviceStatus) uint) uint {
+// ProfilingAverage averages a DCGM profiling metric over the devices that
+// report profiling metrics.
+func (c *ContainerStatus) ProfilingAverage(getPropFunc func(profiling *nvidiadocker.ProfilingInfo) float64) float64 {
+	var total float64
+	var count int
+	for _, device := range c.devices {
+		if device.Profiling == nil {
+			continue
+		}
+		total += getPropFunc(device.Profiling)
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
+
+// HasProfiling returns whether any device reports DCGM profiling metrics.
+func (c *ContainerStatus) HasProfiling() bool {
+	for _, device := range c.devices {
+		if device.Profiling != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// TemperatureHeadroomMin returns the lowest headroom of the devices to their
+// slowdown temperature. ok is false if no device reports its slowdown
+// temperature.
+func (c *ContainerStatus) TemperatureHeadroomMin() (headroom int, ok bool) {
+	for _, device := range c.devices {
+		if device.TemperatureThresholds.Slowdown == 0 {
+			continue
+		}
+		h := int(device.TemperatureThresholds.Slowdown) - int(device.Temperature)
+		if !ok || h < headroom {
+			headroom = h
+			ok = true
+		}
+	}
+	return headroom, ok
+}
+
+// Efficiency returns the mean GPU utilization of the devices as a ratio.
+func (c *ContainerStatus) Efficiency() float64 {
+	return c.PropAverage(func(device *nvidiadocker.DeviceStatus) uint {
+		return device.Utilization.GPU
+	}) / 100
+}
+
+func (c *ContainerStatus) PropSum(getPropFunc func(device *nvidiadocker.DeviceStatus) uint) uint {
 	var total uint
 	for _, device := range c.devices {
 		total += getPropFunc(device)
@@ -70,7 +243,23 @@ func (c *ContainerStatus) PropSum(getPropFunc func(device *DeviceStatus) uint) u
 	return total
 }
 
-func (c *ContainerStatus) PropAverage(getPropFunc func(device *DeviceStatus) uint) float64 {
+func (c *ContainerStatus) PropSum64(getPropFunc func(device *nvidiadocker.DeviceStatus) uint64) uint64 {
+	var total uint64
+	for _, device := range c.devices {
+		total += getPropFunc(device)
+	}
+	return total
+}
+
+func (c *ContainerStatus) PropSumFloat(getPropFunc func(device *nvidiadocker.DeviceStatus) float64) float64 {
+	var total float64
+	for _, device := range c.devices {
+		total += getPropFunc(device)
+	}
+	return total
+}
+
+func (c *ContainerStatus) PropAverage(getPropFunc func(device *nvidiadocker.DeviceStatus) uint) float64 {
 	if len(c.devices) == 0 {
 		return 0
 	}
@@ -87,115 +276,598 @@ func (c *ContainerStatus) PropAverage(getPropFunc func(device *DeviceStatus) uin
 // configuration entries if needed.
 func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
 
-	config := struct {
-		APIURL         string `config:"apiurl"`
-		DockerEndpoint string `config:"dockerendpoint"`
-	}{
-		APIURL:         "",
-		DockerEndpoint: "",
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+	if err := config.ApplyHost(base.HostData().URI); err != nil {
+		return nil, err
 	}
 
-	if err := base.Module().UnpackConfig(&config); err != nil {
+	format, err := newEventFormat(config)
+	if err != nil {
+		return nil, err
+	}
+
+	idle, err := newIdleDetector(config.IdleDetection)
+	if err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	attribution, err := validateAttribution(config.SharedGPUAttribution, collector)
+	if err != nil {
 		return nil, err
 	}
 
-	dockerClient, err := docker.NewClient(config.DockerEndpoint)
+	containerClient, err := nvidiadocker.NewContainerClient(config)
 	if err != nil {
 		return nil, err
 	}
 
+	var prometheus *nvidiadocker.PrometheusExporter
+	if config.Prometheus.Enabled {
+		if prometheus, err = nvidiadocker.NewPrometheusExporter(config.Prometheus); err != nil {
+			return nil, err
+		}
+	}
+
+	var health *nvidiadocker.HealthServer
+	if config.Health.Enabled {
+		if health, err = nvidiadocker.NewHealthServer(config.Health); err != nil {
+			return nil, err
+		}
+	}
+
+	gate := nvidiadocker.NewFetchGate(config, base)
+
+	var watchdog *nvidiadocker.Watchdog
+	if config.Watchdog.Enabled {
+		if watchdog, err = nvidiadocker.NewWatchdog(config.Watchdog, gate.Period()); err != nil {
+			return nil, err
+		}
+	}
+
+	var statsd *nvidiadocker.StatsdPusher
+	if config.Statsd.Enabled {
+		if statsd, err = nvidiadocker.NewStatsdPusher(config.Statsd, gate.Period()); err != nil {
+			return nil, err
+		}
+	}
+
+	var sampler *nvidiadocker.Sampler
+	if config.SampleInterval > 0 {
+		if config.SampleInterval >= gate.Period() {
+			return nil, fmt.Errorf("sample_interval %v must be shorter than the period %v", config.SampleInterval, gate.Period())
+		}
+		sampler = nvidiadocker.NewSampler(collector, config.SampleInterval, gate.Period())
+	}
+
 	return &MetricSet{
-		BaseMetricSet: base,
-		apiURL:        config.APIURL,
-		dockerClient:  dockerClient,
+		BaseMetricSet:     base,
+		collector:         collector,
+		containers:        nvidiadocker.NewContainerCache(containerClient, nvidiadocker.NewContainerFilter(config), gate.Period()),
+		reportPerDevice:   config.ReportPerDevice,
+		format:            format,
+		emitNonGPU:        config.EmitNonGPUContainers,
+		composeProjects:   config.ComposeProjectEvents,
+		imageEvents:       config.ImageEvents,
+		attribution:       attribution,
+		idle:              idle,
+		allocations:       newAllocationTracker(),
+		versions:          nvidiadocker.NewVersionCache(collector),
+		driver:            nvidiadocker.NewDriverCheck(config),
+		gate:              gate,
+		counters:          nvidiadocker.NewCounterStore(),
+		tracker:           nvidiadocker.NewDeviceTracker(),
+		prometheus:        prometheus,
+		period:            gate.Period(),
+		statsd:            statsd,
+		health:            health,
+		watchdog:          watchdog,
+		kubeletCheckpoint: config.KubeletCheckpointPath(),
+		backend:           config.ContainerBackend(),
+		hostFS:            config.HostFS,
+		localHost:         config.LocalHost(),
+		sampler:           sampler,
+		containerClient:   containerClient,
+		includeExited:     config.IncludeExited,
 	}, nil
 }
 
 // Fetch methods implements the data gathering and data conversion to the right format
 // It returns the event which is then forward to the output. In case of an error, a
 // descriptive error must be returned.
-func (m *MetricSet) Fetch() ([]common.MapStr, error) {
-	apiContainers, err := m.dockerClient.ListContainers(docker.ListContainersOptions{})
-	if err != nil {
-		return nil, err
+func (m *MetricSet) Fetch() (_ []common.MapStr, err error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
 	}
 
-	if len(apiContainers) == 0 {
-		return []common.MapStr{}, nil
+	var health nvidiadocker.FetchHealth
+	defer func() {
+		health.Err = err
+		m.health.Report(m.Host(), m.period, health)
+		m.watchdog.Report(m.Host(), err)
+	}()
+
+	// Sampling starts with the first fetch, whose events have no samples. The
+	// samples are dropped by the fetches reporting no GPUs.
+	var summaries map[string]nvidiadocker.SampleSummary
+	if m.sampler != nil {
+		m.sampler.Start()
+		summaries = m.sampler.Summaries()
 	}
 
-	gpuDevices, err := getGPUDeviceStatus(m.apiURL)
+	trace := nvidiadocker.NewFetchTrace(m.BaseMetricSet)
+	containers, failures, err := m.containers.Containers(trace)
 	if err != nil {
+		health.ContainersErr = err
 		return nil, err
 	}
 
-	return m.fetchFromContainers(apiContainers, gpuDevices)
-}
+	if len(containers) == 0 {
+		m.prometheus.Set(m.Host(), m.period, nil)
+		m.statsd.Set(nil)
+		return append(m.releaseEvents(m.allocations.commit()), m.format.failureEvents(failures)...), nil
+	}
 
-func (m *MetricSet) fetchFromContainers(apiContainers []docker.APIContainers, gpuDevices []DeviceStatus) ([]common.MapStr, error) {
-	allEvents := make([]common.MapStr, 0, len(apiContainers))
-	for _, apiContainer := range apiContainers {
-		if container, err := m.dockerClient.InspectContainer(apiContainer.ID); err == nil {
-			event := fetchFromContainer(container, gpuDevices)
-			allEvents = append(allEvents, event)
+	if !m.driver.Available() {
+		m.prometheus.Set(m.Host(), m.period, nil)
+		m.statsd.Set(nil)
+		health.GPUs = new(int)
+		if !m.emitNonGPU {
+			containers = requestingGPUs(containers)
 		}
+		return append(m.format.fetchWithoutDriver(containers), m.format.failureEvents(failures)...), nil
 	}
-	return allEvents, nil
-}
 
-func getGPUDeviceStatus(apiURL string) ([]DeviceStatus, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/v1.0/gpu/status/json", apiURL))
+	gpuDevices, err := m.collector.Query(nil)
 	if err != nil {
 		return nil, err
 	}
-	bytes, err := ioutil.ReadAll(resp.Body)
+	trace.Phase("gpu_query", "gpus", len(gpuDevices))
+	gpus := len(gpuDevices)
+	health.GPUs = &gpus
+
+	events, err := m.fetchFromContainers(containers, gpuDevices, summaries)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	trace.Phase("attribute", "containers", len(containers))
 
-	status := NvidiaStatus{}
-	if err := json.Unmarshal(bytes, &status); err != nil {
-		return nil, err
+	events = append(events, m.format.failureEvents(failures)...)
+	m.versions.AddTo(events)
+	trace.Done(len(events))
+	return events, nil
+}
+
+func (m *MetricSet) fetchFromContainers(cached []*nvidiadocker.CachedContainer, gpuDevices []nvidiadocker.DeviceStatus, summaries map[string]nvidiadocker.SampleSummary) ([]common.MapStr, error) {
+	var (
+		containers    = make([]*docker.Container, 0, len(cached))
+		deviceIndices = make([][]int, 0, len(cached))
+		users         = map[int]int{}
+		allocations   = nvidiadocker.LoadKubeletAllocations(m.kubeletCheckpoint)
+		reset         = m.tracker.Update(gpuDevices)
+	)
+	for _, c := range cached {
+		indices := m.backend.ContainerDeviceIndices(c.Container, c.Runtime, allocations, gpuDevices, m.hostFS)
+		for _, index := range indices {
+			users[index]++
+		}
+		containers = append(containers, c.Container)
+		deviceIndices = append(deviceIndices, indices)
+	}
+
+	energy := m.energyShares(gpuDevices, users)
+	containerIDs := make([]string, 0, len(containers))
+	for _, container := range containers {
+		containerIDs = append(containerIDs, container.ID)
+	}
+	memory := m.processMemory()
+	shares := m.deviceShares(containerIDs, deviceIndices, users, gpuDevices, memory)
+
+	allEvents := make([]common.MapStr, 0, len(containers))
+	for i, container := range containers {
+		if missing := nvidiadocker.MissingDevices(container, gpuDevices); len(missing) > 0 {
+			allEvents = append(allEvents, m.format.containerErrorEvent(container,
+				fmt.Errorf("GPU devices %s not found", strings.Join(missing, ", "))))
+		}
+
+		if len(deviceIndices[i]) == 0 && !m.emitNonGPU {
+			continue
+		}
+
+		var events []common.MapStr
+		if m.idle != nil && len(deviceIndices[i]) > 0 {
+			since, idle := m.idle.observe(container.ID, peakUtilization(deviceIndices[i], gpuDevices))
+			if idle {
+				events = append(events, m.format.idleEvent(container, deviceIndices[i], gpuDevices, since, m.idle))
+			}
+		}
+
+		var containerShares map[int]float64
+		if shares != nil {
+			containerShares = shares[i]
+		}
+		containerEnergy := attributedEnergy(energy, users, containerShares)
+		var containerEvents []common.MapStr
+		if m.reportPerDevice {
+			containerEvents = m.format.fetchFromContainerDevices(container, deviceIndices[i], gpuDevices, containerEnergy, containerShares)
+		} else {
+			containerEvents = []common.MapStr{m.format.fetchFromContainer(container, deviceIndices[i], gpuDevices, containerEnergy, containerShares)}
+		}
+		if memory != nil && len(deviceIndices[i]) > 0 {
+			addProcessMemory(containerEvents, m.reportPerDevice, deviceIndices[i], gpuDevices, memory[container.ID])
+		}
+		if len(deviceIndices[i]) > 0 {
+			addResetDetected(containerEvents, m.reportPerDevice, deviceIndices[i], gpuDevices, reset)
+			addSampleTimestamp(containerEvents, m.reportPerDevice, deviceIndices[i], gpuDevices)
+			addSampleBuckets(containerEvents, m.reportPerDevice, deviceIndices[i], gpuDevices, summaries)
+		}
+		events = append(events, containerEvents...)
+
+		if len(deviceIndices[i]) > 0 {
+			m.allocations.addTo(events, m.allocations.since(container))
+		}
+		allEvents = append(allEvents, events...)
+	}
+	if m.idle != nil {
+		m.idle.commit()
+	}
+	allEvents = append(allEvents, m.releaseEvents(m.allocations.commit())...)
+	if m.composeProjects {
+		allEvents = append(allEvents, m.format.groupEvents("compose", composeProject, containers, deviceIndices, gpuDevices)...)
+	}
+	if m.imageEvents {
+		allEvents = append(allEvents, m.format.groupEvents("image", containerImage, containers, deviceIndices, gpuDevices)...)
+	}
+	if m.prometheus != nil {
+		m.prometheus.Set(m.Host(), m.period, prometheusMetrics(m.Host(), containers, deviceIndices, gpuDevices, shares, users))
+	}
+	if m.statsd != nil {
+		m.statsd.Set(statsdGauges(m.Host(), containers, deviceIndices, gpuDevices, shares, users))
 	}
+	return append(allEvents, hostEvent(gpuDevices, users)), nil
+}
+
+// addResetDetected flags the events of a container with gpu.reset_detected if
+// one of its GPUs was reset or fell off the bus since the previous fetch, or
+// the GPU of the event with report_per_device. reset holds the UUIDs of those
+// GPUs.
+func addResetDetected(events []common.MapStr, perDevice bool, indices []int, gpuDevices []nvidiadocker.DeviceStatus, reset map[string]bool) {
+	if perDevice {
+		for i, event := range events {
+			event.Put("gpu.reset_detected", reset[gpuDevices[indices[i]].UUID])
+		}
+		return
+	}
+
+	detected := false
+	for _, index := range indices {
+		detected = detected || reset[gpuDevices[index].UUID]
+	}
+	for _, event := range events {
+		event.Put("gpu.reset_detected", detected)
+	}
+}
 
-	return status.Devices, nil
+// addSampleTimestamp adds when the GPUs of the container were sampled, the
+// oldest sample for the events of all its GPUs. The containers are listed at
+// the start of the fetch, the @timestamp of the events, the GPUs are sampled
+// after.
+func addSampleTimestamp(events []common.MapStr, perDevice bool, indices []int, gpuDevices []nvidiadocker.DeviceStatus) {
+	if perDevice {
+		for i, event := range events {
+			if timestamp := gpuDevices[indices[i]].Timestamp; !timestamp.IsZero() {
+				event.Put("gpu.sample.timestamp", common.Time(timestamp))
+			}
+		}
+		return
+	}
+
+	var oldest time.Time
+	for _, index := range indices {
+		timestamp := gpuDevices[index].Timestamp
+		if !timestamp.IsZero() && (oldest.IsZero() || timestamp.Before(oldest)) {
+			oldest = timestamp
+		}
+	}
+	if oldest.IsZero() {
+		return
+	}
+	for _, event := range events {
+		event.Put("gpu.sample.timestamp", common.Time(oldest))
+	}
 }
 
-func fetchFromContainer(container *docker.Container, gpuDevices []DeviceStatus) common.MapStr {
+// addSampleBuckets adds the number of GPU utilization samples by quarter taken
+// since the previous fetch, summed over the GPUs of the container, or for the
+// GPU of the event with report_per_device. GPUs without samples, like on the
+// first fetch, are left out.
+func addSampleBuckets(events []common.MapStr, perDevice bool, indices []int, gpuDevices []nvidiadocker.DeviceStatus, summaries map[string]nvidiadocker.SampleSummary) {
+	if perDevice {
+		for i, event := range events {
+			if summary, found := summaries[nvidiadocker.DeviceKey(&gpuDevices[indices[i]])]; found && summary.Count > 0 {
+				event.Put("gpu.samples.utilization.buckets", summary.GPUBuckets.MapStr())
+			}
+		}
+		return
+	}
+
 	var (
-		gpuDevicesLen   = len(gpuDevices)
-		containerID     = container.ID
-		containerName   = strings.TrimPrefix(container.Name, "/")
-		containerLabels = container.Config.Labels
-		event           = common.MapStr{
-			"containerid":   containerID,
-			"containername": containerName,
-			"labels":        containerLabels,
+		buckets nvidiadocker.UtilizationBuckets
+		sampled bool
+	)
+	for _, index := range indices {
+		if summary, found := summaries[nvidiadocker.DeviceKey(&gpuDevices[index])]; found && summary.Count > 0 {
+			buckets.Add(summary.GPUBuckets)
+			sampled = true
+		}
+	}
+	if !sampled {
+		return
+	}
+	for _, event := range events {
+		event.Put("gpu.samples.utilization.buckets", buckets.MapStr())
+	}
+}
+
+// hostEvent returns the event of the host, with the number of GPUs allocated
+// to the reported containers, the number of those with a non-zero
+// utilization, and their ratio as the efficiency of the host.
+func hostEvent(gpuDevices []nvidiadocker.DeviceStatus, users map[int]int) common.MapStr {
+	var allocated, utilized int
+	for index, count := range users {
+		if count == 0 {
+			continue
+		}
+		allocated++
+		if gpuDevices[index].Utilization.GPU > 0 {
+			utilized++
+		}
+	}
+
+	gpu := common.MapStr{
+		"total":     len(gpuDevices),
+		"allocated": allocated,
+		"utilized":  utilized,
+	}
+	if allocated > 0 {
+		gpu["efficiency"] = float64(utilized) / float64(allocated)
+	}
+	return common.MapStr{
+		"host": common.MapStr{"gpu": gpu},
+	}
+}
+
+// energyShares returns the energy in joules each container using a device is
+// attributed, by device position, from the energy the device consumed since
+// the previous fetch split evenly between the containers using it. Devices
+// without an energy counter, or queried for the first time, are left out.
+func (m *MetricSet) energyShares(gpuDevices []nvidiadocker.DeviceStatus, users map[int]int) map[int]float64 {
+	shares := map[int]float64{}
+	for i := range gpuDevices {
+		device := &gpuDevices[i]
+		if device.Energy == 0 {
+			continue
 		}
+		delta, ok := m.counters.Update(nvidiadocker.DeviceKey(device)+"/energy", device.Energy, nvidiadocker.Counter64)
+		if ok && users[i] > 0 {
+			shares[i] = float64(delta.Delta) / 1000 / float64(users[i])
+		}
+	}
+	m.counters.Commit()
+	return shares
+}
+
+// fetchFromContainer returns the event of the container, with the values of
+// the GPUs it has access to aggregated. The GPUs it shares with other
+// containers are scaled to their share, if any. In both formats, the number
+// of GPUs, their identities and their mean utilization are reported as
+// gpu.count, gpu.devices and gpu.efficiency.
+func (f eventFormat) fetchFromContainer(container *docker.Container, indices []int, gpuDevices []nvidiadocker.DeviceStatus, energy map[int]float64, shares map[int]float64) common.MapStr {
+	var (
+		event   = f.containerEvent(container)
 		cStatus = &ContainerStatus{}
 	)
 
-	for _, device := range container.HostConfig.Devices {
-		if findStrs := nvidiaDeviceRegexp.FindStringSubmatch(device.PathOnHost); findStrs != nil && len(findStrs) == 2 {
-			if nvidiaIndex, err := strconv.ParseInt(findStrs[1], 10, 64); err == nil {
-				if int(nvidiaIndex) < gpuDevicesLen {
-					cStatus.AddDevice(&gpuDevices[nvidiaIndex])
-				}
-			}
+	local := newContainerIndices(container, indices, gpuDevices)
+	identities := make([]common.MapStr, 0, len(indices))
+	for _, index := range indices {
+		cStatus.AddDevice(sharedDevice(&gpuDevices[index], shares, index))
+		if joules, found := energy[index]; found {
+			cStatus.AddEnergy(joules)
+		}
+		identities = append(identities, f.sharedDeviceIdentity(index, &gpuDevices[index], shares, local))
+	}
+
+	var (
+		device = f.deviceMapping(cStatus)
+		gpu    = device
+	)
+	if f.ecs {
+		gpu["devices"] = identities
+	} else {
+		device["Devices"] = identities
+		event["device"] = device
+		gpu = common.MapStr{"devices": gpuIdentities(indices, gpuDevices, shares, local)}
+	}
+	gpu["count"] = len(indices)
+	if len(indices) > 0 {
+		gpu["efficiency"] = cStatus.Efficiency()
+	}
+	event["gpu"] = gpu
+	return event
+}
+
+// gpuIdentities returns the identities of the GPUs at the given positions in
+// the gpu.devices layout.
+func gpuIdentities(indices []int, gpuDevices []nvidiadocker.DeviceStatus, shares map[int]float64, local containerIndices) []common.MapStr {
+	identities := make([]common.MapStr, 0, len(indices))
+	for _, index := range indices {
+		identities = append(identities, ecsFormat.sharedDeviceIdentity(index, &gpuDevices[index], shares, local))
+	}
+	return identities
+}
+
+// fetchFromContainerDevices returns one event per GPU the container has
+// access to, identified by the index and UUID of the GPU, with the number of
+// GPUs of the container in gpu.count.
+func (f eventFormat) fetchFromContainerDevices(container *docker.Container, indices []int, gpuDevices []nvidiadocker.DeviceStatus, energy map[int]float64, shares map[int]float64) []common.MapStr {
+	local := newContainerIndices(container, indices, gpuDevices)
+	events := make([]common.MapStr, 0, len(indices))
+	for _, index := range indices {
+		device := &gpuDevices[index]
+		cStatus := &ContainerStatus{}
+		cStatus.AddDevice(sharedDevice(device, shares, index))
+		if joules, found := energy[index]; found {
+			cStatus.AddEnergy(joules)
+		}
+
+		deviceEvent := f.deviceMapping(cStatus)
+		for key, value := range f.sharedDeviceIdentity(index, device, shares, local) {
+			deviceEvent[key] = value
+		}
+
+		event := f.containerEvent(container)
+		event[f.deviceKey()] = deviceEvent
+		if f.ecs {
+			deviceEvent["count"] = len(indices)
+		} else {
+			event["gpu"] = common.MapStr{"count": len(indices)}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// failureEvents returns one error event per container that could not be
+// read, so that the other containers are still reported.
+func (f eventFormat) failureEvents(failures []*nvidiadocker.ContainerError) []common.MapStr {
+	events := make([]common.MapStr, 0, len(failures))
+	for _, failure := range failures {
+		event := f.containerIDEvent(failure.ID)
+		event["error"] = common.MapStr{"message": failure.Err.Error()}
+		events = append(events, event)
+	}
+	return events
+}
+
+// containerErrorEvent returns an error event of the given container.
+func (f eventFormat) containerErrorEvent(container *docker.Container, err error) common.MapStr {
+	event := f.containerEvent(container)
+	event["error"] = common.MapStr{"message": err.Error()}
+	return event
+}
+
+// requestingGPUs returns the containers that were started with GPUs, which
+// on hosts without the NVIDIA driver cannot be resolved to devices: mapping
+// /dev/nvidiaN devices or the capability devices of MIG instances, with docker
+// run --gpus, with the nvidia runtime and NVIDIA_VISIBLE_DEVICES, or with the
+// driver volume of nvidia-docker 1.x.
+func requestingGPUs(cached []*nvidiadocker.CachedContainer) []*nvidiadocker.CachedContainer {
+	var requesting []*nvidiadocker.CachedContainer
+	for _, c := range cached {
+		_, visibleDevices := nvidiadocker.EnvValue(c.Container.Config.Env, nvidiadocker.NvidiaVisibleDevicesEnv)
+		if len(nvidiadocker.MissingDevices(c.Container, nil)) > 0 || len(nvidiadocker.CapabilityDevices(c.Container)) > 0 ||
+			c.Runtime.GPURequest() != nil ||
+			(c.Runtime.UsesNvidiaRuntime() && visibleDevices) || nvidiadocker.NVIDIADockerV1(c.Container) {
+			requesting = append(requesting, c)
 		}
 	}
+	return requesting
+}
+
+// fetchWithoutDriver returns one event per container on hosts without the
+// NVIDIA driver, marking the GPUs as unavailable.
+func (f eventFormat) fetchWithoutDriver(cached []*nvidiadocker.CachedContainer) []common.MapStr {
+	events := make([]common.MapStr, 0, len(cached))
+	for _, c := range cached {
+		event := f.containerEvent(c.Container)
+		event["gpu"] = common.MapStr{"available": false}
+		events = append(events, event)
+	}
+	return events
+}
+
+func legacyContainerEvent(container *docker.Container, labels map[string]string) common.MapStr {
+	return common.MapStr{
+		"containerid":   container.ID,
+		"containername": strings.TrimPrefix(container.Name, "/"),
+		"labels":        labels,
+	}
+}
+
+// legacyDeviceIdentity identifies a GPU by its UUID and PCI bus ID, which unlike
+// the index stay the same when the GPUs are enumerated in a different order.
+func legacyDeviceIdentity(position int, device *nvidiadocker.DeviceStatus) common.MapStr {
+	identity := common.MapStr{
+		"Index": uint(position),
+		"UUID":  device.UUID,
+		"Name":  device.Name,
+		"BusID": device.PCI.BusID,
+	}
+	if device.Index != nil {
+		identity["Index"] = *device.Index
+	}
+	return identity
+}
 
-	event["device"] = common.MapStr{
+func deviceMapping(cStatus *ContainerStatus) common.MapStr {
+	device := common.MapStr{
 		"Utilization": common.MapStr{
-			"GPU":    cStatus.GPUSum(),
-			"Memory": cStatus.GPUMemorySum(),
+			"GPU":     cStatus.GPUSum(),
+			"Memory":  cStatus.GPUMemorySum(),
+			"Encoder": cStatus.EncoderSum(),
+			"Decoder": cStatus.DecoderSum(),
+		},
+		"EncoderSessions": cStatus.EncoderSessionSum(),
+		"Memory": common.MapStr{
+			"Used":  cStatus.MemoryUsedSum(),
+			"Total": cStatus.MemoryTotalSum(),
+			"Free":  cStatus.MemoryFreeSum(),
 		},
 		"Temperature": cStatus.TemperatureAverage(),
+		"PCI": common.MapStr{
+			"RX": cStatus.PCIRXSum(),
+			"TX": cStatus.PCITXSum(),
+		},
+		"Power": common.MapStr{
+			"Draw":          cStatus.PowerSum(),
+			"Limit":         cStatus.PowerLimitSum(),
+			"EnforcedLimit": cStatus.PowerEnforcedLimitSum(),
+		},
 	}
-	return event
+
+	if cStatus.HasProfiling() {
+		device["Profiling"] = profilingMapping(cStatus)
+	}
+	if headroom, ok := cStatus.TemperatureHeadroomMin(); ok {
+		device["TemperatureHeadroom"] = headroom
+	}
+	if cStatus.hasEnergy {
+		device["Energy"] = common.MapStr{
+			"Joules": cStatus.energy,
+		}
+	}
+	return device
 }
 
-func toUintP(val uint) *uint {
-	return &val
+// profilingMapping averages the DCGM profiling metrics, which are ratios, over
+// the devices of the container.
+func profilingMapping(cStatus *ContainerStatus) common.MapStr {
+	return common.MapStr{
+		"GraphicsActive": cStatus.ProfilingAverage(func(p *nvidiadocker.ProfilingInfo) float64 { return p.GraphicsActive }),
+		"SMActive":       cStatus.ProfilingAverage(func(p *nvidiadocker.ProfilingInfo) float64 { return p.SMActive }),
+		"SMOccupancy":    cStatus.ProfilingAverage(func(p *nvidiadocker.ProfilingInfo) float64 { return p.SMOccupancy }),
+		"TensorActive":   cStatus.ProfilingAverage(func(p *nvidiadocker.ProfilingInfo) float64 { return p.TensorActive }),
+		"DRAMActive":     cStatus.ProfilingAverage(func(p *nvidiadocker.ProfilingInfo) float64 { return p.DRAMActive }),
+		"FP64Active":     cStatus.ProfilingAverage(func(p *nvidiadocker.ProfilingInfo) float64 { return p.FP64Active }),
+		"FP32Active":     cStatus.ProfilingAverage(func(p *nvidiadocker.ProfilingInfo) float64 { return p.FP32Active }),
+		"FP16Active":     cStatus.ProfilingAverage(func(p *nvidiadocker.ProfilingInfo) float64 { return p.FP16Active }),
+	}
 }
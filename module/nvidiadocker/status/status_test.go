@@ -2,69 +2,26 @@ package status
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
 	docker "github.com/fsouza/go-dockerclient"
 )
 
-func TestNvidiaDeviceRegexp(t *testing.T) {
-	testDatas := []struct {
-		DeviceName  string
-		DeviceIndex string
-		Matched     bool
-	}{
-		{
-			"/dev/nvidia0",
-			"0",
-			true,
-		},
-		{
-			"/dev/nvidia12",
-			"12",
-			true,
-		},
-		{
-			"/dev/test0",
-			"",
-			false,
-		},
-		{
-			"/nvidia0",
-			"",
-			false,
-		},
-		{
-			"/dev/nvidia",
-			"",
-			false,
-		},
-	}
-
-	for _, testData := range testDatas {
-		findStrs := nvidiaDeviceRegexp.FindStringSubmatch(testData.DeviceName)
-		if (findStrs != nil) != testData.Matched {
-			t.Fatal("not matched")
-		}
-
-		if testData.Matched {
-			if !reflect.DeepEqual([]string{testData.DeviceName, testData.DeviceIndex}, findStrs) {
-				t.Fatal("not matched")
-			}
-		}
-
-	}
-}
-
 func TestFetchFromContainer(t *testing.T) {
 	devicesJSON := `[{"Power":13,"Temperature":15,"Utilization":{"GPU":1,"Memory":1,"Encoder":0,"Decoder":0},"Memory":{"GlobalUsed":8,"ECCErrors":{"L1Cache":null,"L2Cache":null,"Global":null}},"Clocks":{"Cores":40,"Memory":405},"PCI":{"BAR1Used":2,"Throughput":{"RX":0,"TX":0}},"Processes":null},{"Power":9,"Temperature":14,"Utilization":{"GPU":0,"Memory":0,"Encoder":0,"Decoder":0},"Memory":{"GlobalUsed":7,"ECCErrors":{"L1Cache":null,"L2Cache":null,"Global":null}},"Clocks":{"Cores":40,"Memory":405},"PCI":{"BAR1Used":2,"Throughput":{"RX":0,"TX":0}},"Processes":null},{"Power":9,"Temperature":18,"Utilization":{"GPU":0,"Memory":0,"Encoder":0,"Decoder":0},"Memory":{"GlobalUsed":7,"ECCErrors":{"L1Cache":null,"L2Cache":null,"Global":null}},"Clocks":{"Cores":40,"Memory":405},"PCI":{"BAR1Used":2,"Throughput":{"RX":0,"TX":0}},"Processes":null},{"Power":9,"Temperature":16,"Utilization":{"GPU":0,"Memory":0,"Encoder":0,"Decoder":0},"Memory":{"GlobalUsed":7,"ECCErrors":{"L1Cache":null,"L2Cache":null,"Global":null}},"Clocks":{"Cores":40,"Memory":405},"PCI":{"BAR1Used":2,"Throughput":{"RX":0,"TX":0}},"Processes":null},{"Power":9,"Temperature":20,"Utilization":{"GPU":0,"Memory":0,"Encoder":0,"Decoder":0},"Memory":{"GlobalUsed":7,"ECCErrors":{"L1Cache":null,"L2Cache":null,"Global":null}},"Clocks":{"Cores":40,"Memory":405},"PCI":{"BAR1Used":2,"Throughput":{"RX":0,"TX":0}},"Processes":null},{"Power":9,"Temperature":15,"Utilization":{"GPU":0,"Memory":0,"Encoder":0,"Decoder":0},"Memory":{"GlobalUsed":7,"ECCErrors":{"L1Cache":null,"L2Cache":null,"Global":null}},"Clocks":{"Cores":40,"Memory":405},"PCI":{"BAR1Used":2,"Throughput":{"RX":0,"TX":0}},"Processes":null},{"Power":9,"Temperature":18,"Utilization":{"GPU":0,"Memory":0,"Encoder":0,"Decoder":0},"Memory":{"GlobalUsed":7,"ECCErrors":{"L1Cache":null,"L2Cache":null,"Global":null}},"Clocks":{"Cores":40,"Memory":405},"PCI":{"BAR1Used":2,"Throughput":{"RX":0,"TX":0}},"Processes":null},{"Power":9,"Temperature":17,"Utilization":{"GPU":0,"Memory":0,"Encoder":0,"Decoder":0},"Memory":{"GlobalUsed":7,"ECCErrors":{"L1Cache":null,"L2Cache":null,"Global":null}},"Clocks":{"Cores":40,"Memory":405},"PCI":{"BAR1Used":2,"Throughput":{"RX":0,"TX":0}},"Processes":null}]`
-	gpuDevices := []DeviceStatus{}
+	gpuDevices := []nvidiadocker.DeviceStatus{}
 	if err := json.Unmarshal([]byte(devicesJSON), &gpuDevices); err != nil {
 		t.Fatal(err)
 	}
 
-	event := fetchFromContainer(&docker.Container{
+	container := &docker.Container{
 		ID:   "id1",
 		Name: "name1",
 		HostConfig: &docker.HostConfig{
@@ -93,7 +50,8 @@ func TestFetchFromContainer(t *testing.T) {
 				"maintainer":                          "NVIDIA CORPORATION <cudatools@nvidia.com>",
 			},
 		},
-	}, gpuDevices)
+	}
+	event := legacyFormat.fetchFromContainer(container, nvidiadocker.NVIDIABackend.ContainerDeviceIndices(container, nil, nil, gpuDevices, ""), gpuDevices, nil, nil)
 
 	fmt.Println(event.StringToPrint())
 
@@ -101,3 +59,453 @@ func TestFetchFromContainer(t *testing.T) {
 	// 	t.Fatal("no events")
 	// }
 }
+
+func TestFetchFromContainerDevices(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{Index: toUintP(0), UUID: "GPU-0", PCI: nvidiadocker.PCIStatusInfo{BusID: "0000:08:00.0"}, Utilization: nvidiadocker.UtilizationInfo{GPU: 90}},
+		{Index: toUintP(1), UUID: "GPU-1", PCI: nvidiadocker.PCIStatusInfo{BusID: "0000:0B:00.0"}, Utilization: nvidiadocker.UtilizationInfo{GPU: 80}},
+		{Index: toUintP(2), UUID: "GPU-2", PCI: nvidiadocker.PCIStatusInfo{BusID: "0000:0E:00.0"}, Utilization: nvidiadocker.UtilizationInfo{GPU: 70}},
+	}
+
+	container := &docker.Container{
+		ID:   "id1",
+		Name: "/name1",
+		HostConfig: &docker.HostConfig{
+			Devices: []docker.Device{
+				{PathOnHost: "/dev/nvidia0", PathInContainer: "/dev/nvidia0"},
+				{PathOnHost: "/dev/nvidia2", PathInContainer: "/dev/nvidia1"},
+			},
+		},
+		Config: &docker.Config{},
+	}
+	events := legacyFormat.fetchFromContainerDevices(container, nvidiadocker.NVIDIABackend.ContainerDeviceIndices(container, nil, nil, gpuDevices, ""), gpuDevices, nil, nil)
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+
+	for i, expected := range []struct {
+		Index          uint
+		ContainerIndex uint
+		UUID           string
+		BusID          string
+		GPU            uint
+	}{
+		{0, 0, "GPU-0", "0000:08:00.0", 90},
+		{2, 1, "GPU-2", "0000:0E:00.0", 70},
+	} {
+		if events[i]["containername"] != "name1" {
+			t.Fatalf("unexpected container name %v", events[i]["containername"])
+		}
+		device := events[i]["device"].(common.MapStr)
+		if device["Index"] != expected.Index || device["UUID"] != expected.UUID || device["BusID"] != expected.BusID {
+			t.Fatalf("unexpected device identity %v", device)
+		}
+		if device["ContainerIndex"] != expected.ContainerIndex || device["CUDAOrdinal"] != expected.ContainerIndex {
+			t.Fatalf("unexpected container numbers %v", device)
+		}
+		if gpu, _ := device.GetValue("Utilization.GPU"); gpu != expected.GPU {
+			t.Fatalf("unexpected utilization %v", gpu)
+		}
+		if count, _ := events[i].GetValue("gpu.count"); count != 2 {
+			t.Fatalf("unexpected GPU count %v", count)
+		}
+	}
+}
+
+func TestFetchFromContainerGPUList(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{Index: toUintP(0), UUID: "GPU-0", Name: "Tesla P100"},
+		{Index: toUintP(1), UUID: "GPU-1", Name: "Tesla P100"},
+		{Index: toUintP(2), UUID: "GPU-2", Name: "Tesla V100"},
+	}
+	container := &docker.Container{ID: "id1", HostConfig: &docker.HostConfig{}, Config: &docker.Config{}}
+
+	event := legacyFormat.fetchFromContainer(container, []int{0, 2}, gpuDevices, nil, nil)
+	if count, _ := event.GetValue("gpu.count"); count != 2 {
+		t.Fatalf("unexpected GPU count %v", count)
+	}
+	devices, _ := event.GetValue("gpu.devices")
+	identities := devices.([]common.MapStr)
+	if len(identities) != 2 || identities[1]["index"] != uint(2) || identities[1]["uuid"] != "GPU-2" || identities[1]["name"] != "Tesla V100" {
+		t.Fatalf("unexpected GPU devices %v", identities)
+	}
+	if _, err := event.GetValue("device.Devices"); err != nil {
+		t.Fatal("expected the legacy device list to be kept")
+	}
+}
+
+func TestFetchFromContainerECS(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{Index: toUintP(0), UUID: "GPU-0", Utilization: nvidiadocker.UtilizationInfo{GPU: 90}, Memory: nvidiadocker.MemoryInfo{GlobalUsed: 2}},
+		{Index: toUintP(1), UUID: "GPU-1", Utilization: nvidiadocker.UtilizationInfo{GPU: 80}, Memory: nvidiadocker.MemoryInfo{GlobalUsed: 1}},
+	}
+	container := &docker.Container{
+		ID:     "id1",
+		Name:   "/name1",
+		Config: &docker.Config{Labels: map[string]string{"app": "train"}},
+	}
+
+	event := ecsFormat.fetchFromContainer(container, []int{0, 1}, gpuDevices, nil, nil)
+
+	for key, expected := range map[string]interface{}{
+		mb.ModuleData + ".container.id":   "id1",
+		mb.ModuleData + ".container.name": "name1",
+		"gpu.count":                       2,
+		"gpu.utilization.pct":             1.7,
+		"gpu.memory.used.bytes":           uint64(3 * nvidiadocker.MiB),
+	} {
+		if value, _ := event.GetValue(key); value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+	if labels, _ := event.GetValue(mb.ModuleData + ".container.labels"); !reflect.DeepEqual(labels, container.Config.Labels) {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	devices, _ := event.GetValue("gpu.devices")
+	if identities := devices.([]common.MapStr); len(identities) != 2 || identities[1]["uuid"] != "GPU-1" {
+		t.Fatalf("unexpected devices %v", devices)
+	}
+	if _, found := event["containerid"]; found {
+		t.Fatal("expected no legacy fields")
+	}
+
+	events := ecsFormat.failureEvents([]*nvidiadocker.ContainerError{
+		{ID: "id2", Err: errors.New("request timed out")},
+	})
+	if id, _ := events[0].GetValue(mb.ModuleData + ".container.id"); id != "id2" {
+		t.Fatalf("unexpected failure event %v", events[0])
+	}
+}
+
+func TestContainerEventSwarm(t *testing.T) {
+	container := &docker.Container{
+		ID:   "id1",
+		Name: "/ml_train.1.d3f4g5h6j7k8",
+		Config: &docker.Config{Labels: map[string]string{
+			nvidiadocker.SwarmServiceLabel: "ml_train",
+			nvidiadocker.SwarmTaskLabel:    "ml_train.1.d3f4g5h6j7k8",
+			nvidiadocker.SwarmStackLabel:   "ml",
+		}},
+	}
+
+	for _, testData := range []struct {
+		Format eventFormat
+		Key    string
+	}{
+		{legacyFormat, "swarm.service"},
+		{ecsFormat, mb.ModuleData + ".container.swarm.service"},
+	} {
+		if service, _ := testData.Format.containerEvent(container).GetValue(testData.Key); service != "ml_train" {
+			t.Fatalf("%s: unexpected swarm service %v", testData.Key, service)
+		}
+	}
+}
+
+func TestNewEventFormat(t *testing.T) {
+	if format, err := newEventFormat(nvidiadocker.Config{FieldsFormat: "ECS"}); err != nil || !format.ecs {
+		t.Fatalf("unexpected format %v, %v", format, err)
+	}
+	if _, err := newEventFormat(nvidiadocker.Config{FieldsFormat: "flat"}); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func toUintP(val uint) *uint {
+	return &val
+}
+
+func TestFetchWithoutDriver(t *testing.T) {
+	events := legacyFormat.fetchWithoutDriver([]*nvidiadocker.CachedContainer{
+		{Container: &docker.Container{ID: "id1", Name: "/name1", Config: &docker.Config{}}},
+	})
+
+	if len(events) != 1 || events[0]["containername"] != "name1" {
+		t.Fatalf("unexpected events %v", events)
+	}
+	if available, _ := events[0].GetValue("gpu.available"); available != false {
+		t.Fatalf("expected unavailable GPUs, got %v", available)
+	}
+	if _, found := events[0]["device"]; found {
+		t.Fatal("expected no device without driver")
+	}
+}
+
+func TestFetchFromContainersNonGPU(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{{Index: toUintP(0), UUID: "GPU-0"}}
+	cached := []*nvidiadocker.CachedContainer{
+		{Container: &docker.Container{
+			ID:         "train",
+			HostConfig: &docker.HostConfig{Devices: []docker.Device{{PathOnHost: "/dev/nvidia0", PathInContainer: "/dev/nvidia0"}}},
+			Config:     &docker.Config{},
+		}},
+		{Container: &docker.Container{ID: "sidecar", HostConfig: &docker.HostConfig{}, Config: &docker.Config{}}},
+	}
+
+	m := &MetricSet{format: legacyFormat, allocations: newAllocationTracker(), counters: nvidiadocker.NewCounterStore(), tracker: nvidiadocker.NewDeviceTracker()}
+	events, err := m.fetchFromContainers(cached, gpuDevices, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last event is the one of the host.
+	if len(events) != 2 || events[0]["containerid"] != "train" {
+		t.Fatalf("expected the container with GPUs only, got %v", events)
+	}
+
+	m.emitNonGPU = true
+	if events, _ := m.fetchFromContainers(cached, gpuDevices, nil); len(events) != 3 {
+		t.Fatalf("expected every container, got %v", events)
+	}
+}
+
+func TestFetchFromContainersReset(t *testing.T) {
+	cached := []*nvidiadocker.CachedContainer{
+		{Container: &docker.Container{
+			ID:         "train",
+			HostConfig: &docker.HostConfig{Devices: []docker.Device{{PathOnHost: "/dev/nvidia0", PathInContainer: "/dev/nvidia0"}}},
+			Config:     &docker.Config{},
+		}},
+	}
+
+	m := &MetricSet{format: ecsFormat, allocations: newAllocationTracker(), counters: nvidiadocker.NewCounterStore(), tracker: nvidiadocker.NewDeviceTracker()}
+	for i, c := range []struct {
+		gpuDevices []nvidiadocker.DeviceStatus
+		expected   bool
+	}{
+		{[]nvidiadocker.DeviceStatus{{Index: toUintP(0), UUID: "GPU-0"}, {Index: toUintP(1), UUID: "GPU-1"}}, false},
+		// GPU-0 fell off the bus, GPU-1 took its index.
+		{[]nvidiadocker.DeviceStatus{{Index: toUintP(0), UUID: "GPU-1"}}, true},
+		{[]nvidiadocker.DeviceStatus{{Index: toUintP(0), UUID: "GPU-1"}}, false},
+	} {
+		events, err := m.fetchFromContainers(cached, c.gpuDevices, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detected, _ := events[0].GetValue("gpu.reset_detected"); detected != c.expected {
+			t.Fatalf("%d: expected reset_detected %v, got %v", i, c.expected, detected)
+		}
+	}
+}
+
+func TestAddSampleTimestamp(t *testing.T) {
+	sampled := time.Date(2017, time.January, 31, 10, 20, 30, 0, time.UTC)
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{UUID: "GPU-0", Timestamp: sampled.Add(time.Millisecond)},
+		{UUID: "GPU-1", Timestamp: sampled},
+		{UUID: "GPU-2"},
+	}
+
+	events := []common.MapStr{{}}
+	addSampleTimestamp(events, false, []int{0, 1, 2}, gpuDevices)
+	if timestamp, _ := events[0].GetValue("gpu.sample.timestamp"); timestamp != common.Time(sampled) {
+		t.Fatalf("expected the oldest sample, got %v", timestamp)
+	}
+
+	events = []common.MapStr{{}, {}}
+	addSampleTimestamp(events, true, []int{0, 2}, gpuDevices)
+	if timestamp, _ := events[0].GetValue("gpu.sample.timestamp"); timestamp != common.Time(sampled.Add(time.Millisecond)) {
+		t.Fatalf("unexpected sample timestamp %v", timestamp)
+	}
+	if _, err := events[1].GetValue("gpu.sample.timestamp"); err == nil {
+		t.Fatal("expected no timestamp for a GPU without one")
+	}
+}
+
+func TestAddSampleBuckets(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{{UUID: "GPU-0"}, {UUID: "GPU-1"}, {UUID: "GPU-2"}}
+	summaries := map[string]nvidiadocker.SampleSummary{
+		"GPU-0": {Count: 4, GPUBuckets: nvidiadocker.UtilizationBuckets{4, 0, 0, 0}},
+		"GPU-1": {Count: 4, GPUBuckets: nvidiadocker.UtilizationBuckets{0, 1, 1, 2}},
+	}
+
+	events := []common.MapStr{{}}
+	addSampleBuckets(events, false, []int{0, 1, 2}, gpuDevices, summaries)
+	for key, expected := range map[string]interface{}{
+		"gpu.samples.utilization.buckets.0_25":   4,
+		"gpu.samples.utilization.buckets.25_50":  1,
+		"gpu.samples.utilization.buckets.75_100": 2,
+	} {
+		if value, _ := events[0].GetValue(key); value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	events = []common.MapStr{{}, {}}
+	addSampleBuckets(events, true, []int{1, 2}, gpuDevices, summaries)
+	if value, _ := events[0].GetValue("gpu.samples.utilization.buckets.75_100"); value != 2 {
+		t.Fatalf("unexpected buckets %v", events[0])
+	}
+	if _, err := events[1].GetValue("gpu.samples"); err == nil {
+		t.Fatal("expected no buckets for a GPU without samples")
+	}
+}
+
+func TestEfficiency(t *testing.T) {
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{UUID: "GPU-0", Utilization: nvidiadocker.UtilizationInfo{GPU: 90}},
+		{UUID: "GPU-1", Utilization: nvidiadocker.UtilizationInfo{GPU: 0}},
+		{UUID: "GPU-2", Utilization: nvidiadocker.UtilizationInfo{GPU: 40}},
+	}
+	container := &docker.Container{ID: "id1", HostConfig: &docker.HostConfig{}, Config: &docker.Config{}}
+
+	for _, format := range []eventFormat{legacyFormat, ecsFormat} {
+		event := format.fetchFromContainer(container, []int{0, 1}, gpuDevices, nil, nil)
+		if efficiency, _ := event.GetValue("gpu.efficiency"); efficiency != 0.45 {
+			t.Fatalf("unexpected container efficiency %v", efficiency)
+		}
+	}
+
+	event := hostEvent(gpuDevices, map[int]int{0: 1, 1: 2})
+	for key, expected := range map[string]interface{}{
+		"host.gpu.total":      3,
+		"host.gpu.allocated":  2,
+		"host.gpu.utilized":   1,
+		"host.gpu.efficiency": 0.5,
+	} {
+		if value, _ := event.GetValue(key); value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+	if _, err := hostEvent(gpuDevices, nil).GetValue("host.gpu.efficiency"); err == nil {
+		t.Fatal("expected no efficiency without allocated GPUs")
+	}
+}
+
+func TestRequestingGPUs(t *testing.T) {
+	container := func(id string, env ...string) *docker.Container {
+		return &docker.Container{ID: id, HostConfig: &docker.HostConfig{}, Config: &docker.Config{Env: env}}
+	}
+	devices := container("devices")
+	devices.HostConfig.Devices = []docker.Device{{PathOnHost: "/dev/nvidia1", PathInContainer: "/dev/nvidia1"}}
+
+	cached := []*nvidiadocker.CachedContainer{
+		{Container: devices},
+		{Container: container("gpus"), Runtime: &nvidiadocker.ContainerRuntime{
+			DeviceRequests: []nvidiadocker.DeviceRequest{{Driver: "nvidia", Count: -1}},
+		}},
+		{Container: container("runtime", "NVIDIA_VISIBLE_DEVICES=all"), Runtime: &nvidiadocker.ContainerRuntime{Runtime: "nvidia"}},
+		// The CUDA images set NVIDIA_VISIBLE_DEVICES without requesting GPUs.
+		{Container: container("image", "NVIDIA_VISIBLE_DEVICES=all"), Runtime: &nvidiadocker.ContainerRuntime{Runtime: "runc"}},
+		{Container: container("sidecar")},
+	}
+	mig := container("mig")
+	mig.HostConfig.Devices = []docker.Device{{PathOnHost: "/dev/nvidia-caps/nvidia-cap13", PathInContainer: "/dev/nvidia-caps/nvidia-cap13"}}
+	control := container("control")
+	control.HostConfig.Devices = []docker.Device{{PathOnHost: "/dev/nvidiactl", PathInContainer: "/dev/nvidiactl"}}
+	v1 := container("v1")
+	v1.HostConfig.VolumeDriver = "nvidia-docker"
+	cached = append(cached, &nvidiadocker.CachedContainer{Container: mig}, &nvidiadocker.CachedContainer{Container: control},
+		&nvidiadocker.CachedContainer{Container: v1})
+
+	var ids []string
+	for _, c := range requestingGPUs(cached) {
+		ids = append(ids, c.Container.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"devices", "gpus", "runtime", "mig", "v1"}) {
+		t.Fatalf("unexpected containers %v", ids)
+	}
+}
+
+func TestFailureEvents(t *testing.T) {
+	events := legacyFormat.failureEvents([]*nvidiadocker.ContainerError{
+		{ID: "id1", Err: errors.New("request timed out")},
+	})
+
+	if len(events) != 1 || events[0]["containerid"] != "id1" {
+		t.Fatalf("unexpected events %v", events)
+	}
+	if message, _ := events[0].GetValue("error.message"); message != "request timed out" {
+		t.Fatalf("unexpected error message %v", message)
+	}
+}
+
+func TestContainerStatusPower(t *testing.T) {
+	cStatus := &ContainerStatus{}
+	cStatus.AddDevice(&nvidiadocker.DeviceStatus{Power: 120.5, PowerLimit: 250, PowerEnforcedLimit: 250})
+	cStatus.AddDevice(&nvidiadocker.DeviceStatus{Power: 80.25, PowerLimit: 250, PowerEnforcedLimit: 200})
+
+	if cStatus.PowerSum() != 200.75 || cStatus.PowerLimitSum() != 500 || cStatus.PowerEnforcedLimitSum() != 450 {
+		t.Fatalf("unexpected power sums %v %v %v",
+			cStatus.PowerSum(), cStatus.PowerLimitSum(), cStatus.PowerEnforcedLimitSum())
+	}
+}
+
+func TestContainerStatusProfiling(t *testing.T) {
+	cStatus := &ContainerStatus{}
+	cStatus.AddDevice(&nvidiadocker.DeviceStatus{})
+	if _, found := deviceMapping(cStatus)["Profiling"]; found {
+		t.Fatal("expected no profiling without DCGM")
+	}
+
+	cStatus.AddDevice(&nvidiadocker.DeviceStatus{Profiling: &nvidiadocker.ProfilingInfo{SMActive: 0.5, TensorActive: 0.25}})
+	cStatus.AddDevice(&nvidiadocker.DeviceStatus{Profiling: &nvidiadocker.ProfilingInfo{SMActive: 1, TensorActive: 0.75}})
+
+	device := deviceMapping(cStatus)
+	for key, expected := range map[string]float64{
+		"Profiling.SMActive":     0.75,
+		"Profiling.TensorActive": 0.5,
+		"Profiling.FP64Active":   0,
+	} {
+		value, err := device.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+}
+
+func TestEnergyShares(t *testing.T) {
+	m := &MetricSet{counters: nvidiadocker.NewCounterStore()}
+	gpuDevices := []nvidiadocker.DeviceStatus{
+		{Index: toUintP(0), UUID: "GPU-0", Energy: 1000000},
+		{Index: toUintP(1), UUID: "GPU-1", Energy: 2000000},
+		{Index: toUintP(2), UUID: "GPU-2"},
+	}
+	users := map[int]int{0: 2, 1: 1, 2: 1}
+
+	if shares := m.energyShares(gpuDevices, users); len(shares) != 0 {
+		t.Fatalf("expected no energy on the first fetch, got %v", shares)
+	}
+
+	gpuDevices[0].Energy += 600000
+	gpuDevices[1].Energy += 450000
+	shares := m.energyShares(gpuDevices, users)
+	if !reflect.DeepEqual(shares, map[int]float64{0: 300, 1: 450}) {
+		t.Fatalf("unexpected energy shares %v", shares)
+	}
+
+	container := &docker.Container{
+		ID:         "id1",
+		Name:       "/name1",
+		HostConfig: &docker.HostConfig{},
+		Config:     &docker.Config{},
+	}
+	event := legacyFormat.fetchFromContainer(container, []int{0, 1, 2}, gpuDevices, shares, nil)
+	if joules, _ := event.GetValue("device.Energy.Joules"); joules != float64(750) {
+		t.Fatalf("unexpected container energy %v", joules)
+	}
+
+	event = legacyFormat.fetchFromContainer(container, []int{2}, gpuDevices, shares, nil)
+	if _, err := event.GetValue("device.Energy"); err == nil {
+		t.Fatal("expected no energy for devices without energy counter")
+	}
+}
+
+func TestContainerStatusTemperatureHeadroom(t *testing.T) {
+	cStatus := &ContainerStatus{}
+	cStatus.AddDevice(&nvidiadocker.DeviceStatus{Temperature: 50})
+	if _, ok := cStatus.TemperatureHeadroomMin(); ok {
+		t.Fatal("expected no headroom without thresholds")
+	}
+
+	cStatus.AddDevice(&nvidiadocker.DeviceStatus{Temperature: 60, TemperatureThresholds: nvidiadocker.TemperatureThresholds{Slowdown: 92}})
+	cStatus.AddDevice(&nvidiadocker.DeviceStatus{Temperature: 85, TemperatureThresholds: nvidiadocker.TemperatureThresholds{Slowdown: 90}})
+	if headroom, ok := cStatus.TemperatureHeadroomMin(); !ok || headroom != 5 {
+		t.Fatalf("expected headroom 5, got %d", headroom)
+	}
+	if headroom := deviceMapping(cStatus)["TemperatureHeadroom"]; headroom != 5 {
+		t.Fatalf("expected headroom 5 in event, got %v", headroom)
+	}
+}
@@ -0,0 +1,146 @@
+package summary
+
+import (
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "summary", New, nvidiadocker.ParseHost); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports one event per host summarizing its GPUs and how many of
+// them are allocated to containers.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector  nvidiadocker.GPUCollector
+	containers *nvidiadocker.ContainerCache
+	versions   *nvidiadocker.VersionCache
+	driver     *nvidiadocker.DriverCheck
+	gate       *nvidiadocker.FetchGate
+	backend    *nvidiadocker.Backend
+	hostFS     string
+
+	// kubeletCheckpoint is read on every fetch for the GPUs the kubelet
+	// allocated to the containers of pods.
+	kubeletCheckpoint string
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+	if err := config.ApplyHost(base.HostData().URI); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	containerClient, err := nvidiadocker.NewContainerClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every container counts towards the allocated GPUs of the host, so the
+	// container filter of the module does not apply.
+	gate := nvidiadocker.NewFetchGate(config, base)
+	return &MetricSet{
+		BaseMetricSet:     base,
+		collector:         collector,
+		containers:        nvidiadocker.NewContainerCache(containerClient, nil, gate.Period()),
+		versions:          nvidiadocker.NewVersionCache(collector),
+		driver:            nvidiadocker.NewDriverCheck(config),
+		gate:              gate,
+		kubeletCheckpoint: config.KubeletCheckpointPath(),
+		backend:           config.ContainerBackend(),
+		hostFS:            config.HostFS,
+	}, nil
+}
+
+// Fetch returns a single event summarizing the GPUs of the host.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	trace := nvidiadocker.NewFetchTrace(m.BaseMetricSet)
+	containers, _, err := m.containers.Containers(trace)
+	if err != nil {
+		return nil, err
+	}
+
+	gpuDevices, err := m.collector.Query(nil)
+	if err != nil {
+		return nil, err
+	}
+	trace.Phase("gpu_query", "gpus", len(gpuDevices))
+
+	var (
+		allocations = nvidiadocker.LoadKubeletAllocations(m.kubeletCheckpoint)
+		allocated   = map[int]bool{}
+	)
+	for _, c := range containers {
+		for _, index := range m.backend.ContainerDeviceIndices(c.Container, c.Runtime, allocations, gpuDevices, m.hostFS) {
+			allocated[index] = true
+		}
+	}
+
+	trace.Phase("attribute", "containers", len(containers))
+
+	events := []common.MapStr{eventMapping(gpuDevices, len(allocated))}
+	m.versions.AddTo(events)
+	trace.Done(len(events))
+	return events, nil
+}
+
+// eventMapping returns the summary of the GPUs, of which the given number is
+// allocated to at least one container.
+func eventMapping(gpuDevices []nvidiadocker.DeviceStatus, allocated int) common.MapStr {
+	var (
+		utilization uint
+		temperature uint
+		power       float64
+	)
+	for i := range gpuDevices {
+		device := &gpuDevices[i]
+		utilization += device.Utilization.GPU
+		if device.Temperature > temperature {
+			temperature = device.Temperature
+		}
+		power += device.Power
+	}
+
+	event := common.MapStr{
+		"gpu": common.MapStr{
+			"total":     len(gpuDevices),
+			"allocated": allocated,
+			"free":      len(gpuDevices) - allocated,
+		},
+		"temperature": common.MapStr{
+			"max": temperature,
+		},
+		"power": common.MapStr{
+			"draw": common.MapStr{"watts": power},
+		},
+	}
+	if len(gpuDevices) > 0 {
+		event["utilization"] = common.MapStr{
+			"pct": nvidiadocker.Percent(utilization) / float64(len(gpuDevices)),
+		}
+	}
+	return event
+}
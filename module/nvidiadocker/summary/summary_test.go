@@ -0,0 +1,48 @@
+package summary
+
+import (
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	event := eventMapping([]nvidiadocker.DeviceStatus{
+		{UUID: "GPU-0", Temperature: 71, Power: 212.5, Utilization: nvidiadocker.UtilizationInfo{GPU: 90}},
+		{UUID: "GPU-1", Temperature: 64, Power: 180, Utilization: nvidiadocker.UtilizationInfo{GPU: 40}},
+		{UUID: "GPU-2", Temperature: 38, Power: 25, Utilization: nvidiadocker.UtilizationInfo{GPU: 0}},
+		{UUID: "GPU-3", Temperature: 36, Power: 24.5, Utilization: nvidiadocker.UtilizationInfo{GPU: 0}},
+	}, 3)
+
+	testDatas := map[string]interface{}{
+		"gpu.total":        4,
+		"gpu.allocated":    3,
+		"gpu.free":         1,
+		"utilization.pct":  0.325,
+		"temperature.max":  uint(71),
+		"power.draw.watts": 442.0,
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
+
+func TestEventMappingNoGPU(t *testing.T) {
+	event := eventMapping(nil, 0)
+	if total, _ := event.GetValue("gpu.total"); total != 0 {
+		t.Fatalf("unexpected total %v", total)
+	}
+	if _, err := event.GetValue("utilization.pct"); err == nil {
+		t.Fatal("expected no utilization without GPUs")
+	}
+}
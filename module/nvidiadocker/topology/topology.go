@@ -0,0 +1,103 @@
+package topology
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "topology", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports how every GPU of the host is connected.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector nvidiadocker.TopologyCollector
+	versions  *nvidiadocker.VersionCache
+	driver    *nvidiadocker.DriverCheck
+	gate      *nvidiadocker.FetchGate
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	topologyCollector, ok := collector.(nvidiadocker.TopologyCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support reporting the GPU topology", config.GPUSource)
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		collector:     topologyCollector,
+		versions:      nvidiadocker.NewVersionCache(collector),
+		driver:        nvidiadocker.NewDriverCheck(config),
+		gate:          nvidiadocker.NewFetchGate(config, base),
+	}, nil
+}
+
+// Fetch returns one event per GPU.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	topology, err := m.collector.Topology()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]common.MapStr, 0, len(topology))
+	for i := range topology {
+		events = append(events, eventMapping(&topology[i]))
+	}
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+func eventMapping(gpu *nvidiadocker.GPUTopology) common.MapStr {
+	links := make([]common.MapStr, 0, len(gpu.Links))
+	for _, link := range gpu.Links {
+		l := common.MapStr{
+			"peer": link.Peer,
+			"type": link.Type,
+		}
+		// NV# is a connection over # bonded NVLinks.
+		if strings.HasPrefix(link.Type, "NV") {
+			if count, err := strconv.ParseUint(strings.TrimPrefix(link.Type, "NV"), 10, 64); err == nil {
+				l["nvlinks"] = count
+			}
+		}
+		links = append(links, l)
+	}
+
+	return common.MapStr{
+		"gpu": common.MapStr{
+			"index": gpu.Index,
+		},
+		"cpu_affinity":  gpu.CPUAffinity,
+		"numa_affinity": gpu.NUMAAffinity,
+		"links":         links,
+	}
+}
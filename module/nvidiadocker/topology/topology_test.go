@@ -0,0 +1,50 @@
+package topology
+
+import (
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	event := eventMapping(&nvidiadocker.GPUTopology{
+		Index: 1,
+		Links: []nvidiadocker.TopologyLink{
+			{Peer: "GPU0", Type: "NV2"},
+			{Peer: "mlx5_0", Type: "PIX"},
+		},
+		CPUAffinity:  "0-19,40-59",
+		NUMAAffinity: "0",
+	})
+
+	testDatas := map[string]interface{}{
+		"gpu.index":     uint(1),
+		"cpu_affinity":  "0-19,40-59",
+		"numa_affinity": "0",
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	links := event["links"].([]common.MapStr)
+	if len(links) != 2 {
+		t.Fatalf("expected 2 links, got %v", links)
+	}
+	if links[0]["peer"] != "GPU0" || links[0]["type"] != "NV2" || links[0]["nvlinks"] != uint64(2) {
+		t.Fatalf("unexpected link %v", links[0])
+	}
+	if _, found := links[1]["nvlinks"]; found || links[1]["type"] != "PIX" {
+		t.Fatalf("unexpected link %v", links[1])
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
@@ -0,0 +1,91 @@
+package nvidiadocker
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// debugSelector selects the debug logs of the module, with -d nvidiadocker.
+const debugSelector = "nvidiadocker"
+
+// FetchTrace logs how long the phases of a fetch took, like listing and
+// inspecting the containers, querying the GPUs and attributing them to the
+// containers, to diagnose slow fetches in the field. The logger of libbeat 5.5
+// has no structured fields, so the fields are logged as key=value pairs.
+//
+// Tracing is only enabled with the nvidiadocker debug selector, NewFetchTrace
+// returns nil otherwise and a nil FetchTrace logs nothing.
+type FetchTrace struct {
+	keyvals []interface{}
+	start   time.Time
+	last    time.Time
+}
+
+// NewFetchTrace starts tracing a fetch of the MetricSet.
+func NewFetchTrace(base mb.BaseMetricSet) *FetchTrace {
+	if !logp.IsDebug(debugSelector) {
+		return nil
+	}
+	now := time.Now()
+	keyvals := []interface{}{"metricset", base.Name()}
+	if host := base.Host(); host != "" {
+		keyvals = append(keyvals, "host", host)
+	}
+	return &FetchTrace{
+		keyvals: keyvals,
+		start:   now,
+		last:    now,
+	}
+}
+
+// Phase logs the phase that ended now, along with the given key value pairs.
+func (t *FetchTrace) Phase(name string, keyvals ...interface{}) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	logPhase(name, now.Sub(t.last), t.keyvals, keyvals)
+	t.last = now
+}
+
+// Done logs the duration of the whole fetch and the number of its events.
+func (t *FetchTrace) Done(events int) {
+	if t == nil {
+		return
+	}
+	logPhase("total", time.Since(t.start), t.keyvals, []interface{}{"events", events})
+}
+
+// tracePhase logs a phase outside of a FetchTrace, like parsing the output of
+// a GPU source, that started at start.
+func tracePhase(name string, start time.Time, keyvals ...interface{}) {
+	if !logp.IsDebug(debugSelector) {
+		return
+	}
+	logPhase(name, time.Since(start), nil, keyvals)
+}
+
+func logPhase(name string, took time.Duration, context, keyvals []interface{}) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "phase=%s took_ms=%.3f", name, float64(took)/float64(time.Millisecond))
+	writeKeyvals(&buf, context)
+	writeKeyvals(&buf, keyvals)
+	logp.Debug(debugSelector, "%s", buf.String())
+}
+
+// writeKeyvals writes the key value pairs separated by spaces, quoting the
+// values that hold spaces or quotes.
+func writeKeyvals(buf *bytes.Buffer, keyvals []interface{}) {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		value := fmt.Sprint(keyvals[i+1])
+		if value == "" || strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(buf, " %v=%s", keyvals[i], value)
+	}
+}
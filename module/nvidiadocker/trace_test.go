@@ -0,0 +1,22 @@
+package nvidiadocker
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteKeyvals(t *testing.T) {
+	var buf bytes.Buffer
+	writeKeyvals(&buf, []interface{}{"metricset", "status", "gpus", 8, "cached", true, "host", "", "error", `no "GPU" found`, "odd"})
+
+	expected := ` metricset=status gpus=8 cached=true host="" error="no \"GPU\" found"`
+	if buf.String() != expected {
+		t.Fatalf("expected %s, got %s", expected, buf.String())
+	}
+}
+
+func TestNilFetchTrace(t *testing.T) {
+	var trace *FetchTrace
+	trace.Phase("list", "cached", false)
+	trace.Done(0)
+}
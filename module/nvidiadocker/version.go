@@ -0,0 +1,55 @@
+package nvidiadocker
+
+import (
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// Versions holds the NVIDIA driver version and the highest CUDA version it
+// supports.
+type Versions struct {
+	Driver string
+	CUDA   string
+}
+
+// VersionCache queries the driver and CUDA versions once and adds them to the
+// events of a MetricSet as module fields.
+type VersionCache struct {
+	collector VersionCollector
+	versions  *Versions
+}
+
+// NewVersionCache creates a VersionCache for the given collector. Events are
+// left unchanged if the collector cannot report the versions.
+func NewVersionCache(collector GPUCollector) *VersionCache {
+	versionCollector, _ := collector.(VersionCollector)
+	return &VersionCache{collector: versionCollector}
+}
+
+// AddTo adds the versions to the given events. The versions are queried on
+// the first call, and again on the next calls until a query succeeds.
+func (c *VersionCache) AddTo(events []common.MapStr) {
+	if c.collector == nil {
+		return
+	}
+
+	if c.versions == nil {
+		versions, err := c.collector.Versions()
+		if err != nil {
+			logp.Debug("nvidiadocker", "Cannot read driver and CUDA versions: %v", err)
+			return
+		}
+		c.versions = &versions
+	}
+
+	for _, event := range events {
+		moduleData, _ := event[mb.ModuleData].(common.MapStr)
+		if moduleData == nil {
+			moduleData = common.MapStr{}
+			event[mb.ModuleData] = moduleData
+		}
+		moduleData["driver_version"] = c.versions.Driver
+		moduleData["cuda_version"] = c.versions.CUDA
+	}
+}
@@ -0,0 +1,55 @@
+package nvidiadocker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+type mockVersionCollector struct {
+	mockCollector
+	queries int
+	err     error
+}
+
+func (c *mockVersionCollector) Versions() (Versions, error) {
+	c.queries++
+	return Versions{Driver: "378.13", CUDA: "8.0"}, c.err
+}
+
+func TestVersionCache(t *testing.T) {
+	collector := &mockVersionCollector{err: errors.New("not ready")}
+	cache := NewVersionCache(collector)
+
+	events := []common.MapStr{{}}
+	cache.AddTo(events)
+	if _, found := events[0][mb.ModuleData]; found {
+		t.Fatalf("unexpected versions after failed query %v", events[0])
+	}
+
+	collector.err = nil
+	for i := 0; i < 2; i++ {
+		events = []common.MapStr{{}, {mb.ModuleData: common.MapStr{"other": 1}}}
+		cache.AddTo(events)
+		for _, event := range events {
+			if driver, _ := event.GetValue(mb.ModuleData + ".driver_version"); driver != "378.13" {
+				t.Fatalf("unexpected driver version in %v", event)
+			}
+			if cuda, _ := event.GetValue(mb.ModuleData + ".cuda_version"); cuda != "8.0" {
+				t.Fatalf("unexpected cuda version in %v", event)
+			}
+		}
+	}
+	if collector.queries != 2 {
+		t.Fatalf("expected versions to be cached, queried %d times", collector.queries)
+	}
+
+	// Collectors without version support leave the events unchanged.
+	events = []common.MapStr{{}}
+	NewVersionCache(&mockCollector{}).AddTo(events)
+	if len(events[0]) != 0 {
+		t.Fatalf("unexpected fields %v", events[0])
+	}
+}
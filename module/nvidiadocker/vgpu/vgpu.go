@@ -0,0 +1,125 @@
+package vgpu
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "vgpu", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports the vGPUs the GPUs of a vGPU host expose to virtual
+// machines.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector nvidiadocker.VGPUCollector
+	versions  *nvidiadocker.VersionCache
+	driver    *nvidiadocker.DriverCheck
+	gate      *nvidiadocker.FetchGate
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	vgpuCollector, ok := collector.(nvidiadocker.VGPUCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support reporting vGPUs", config.GPUSource)
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		collector:     vgpuCollector,
+		versions:      nvidiadocker.NewVersionCache(collector),
+		driver:        nvidiadocker.NewDriverCheck(config),
+		gate:          nvidiadocker.NewFetchGate(config, base),
+	}, nil
+}
+
+// Fetch returns one event per active vGPU.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	vgpus, err := m.collector.VGPUs()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]common.MapStr, 0, len(vgpus))
+	for i := range vgpus {
+		events = append(events, eventMapping(&vgpus[i]))
+	}
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+func eventMapping(vgpu *nvidiadocker.VGPU) common.MapStr {
+	event := common.MapStr{
+		"id":   vgpu.ID,
+		"name": vgpu.Name,
+		"gpu": common.MapStr{
+			"bus_id": vgpu.GPUBusID,
+		},
+		"utilization": common.MapStr{
+			"gpu":     vgpu.Utilization.GPU,
+			"memory":  vgpu.Utilization.Memory,
+			"encoder": vgpu.Utilization.Encoder,
+			"decoder": vgpu.Utilization.Decoder,
+		},
+		"usage": common.MapStr{
+			"gpu":     common.MapStr{"pct": nvidiadocker.Percent(vgpu.Utilization.GPU)},
+			"memory":  common.MapStr{"pct": nvidiadocker.Percent(vgpu.Utilization.Memory)},
+			"encoder": common.MapStr{"pct": nvidiadocker.Percent(vgpu.Utilization.Encoder)},
+			"decoder": common.MapStr{"pct": nvidiadocker.Percent(vgpu.Utilization.Decoder)},
+		},
+		"memory": common.MapStr{
+			"used": common.MapStr{
+				"bytes": vgpu.Memory.GlobalUsed * nvidiadocker.MiB,
+			},
+			"total": common.MapStr{
+				"bytes": vgpu.Memory.GlobalTotal * nvidiadocker.MiB,
+			},
+			"free": common.MapStr{
+				"bytes": vgpu.Memory.GlobalFree * nvidiadocker.MiB,
+			},
+		},
+	}
+
+	// The guests of vGPUs without a loaded driver report less.
+	optional := map[string]string{
+		"type_id":              vgpu.Type,
+		"uuid":                 vgpu.UUID,
+		"vm.uuid":              vgpu.VMUUID,
+		"vm.name":              vgpu.VMName,
+		"guest_driver_version": vgpu.GuestDriverVersion,
+		"license_status":       vgpu.LicenseStatus,
+	}
+	for key, value := range optional {
+		if value != "" {
+			event.Put(key, value)
+		}
+	}
+	return event
+}
@@ -0,0 +1,57 @@
+package vgpu
+
+import (
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	event := eventMapping(&nvidiadocker.VGPU{
+		ID:            "3251634191",
+		Name:          "GRID V100-4C",
+		Type:          "299",
+		GPUBusID:      "00000000:3B:00.0",
+		VMName:        "vdi-01",
+		LicenseStatus: "Licensed",
+		Utilization:   nvidiadocker.UtilizationInfo{GPU: 12, Memory: 3},
+		Memory: nvidiadocker.MemoryInfo{
+			GlobalUsed:  312,
+			GlobalTotal: 4096,
+			GlobalFree:  3784,
+		},
+	})
+
+	testDatas := map[string]interface{}{
+		"id":                 "3251634191",
+		"name":               "GRID V100-4C",
+		"type_id":            "299",
+		"gpu.bus_id":         "00000000:3B:00.0",
+		"vm.name":            "vdi-01",
+		"license_status":     "Licensed",
+		"utilization.gpu":    uint(12),
+		"usage.gpu.pct":      0.12,
+		"memory.used.bytes":  uint64(312 * 1024 * 1024),
+		"memory.total.bytes": uint64(4096 * 1024 * 1024),
+		"memory.free.bytes":  uint64(3784 * 1024 * 1024),
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	for _, key := range []string{"uuid", "vm.uuid", "guest_driver_version"} {
+		if _, err := event.GetValue(key); err == nil {
+			t.Fatalf("expected %s to be left out", key)
+		}
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
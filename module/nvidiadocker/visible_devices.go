@@ -0,0 +1,291 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NvidiaVisibleDevicesEnv is the environment variable the NVIDIA container
+// runtime reads the GPUs to expose to a container from.
+const NvidiaVisibleDevicesEnv = "NVIDIA_VISIBLE_DEVICES"
+
+// CUDAVisibleDevicesEnv is the environment variable CUDA reads the GPUs the
+// applications of a container enumerate from, among the GPUs of the container.
+const CUDAVisibleDevicesEnv = "CUDA_VISIBLE_DEVICES"
+
+// UsesNvidiaRuntime reports whether the container runs with the nvidia OCI
+// runtime of nvidia-docker2.
+func (r *ContainerRuntime) UsesNvidiaRuntime() bool {
+	return r != nil && r.Runtime == "nvidia"
+}
+
+// GPURequest returns the GPU device request made with docker run --gpus, or
+// nil if the container did not request GPUs.
+func (r *ContainerRuntime) GPURequest() *DeviceRequest {
+	if r == nil {
+		return nil
+	}
+	for i, request := range r.DeviceRequests {
+		if request.Driver == "nvidia" {
+			return &r.DeviceRequests[i]
+		}
+		for _, capabilities := range request.Capabilities {
+			for _, capability := range capabilities {
+				if capability == "gpu" {
+					return &r.DeviceRequests[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// EnvValue returns the value of the given variable in a container
+// environment.
+func EnvValue(env []string, name string) (string, bool) {
+	prefix := name + "="
+	for _, e := range env {
+		if strings.HasPrefix(e, prefix) {
+			return strings.TrimPrefix(e, prefix), true
+		}
+	}
+	return "", false
+}
+
+// VisibleDevices returns the positions in devices of the GPUs exposed to a
+// container by the NVIDIA container runtime, either through docker run --gpus
+// or through the nvidia runtime and NVIDIA_VISIBLE_DEVICES. Containers started
+// without either have no runtime provided GPUs, even if their image sets
+// NVIDIA_VISIBLE_DEVICES.
+func VisibleDevices(env []string, runtime *ContainerRuntime, devices []DeviceStatus) []int {
+	if request := runtime.GPURequest(); request != nil {
+		if len(request.DeviceIDs) > 0 {
+			return resolveDevices(request.DeviceIDs, devices)
+		}
+		if request.Count < 0 || request.Count > len(devices) {
+			return resolveDevices([]string{"all"}, devices)
+		}
+		return indexPositions(indexList(request.Count), devices)
+	}
+
+	if runtime.UsesNvidiaRuntime() {
+		value, found := EnvValue(env, NvidiaVisibleDevicesEnv)
+		if !found {
+			return nil
+		}
+		return resolveDevices(strings.Split(value, ","), devices)
+	}
+
+	return nil
+}
+
+// CUDAOrdinals returns the CUDA device ordinal, the device number the
+// applications of a container report, of the GPUs of the container at the
+// given positions in devices, by position. The GPUs of the container are
+// enumerated in the order of the host, and CUDA_VISIBLE_DEVICES selects and
+// reorders them by their ordinal in the container or their UUID. Like CUDA,
+// the list stops at the first entry matching no GPU. CUDA enumerates the
+// fastest GPU first unless CUDA_DEVICE_ORDER is PCI_BUS_ID, so the ordinals
+// can be wrong for containers with GPUs of different models.
+func CUDAOrdinals(env []string, positions []int, devices []DeviceStatus) map[int]uint {
+	enumerated := make([]int, len(positions))
+	copy(enumerated, positions)
+	sort.Ints(enumerated)
+
+	value, found := EnvValue(env, CUDAVisibleDevicesEnv)
+	if !found {
+		ordinals := make(map[int]uint, len(enumerated))
+		for ordinal, position := range enumerated {
+			ordinals[position] = uint(ordinal)
+		}
+		return ordinals
+	}
+
+	ordinals := map[int]uint{}
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		position := -1
+		if strings.HasPrefix(id, "GPU-") {
+			for _, p := range enumerated {
+				if strings.HasPrefix(devices[p].UUID, id) {
+					position = p
+					break
+				}
+			}
+		} else if index, err := strconv.ParseUint(id, 10, 64); err == nil && index < uint64(len(enumerated)) {
+			position = enumerated[index]
+		}
+		if position < 0 {
+			break
+		}
+		if _, seen := ordinals[position]; seen {
+			break
+		}
+		ordinals[position] = uint(len(ordinals))
+	}
+	return ordinals
+}
+
+// VisibleMIGDevices returns the positions in migs of the MIG devices exposed
+// to a container by the NVIDIA container runtime. MIG devices are selected by
+// their MIG-<uuid> UUID, by MIG-GPU-<gpu uuid>/<gpu instance>/<compute
+// instance> with older drivers, or by <gpu index>:<mig index>.
+func VisibleMIGDevices(env []string, runtime *ContainerRuntime, migs []MIGDevice) []int {
+	var ids []string
+	if request := runtime.GPURequest(); request != nil {
+		ids = request.DeviceIDs
+		if len(ids) == 0 && request.Count < 0 {
+			ids = []string{"all"}
+		}
+	} else if runtime.UsesNvidiaRuntime() {
+		if value, found := EnvValue(env, NvidiaVisibleDevicesEnv); found {
+			ids = strings.Split(value, ",")
+		}
+	}
+
+	var positions []int
+	seen := map[int]bool{}
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		for i := range migs {
+			if !seen[i] && (id == "all" || migDeviceMatches(id, &migs[i])) {
+				seen[i] = true
+				positions = append(positions, i)
+			}
+		}
+	}
+	return positions
+}
+
+func migDeviceMatches(id string, mig *MIGDevice) bool {
+	if id == mig.UUID {
+		return true
+	}
+	if strings.HasPrefix(id, "MIG-GPU-") {
+		return id == fmt.Sprintf("MIG-%s/%d/%d", mig.GPUUUID, mig.GPUInstanceID, mig.ComputeInstanceID)
+	}
+	if parts := strings.SplitN(id, ":", 2); len(parts) == 2 && mig.GPUIndex != nil {
+		return parts[0] == strconv.FormatUint(uint64(*mig.GPUIndex), 10) &&
+			parts[1] == strconv.FormatUint(uint64(mig.Index), 10)
+	}
+	return false
+}
+
+// resolveDevices maps a NVIDIA_VISIBLE_DEVICES style list of GPU indices and
+// UUIDs, or the keywords all, none and void, to positions in devices. PCI bus
+// IDs, which the AMD device plugin identifies GPUs by, are resolved too.
+func resolveDevices(ids []string, devices []DeviceStatus) []int {
+	var positions []int
+	seen := map[int]bool{}
+	add := func(position int) {
+		if position >= 0 && !seen[position] {
+			seen[position] = true
+			positions = append(positions, position)
+		}
+	}
+
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		switch {
+		case id == "all":
+			for i := range devices {
+				add(i)
+			}
+		case id == "" || id == "none" || id == "void":
+		case strings.HasPrefix(id, "GPU-"):
+			add(uuidPosition(id, devices))
+		case normalizeBusID(id) != "":
+			add(busIDPosition(id, devices))
+		default:
+			if index, err := strconv.ParseUint(id, 10, 64); err == nil {
+				add(indexPosition(uint(index), devices))
+			}
+		}
+	}
+	return positions
+}
+
+func indexPositions(indices []uint, devices []DeviceStatus) []int {
+	positions := make([]int, 0, len(indices))
+	for _, index := range indices {
+		if position := indexPosition(index, devices); position >= 0 {
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
+
+// minorPositions returns the positions in devices of the GPUs of the
+// /dev/nvidiaN devices with the given minor numbers.
+func minorPositions(minors []uint, devices []DeviceStatus) []int {
+	positions := make([]int, 0, len(minors))
+	for _, minor := range minors {
+		if position := minorPosition(minor, devices); position >= 0 {
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
+
+// minorPosition returns the position of the GPU of the /dev/nvidiaN device
+// with the given minor number in devices, or -1 if there is none. The minor
+// number is taken as the index of the GPU if the GPU source does not report
+// minor numbers, which is wrong once a GPU fell off the bus.
+func minorPosition(minor uint, devices []DeviceStatus) int {
+	reported := false
+	for i := range devices {
+		if devices[i].MinorNumber == nil {
+			continue
+		}
+		if *devices[i].MinorNumber == minor {
+			return i
+		}
+		reported = true
+	}
+	if reported {
+		return -1
+	}
+	return indexPosition(minor, devices)
+}
+
+// indexPosition returns the position of the GPU with the given index in
+// devices, or -1 if there is none.
+func indexPosition(index uint, devices []DeviceStatus) int {
+	for i, device := range devices {
+		if device.Index != nil && *device.Index == index {
+			return i
+		}
+	}
+	if int(index) < len(devices) && devices[index].Index == nil {
+		return int(index)
+	}
+	return -1
+}
+
+// busIDPosition returns the position of the GPU with the given PCI bus ID in
+// devices, or -1 if there is none.
+func busIDPosition(busID string, devices []DeviceStatus) int {
+	busID = normalizeBusID(busID)
+	if busID == "" {
+		return -1
+	}
+	for i := range devices {
+		if normalizeBusID(devices[i].PCI.BusID) == busID {
+			return i
+		}
+	}
+	return -1
+}
+
+// uuidPosition returns the position of the GPU with the given UUID in
+// devices, or -1 if there is none.
+func uuidPosition(uuid string, devices []DeviceStatus) int {
+	for i, device := range devices {
+		if device.UUID == uuid {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,106 @@
+package nvidiadocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVisibleDevices(t *testing.T) {
+	devices := []DeviceStatus{
+		{Index: toUintP(0), UUID: "GPU-66a2874a-837d-cd53-ab26-0d2d842d9822"},
+		{Index: toUintP(1), UUID: "GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6"},
+		{Index: toUintP(2), UUID: "GPU-149648d8-7e32-715a-b5c3-fe6df5976c7e"},
+	}
+	nvidiaRuntime := &ContainerRuntime{Runtime: "nvidia"}
+
+	testDatas := []struct {
+		Env       []string
+		Runtime   *ContainerRuntime
+		Positions []int
+	}{
+		{[]string{"NVIDIA_VISIBLE_DEVICES=all"}, nvidiaRuntime, []int{0, 1, 2}},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=2,0"}, nvidiaRuntime, []int{2, 0}},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=GPU-535c289c-6dd8-e308-b4ca-524b0fc07fa6"}, nvidiaRuntime, []int{1}},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=none"}, nvidiaRuntime, nil},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=7"}, nvidiaRuntime, nil},
+		{[]string{"PATH=/usr/bin"}, nvidiaRuntime, nil},
+		// CUDA images set NVIDIA_VISIBLE_DEVICES, which runc ignores.
+		{[]string{"NVIDIA_VISIBLE_DEVICES=all"}, &ContainerRuntime{Runtime: "runc"}, nil},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=all"}, nil, nil},
+		{nil, &ContainerRuntime{DeviceRequests: []DeviceRequest{{Count: -1, Capabilities: [][]string{{"gpu"}}}}}, []int{0, 1, 2}},
+		{nil, &ContainerRuntime{DeviceRequests: []DeviceRequest{{Count: 2, Capabilities: [][]string{{"gpu"}}}}}, []int{0, 1}},
+		{nil, &ContainerRuntime{DeviceRequests: []DeviceRequest{{Driver: "nvidia", DeviceIDs: []string{"1"}}}}, []int{1}},
+	}
+
+	for i, testData := range testDatas {
+		positions := VisibleDevices(testData.Env, testData.Runtime, devices)
+		if !reflect.DeepEqual(positions, testData.Positions) {
+			t.Fatalf("%d: expected %v, got %v", i, testData.Positions, positions)
+		}
+	}
+}
+
+func TestCUDAOrdinals(t *testing.T) {
+	devices := []DeviceStatus{
+		{Index: toUintP(0), UUID: "GPU-5d5ba0d6-d33d-2b2c-524d-e4e8e8e3d0ba"},
+		{Index: toUintP(1), UUID: "GPU-7a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d"},
+		{Index: toUintP(2), UUID: "GPU-9c8b7a6d-5e4f-3a2b-1c0d-e9f8a7b6c5d4"},
+		{Index: toUintP(3), UUID: "GPU-1f2e3d4c-5b6a-7980-a1b2-c3d4e5f6a7b8"},
+	}
+	positions := []int{3, 1, 2}
+
+	testDatas := []struct {
+		Env      []string
+		Ordinals map[int]uint
+	}{
+		{nil, map[int]uint{1: 0, 2: 1, 3: 2}},
+		{[]string{"CUDA_VISIBLE_DEVICES=2,0"}, map[int]uint{3: 0, 1: 1}},
+		{[]string{"CUDA_VISIBLE_DEVICES=GPU-9c8b7a6d,1"}, map[int]uint{2: 0}},
+		// CUDA stops at the first invalid entry.
+		{[]string{"CUDA_VISIBLE_DEVICES=1,5,0"}, map[int]uint{2: 0}},
+		// The GPUs of the host not in the container are invalid.
+		{[]string{"CUDA_VISIBLE_DEVICES=GPU-5d5ba0d6-d33d-2b2c-524d-e4e8e8e3d0ba"}, map[int]uint{}},
+		{[]string{"CUDA_VISIBLE_DEVICES="}, map[int]uint{}},
+	}
+	for _, testData := range testDatas {
+		ordinals := CUDAOrdinals(testData.Env, positions, devices)
+		if !reflect.DeepEqual(ordinals, testData.Ordinals) {
+			t.Fatalf("%v: expected %v, got %v", testData.Env, testData.Ordinals, ordinals)
+		}
+	}
+}
+
+func TestVisibleMIGDevices(t *testing.T) {
+	migs := []MIGDevice{
+		{UUID: "MIG-c6d4f1ef-42e4-5de3-91c7-45d71c87eb3f", Index: 0, GPUIndex: toUintP(0),
+			GPUUUID: "GPU-5d5ba0d6-d33d-2b2c-524d-e4e8e8e3d0ba", GPUInstanceID: 7, ComputeInstanceID: 0},
+		{UUID: "MIG-e9bd7c2c-8d07-5b2f-a3a5-2e3f1d5a6b7c", Index: 1, GPUIndex: toUintP(0),
+			GPUUUID: "GPU-5d5ba0d6-d33d-2b2c-524d-e4e8e8e3d0ba", GPUInstanceID: 8, ComputeInstanceID: 0},
+		{UUID: "MIG-0f3a5b1e-9c2d-5e4f-8a7b-6c5d4e3f2a1b", Index: 0, GPUIndex: toUintP(1),
+			GPUUUID: "GPU-7a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d", GPUInstanceID: 1, ComputeInstanceID: 0},
+	}
+	nvidia := &ContainerRuntime{Runtime: "nvidia"}
+
+	testDatas := []struct {
+		Env       []string
+		Runtime   *ContainerRuntime
+		Positions []int
+	}{
+		{[]string{"NVIDIA_VISIBLE_DEVICES=MIG-e9bd7c2c-8d07-5b2f-a3a5-2e3f1d5a6b7c"}, nvidia, []int{1}},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=MIG-GPU-5d5ba0d6-d33d-2b2c-524d-e4e8e8e3d0ba/7/0"}, nvidia, []int{0}},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=1:0,0:1"}, nvidia, []int{2, 1}},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=all"}, nvidia, []int{0, 1, 2}},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=0"}, nvidia, nil},
+		{[]string{"NVIDIA_VISIBLE_DEVICES=1:0"}, &ContainerRuntime{Runtime: "runc"}, nil},
+		{nil, &ContainerRuntime{DeviceRequests: []DeviceRequest{
+			{Driver: "nvidia", DeviceIDs: []string{"MIG-0f3a5b1e-9c2d-5e4f-8a7b-6c5d4e3f2a1b"}},
+		}}, []int{2}},
+	}
+
+	for _, testData := range testDatas {
+		positions := VisibleMIGDevices(testData.Env, testData.Runtime, migs)
+		if !reflect.DeepEqual(positions, testData.Positions) {
+			t.Fatalf("%v: expected %v, got %v", testData.Env, testData.Positions, positions)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package nvidiadocker
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// The messages of the sd_notify protocol of systemd.
+const (
+	watchdogReady  = "READY=1"
+	watchdogNotify = "WATCHDOG=1"
+)
+
+// Watchdog notifies the watchdog of systemd after the fetches of the
+// MetricSets, so that systemd restarts a beat whose fetches are stuck, like on
+// a hung nvidia-smi, or keep failing. The MetricSets of all the modules share
+// the watchdog of the process. A fetch notifies the watchdog unless the
+// fetches of its host failed MaxFailures times in a row, so that a host whose
+// fetches keep failing stops notifying while the others still do.
+type Watchdog struct {
+	conn        io.Writer
+	maxFailures int
+
+	mutex    sync.Mutex
+	failures map[string]int
+}
+
+var (
+	watchdogOnce sync.Once
+	watchdog     *Watchdog
+	watchdogErr  error
+)
+
+// NewWatchdog returns the watchdog of the process, for a MetricSet fetching
+// at the given period. It returns nil, which notifies nothing, if the beat is
+// not run by systemd with WatchdogSec set.
+func NewWatchdog(config WatchdogConfig, period time.Duration) (*Watchdog, error) {
+	if config.MaxFailures <= 0 {
+		return nil, fmt.Errorf("watchdog.max_failures %d must be positive", config.MaxFailures)
+	}
+
+	watchdogOnce.Do(func() {
+		watchdog, watchdogErr = newSystemdWatchdog(config)
+	})
+	if watchdog == nil || watchdogErr != nil {
+		return nil, watchdogErr
+	}
+
+	if timeout, err := watchdogTimeout(); err == nil && timeout <= period {
+		logp.Warn("The systemd watchdog timeout %v is not longer than the period %v, systemd will restart the beat between fetches", timeout, period)
+	}
+	return watchdog, nil
+}
+
+func newSystemdWatchdog(config WatchdogConfig) (*Watchdog, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil, nil
+	}
+	if _, err := watchdogTimeout(); err != nil {
+		return nil, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	// A leading @ names a socket in the abstract namespace.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, fmt.Errorf("systemd watchdog: %v", err)
+	}
+	w := &Watchdog{
+		conn:        conn,
+		maxFailures: config.MaxFailures,
+		failures:    map[string]int{},
+	}
+	w.send(watchdogReady)
+	logp.Info("Notifying the systemd watchdog after the fetches")
+	return w, nil
+}
+
+// watchdogTimeout returns the watchdog timeout systemd passes to the beat in
+// WATCHDOG_USEC.
+func watchdogTimeout() (time.Duration, error) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if usec <= 0 {
+		return 0, fmt.Errorf("WATCHDOG_USEC %d must be positive", usec)
+	}
+	return time.Duration(usec) * time.Microsecond, nil
+}
+
+// Report records the outcome of the latest fetch of the given source, like the
+// host a MetricSet reads, and notifies the watchdog unless the fetches of the
+// source failed MaxFailures times in a row.
+func (w *Watchdog) Report(source string, err error) {
+	if w == nil {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err == nil {
+		w.failures[source] = 0
+	} else {
+		w.failures[source]++
+	}
+	switch failures := w.failures[source]; {
+	case failures < w.maxFailures:
+		w.send(watchdogNotify)
+	case failures == w.maxFailures:
+		logp.Err("The fetches of %s failed %d times in a row, stopped notifying the systemd watchdog: %v", source, failures, err)
+	}
+}
+
+func (w *Watchdog) send(message string) {
+	if _, err := w.conn.Write([]byte(message)); err != nil {
+		logp.Debug("nvidiadocker", "Cannot notify the systemd watchdog: %v", err)
+	}
+}
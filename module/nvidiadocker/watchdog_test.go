@@ -0,0 +1,39 @@
+package nvidiadocker
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWatchdogReport(t *testing.T) {
+	recorder := &packetRecorder{}
+	w := &Watchdog{conn: recorder, maxFailures: 2, failures: map[string]int{}}
+
+	err := errors.New("nvidia-smi timed out")
+	w.Report("localhost", nil)
+	w.Report("localhost", err)
+	if len(recorder.packets) != 2 {
+		t.Fatalf("expected 2 notifications, got %v", recorder.packets)
+	}
+
+	// The second failure in a row stops the notifications of the host, but
+	// not the ones of the other hosts.
+	w.Report("localhost", err)
+	w.Report("localhost", err)
+	if len(recorder.packets) != 2 {
+		t.Fatalf("expected 2 notifications, got %v", recorder.packets)
+	}
+	w.Report("gpu1:2376", nil)
+	if len(recorder.packets) != 3 {
+		t.Fatalf("expected 3 notifications, got %v", recorder.packets)
+	}
+
+	// A successful fetch resumes them.
+	w.Report("localhost", nil)
+	if len(recorder.packets) != 4 || recorder.packets[3] != watchdogNotify {
+		t.Fatalf("unexpected notifications %v", recorder.packets)
+	}
+
+	var nilWatchdog *Watchdog
+	nilWatchdog.Report("localhost", nil)
+}
@@ -0,0 +1,44 @@
+package nvidiadocker
+
+import "fmt"
+
+// XIDEvent is an XID error reported by the driver for a GPU.
+type XIDEvent struct {
+	GPUIndex *uint
+	GPUUUID  string
+	Code     uint64
+}
+
+// xidDescriptions holds the descriptions of the common XID errors, from the
+// NVIDIA XID errors documentation.
+var xidDescriptions = map[uint64]string{
+	13:  "Graphics Engine Exception",
+	31:  "GPU memory page fault",
+	32:  "Invalid or corrupted push buffer stream",
+	38:  "Driver firmware error",
+	43:  "GPU stopped processing",
+	44:  "Graphics Engine fault during context switch",
+	45:  "Preemptive cleanup, due to previous errors",
+	48:  "Double Bit ECC Error",
+	61:  "Internal micro-controller breakpoint/warning",
+	62:  "Internal micro-controller halt",
+	63:  "ECC page retirement or row remapping recording event",
+	64:  "ECC page retirement or row remapper recording failure",
+	68:  "Video processor exception",
+	69:  "Graphics Engine class error",
+	74:  "NVLink Error",
+	79:  "GPU has fallen off the bus",
+	92:  "High single-bit ECC error rate",
+	94:  "Contained ECC error",
+	95:  "Uncontained ECC error",
+	119: "GSP RPC timeout",
+	120: "GSP error",
+}
+
+// XIDDescription returns the description of an XID error code.
+func XIDDescription(code uint64) string {
+	if description, found := xidDescriptions[code]; found {
+		return description
+	}
+	return fmt.Sprintf("Unknown XID error %d", code)
+}
@@ -0,0 +1,91 @@
+package xid
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// init registers the MetricSet with the central registry.
+// The New method will be called after the setup of the module and before starting to fetch data
+func init() {
+	if err := mb.Registry.AddMetricSet("nvidiadocker", "xid", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet reports every XID error of the driver.
+type MetricSet struct {
+	mb.BaseMetricSet
+	collector nvidiadocker.XIDCollector
+	versions  *nvidiadocker.VersionCache
+	driver    *nvidiadocker.DriverCheck
+	gate      *nvidiadocker.FetchGate
+}
+
+// New create a new instance of the MetricSet
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	config := nvidiadocker.DefaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	collector, err := nvidiadocker.NewCollector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	xidCollector, ok := collector.(nvidiadocker.XIDCollector)
+	if !ok {
+		return nil, fmt.Errorf("gpu_source '%s' does not support reporting XID errors", config.GPUSource)
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		collector:     xidCollector,
+		versions:      nvidiadocker.NewVersionCache(collector),
+		driver:        nvidiadocker.NewDriverCheck(config),
+		gate:          nvidiadocker.NewFetchGate(config, base),
+	}, nil
+}
+
+// Fetch returns one event per XID error that occurred since the previous
+// fetch.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	if !m.gate.Due() {
+		return []common.MapStr{}, nil
+	}
+
+	if !m.driver.Available() {
+		return []common.MapStr{}, nil
+	}
+
+	xids, err := m.collector.XIDEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]common.MapStr, 0, len(xids))
+	for i := range xids {
+		events = append(events, eventMapping(&xids[i]))
+	}
+	m.versions.AddTo(events)
+	return events, nil
+}
+
+func eventMapping(xid *nvidiadocker.XIDEvent) common.MapStr {
+	gpu := common.MapStr{
+		"uuid": xid.GPUUUID,
+	}
+	if xid.GPUIndex != nil {
+		gpu["index"] = *xid.GPUIndex
+	}
+
+	return common.MapStr{
+		"code":        xid.Code,
+		"description": nvidiadocker.XIDDescription(xid.Code),
+		"gpu":         gpu,
+	}
+}
@@ -0,0 +1,36 @@
+package xid
+
+import (
+	"testing"
+
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker/fieldstest"
+)
+
+func TestEventMapping(t *testing.T) {
+	index := uint(2)
+	event := eventMapping(&nvidiadocker.XIDEvent{
+		GPUIndex: &index,
+		GPUUUID:  "GPU-149648d8-7e32-715a-b5c3-fe6df5976c7e",
+		Code:     79,
+	})
+
+	testDatas := map[string]interface{}{
+		"code":        uint64(79),
+		"description": "GPU has fallen off the bus",
+		"gpu.index":   uint(2),
+		"gpu.uuid":    "GPU-149648d8-7e32-715a-b5c3-fe6df5976c7e",
+	}
+
+	for key, expected := range testDatas {
+		value, err := event.GetValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%s: expected %v, got %v", key, expected, value)
+		}
+	}
+
+	fieldstest.CheckEvent(t, event)
+}
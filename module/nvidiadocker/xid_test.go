@@ -0,0 +1,22 @@
+package nvidiadocker
+
+import (
+	"testing"
+)
+
+func TestXIDDescription(t *testing.T) {
+	testDatas := []struct {
+		Code        uint64
+		Description string
+	}{
+		{48, "Double Bit ECC Error"},
+		{79, "GPU has fallen off the bus"},
+		{1000, "Unknown XID error 1000"},
+	}
+
+	for _, testData := range testDatas {
+		if description := XIDDescription(testData.Code); description != testData.Description {
+			t.Fatalf("%d: expected %q, got %q", testData.Code, testData.Description, description)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/elastic/beats/metricbeat/mb/module"
+)
+
+// previewFlags are the spellings of the flag running the beat in preview
+// mode. It is handled before the flags of libbeat are parsed, which do not
+// know it.
+var previewFlags = []string{"-preview", "--preview"}
+
+// isPreview tells whether the beat is run in preview mode.
+func isPreview(args []string) bool {
+	for _, arg := range args[1:] {
+		for _, flag := range previewFlags {
+			if arg == flag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// preview fetches the metricsets of the enabled nvidiadocker modules of the
+// configuration once and prints the events they would ship, as indented
+// JSON, instead of publishing them. How the GPUs are matched to every
+// container is logged to the standard error, with the nvidiadocker debug
+// selector unless other selectors are given with -d, so that users can check
+// the device matching of their setup. It returns the exit code, 1 if a fetch
+// failed.
+func preview() int {
+	args := os.Args[:1]
+	for _, arg := range os.Args[1:] {
+		if arg != previewFlags[0] && arg != previewFlags[1] {
+			args = append(args, arg)
+		}
+	}
+	os.Args = args
+
+	// The preview fetches once, -once is accepted to spell it out.
+	flag.Bool("once", true, "Fetch once, the only mode of -preview")
+	if err := handleFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	selectors := []string{"nvidiadocker"}
+	if d := flag.Lookup("d").Value.String(); d != "" {
+		selectors = strings.Split(d, ",")
+	}
+	logp.LogInit(logp.LOG_DEBUG, "", false, true, selectors)
+
+	modules, err := loadModules()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config file: %v\n", err)
+		return 1
+	}
+	if len(modules) == 0 {
+		fmt.Fprintf(os.Stderr, "No nvidiadocker module is enabled in %s.modules\n", Name)
+		return 1
+	}
+	configs := make([]*common.Config, 0, len(modules))
+	for _, module := range modules {
+		configs = append(configs, module.raw)
+	}
+	metricSets, err := mb.NewModules(configs, mb.Registry)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	code := 0
+	for mod, sets := range metricSets {
+		for _, ms := range sets {
+			if err := previewMetricSet(mod, ms); err != nil {
+				fmt.Fprintf(os.Stderr, "Error fetching %s/%s from %s: %v\n", mod.Name(), ms.Name(), ms.Host(), err)
+				code = 1
+			}
+		}
+	}
+	return code
+}
+
+// previewMetricSet fetches the MetricSet once and prints its events like
+// Metricbeat builds them.
+func previewMetricSet(mod mb.Module, ms mb.MetricSet) error {
+	start := time.Now()
+	var events []common.MapStr
+	switch fetcher := ms.(type) {
+	case mb.EventsFetcher:
+		var err error
+		if events, err = fetcher.Fetch(); err != nil {
+			return err
+		}
+	case mb.EventFetcher:
+		event, err := fetcher.Fetch()
+		if err != nil {
+			return err
+		}
+		events = []common.MapStr{event}
+	default:
+		return fmt.Errorf("metricset cannot be fetched")
+	}
+	duration := time.Since(start)
+
+	for _, event := range events {
+		built, err := module.EventBuilder{
+			ModuleName:    mod.Name(),
+			MetricSetName: ms.Name(),
+			Host:          ms.Host(),
+			StartTime:     start,
+			FetchDuration: duration,
+			Event:         event,
+		}.Build()
+		if err != nil {
+			return err
+		}
+		// The publisher applies the metadata of the beat, it is not shipped.
+		delete(built, common.EventMetadataKey)
+		data, err := json.MarshalIndent(built, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	}
+	return nil
+}
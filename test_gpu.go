@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/cfgfile"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/fpgeek/nvidiadockerbeat/module/nvidiadocker"
+)
+
+// isTestGPU tells whether the beat is run as nvidiadockerbeat test gpu.
+func isTestGPU(args []string) bool {
+	return len(args) > 2 && args[1] == "test" && args[2] == "gpu"
+}
+
+// testGPU runs the discovery of the enabled nvidiadocker modules of the
+// configuration end to end, for every host, and prints a report, to validate
+// a deployment before it ships events. libbeat 5.5 has no subcommands, so the
+// command is handled before the beat starts, with the same flags. It returns
+// the exit code, 1 if a check failed.
+func testGPU() int {
+	os.Args = append(os.Args[:1], os.Args[3:]...)
+	if err := handleFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	modules, err := loadModules()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config file: %v\n", err)
+		return 1
+	}
+	if len(modules) == 0 {
+		fmt.Fprintf(os.Stderr, "No nvidiadocker module is enabled in %s.modules\n", Name)
+		return 1
+	}
+
+	ok := true
+	for i, module := range modules {
+		hosts := module.Hosts
+		if len(hosts) == 0 {
+			hosts = []string{""}
+		}
+		for _, host := range hosts {
+			config := module.config
+			if err := config.ApplyHost(host); err != nil {
+				fmt.Printf("nvidiadocker module %d: %v\n", i+1, err)
+				ok = false
+				continue
+			}
+			if host == "" {
+				fmt.Printf("nvidiadocker module %d, metricsets %s\n", i+1, strings.Join(module.MetricSets, ", "))
+			} else {
+				fmt.Printf("nvidiadocker module %d, metricsets %s, host %s\n", i+1, strings.Join(module.MetricSets, ", "), host)
+			}
+			if !nvidiadocker.CheckDiscovery(os.Stdout, config) {
+				ok = false
+			}
+			fmt.Println()
+		}
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// handleFlags parses the command line flags like libbeat does before it
+// starts the beat.
+func handleFlags() error {
+	if err := cfgfile.ChangeDefaultCfgfileFlag(Name); err != nil {
+		return err
+	}
+	flag.Parse()
+	if err := logp.HandleFlags(Name); err != nil {
+		return err
+	}
+	return cfgfile.HandleFlags()
+}
+
+type moduleConfig struct {
+	Module     string   `config:"module"`
+	MetricSets []string `config:"metricsets"`
+	Hosts      []string `config:"hosts"`
+	Enabled    bool     `config:"enabled"`
+
+	raw    *common.Config
+	config nvidiadocker.Config
+}
+
+// loadModules returns the enabled nvidiadocker modules of the configuration
+// file.
+func loadModules() ([]moduleConfig, error) {
+	cfg, err := cfgfile.Load("")
+	if err != nil {
+		return nil, err
+	}
+
+	var beatConfig struct {
+		Modules []*common.Config `config:"modules"`
+	}
+	if cfg.HasField(Name) {
+		sub, err := cfg.Child(Name, -1)
+		if err != nil {
+			return nil, err
+		}
+		if err := sub.Unpack(&beatConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	var modules []moduleConfig
+	for _, raw := range beatConfig.Modules {
+		module := moduleConfig{Enabled: true}
+		if err := raw.Unpack(&module); err != nil {
+			return nil, err
+		}
+		if module.Module != "nvidiadocker" || !module.Enabled {
+			continue
+		}
+		module.raw = raw
+		module.config = nvidiadocker.DefaultConfig()
+		if err := raw.Unpack(&module.config); err != nil {
+			return nil, err
+		}
+		modules = append(modules, module)
+	}
+	return modules, nil
+}